@@ -0,0 +1,200 @@
+package main
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+
+	"sso/internal/domain/models"
+	"sso/internal/lib/biometrics"
+)
+
+const (
+	formatCMU   = "cmu"
+	formatJSONL = "jsonl"
+)
+
+var errUnknownFormat = errors.New("unknown dataset format")
+
+// dataset maps a subject to its samples in capture order.
+type dataset struct {
+	subjects []string
+	samples  map[string][]biometrics.Sample
+}
+
+func (d *dataset) add(subject string, s biometrics.Sample) {
+	if d.samples == nil {
+		d.samples = make(map[string][]biometrics.Sample)
+	}
+	if _, ok := d.samples[subject]; !ok {
+		d.subjects = append(d.subjects, subject)
+	}
+	d.samples[subject] = append(d.samples[subject], s)
+}
+
+// jsonlRecord is one line of our own dataset layout.
+type jsonlRecord struct {
+	Subject           string            `json:"subject"`
+	KeyPressTimes     []float32         `json:"key_press_times"`
+	KeyPressIntervals []float32         `json:"key_press_intervals"`
+	KeyEvents         []models.KeyEvent `json:"key_events"`
+}
+
+func loadDataset(path string, format string) (*dataset, error) {
+	const op = "bioeval.loadDataset"
+
+	if format == "" {
+		format = formatJSONL
+		if strings.HasSuffix(path, ".csv") {
+			format = formatCMU
+		}
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	defer f.Close()
+
+	var ds *dataset
+	switch format {
+	case formatCMU:
+		ds, err = readCMU(f)
+	case formatJSONL:
+		ds, err = readJSONL(f)
+	default:
+		err = errUnknownFormat
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return ds, nil
+}
+
+// readCMU reads the CMU keystroke benchmark layout: subject, sessionIndex,
+// rep, followed by H.* (hold), DD.* (keydown-keydown) and UD.* columns.
+// Hold times become press times and DD latencies become intervals; key
+// events are rebuilt from the key names in the H.* headers.
+func readCMU(r io.Reader) (*dataset, error) {
+	cr := csv.NewReader(r)
+
+	header, err := cr.Read()
+	if err != nil {
+		return nil, err
+	}
+
+	subjectCol := -1
+	var holdCols, intervalCols []int
+	var keys []string
+	for i, name := range header {
+		switch {
+		case name == "subject":
+			subjectCol = i
+		case strings.HasPrefix(name, "H."):
+			holdCols = append(holdCols, i)
+			keys = append(keys, strings.TrimPrefix(name, "H."))
+		case strings.HasPrefix(name, "DD."):
+			intervalCols = append(intervalCols, i)
+		}
+	}
+	if subjectCol < 0 || len(holdCols) == 0 || len(intervalCols) == 0 {
+		return nil, errors.New("not a CMU keystroke dataset")
+	}
+
+	ds := &dataset{}
+	for {
+		record, err := cr.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		pressTimes, err := columns(record, holdCols)
+		if err != nil {
+			return nil, err
+		}
+		intervalTimes, err := columns(record, intervalCols)
+		if err != nil {
+			return nil, err
+		}
+
+		ds.add(record[subjectCol], biometrics.Sample{
+			PressTimes:    pressTimes,
+			IntervalTimes: intervalTimes,
+			KeyEvents:     cmuKeyEvents(keys, pressTimes, intervalTimes),
+		})
+	}
+
+	return ds, nil
+}
+
+// cmuKeyEvents rebuilds key events in milliseconds from hold times (seconds)
+// and keydown-keydown latencies between consecutive keys.
+func cmuKeyEvents(keys []string, holds []float32, latencies []float32) []models.KeyEvent {
+	events := make([]models.KeyEvent, 0, len(keys))
+	pressedAt := 0.0
+	for i, key := range keys {
+		if i > 0 {
+			if i-1 >= len(latencies) {
+				break
+			}
+			pressedAt += float64(latencies[i-1]) * 1000
+		}
+		events = append(events, models.KeyEvent{
+			Key:        key,
+			PressedAt:  pressedAt,
+			ReleasedAt: pressedAt + float64(holds[i])*1000,
+		})
+	}
+	return events
+}
+
+func readJSONL(r io.Reader) (*dataset, error) {
+	ds := &dataset{}
+
+	scanner := bufio.NewScanner(r)
+	line := 0
+	for scanner.Scan() {
+		line++
+		if strings.TrimSpace(scanner.Text()) == "" {
+			continue
+		}
+
+		var rec jsonlRecord
+		if err := json.Unmarshal(scanner.Bytes(), &rec); err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+
+		ds.add(rec.Subject, biometrics.Sample{
+			PressTimes:    rec.KeyPressTimes,
+			IntervalTimes: rec.KeyPressIntervals,
+			KeyEvents:     rec.KeyEvents,
+		})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return ds, nil
+}
+
+func columns(record []string, cols []int) ([]float32, error) {
+	values := make([]float32, 0, len(cols))
+	for _, c := range cols {
+		v, err := strconv.ParseFloat(record[c], 32)
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, float32(v))
+	}
+	return values, nil
+}
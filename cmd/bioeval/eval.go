@@ -0,0 +1,106 @@
+package main
+
+import (
+	"math"
+
+	"sso/internal/lib/biometrics"
+)
+
+// rocPoint is the error rates of the matcher at a given score threshold.
+type rocPoint struct {
+	Threshold float64 `json:"threshold"`
+	FAR       float64 `json:"far"`
+	FRR       float64 `json:"frr"`
+}
+
+// report is the outcome of an evaluation run. FAR and FRR are measured on
+// the production decision (Matcher.Match), EER on the score curve.
+type report struct {
+	GenuinePairs  int        `json:"genuine_pairs"`
+	ImpostorPairs int        `json:"impostor_pairs"`
+	FAR           float64    `json:"far"`
+	FRR           float64    `json:"frr"`
+	EER           float64    `json:"eer"`
+	EERThreshold  float64    `json:"eer_threshold"`
+	ROC           []rocPoint `json:"roc"`
+}
+
+// evaluate enrolls every subject with its first sample, the same way
+// registration does, and matches it against the rest of the subject's
+// samples (genuine) and the first impostorSamples of every other subject.
+func evaluate(ds *dataset, matcher biometrics.Matcher, impostorSamples int, rocSteps int) report {
+	var genuine, impostor []float64
+	var falseAccepts, falseRejects int
+
+	for _, subject := range ds.subjects {
+		samples := ds.samples[subject]
+		if len(samples) == 0 {
+			continue
+		}
+		template := samples[0]
+
+		for _, s := range samples[1:] {
+			genuine = append(genuine, matcher.Score(template, s))
+			if ok, _ := matcher.Match(template, s); !ok {
+				falseRejects++
+			}
+		}
+
+		for _, other := range ds.subjects {
+			if other == subject {
+				continue
+			}
+			others := ds.samples[other]
+			for i := 0; i < len(others) && i < impostorSamples; i++ {
+				impostor = append(impostor, matcher.Score(template, others[i]))
+				if ok, _ := matcher.Match(template, others[i]); ok {
+					falseAccepts++
+				}
+			}
+		}
+	}
+
+	r := report{
+		GenuinePairs:  len(genuine),
+		ImpostorPairs: len(impostor),
+		FAR:           rate(falseAccepts, len(impostor)),
+		FRR:           rate(falseRejects, len(genuine)),
+		ROC:           make([]rocPoint, 0, rocSteps+1),
+	}
+
+	bestGap := math.Inf(1)
+	for i := 0; i <= rocSteps; i++ {
+		threshold := float64(i) / float64(rocSteps)
+		p := rocPoint{
+			Threshold: threshold,
+			FAR:       rate(countAtLeast(impostor, threshold), len(impostor)),
+			FRR:       rate(len(genuine)-countAtLeast(genuine, threshold), len(genuine)),
+		}
+		r.ROC = append(r.ROC, p)
+
+		if gap := math.Abs(p.FAR - p.FRR); gap < bestGap {
+			bestGap = gap
+			r.EER = (p.FAR + p.FRR) / 2
+			r.EERThreshold = threshold
+		}
+	}
+
+	return r
+}
+
+func countAtLeast(scores []float64, threshold float64) int {
+	n := 0
+	for _, s := range scores {
+		if s >= threshold {
+			n++
+		}
+	}
+	return n
+}
+
+func rate(n, total int) float64 {
+	if total == 0 {
+		return 0
+	}
+	return float64(n) / float64(total)
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"sso/internal/domain/models"
+	"sso/internal/lib/biometrics"
+)
+
+// typedSample returns a sample of text typed with a constant dwell and gap.
+func typedSample(text string, dwell float64, gap float64) biometrics.Sample {
+	events := make([]models.KeyEvent, 0, len(text))
+	for i, key := range text {
+		pressedAt := float64(i) * gap
+		events = append(events, models.KeyEvent{Key: string(key), PressedAt: pressedAt, ReleasedAt: pressedAt + dwell})
+	}
+	pressTimes, intervalTimes := biometrics.FlatTimings(events)
+	return biometrics.Sample{PressTimes: pressTimes, IntervalTimes: intervalTimes, KeyEvents: events}
+}
+
+func TestEvaluate(t *testing.T) {
+	matcher := biometrics.NewMatcher(biometrics.DefaultLowerThreshold, biometrics.DefaultUpperThreshold, biometrics.DefaultFeatureTolerance)
+
+	ds := &dataset{}
+	for _, factor := range []float64{1, 1.05, 0.95} {
+		ds.add("slow", typedSample("password", 100*factor, 200*factor))
+		ds.add("fast", typedSample("password", 300*factor, 600*factor))
+	}
+
+	r := evaluate(ds, matcher, 2, 10)
+	assert.Equal(t, 4, r.GenuinePairs)
+	assert.Equal(t, 4, r.ImpostorPairs)
+	assert.Zero(t, r.FAR)
+	assert.Zero(t, r.FRR)
+	assert.Zero(t, r.EER)
+	require.Len(t, r.ROC, 11)
+	assert.Equal(t, 1.0, r.ROC[0].FAR, "every impostor is accepted at threshold 0")
+	assert.Zero(t, r.ROC[10].FAR)
+	assert.Zero(t, r.ROC[10].FRR, "genuine samples within the tolerance match every feature")
+}
+
+func TestReadCMU(t *testing.T) {
+	const csv = `subject,sessionIndex,rep,H.a,DD.a.b,UD.a.b,H.b
+s002,1,1,0.1,0.25,0.15,0.09
+s003,1,1,0.2,0.5,0.3,0.18
+`
+	ds, err := readCMU(strings.NewReader(csv))
+	require.NoError(t, err)
+	require.Equal(t, []string{"s002", "s003"}, ds.subjects)
+
+	s := ds.samples["s002"][0]
+	assert.Equal(t, []float32{0.1, 0.09}, s.PressTimes)
+	assert.Equal(t, []float32{0.25}, s.IntervalTimes)
+	require.Len(t, s.KeyEvents, 2)
+	assert.Equal(t, "b", s.KeyEvents[1].Key)
+	assert.InDelta(t, 250, s.KeyEvents[1].PressedAt, 1e-3, "latencies in seconds become milliseconds")
+	assert.InDelta(t, 340, s.KeyEvents[1].ReleasedAt, 1e-3)
+
+	_, err = readCMU(strings.NewReader("subject,rep\ns002,1\n"))
+	assert.Error(t, err)
+}
+
+func TestReadJSONL(t *testing.T) {
+	const jsonl = `{"subject":"a","key_press_times":[100,90],"key_press_intervals":[200]}
+
+{"subject":"a","key_press_times":[105,95],"key_press_intervals":[210],"key_events":[{"key":"x","pressed_at":0,"released_at":105}]}
+`
+	ds, err := readJSONL(strings.NewReader(jsonl))
+	require.NoError(t, err)
+	require.Len(t, ds.samples["a"], 2)
+	assert.Equal(t, []float32{100, 90}, ds.samples["a"][0].PressTimes)
+	assert.Len(t, ds.samples["a"][1].KeyEvents, 1)
+
+	_, err = readJSONL(strings.NewReader("{not json}\n"))
+	assert.ErrorContains(t, err, "line 1")
+}
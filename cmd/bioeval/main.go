@@ -0,0 +1,117 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+
+	"sso/internal/config"
+	"sso/internal/lib/biometrics"
+)
+
+// bioeval measures the keystroke matcher used by the server on a labelled
+// dataset and reports FAR, FRR, EER and the ROC curve.
+func main() {
+	var configPath, datasetPath, datasetFormat, outFormat, outPath, rocPath string
+	var impostorSamples, rocSteps int
+
+	flag.StringVar(&configPath, "config", "", "server config to take matcher thresholds from")
+	flag.StringVar(&datasetPath, "dataset", "", "path to labelled dataset")
+	flag.StringVar(&datasetFormat, "dataset-format", "", "dataset format: cmu or jsonl (default by extension)")
+	flag.StringVar(&outFormat, "format", "json", "report format: json or csv")
+	flag.StringVar(&outPath, "out", "", "report path (default stdout)")
+	flag.StringVar(&rocPath, "roc-out", "", "path to write the ROC curve as csv")
+	flag.IntVar(&impostorSamples, "impostor-samples", 5, "samples of every other subject used as impostor attempts")
+	flag.IntVar(&rocSteps, "roc-steps", 100, "number of threshold steps of the ROC curve")
+	flag.Parse()
+
+	if datasetPath == "" {
+		panic("dataset is required")
+	}
+
+	matcher := biometrics.NewMatcher(biometrics.DefaultLowerThreshold, biometrics.DefaultUpperThreshold, biometrics.DefaultFeatureTolerance)
+	if configPath != "" {
+		cfg := config.MustLoadPath(configPath)
+		matcher = biometrics.NewMatcher(cfg.Biometrics.LowerThreshold, cfg.Biometrics.UpperThreshold, cfg.Biometrics.FeatureTolerance)
+	}
+
+	ds, err := loadDataset(datasetPath, datasetFormat)
+	if err != nil {
+		panic(err)
+	}
+
+	r := evaluate(ds, matcher, impostorSamples, rocSteps)
+
+	out := io.Writer(os.Stdout)
+	if outPath != "" {
+		f, err := os.Create(outPath)
+		if err != nil {
+			panic(err)
+		}
+		defer f.Close()
+		out = f
+	}
+
+	switch outFormat {
+	case "json":
+		err = writeJSON(out, r)
+	case "csv":
+		err = writeSummaryCSV(out, r)
+	default:
+		err = fmt.Errorf("unknown report format %q", outFormat)
+	}
+	if err != nil {
+		panic(err)
+	}
+
+	if rocPath != "" {
+		f, err := os.Create(rocPath)
+		if err != nil {
+			panic(err)
+		}
+		defer f.Close()
+
+		if err := writeROCCSV(f, r.ROC); err != nil {
+			panic(err)
+		}
+	}
+}
+
+func writeJSON(w io.Writer, r report) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(r)
+}
+
+func writeSummaryCSV(w io.Writer, r report) error {
+	cw := csv.NewWriter(w)
+	_ = cw.Write([]string{"genuine_pairs", "impostor_pairs", "far", "frr", "eer", "eer_threshold"})
+	_ = cw.Write([]string{
+		strconv.Itoa(r.GenuinePairs),
+		strconv.Itoa(r.ImpostorPairs),
+		formatFloat(r.FAR),
+		formatFloat(r.FRR),
+		formatFloat(r.EER),
+		formatFloat(r.EERThreshold),
+	})
+	cw.Flush()
+	return cw.Error()
+}
+
+func writeROCCSV(w io.Writer, roc []rocPoint) error {
+	cw := csv.NewWriter(w)
+	_ = cw.Write([]string{"threshold", "far", "frr"})
+	for _, p := range roc {
+		_ = cw.Write([]string{formatFloat(p.Threshold), formatFloat(p.FAR), formatFloat(p.FRR)})
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+func formatFloat(v float64) string {
+	return strconv.FormatFloat(v, 'f', 6, 64)
+}
@@ -0,0 +1,296 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+
+	"github.com/golang-migrate/migrate/v4"
+	"github.com/golang-migrate/migrate/v4/source"
+)
+
+// step is a single migration of a plan.
+type step struct {
+	version uint
+	up      bool
+}
+
+func run(m *migrate.Migrate, src source.Driver, command string, args []string, dryRun bool) error {
+	current, dirty, applied, err := version(m)
+	if err != nil {
+		return err
+	}
+
+	switch command {
+	case "up":
+		n, err := parseCount(args, 0)
+		if err != nil {
+			return err
+		}
+		if dryRun {
+			steps, err := planUp(src, current, applied, n)
+			if err != nil {
+				return err
+			}
+			return printPlan(os.Stdout, src, steps)
+		}
+		if n == 0 {
+			return done(m, m.Up())
+		}
+		return done(m, m.Steps(n))
+	case "down":
+		n, err := parseCount(args, 1)
+		if err != nil {
+			return err
+		}
+		if dryRun {
+			steps, err := planDown(src, current, applied, n)
+			if err != nil {
+				return err
+			}
+			return printPlan(os.Stdout, src, steps)
+		}
+		return done(m, m.Steps(-n))
+	case "goto":
+		if len(args) == 0 {
+			return errors.New("goto: version is required")
+		}
+		target, err := strconv.ParseUint(args[0], 10, 64)
+		if err != nil {
+			return fmt.Errorf("goto: invalid version %q", args[0])
+		}
+		if dryRun {
+			steps, err := planGoto(src, current, applied, uint(target))
+			if err != nil {
+				return err
+			}
+			return printPlan(os.Stdout, src, steps)
+		}
+		return done(m, m.Migrate(uint(target)))
+	case "version":
+		printVersion(current, dirty, applied)
+		return nil
+	case "force":
+		if len(args) == 0 {
+			return errors.New("force: version is required")
+		}
+		target, err := strconv.Atoi(args[0])
+		if err != nil || target < -1 {
+			return fmt.Errorf("force: invalid version %q", args[0])
+		}
+		if dryRun {
+			fmt.Printf("would force version %d\n", target)
+			return nil
+		}
+		if err := m.Force(target); err != nil {
+			return err
+		}
+		fmt.Printf("forced version %d\n", target)
+		return nil
+	case "status":
+		return printStatus(src, current, dirty, applied)
+	default:
+		return fmt.Errorf("unknown command %q", command)
+	}
+}
+
+// version returns the current version; applied is false when no migration
+// has been applied yet.
+func version(m *migrate.Migrate) (current uint, dirty bool, applied bool, err error) {
+	current, dirty, err = m.Version()
+	if errors.Is(err, migrate.ErrNilVersion) {
+		return 0, false, false, nil
+	}
+	if err != nil {
+		return 0, false, false, err
+	}
+	return current, dirty, true, nil
+}
+
+// done reports the result of a migration.
+func done(m *migrate.Migrate, err error) error {
+	if errors.Is(err, migrate.ErrNoChange) {
+		fmt.Println("no changes")
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	current, dirty, applied, err := version(m)
+	if err != nil {
+		return err
+	}
+	fmt.Print("migrations done, ")
+	printVersion(current, dirty, applied)
+	return nil
+}
+
+func printVersion(current uint, dirty bool, applied bool) {
+	switch {
+	case !applied:
+		fmt.Println("no migrations applied")
+	case dirty:
+		fmt.Printf("version %d (dirty)\n", current)
+	default:
+		fmt.Printf("version %d\n", current)
+	}
+}
+
+func printStatus(src source.Driver, current uint, dirty bool, applied bool) error {
+	printVersion(current, dirty, applied)
+
+	v, err := src.First()
+	for err == nil {
+		state := "pending"
+		switch {
+		case applied && dirty && v == current:
+			state = "dirty"
+		case applied && v <= current:
+			state = "applied"
+		}
+
+		id, idErr := identifier(src, v)
+		if idErr != nil {
+			return idErr
+		}
+		fmt.Printf("%-8s %d %s\n", state, v, id)
+
+		v, err = src.Next(v)
+	}
+	if !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+func identifier(src source.Driver, v uint) (string, error) {
+	r, identifier, err := src.ReadUp(v)
+	if errors.Is(err, os.ErrNotExist) {
+		r, identifier, err = src.ReadDown(v)
+	}
+	if err != nil {
+		return "", err
+	}
+	r.Close()
+	return identifier, nil
+}
+
+// planUp lists the next n pending migrations, all of them when n is 0.
+func planUp(src source.Driver, current uint, applied bool, n int) ([]step, error) {
+	var steps []step
+	for n == 0 || len(steps) < n {
+		next, err := nextVersion(src, current, applied)
+		if errors.Is(err, os.ErrNotExist) {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		steps = append(steps, step{version: next, up: true})
+		current, applied = next, true
+	}
+	return steps, nil
+}
+
+// planDown lists the last n applied migrations, newest first.
+func planDown(src source.Driver, current uint, applied bool, n int) ([]step, error) {
+	var steps []step
+	for applied && len(steps) < n {
+		steps = append(steps, step{version: current})
+
+		prev, err := src.Prev(current)
+		if errors.Is(err, os.ErrNotExist) {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		current = prev
+	}
+	return steps, nil
+}
+
+func planGoto(src source.Driver, current uint, applied bool, target uint) ([]step, error) {
+	if applied && current == target {
+		return nil, nil
+	}
+
+	var steps []step
+	if !applied || target > current {
+		for !applied || current < target {
+			next, err := nextVersion(src, current, applied)
+			if errors.Is(err, os.ErrNotExist) {
+				return nil, fmt.Errorf("version %d not found", target)
+			}
+			if err != nil {
+				return nil, err
+			}
+			steps = append(steps, step{version: next, up: true})
+			current, applied = next, true
+		}
+	} else {
+		for current > target {
+			steps = append(steps, step{version: current})
+
+			prev, err := src.Prev(current)
+			if errors.Is(err, os.ErrNotExist) {
+				break
+			}
+			if err != nil {
+				return nil, err
+			}
+			current = prev
+		}
+	}
+	if current != target {
+		return nil, fmt.Errorf("version %d not found", target)
+	}
+
+	return steps, nil
+}
+
+func nextVersion(src source.Driver, current uint, applied bool) (uint, error) {
+	if !applied {
+		return src.First()
+	}
+	return src.Next(current)
+}
+
+// printPlan prints the SQL of the steps to w without running it.
+func printPlan(w io.Writer, src source.Driver, steps []step) error {
+	if len(steps) == 0 {
+		fmt.Fprintln(w, "no changes")
+		return nil
+	}
+
+	for _, s := range steps {
+		read, direction := src.ReadDown, "down"
+		if s.up {
+			read, direction = src.ReadUp, "up"
+		}
+
+		r, name, err := read(s.version)
+		if errors.Is(err, os.ErrNotExist) {
+			// the name is taken from the migration of the other direction
+			name, _ = identifier(src, s.version)
+			fmt.Fprintf(w, "-- %d %s (%s): no migration file\n\n", s.version, name, direction)
+			continue
+		}
+		if err != nil {
+			return err
+		}
+
+		fmt.Fprintf(w, "-- %d %s (%s)\n", s.version, name, direction)
+		_, err = io.Copy(w, r)
+		r.Close()
+		if err != nil {
+			return err
+		}
+		fmt.Fprint(w, "\n\n")
+	}
+
+	return nil
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+	"testing/fstest"
+
+	"github.com/golang-migrate/migrate/v4/source"
+	"github.com/golang-migrate/migrate/v4/source/iofs"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newSource returns migrations 1, 2 and 4; 4 has no down migration.
+func newSource(t *testing.T) source.Driver {
+	t.Helper()
+
+	src, err := iofs.New(fstest.MapFS{
+		"1_users.up.sql":    {Data: []byte("CREATE TABLE users;")},
+		"1_users.down.sql":  {Data: []byte("DROP TABLE users;")},
+		"2_apps.up.sql":     {Data: []byte("CREATE TABLE apps;")},
+		"2_apps.down.sql":   {Data: []byte("DROP TABLE apps;")},
+		"4_sessions.up.sql": {Data: []byte("CREATE TABLE sessions;")},
+	}, ".")
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = src.Close() })
+
+	return src
+}
+
+func up(versions ...uint) []step {
+	steps := make([]step, 0, len(versions))
+	for _, v := range versions {
+		steps = append(steps, step{version: v, up: true})
+	}
+	return steps
+}
+
+func down(versions ...uint) []step {
+	steps := make([]step, 0, len(versions))
+	for _, v := range versions {
+		steps = append(steps, step{version: v})
+	}
+	return steps
+}
+
+func TestPlanUp(t *testing.T) {
+	src := newSource(t)
+
+	for name, tt := range map[string]struct {
+		current uint
+		applied bool
+		n       int
+		want    []step
+	}{
+		"all from scratch":  {n: 0, want: up(1, 2, 4)},
+		"one from scratch":  {n: 1, want: up(1)},
+		"all pending":       {current: 1, applied: true, n: 0, want: up(2, 4)},
+		"more than pending": {current: 2, applied: true, n: 5, want: up(4)},
+		"up to date":        {current: 4, applied: true, n: 0, want: nil},
+	} {
+		t.Run(name, func(t *testing.T) {
+			steps, err := planUp(src, tt.current, tt.applied, tt.n)
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, steps)
+		})
+	}
+}
+
+func TestPlanDown(t *testing.T) {
+	src := newSource(t)
+
+	for name, tt := range map[string]struct {
+		current uint
+		applied bool
+		n       int
+		want    []step
+	}{
+		"one":                  {current: 4, applied: true, n: 1, want: down(4)},
+		"newest first":         {current: 4, applied: true, n: 2, want: down(4, 2)},
+		"more than applied":    {current: 2, applied: true, n: 5, want: down(2, 1)},
+		"no migration applied": {n: 1, want: nil},
+	} {
+		t.Run(name, func(t *testing.T) {
+			steps, err := planDown(src, tt.current, tt.applied, tt.n)
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, steps)
+		})
+	}
+}
+
+func TestPlanGoto(t *testing.T) {
+	src := newSource(t)
+
+	for name, tt := range map[string]struct {
+		current uint
+		applied bool
+		target  uint
+		want    []step
+		wantErr bool
+	}{
+		"forwards":             {current: 1, applied: true, target: 4, want: up(2, 4)},
+		"backwards":            {current: 4, applied: true, target: 1, want: down(4, 2)},
+		"current version":      {current: 2, applied: true, target: 2, want: nil},
+		"no migration applied": {target: 2, want: up(1, 2)},
+		"missing forwards":     {current: 1, applied: true, target: 3, wantErr: true},
+		"missing backwards":    {current: 4, applied: true, target: 3, wantErr: true},
+		"beyond the last":      {current: 4, applied: true, target: 9, wantErr: true},
+	} {
+		t.Run(name, func(t *testing.T) {
+			steps, err := planGoto(src, tt.current, tt.applied, tt.target)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, steps)
+		})
+	}
+}
+
+func TestPrintPlan(t *testing.T) {
+	src := newSource(t)
+
+	for name, tt := range map[string]struct {
+		steps []step
+		want  string
+	}{
+		"no changes": {want: "no changes\n"},
+		"up": {
+			steps: up(1, 2),
+			want:  "-- 1 users (up)\nCREATE TABLE users;\n\n-- 2 apps (up)\nCREATE TABLE apps;\n\n",
+		},
+		"down without a file": {
+			steps: down(4, 2),
+			want:  "-- 4 sessions (down): no migration file\n\n-- 2 apps (down)\nDROP TABLE apps;\n\n",
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			var buf bytes.Buffer
+			require.NoError(t, printPlan(&buf, src, tt.steps))
+			assert.Equal(t, tt.want, buf.String())
+		})
+	}
+}
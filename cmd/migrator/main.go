@@ -1,48 +1,168 @@
 package main
 
 import (
-	"errors"
 	"flag"
 	"fmt"
+	"os"
+	"strconv"
+
+	"sso/internal/config"
+	"sso/internal/storage/schema"
 
 	// Migrations lib
 	"github.com/golang-migrate/migrate/v4"
-	// Driver for sqlite
-	_ "github.com/golang-migrate/migrate/v4/database/sqlite3"
+	"github.com/golang-migrate/migrate/v4/source"
 	// Driver for getting migrations from file
 	_ "github.com/golang-migrate/migrate/v4/source/file"
+	"github.com/golang-migrate/migrate/v4/source/iofs"
 )
 
+const usage = `usage: migrator [flags] [command]
+
+commands:
+  up [N]     apply all or the next N pending migrations (default)
+  down [N]   roll back the last N applied migrations (default 1)
+  goto V     migrate up or down to version V
+  version    print the current version
+  force V    set the version without running migrations, to recover from
+             a dirty state; -1 means no migration applied
+  status     list applied and pending migrations
+
+flags:
+`
+
+// migrator applies the schema migrations. The database is taken from the
+// server config (--config or CONFIG_PATH) unless --storage-path or --dsn is
+// given; migrations are the ones embedded into the binary unless
+// --migrations-path is given.
 func main() {
-	var storagePath, migrationsPath, migrationsTable string
+	var configPath, driver, storagePath, dsn, migrationsPath, migrationsTable string
+	var dryRun bool
 
+	flag.StringVar(&configPath, "config", "", "server config to take the storage from")
+	flag.StringVar(&driver, "driver", "", "storage driver: sqlite or postgres (default from config or sqlite)")
 	flag.StringVar(&storagePath, "storage-path", "", "path to storage")
-	flag.StringVar(&migrationsPath, "migrations-path", "", "path to migrations")
-	flag.StringVar(&migrationsTable, "migrations-table", "migrations", "name of migrations table")
+	flag.StringVar(&dsn, "dsn", "", "postgres connection string")
+	flag.StringVar(&migrationsPath, "migrations-path", "", "path to migrations (default embedded)")
+	flag.StringVar(&migrationsTable, "migrations-table", schema.MigrationsTable, "name of migrations table")
+	flag.BoolVar(&dryRun, "dry-run", false, "print the SQL that would run instead of running it")
+	flag.Usage = func() {
+		fmt.Fprint(flag.CommandLine.Output(), usage)
+		flag.PrintDefaults()
+	}
 	flag.Parse()
 
-	if storagePath == "" {
-		panic("storage-path is required")
+	args := parseArgs()
+
+	if configPath == "" && storagePath == "" && dsn == "" {
+		configPath = os.Getenv("CONFIG_PATH")
 	}
-	if migrationsPath == "" {
-		panic("migrations-path is required")
+	if configPath != "" {
+		cfg := config.MustLoadPath(configPath)
+		if driver == "" {
+			driver = cfg.StorageDriver()
+		}
+		if storagePath == "" {
+			storagePath = cfg.StoragePath
+		}
+		if dsn == "" {
+			dsn = cfg.Storage.DSN
+		}
+	}
+	if driver == "" {
+		driver = config.StorageDriverSQLite
 	}
 
-	m, err := migrate.New(
-		"file://"+migrationsPath,
-		fmt.Sprintf("sqlite3://%s?x-migrations-table=%s", storagePath, migrationsTable),
-	)
+	var storageSource string
+	switch driver {
+	case config.StorageDriverSQLite:
+		if storagePath == "" {
+			panic("storage-path is required")
+		}
+		storageSource = storagePath
+	case config.StorageDriverPostgres:
+		if dsn == "" {
+			panic("dsn is required")
+		}
+		storageSource = dsn
+	default:
+		panic("unknown driver: " + driver)
+	}
+
+	src, err := openSource(driver, migrationsPath)
 	if err != nil {
 		panic(err)
 	}
 
-	if err := m.Up(); err != nil {
-		if errors.Is(err, migrate.ErrNoChange) {
-			fmt.Println("no changes")
-			return
-		}
+	databaseURL, err := schema.DatabaseURL(driver, storageSource, migrationsTable)
+	if err != nil {
 		panic(err)
 	}
 
-	fmt.Println("migrations done")
+	m, err := migrate.NewWithSourceInstance("migrations", src, databaseURL)
+	if err != nil {
+		panic(err)
+	}
+	defer m.Close()
+
+	command := "up"
+	if len(args) > 0 {
+		command, args = args[0], args[1:]
+	}
+
+	if err := run(m, src, command, args, dryRun); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+// parseArgs returns the positional arguments, so flags may follow the
+// command as well: migrator down 2 --dry-run. Numbers are never flags, which
+// keeps force -1 working.
+func parseArgs() []string {
+	var positional []string
+
+	args := flag.Args()
+	for len(args) > 0 {
+		positional = append(positional, args[0])
+		args = args[1:]
+		for len(args) > 0 {
+			if _, err := strconv.Atoi(args[0]); err != nil {
+				break
+			}
+			positional = append(positional, args[0])
+			args = args[1:]
+		}
+
+		if err := flag.CommandLine.Parse(args); err != nil {
+			panic(err)
+		}
+		args = flag.Args()
+	}
+
+	return positional
+}
+
+func openSource(driver string, migrationsPath string) (source.Driver, error) {
+	if migrationsPath != "" {
+		return source.Open("file://" + migrationsPath)
+	}
+
+	migrationsFS, err := schema.Migrations(driver)
+	if err != nil {
+		return nil, err
+	}
+	return iofs.New(migrationsFS, ".")
+}
+
+// parseCount parses the optional N of up and down.
+func parseCount(args []string, def int) (int, error) {
+	if len(args) == 0 {
+		return def, nil
+	}
+	n, err := strconv.Atoi(args[0])
+	if err != nil || n < 1 {
+		return 0, fmt.Errorf("invalid count %q", args[0])
+	}
+	return n, nil
 }
@@ -0,0 +1,296 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	mathrand "math/rand"
+	"os"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+
+	"sso/internal/config"
+	"sso/internal/domain/models"
+	"sso/internal/lib/envelope"
+	"sso/internal/storage"
+	"sso/internal/storage/postgres"
+	"sso/internal/storage/sqlite"
+)
+
+const usage = `usage:
+  profiles export [--config=PATH | --storage-path=PATH | --driver=postgres --dsn=DSN] [--key-file=PATH] [--format=jsonl|csv] [--out=PATH] [--salt=S] [--noise=SIGMA] [--drop-emails=true] [--key-events=none|hashed]
+  profiles import [--config=PATH | --storage-path=PATH | --driver=postgres --dsn=DSN] [--key-file=PATH] --in=PATH [--format=jsonl|csv]`
+
+// profileStorage is a storage profiles can be exported from and imported into.
+type profileStorage interface {
+	Profiles(ctx context.Context) ([]models.User, error)
+	SaveUser(ctx context.Context, email string, passHash []byte, pressTimes []float32, intervalTimes []float32, keyEvents []models.KeyEvent) (int64, error)
+	Close() error
+}
+
+// profiles exports keystroke profiles under pseudonymous ids for offline
+// research, and imports such an export into a fresh database. The database
+// and master key are taken from the server config (--config or CONFIG_PATH)
+// unless --storage-path or --dsn is given.
+func main() {
+	if len(os.Args) < 2 {
+		fmt.Fprintln(os.Stderr, usage)
+		os.Exit(2)
+	}
+
+	var err error
+	switch os.Args[1] {
+	case "export":
+		err = runExport(os.Args[2:])
+	case "import":
+		err = runImport(os.Args[2:])
+	default:
+		fmt.Fprintln(os.Stderr, usage)
+		os.Exit(2)
+	}
+	if err != nil {
+		panic(err)
+	}
+}
+
+func runExport(args []string) error {
+	var format, outPath, salt, keyEvents string
+	var noise float64
+	var dropEmails bool
+
+	fs := flag.NewFlagSet("export", flag.ExitOnError)
+	sf := addStorageFlags(fs)
+	fs.StringVar(&format, "format", formatJSONL, "output format: jsonl or csv")
+	fs.StringVar(&outPath, "out", "", "output path (default stdout)")
+	fs.StringVar(&salt, "salt", "", "pseudonym salt; exports with the same salt share pseudonyms (default random)")
+	fs.Float64Var(&noise, "noise", 0, "relative gaussian noise added to every timing, e.g. 0.05")
+	fs.BoolVar(&dropEmails, "drop-emails", true, "leave emails out of the export")
+	fs.StringVar(&keyEvents, "key-events", keyEventsNone, "key events: none exports the timing arrays only, hashed replaces key identities with an HMAC under a random per-export key")
+	_ = fs.Parse(args)
+
+	if keyEvents != keyEventsNone && keyEvents != keyEventsHashed {
+		return fmt.Errorf("unknown key-events %q", keyEvents)
+	}
+
+	saltBytes := []byte(salt)
+	if salt == "" {
+		saltBytes = make([]byte, 32)
+		if _, err := rand.Read(saltBytes); err != nil {
+			return err
+		}
+	}
+
+	// Key identities are the typed password characters. The key is never
+	// written anywhere, so hashed keys cannot be mapped back to characters.
+	eventKey := make([]byte, 32)
+	if _, err := rand.Read(eventKey); err != nil {
+		return err
+	}
+
+	ctx := context.Background()
+
+	st, err := sf.open(ctx)
+	if err != nil {
+		return err
+	}
+	defer st.Close()
+
+	users, err := st.Profiles(ctx)
+	if err != nil {
+		return err
+	}
+
+	out := io.Writer(os.Stdout)
+	if outPath != "" {
+		f, err := os.Create(outPath)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		out = f
+	}
+
+	w, err := newRecordWriter(out, format)
+	if err != nil {
+		return err
+	}
+
+	rnd := mathrand.New(mathrand.NewSource(time.Now().UnixNano()))
+	for _, u := range users {
+		rec := record{
+			Pseudonym:         pseudonym(saltBytes, u.ID),
+			KeyPressTimes:     u.PressTimes,
+			KeyPressIntervals: u.PressIntervals,
+		}
+		if keyEvents == keyEventsHashed {
+			rec.KeyEvents = hashKeys(eventKey, u.KeyEvents)
+		}
+		if !dropEmails {
+			rec.Email = u.Email
+		}
+		if noise > 0 {
+			addNoise(&rec, rnd, noise)
+		}
+
+		if err := w.Write(rec); err != nil {
+			return err
+		}
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+
+	fmt.Fprintf(os.Stderr, "exported %d profiles\n", len(users))
+	return nil
+}
+
+func runImport(args []string) error {
+	var format, inPath string
+
+	fs := flag.NewFlagSet("import", flag.ExitOnError)
+	sf := addStorageFlags(fs)
+	fs.StringVar(&format, "format", "", "input format: jsonl or csv (default by extension)")
+	fs.StringVar(&inPath, "in", "", "path to export")
+	_ = fs.Parse(args)
+
+	if inPath == "" {
+		return errors.New("in is required")
+	}
+	if format == "" {
+		format = formatJSONL
+		if strings.HasSuffix(inPath, ".csv") {
+			format = formatCSV
+		}
+	}
+
+	f, err := os.Open(inPath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	records, err := readRecords(f, format)
+	if err != nil {
+		return err
+	}
+
+	ctx := context.Background()
+
+	st, err := sf.open(ctx)
+	if err != nil {
+		return err
+	}
+	defer st.Close()
+
+	// Imported users can never log in: their password is random and unknown.
+	password := make([]byte, 32)
+	if _, err := rand.Read(password); err != nil {
+		return err
+	}
+	passHash, err := bcrypt.GenerateFromPassword(password, bcrypt.DefaultCost)
+	if err != nil {
+		return err
+	}
+
+	imported := 0
+	for _, rec := range records {
+		email := rec.Email
+		if email == "" {
+			email = rec.Pseudonym + "@profiles.invalid"
+		}
+
+		_, err := st.SaveUser(ctx, email, passHash, rec.KeyPressTimes, rec.KeyPressIntervals, rec.KeyEvents)
+		if err != nil {
+			if errors.Is(err, storage.ErrUserExists) {
+				fmt.Fprintf(os.Stderr, "skipping %s: already imported\n", rec.Pseudonym)
+				continue
+			}
+			return err
+		}
+		imported++
+	}
+
+	fmt.Fprintf(os.Stderr, "imported %d profiles\n", imported)
+	return nil
+}
+
+// storageFlags select the storage to export from or import into.
+type storageFlags struct {
+	configPath, driver, storagePath, dsn, keyFile string
+}
+
+func addStorageFlags(fs *flag.FlagSet) *storageFlags {
+	var sf storageFlags
+	fs.StringVar(&sf.configPath, "config", "", "server config to take the storage and master key from")
+	fs.StringVar(&sf.driver, "driver", "", "storage driver: sqlite or postgres (default from config or sqlite)")
+	fs.StringVar(&sf.storagePath, "storage-path", "", "path to a migrated storage")
+	fs.StringVar(&sf.dsn, "dsn", "", "postgres connection string")
+	fs.StringVar(&sf.keyFile, "key-file", "", "path to biometrics master key of that storage (default from config)")
+	return &sf
+}
+
+// open opens the selected storage. Flags take precedence over the config.
+func (sf *storageFlags) open(ctx context.Context) (profileStorage, error) {
+	driver, storagePath, dsn := sf.driver, sf.storagePath, sf.dsn
+	var masterKey, masterKeyFile string
+
+	configPath := sf.configPath
+	if configPath == "" && storagePath == "" && dsn == "" {
+		configPath = os.Getenv("CONFIG_PATH")
+	}
+	if configPath != "" {
+		cfg := config.MustLoadPath(configPath)
+		if driver == "" {
+			driver = cfg.StorageDriver()
+		}
+		if storagePath == "" {
+			storagePath = cfg.StoragePath
+		}
+		if dsn == "" {
+			dsn = cfg.Storage.DSN
+		}
+		masterKey, masterKeyFile = cfg.Biometrics.MasterKey, cfg.Biometrics.MasterKeyFile
+	}
+	if driver == "" {
+		driver = config.StorageDriverSQLite
+	}
+	if sf.keyFile != "" {
+		masterKey, masterKeyFile = "", sf.keyFile
+	}
+	if masterKey == "" && masterKeyFile == "" {
+		return nil, errors.New("key-file is required")
+	}
+
+	keyring, err := envelope.New(envelope.MustReadKey(masterKey, masterKeyFile))
+	if err != nil {
+		return nil, err
+	}
+
+	switch driver {
+	case config.StorageDriverSQLite:
+		if storagePath == "" {
+			return nil, errors.New("storage-path is required")
+		}
+		st, err := sqlite.New(storagePath, sqlite.DefaultOptions(), keyring)
+		if err != nil {
+			return nil, err
+		}
+		return st, nil
+	case config.StorageDriverPostgres:
+		if dsn == "" {
+			return nil, errors.New("dsn is required")
+		}
+		st, err := postgres.New(ctx, dsn, keyring)
+		if err != nil {
+			return nil, err
+		}
+		return st, nil
+	default:
+		return nil, fmt.Errorf("cannot export or import driver %q", driver)
+	}
+}
@@ -0,0 +1,232 @@
+package main
+
+import (
+	"bufio"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/csv"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"strings"
+
+	"sso/internal/domain/models"
+	"sso/internal/lib/converter"
+)
+
+const (
+	formatCSV   = "csv"
+	formatJSONL = "jsonl"
+)
+
+// Values of the export --key-events flag.
+const (
+	keyEventsNone   = "none"
+	keyEventsHashed = "hashed"
+)
+
+var csvHeader = []string{"pseudonym", "email", "key_press_times", "key_press_intervals", "key_events"}
+
+// record is a keystroke profile detached from the user row. It is keyed by
+// a pseudonym that cannot be linked back to the user id without the salt.
+type record struct {
+	Pseudonym         string            `json:"pseudonym"`
+	Email             string            `json:"email,omitempty"`
+	KeyPressTimes     []float32         `json:"key_press_times"`
+	KeyPressIntervals []float32         `json:"key_press_intervals"`
+	KeyEvents         []models.KeyEvent `json:"key_events,omitempty"`
+}
+
+func pseudonym(salt []byte, userID int64) string {
+	mac := hmac.New(sha256.New, salt)
+	_, _ = fmt.Fprintf(mac, "%d", userID)
+	return hex.EncodeToString(mac.Sum(nil))[:16]
+}
+
+// hashKeys returns a copy of events with every key identity replaced by its
+// HMAC under key. Equal keys keep equal hashes, so per-key features can still
+// be computed, but the typed characters are not exported.
+func hashKeys(key []byte, events []models.KeyEvent) []models.KeyEvent {
+	if len(events) == 0 {
+		return nil
+	}
+
+	hashed := make([]models.KeyEvent, len(events))
+	for i, e := range events {
+		mac := hmac.New(sha256.New, key)
+		_, _ = mac.Write([]byte(e.Key))
+		e.Key = hex.EncodeToString(mac.Sum(nil))[:16]
+		hashed[i] = e
+	}
+	return hashed
+}
+
+// addNoise scales every timing by a factor drawn from N(1, sigma), so that
+// exported values cannot be matched against the production templates.
+func addNoise(r *record, rnd *rand.Rand, sigma float64) {
+	noisy := func(v float64) float64 {
+		return v * (1 + rnd.NormFloat64()*sigma)
+	}
+
+	for i, v := range r.KeyPressTimes {
+		r.KeyPressTimes[i] = float32(noisy(float64(v)))
+	}
+	for i, v := range r.KeyPressIntervals {
+		r.KeyPressIntervals[i] = float32(noisy(float64(v)))
+	}
+
+	// Events keep their order: gaps and dwells are perturbed, not timestamps.
+	prevPressed, prevNoisy := 0.0, 0.0
+	for i, e := range r.KeyEvents {
+		pressedAt := prevNoisy
+		if i > 0 {
+			pressedAt += noisy(e.PressedAt - prevPressed)
+		}
+		prevPressed, prevNoisy = e.PressedAt, pressedAt
+
+		r.KeyEvents[i] = models.KeyEvent{
+			Key:        e.Key,
+			PressedAt:  pressedAt,
+			ReleasedAt: pressedAt + noisy(e.ReleasedAt-e.PressedAt),
+		}
+	}
+}
+
+type recordWriter interface {
+	Write(r record) error
+	Flush() error
+}
+
+func newRecordWriter(w io.Writer, format string) (recordWriter, error) {
+	switch format {
+	case formatJSONL:
+		return &jsonlWriter{w: bufio.NewWriter(w)}, nil
+	case formatCSV:
+		cw := csv.NewWriter(w)
+		if err := cw.Write(csvHeader); err != nil {
+			return nil, err
+		}
+		return &csvWriter{w: cw}, nil
+	}
+	return nil, fmt.Errorf("unknown format %q", format)
+}
+
+type jsonlWriter struct {
+	w *bufio.Writer
+}
+
+func (j *jsonlWriter) Write(r record) error {
+	raw, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	if _, err := j.w.Write(append(raw, '\n')); err != nil {
+		return err
+	}
+	return nil
+}
+
+func (j *jsonlWriter) Flush() error {
+	return j.w.Flush()
+}
+
+type csvWriter struct {
+	w *csv.Writer
+}
+
+func (c *csvWriter) Write(r record) error {
+	events := ""
+	if len(r.KeyEvents) > 0 {
+		raw, err := json.Marshal(r.KeyEvents)
+		if err != nil {
+			return err
+		}
+		events = string(raw)
+	}
+
+	return c.w.Write([]string{
+		r.Pseudonym,
+		r.Email,
+		converter.ToStringFromFloat32Slice(r.KeyPressTimes),
+		converter.ToStringFromFloat32Slice(r.KeyPressIntervals),
+		events,
+	})
+}
+
+func (c *csvWriter) Flush() error {
+	c.w.Flush()
+	return c.w.Error()
+}
+
+func readRecords(r io.Reader, format string) ([]record, error) {
+	switch format {
+	case formatJSONL:
+		return readJSONL(r)
+	case formatCSV:
+		return readCSV(r)
+	}
+	return nil, fmt.Errorf("unknown format %q", format)
+}
+
+func readJSONL(r io.Reader) ([]record, error) {
+	var records []record
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), 4*1024*1024)
+	line := 0
+	for scanner.Scan() {
+		line++
+		if strings.TrimSpace(scanner.Text()) == "" {
+			continue
+		}
+
+		var rec record
+		if err := json.Unmarshal(scanner.Bytes(), &rec); err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+		records = append(records, rec)
+	}
+
+	return records, scanner.Err()
+}
+
+func readCSV(r io.Reader) ([]record, error) {
+	cr := csv.NewReader(r)
+
+	header, err := cr.Read()
+	if err != nil {
+		return nil, err
+	}
+	if strings.Join(header, ",") != strings.Join(csvHeader, ",") {
+		return nil, errors.New("unexpected csv header")
+	}
+
+	var records []record
+	for {
+		row, err := cr.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		rec := record{
+			Pseudonym:         row[0],
+			Email:             row[1],
+			KeyPressTimes:     converter.ToFloat32SliceFromString(row[2]),
+			KeyPressIntervals: converter.ToFloat32SliceFromString(row[3]),
+		}
+		if row[4] != "" {
+			if err := json.Unmarshal([]byte(row[4]), &rec.KeyEvents); err != nil {
+				return nil, err
+			}
+		}
+		records = append(records, rec)
+	}
+
+	return records, nil
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"bytes"
+	"math/rand"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"sso/internal/domain/models"
+)
+
+func TestPseudonym(t *testing.T) {
+	salt := []byte("fixed salt")
+
+	assert.Equal(t, pseudonym(salt, 42), pseudonym(salt, 42), "equal salts must share pseudonyms")
+	assert.Len(t, pseudonym(salt, 42), 16)
+	assert.NotEqual(t, pseudonym(salt, 42), pseudonym(salt, 43))
+	assert.NotEqual(t, pseudonym(salt, 42), pseudonym([]byte("other salt"), 42))
+}
+
+func TestAddNoise(t *testing.T) {
+	rec := record{
+		KeyPressTimes:     []float32{95, 110, 102, 98},
+		KeyPressIntervals: []float32{180, 210, 190},
+		KeyEvents: []models.KeyEvent{
+			{Key: "a", PressedAt: 0, ReleasedAt: 95},
+			{Key: "b", PressedAt: 180, ReleasedAt: 290},
+			{Key: "c", PressedAt: 390, ReleasedAt: 492},
+			{Key: "d", PressedAt: 580, ReleasedAt: 678},
+		},
+	}
+	original := record{
+		KeyPressTimes:     append([]float32(nil), rec.KeyPressTimes...),
+		KeyPressIntervals: append([]float32(nil), rec.KeyPressIntervals...),
+		KeyEvents:         append([]models.KeyEvent(nil), rec.KeyEvents...),
+	}
+
+	addNoise(&rec, rand.New(rand.NewSource(1)), 0.05)
+
+	require.Len(t, rec.KeyPressTimes, len(original.KeyPressTimes))
+	require.Len(t, rec.KeyPressIntervals, len(original.KeyPressIntervals))
+	require.Len(t, rec.KeyEvents, len(original.KeyEvents))
+
+	assert.NotEqual(t, original.KeyPressTimes, rec.KeyPressTimes)
+	for i, v := range rec.KeyPressTimes {
+		assert.Positive(t, v)
+		assert.InEpsilon(t, original.KeyPressTimes[i], v, 0.3)
+	}
+	for i, v := range rec.KeyPressIntervals {
+		assert.Positive(t, v)
+		assert.InEpsilon(t, original.KeyPressIntervals[i], v, 0.3)
+	}
+
+	assert.Zero(t, rec.KeyEvents[0].PressedAt, "the first press stays at the origin")
+	for i, e := range rec.KeyEvents {
+		assert.Equal(t, original.KeyEvents[i].Key, e.Key)
+		assert.Greater(t, e.ReleasedAt, e.PressedAt)
+		if i > 0 {
+			assert.Greater(t, e.PressedAt, rec.KeyEvents[i-1].PressedAt, "events keep their order")
+		}
+	}
+}
+
+func TestRecordsRoundTrip(t *testing.T) {
+	records := []record{
+		{
+			Pseudonym:         pseudonym([]byte("salt"), 1),
+			Email:             "alice@example.com",
+			KeyPressTimes:     []float32{95.5, 110, 102.25},
+			KeyPressIntervals: []float32{180, 210.75},
+			KeyEvents: hashKeys([]byte("key"), []models.KeyEvent{
+				{Key: "a", PressedAt: 0, ReleasedAt: 95.5},
+				{Key: "b", PressedAt: 180, ReleasedAt: 290},
+			}),
+		},
+		{
+			Pseudonym:         pseudonym([]byte("salt"), 2),
+			KeyPressTimes:     []float32{80, 85},
+			KeyPressIntervals: []float32{150},
+		},
+	}
+
+	for _, format := range []string{formatJSONL, formatCSV} {
+		t.Run(format, func(t *testing.T) {
+			var buf bytes.Buffer
+			w, err := newRecordWriter(&buf, format)
+			require.NoError(t, err)
+			for _, rec := range records {
+				require.NoError(t, w.Write(rec))
+			}
+			require.NoError(t, w.Flush())
+
+			got, err := readRecords(&buf, format)
+			require.NoError(t, err)
+			assert.Equal(t, records, got)
+		})
+	}
+}
+
+func TestReadRecords_UnknownFormat(t *testing.T) {
+	_, err := readRecords(bytes.NewReader(nil), "xml")
+	assert.Error(t, err)
+}
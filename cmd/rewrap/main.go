@@ -0,0 +1,101 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+
+	"sso/internal/config"
+	"sso/internal/lib/envelope"
+	"sso/internal/storage/postgres"
+	"sso/internal/storage/sqlite"
+)
+
+// rewrapper is a storage whose data keys can be wrapped with a new master key.
+type rewrapper interface {
+	Rewrap(ctx context.Context, next *envelope.Keyring) (int, error)
+	Close() error
+}
+
+// rewrap rotates the master key of biometric templates and app secrets:
+// every data key is unwrapped with the old master key and wrapped again with
+// the new one. Without --old-key-file it only encrypts templates and secrets
+// stored in plain text. The database is taken from the server config
+// (--config or CONFIG_PATH) unless --storage-path or --dsn is given.
+func main() {
+	var configPath, driver, storagePath, dsn, oldKeyFile, newKeyFile string
+
+	flag.StringVar(&configPath, "config", "", "server config to take the storage from")
+	flag.StringVar(&driver, "driver", "", "storage driver: sqlite or postgres (default from config or sqlite)")
+	flag.StringVar(&storagePath, "storage-path", "", "path to storage")
+	flag.StringVar(&dsn, "dsn", "", "postgres connection string")
+	flag.StringVar(&oldKeyFile, "old-key-file", "", "path to current master key")
+	flag.StringVar(&newKeyFile, "new-key-file", "", "path to new master key")
+	flag.Parse()
+
+	if newKeyFile == "" {
+		panic("new-key-file is required")
+	}
+
+	if configPath == "" && storagePath == "" && dsn == "" {
+		configPath = os.Getenv("CONFIG_PATH")
+	}
+	if configPath != "" {
+		cfg := config.MustLoadPath(configPath)
+		if driver == "" {
+			driver = cfg.StorageDriver()
+		}
+		if storagePath == "" {
+			storagePath = cfg.StoragePath
+		}
+		if dsn == "" {
+			dsn = cfg.Storage.DSN
+		}
+	}
+	if driver == "" {
+		driver = config.StorageDriverSQLite
+	}
+
+	next, err := envelope.New(envelope.MustReadKey("", newKeyFile))
+	if err != nil {
+		panic(err)
+	}
+
+	current := next
+	if oldKeyFile != "" {
+		current, err = envelope.New(envelope.MustReadKey("", oldKeyFile))
+		if err != nil {
+			panic(err)
+		}
+	}
+
+	ctx := context.Background()
+
+	var storage rewrapper
+	switch driver {
+	case config.StorageDriverSQLite:
+		if storagePath == "" {
+			panic("storage-path is required")
+		}
+		storage, err = sqlite.New(storagePath, sqlite.DefaultOptions(), current)
+	case config.StorageDriverPostgres:
+		if dsn == "" {
+			panic("dsn is required")
+		}
+		storage, err = postgres.New(ctx, dsn, current)
+	default:
+		panic("cannot rewrap driver: " + driver)
+	}
+	if err != nil {
+		panic(err)
+	}
+	defer storage.Close()
+
+	n, err := storage.Rewrap(ctx, next)
+	if err != nil {
+		panic(err)
+	}
+
+	fmt.Printf("rewrapped %d templates and app secrets\n", n)
+}
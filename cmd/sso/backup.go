@@ -0,0 +1,136 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"sso/internal/backup"
+	"sso/internal/config"
+	"sso/internal/lib/envelope"
+	"sso/internal/lib/logger/sl"
+)
+
+// runBackup implements sso backup: it snapshots the sqlite storage of the
+// config, which is safe while the server runs, and prunes old snapshots.
+func runBackup(args []string) {
+	fs := flag.NewFlagSet("backup", flag.ExitOnError)
+	configPath := fs.String("config", os.Getenv("CONFIG_PATH"), "config path")
+	dir := fs.String("dir", "", "directory to write the snapshot to (default from config)")
+	keep := fs.Int("keep", -1, "number of snapshots to keep, 0 keeps all (default from config)")
+	compress := fs.Bool("compress", false, "gzip the snapshot (default from config)")
+	encrypt := fs.Bool("encrypt", false, "encrypt the snapshot with the master key (default from config)")
+	_ = fs.Parse(args)
+
+	cfg := mustLoadSQLiteConfig(*configPath)
+	log := setupLogger(cfg.Env)
+
+	set := setFlags(fs)
+	if !set["dir"] {
+		*dir = cfg.Backup.Dir
+	}
+	if !set["keep"] {
+		*keep = cfg.Backup.Keep
+	}
+	if !set["compress"] {
+		*compress = cfg.Backup.Compress
+	}
+	if !set["encrypt"] {
+		*encrypt = cfg.Backup.Encrypt
+	}
+
+	opts := backup.Options{Dir: *dir, Compress: *compress}
+	if *encrypt {
+		opts.Keyring = mustKeyring(cfg)
+	}
+
+	path, err := backup.Create(context.Background(), cfg.StoragePath, opts)
+	if err != nil {
+		log.Error("failed to back up storage", sl.Err(err))
+		os.Exit(1)
+	}
+	log.Info("backup done", slog.String("path", path))
+
+	removed, err := backup.Prune(*dir, *keep)
+	if err != nil {
+		log.Error("failed to prune snapshots", sl.Err(err))
+		os.Exit(1)
+	}
+	for _, path := range removed {
+		log.Info("snapshot removed", slog.String("path", path))
+	}
+}
+
+// runRestore implements sso restore: it replaces the sqlite storage of the
+// config with a snapshot. The server must be stopped.
+func runRestore(args []string) {
+	fs := flag.NewFlagSet("restore", flag.ExitOnError)
+	configPath := fs.String("config", os.Getenv("CONFIG_PATH"), "config path")
+	migrate := fs.Bool("migrate", false, "migrate a snapshot taken with an older schema")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: sso restore [flags] snapshot")
+		fs.PrintDefaults()
+	}
+	_ = fs.Parse(args)
+
+	if fs.NArg() != 1 {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	cfg := mustLoadSQLiteConfig(*configPath)
+	log := setupLogger(cfg.Env)
+
+	opts := backup.RestoreOptions{Migrate: *migrate}
+	// The key is only needed for encrypted snapshots.
+	if key, err := envelope.ReadKey(cfg.Biometrics.MasterKey, cfg.Biometrics.MasterKeyFile); err == nil {
+		opts.Keyring, err = envelope.New(key)
+		if err != nil {
+			panic(err)
+		}
+	} else if !errors.Is(err, envelope.ErrMasterKeyRequired) {
+		panic(err)
+	}
+
+	previous, err := backup.Restore(context.Background(), fs.Arg(0), cfg.StoragePath, opts)
+	if err != nil {
+		log.Error("failed to restore storage", sl.Err(err))
+		os.Exit(1)
+	}
+
+	log.Info("restore done",
+		slog.String("snapshot", fs.Arg(0)),
+		slog.String("storage", cfg.StoragePath),
+		slog.String("previous", previous),
+	)
+}
+
+func mustLoadSQLiteConfig(path string) *config.Config {
+	if path == "" {
+		panic("config path is empty")
+	}
+
+	cfg := config.MustLoadPath(path)
+	if cfg.StorageDriver() != config.StorageDriverSQLite {
+		panic("backup and restore support the sqlite storage only, use pg_dump for postgres")
+	}
+
+	return cfg
+}
+
+func mustKeyring(cfg *config.Config) *envelope.Keyring {
+	keyring, err := envelope.New(envelope.MustReadKey(cfg.Biometrics.MasterKey, cfg.Biometrics.MasterKeyFile))
+	if err != nil {
+		panic(err)
+	}
+	return keyring
+}
+
+// setFlags returns the flags given on the command line.
+func setFlags(fs *flag.FlagSet) map[string]bool {
+	set := make(map[string]bool)
+	fs.Visit(func(f *flag.Flag) { set[f.Name] = true })
+	return set
+}
@@ -1,13 +1,26 @@
 package main
 
 import (
+	"flag"
 	"log/slog"
 	"os"
 	"os/signal"
 	"sso/internal/app"
 	"sso/internal/config"
+	"sso/internal/domain/models"
+	"sso/internal/lib/biometrics"
+	"sso/internal/lib/envelope"
 	"sso/internal/lib/logger/handlers/slogpretty"
+	"sso/internal/lib/logger/sl"
+	"sso/internal/lib/mailer"
+	"sso/internal/services/auth"
+	"sso/internal/services/risk"
+	"sso/internal/storage/schema"
+	"sso/internal/storage/sqlite"
 	"syscall"
+	// IANA time zones of user profiles are validated without relying on
+	// the zoneinfo of the host.
+	_ "time/tzdata"
 )
 
 const (
@@ -17,14 +30,42 @@ const (
 )
 
 func main() {
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "backup":
+			runBackup(os.Args[2:])
+			return
+		case "restore":
+			runRestore(os.Args[2:])
+			return
+		}
+	}
+
+	// --migrate applies the embedded migrations and exits.
+	migrateOnly := flag.Bool("migrate", false, "apply storage migrations and exit")
+
 	cfg := config.MustLoad()
 
 	log := setupLogger(cfg.Env)
 
+	if *migrateOnly {
+		runMigrations(log, cfg)
+		return
+	}
+
 	log.Info("Starting application",
 		slog.Any("config", cfg))
 
-	application := app.New(log, cfg.GRPC.Port, cfg.StoragePath, cfg.TokenTTL)
+	masterKey := envelope.MustReadKey(cfg.Biometrics.MasterKey, cfg.Biometrics.MasterKeyFile)
+
+	matcher := biometrics.NewMatcher(cfg.Biometrics.LowerThreshold, cfg.Biometrics.UpperThreshold, cfg.Biometrics.FeatureTolerance)
+
+	riskEngine := risk.New(log, risk.Thresholds{
+		StepUp: cfg.Risk.StepUpThreshold,
+		Deny:   cfg.Risk.DenyThreshold,
+	}).UseDefaultSignals()
+
+	application := app.New(log, cfg.GRPC.Port, cfg.StorageDriver(), cfg.StorageSource(), sqliteOptions(cfg.Storage.SQLite), cfg.Storage.AutoMigrate, seedApps(cfg.Storage.Apps), masterKey, newMailer(log, cfg.Mail), matcher, riskEngine, cfg.TokenTTL, cfg.TokenIssuer, cfg.AdminAppID, cfg.Account.DeletionGracePeriod, cfg.Account.PurgeInterval)
 
 	go application.GRPCSrv.MustRun()
 
@@ -36,23 +77,86 @@ func main() {
 
 	log.Info("stopping application", slog.String("signal", signal.String()))
 
-	application.GRPCSrv.Stop()
+	application.Stop()
 
 	log.Info("Application stopped")
 }
 
+func runMigrations(log *slog.Logger, cfg *config.Config) {
+	if cfg.StorageDriver() == config.StorageDriverMemory {
+		log.Info("memory storage has no schema, nothing to migrate")
+		return
+	}
+
+	if err := schema.Up(cfg.StorageDriver(), cfg.StorageSource()); err != nil {
+		log.Error("failed to migrate storage", sl.Err(err))
+		os.Exit(1)
+	}
+
+	status, err := schema.Check(cfg.StorageDriver(), cfg.StorageSource())
+	if err != nil {
+		log.Error("failed to check storage schema", sl.Err(err))
+		os.Exit(1)
+	}
+
+	log.Info("migrations done", slog.Uint64("version", uint64(status.Current)))
+}
+
+func sqliteOptions(cfg config.SQLiteConfig) sqlite.Options {
+	return sqlite.Options{
+		JournalMode:     cfg.JournalMode,
+		BusyTimeout:     cfg.BusyTimeout,
+		ForeignKeys:     cfg.ForeignKeys,
+		Synchronous:     cfg.Synchronous,
+		MaxOpenConns:    cfg.MaxOpenConns,
+		MaxIdleConns:    cfg.MaxIdleConns,
+		ConnMaxLifetime: cfg.ConnMaxLifetime,
+	}
+}
+
+func newMailer(log *slog.Logger, cfg config.MailConfig) auth.EmailSender {
+	if cfg.SMTPHost == "" {
+		log.Warn("no smtp host configured, verification codes are written to the log")
+		return mailer.NewLog(log)
+	}
+	return mailer.NewSMTP(cfg.SMTPHost, cfg.SMTPPort, cfg.From, cfg.Username, cfg.Password)
+}
+
+func seedApps(apps []config.AppConfig) []models.App {
+	result := make([]models.App, 0, len(apps))
+	for _, a := range apps {
+		result = append(result, models.App{
+			ID:     a.ID,
+			Name:   a.Name,
+			Secret: a.Secret,
+			// same default as the apps table
+			ContinuousAuthThreshold: 0.5,
+			TokenTTL:                a.TokenTTL,
+			Audience:                a.Audience,
+			Issuer:                  a.Issuer,
+			ClaimsTemplate:          a.Claims,
+		})
+	}
+	return result
+}
+
 func setupLogger(env string) *slog.Logger {
 	var log *slog.Logger
 
 	switch env {
 	case envLocal:
 		log = setupPrettySlog()
+	case envDev:
+		log = slog.New(slog.NewJSONHandler(os.Stdout, &slog.HandlerOptions{Level: slog.LevelDebug}))
 	case envProd:
 		// maybe switch to texthandler
 		log = slog.New(slog.NewJSONHandler(os.Stdout, &slog.HandlerOptions{Level: slog.LevelInfo}))
+	default:
+		panic("unknown env: " + env)
 	}
 
-	return log
+	// records logged with a request context carry its request ID
+	return slog.New(sl.NewContextHandler(log.Handler()))
 }
 
 func setupPrettySlog() *slog.Logger {
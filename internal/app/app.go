@@ -1,26 +1,141 @@
 package app
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"log/slog"
 	grpcapp "sso/internal/app/grpc"
+	"sso/internal/config"
+	"sso/internal/domain/models"
+	"sso/internal/lib/biometrics"
+	"sso/internal/lib/envelope"
 	"sso/internal/services/auth"
+	"sso/internal/services/risk"
+	"sso/internal/storage/memory"
+	"sso/internal/storage/postgres"
+	"sso/internal/storage/schema"
 	"sso/internal/storage/sqlite"
 	"time"
 )
 
+var ErrUnknownStorageDriver = errors.New("unknown storage driver")
+
 type App struct {
 	GRPCSrv *grpcapp.App
+
+	stopPurge context.CancelFunc
 }
 
-func New(log *slog.Logger, grpcPort int, storagepath string, tokenTTL time.Duration) *App {
-	storage, err := sqlite.New(storagepath)
+// Storage is everything the auth service needs from a storage backend.
+type Storage interface {
+	auth.UserSaver
+	auth.UserProvider
+	auth.AppProvider
+	auth.AppManager
+	auth.SessionStorage
+	auth.LoginHistory
+	auth.Transactor
+	auth.AuditLog
+}
+
+// AppSeeder is implemented by storages that can be seeded with apps.
+type AppSeeder interface {
+	SaveApp(ctx context.Context, app models.App) error
+}
+
+// New wires the application. storageDriver is one of config.StorageDriver*;
+// storageSource is the sqlite database path or the postgres DSN, sqliteOpts
+// tune the sqlite connections. seedApps are
+// added to storages implementing AppSeeder. With autoMigrate the schema is
+// migrated first; otherwise New panics if the schema is not up to date.
+// Accounts whose deletion grace period is over are purged every
+// purgeInterval until Stop is called.
+func New(log *slog.Logger, grpcPort int, storageDriver string, storageSource string, sqliteOpts sqlite.Options, autoMigrate bool, seedApps []models.App, masterKey []byte, mailer auth.EmailSender, matcher biometrics.Matcher, riskEngine *risk.Engine, tokenTTL time.Duration, tokenIssuer string, adminAppID int, deletionGrace time.Duration, purgeInterval time.Duration) *App {
+	keyring, err := envelope.New(masterKey)
+	if err != nil {
+		panic(err)
+	}
+	status, err := schema.Ensure(storageDriver, storageSource, autoMigrate)
 	if err != nil {
 		panic(err)
 	}
-	authService := auth.New(log, storage, storage, storage, tokenTTL)
-	grpcApp := grpcapp.New(log, authService, grpcPort)
+	if storageDriver != config.StorageDriverMemory {
+		log.Info("storage schema is up to date", slog.Uint64("version", uint64(status.Current)))
+	}
+	storage, err := NewStorage(context.Background(), storageDriver, storageSource, sqliteOpts, keyring)
+	if err != nil {
+		panic(err)
+	}
+	if err := seed(context.Background(), storage, seedApps); err != nil {
+		panic(err)
+	}
+	authService := auth.New(log, storage, storage, storage, storage, storage, storage, storage, storage, mailer, matcher, riskEngine, tokenTTL, tokenIssuer, adminAppID, deletionGrace)
+	grpcApp := grpcapp.New(log, authService, authService, authService, grpcPort)
+
+	purgeCtx, stopPurge := context.WithCancel(context.Background())
+	if purgeInterval > 0 {
+		go authService.RunPurge(purgeCtx, purgeInterval)
+	}
 
 	return &App{
-		GRPCSrv: grpcApp,
+		GRPCSrv:   grpcApp,
+		stopPurge: stopPurge,
 	}
 }
+
+// Stop stops the gRPC server and the purge of deleted accounts.
+func (a *App) Stop() {
+	a.stopPurge()
+	a.GRPCSrv.Stop()
+}
+
+// NewStorage opens the storage backend selected by driver.
+func NewStorage(ctx context.Context, driver string, source string, sqliteOpts sqlite.Options, keyring *envelope.Keyring) (Storage, error) {
+	const op = "app.NewStorage"
+
+	if driver == config.StorageDriverMemory {
+		return memory.New(), nil
+	}
+
+	if source == "" {
+		return nil, fmt.Errorf("%s: storage source is required for driver %q", op, driver)
+	}
+
+	switch driver {
+	case config.StorageDriverSQLite, "":
+		storage, err := sqlite.New(source, sqliteOpts, keyring)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", op, err)
+		}
+		return storage, nil
+	case config.StorageDriverPostgres:
+		storage, err := postgres.New(ctx, source, keyring)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", op, err)
+		}
+		return storage, nil
+	default:
+		return nil, fmt.Errorf("%s: %w: %q", op, ErrUnknownStorageDriver, driver)
+	}
+}
+
+func seed(ctx context.Context, storage Storage, apps []models.App) error {
+	const op = "app.seed"
+
+	if len(apps) == 0 {
+		return nil
+	}
+
+	seeder, ok := storage.(AppSeeder)
+	if !ok {
+		return fmt.Errorf("%s: storage does not support seeding apps", op)
+	}
+	for _, app := range apps {
+		if err := seeder.SaveApp(ctx, app); err != nil {
+			return fmt.Errorf("%s: %w", op, err)
+		}
+	}
+
+	return nil
+}
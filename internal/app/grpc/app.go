@@ -5,7 +5,10 @@ import (
 	"google.golang.org/grpc"
 	"log/slog"
 	"net"
+	admingrpc "sso/internal/grpc/admin"
+	appsgrpc "sso/internal/grpc/apps"
 	authgrpc "sso/internal/grpc/auth"
+	"sso/internal/grpc/interceptors"
 )
 
 type App struct {
@@ -14,9 +17,22 @@ type App struct {
 	port       int
 }
 
-func New(log *slog.Logger, authService authgrpc.Auth, port int) *App {
-	gRPCServer := grpc.NewServer()
+func New(log *slog.Logger, authService authgrpc.Auth, adminService admingrpc.Admin, appsService appsgrpc.Apps, port int) *App {
+	gRPCServer := grpc.NewServer(
+		grpc.ChainUnaryInterceptor(
+			interceptors.RequestID(),
+			interceptors.Logging(log),
+			interceptors.Recovery(log),
+		),
+		grpc.ChainStreamInterceptor(
+			interceptors.StreamRequestID(),
+			interceptors.StreamLogging(log),
+			interceptors.StreamRecovery(log),
+		),
+	)
 	authgrpc.Register(gRPCServer, authService)
+	admingrpc.Register(gRPCServer, adminService)
+	appsgrpc.Register(gRPCServer, appsService)
 
 	return &App{
 		log:        log,
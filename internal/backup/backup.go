@@ -0,0 +1,384 @@
+// Package backup takes snapshots of the sqlite storage and restores them.
+//
+// A snapshot is a copy of the database taken with the SQLite online backup
+// API, optionally gzip compressed and then encrypted with a data key wrapped
+// by the biometrics master key. Snapshots are named after the time they were
+// taken, so sorting them by name sorts them by age.
+package backup
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"sso/internal/config"
+	"sso/internal/lib/envelope"
+	"sso/internal/storage/schema"
+	"sso/internal/storage/sqlite"
+	"strings"
+	"time"
+)
+
+const (
+	filePrefix = "sso-"
+	dbExt      = ".db"
+	gzipExt    = ".gz"
+	encExt     = ".enc"
+	timeFormat = "20060102T150405.000Z"
+)
+
+var (
+	// encryptedMagic starts every encrypted snapshot.
+	encryptedMagic = []byte("SSOBACKUP1\n")
+	gzipMagic      = []byte{0x1f, 0x8b}
+	sqliteMagic    = []byte("SQLite format 3\x00")
+
+	// journalSuffixes name the files sqlite keeps next to a database.
+	journalSuffixes = []string{"-wal", "-shm", "-journal"}
+)
+
+var (
+	ErrStorageNotFound   = errors.New("storage not found")
+	ErrKeyringRequired   = errors.New("snapshot is encrypted, master key is required")
+	ErrMalformedSnapshot = errors.New("malformed snapshot")
+	ErrSchemaMismatch    = errors.New("snapshot schema does not match the binary")
+)
+
+// Options configures Create.
+type Options struct {
+	Dir      string
+	Compress bool
+	// Keyring encrypts the snapshot when set.
+	Keyring *envelope.Keyring
+}
+
+// Create takes a snapshot of the database at storagePath while it may be in
+// use and stores it in opts.Dir. It returns the path of the snapshot.
+func Create(ctx context.Context, storagePath string, opts Options) (string, error) {
+	const op = "backup.Create"
+
+	if _, err := os.Stat(storagePath); err != nil {
+		return "", fmt.Errorf("%s: %w: %s", op, ErrStorageNotFound, storagePath)
+	}
+	if err := os.MkdirAll(opts.Dir, 0o700); err != nil {
+		return "", fmt.Errorf("%s: %w", op, err)
+	}
+
+	tmp, err := tempFile(opts.Dir)
+	if err != nil {
+		return "", fmt.Errorf("%s: %w", op, err)
+	}
+	defer os.Remove(tmp)
+
+	if err := sqlite.Backup(ctx, storagePath, tmp); err != nil {
+		return "", fmt.Errorf("%s: %w", op, err)
+	}
+
+	name := filePrefix + time.Now().UTC().Format(timeFormat) + dbExt
+	if opts.Compress {
+		name += gzipExt
+	}
+	if opts.Keyring != nil {
+		name += encExt
+	}
+	path := filepath.Join(opts.Dir, name)
+
+	if err := encode(tmp, opts.Compress, opts.Keyring); err != nil {
+		return "", fmt.Errorf("%s: %w", op, err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return "", fmt.Errorf("%s: %w", op, err)
+	}
+
+	return path, nil
+}
+
+// encode compresses and encrypts the snapshot at path in place.
+func encode(path string, compress bool, keyring *envelope.Keyring) error {
+	if !compress && keyring == nil {
+		return nil
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	if compress {
+		var buf bytes.Buffer
+		zw := gzip.NewWriter(&buf)
+		if _, err := zw.Write(data); err != nil {
+			return err
+		}
+		if err := zw.Close(); err != nil {
+			return err
+		}
+		data = buf.Bytes()
+	}
+
+	if keyring != nil {
+		data, err = encrypt(keyring, data)
+		if err != nil {
+			return err
+		}
+	}
+
+	return os.WriteFile(path, data, 0o600)
+}
+
+// encrypt returns magic || len(wrapped key) || wrapped key || ciphertext.
+func encrypt(keyring *envelope.Keyring, plaintext []byte) ([]byte, error) {
+	dataKey, wrapped, err := keyring.NewDataKey()
+	if err != nil {
+		return nil, err
+	}
+
+	ciphertext, err := envelope.SealBytes(dataKey, plaintext)
+	if err != nil {
+		return nil, err
+	}
+
+	out := make([]byte, 0, len(encryptedMagic)+4+len(wrapped)+len(ciphertext))
+	out = append(out, encryptedMagic...)
+	out = binary.BigEndian.AppendUint32(out, uint32(len(wrapped)))
+	out = append(out, wrapped...)
+	return append(out, ciphertext...), nil
+}
+
+func decrypt(keyring *envelope.Keyring, data []byte) ([]byte, error) {
+	data = data[len(encryptedMagic):]
+	if len(data) < 4 {
+		return nil, ErrMalformedSnapshot
+	}
+	n := binary.BigEndian.Uint32(data)
+	data = data[4:]
+	if uint32(len(data)) < n {
+		return nil, ErrMalformedSnapshot
+	}
+
+	dataKey, err := keyring.Unwrap(data[:n])
+	if err != nil {
+		return nil, err
+	}
+
+	return envelope.OpenBytes(dataKey, data[n:])
+}
+
+// decode undoes encode; the format is detected from the content.
+func decode(data []byte, keyring *envelope.Keyring) ([]byte, error) {
+	if bytes.HasPrefix(data, encryptedMagic) {
+		if keyring == nil {
+			return nil, ErrKeyringRequired
+		}
+		var err error
+		data, err = decrypt(keyring, data)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if bytes.HasPrefix(data, gzipMagic) {
+		zr, err := gzip.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return nil, err
+		}
+		data, err = io.ReadAll(zr)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if !bytes.HasPrefix(data, sqliteMagic) {
+		return nil, ErrMalformedSnapshot
+	}
+
+	return data, nil
+}
+
+// RestoreOptions configures Restore.
+type RestoreOptions struct {
+	// Keyring decrypts encrypted snapshots.
+	Keyring *envelope.Keyring
+	// Migrate applies pending migrations to a snapshot taken with an older
+	// schema; without it such a snapshot is refused.
+	Migrate bool
+}
+
+// Restore replaces the database at storagePath with a snapshot. The snapshot
+// is decoded next to the database and must pass an integrity check and have
+// the schema version of the binary before the files are swapped. The
+// replaced database is kept and its path returned; it is empty if there was
+// none. The server must be stopped while restoring.
+func Restore(ctx context.Context, snapshotPath string, storagePath string, opts RestoreOptions) (string, error) {
+	const op = "backup.Restore"
+
+	data, err := os.ReadFile(snapshotPath)
+	if err != nil {
+		return "", fmt.Errorf("%s: %w", op, err)
+	}
+	data, err = decode(data, opts.Keyring)
+	if err != nil {
+		return "", fmt.Errorf("%s: %w", op, err)
+	}
+
+	tmp, err := tempFile(filepath.Dir(storagePath))
+	if err != nil {
+		return "", fmt.Errorf("%s: %w", op, err)
+	}
+	defer os.Remove(tmp)
+
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		return "", fmt.Errorf("%s: %w", op, err)
+	}
+
+	if err := verify(ctx, tmp, opts.Migrate); err != nil {
+		return "", fmt.Errorf("%s: %w", op, err)
+	}
+
+	previous, err := swap(tmp, storagePath)
+	if err != nil {
+		return "", fmt.Errorf("%s: %w", op, err)
+	}
+
+	return previous, nil
+}
+
+func verify(ctx context.Context, path string, migrate bool) error {
+	if err := sqlite.IntegrityCheck(ctx, path); err != nil {
+		return err
+	}
+
+	if migrate {
+		if err := schema.Up(config.StorageDriverSQLite, path); err != nil {
+			return err
+		}
+	}
+
+	status, err := schema.Check(config.StorageDriverSQLite, path)
+	if err != nil {
+		return err
+	}
+	switch {
+	case status.Dirty:
+		return fmt.Errorf("%w: version %d is dirty", ErrSchemaMismatch, status.Current)
+	case status.Current != status.Latest:
+		return fmt.Errorf("%w: version %d, want %d", ErrSchemaMismatch, status.Current, status.Latest)
+	}
+
+	return nil
+}
+
+// swap moves the database at storagePath aside together with its journal
+// files and puts the file at path in its place. If any rename fails, the
+// earlier ones are undone so the database is never left split between the
+// two names.
+func swap(path string, storagePath string) (previous string, err error) {
+	var renamed [][2]string
+	rename := func(from, to string) error {
+		if err := os.Rename(from, to); err != nil {
+			return err
+		}
+		renamed = append(renamed, [2]string{from, to})
+		return nil
+	}
+	defer func() {
+		if err == nil {
+			return
+		}
+		for i := len(renamed) - 1; i >= 0; i-- {
+			if rbErr := os.Rename(renamed[i][1], renamed[i][0]); rbErr != nil {
+				err = errors.Join(err, fmt.Errorf("roll back %s: %w", renamed[i][1], rbErr))
+			}
+		}
+		previous = ""
+	}()
+
+	if _, err := os.Stat(storagePath); err == nil {
+		previous = storagePath + ".pre-restore-" + time.Now().UTC().Format(timeFormat)
+		if err := rename(storagePath, previous); err != nil {
+			return "", err
+		}
+		for _, suffix := range journalSuffixes {
+			if _, err := os.Stat(storagePath + suffix); err == nil {
+				if err := rename(storagePath+suffix, previous+suffix); err != nil {
+					return "", err
+				}
+			}
+		}
+	}
+
+	if err := rename(path, storagePath); err != nil {
+		return "", err
+	}
+
+	return previous, nil
+}
+
+// List returns the snapshots in dir, oldest first.
+func List(dir string) ([]string, error) {
+	const op = "backup.List"
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	var snapshots []string
+	for _, e := range entries {
+		if e.Type().IsRegular() && strings.HasPrefix(e.Name(), filePrefix) && strings.Contains(e.Name(), dbExt) {
+			snapshots = append(snapshots, filepath.Join(dir, e.Name()))
+		}
+	}
+	sort.Strings(snapshots)
+
+	return snapshots, nil
+}
+
+// Prune removes all but the keep newest snapshots in dir and returns the
+// removed ones. keep 0 keeps all of them.
+func Prune(dir string, keep int) ([]string, error) {
+	const op = "backup.Prune"
+
+	if keep <= 0 {
+		return nil, nil
+	}
+
+	snapshots, err := List(dir)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	if len(snapshots) <= keep {
+		return nil, nil
+	}
+
+	removed := snapshots[:len(snapshots)-keep]
+	for _, path := range removed {
+		if err := os.Remove(path); err != nil {
+			return nil, fmt.Errorf("%s: %w", op, err)
+		}
+	}
+
+	return removed, nil
+}
+
+// tempFile returns the path of a new empty file in dir; dot files are not
+// taken for snapshots.
+func tempFile(dir string) (string, error) {
+	f, err := os.CreateTemp(dir, ".sso-backup-*")
+	if err != nil {
+		return "", err
+	}
+	if err := f.Close(); err != nil {
+		return "", err
+	}
+	return f.Name(), nil
+}
@@ -0,0 +1,171 @@
+package backup_test
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"os"
+	"path/filepath"
+	"sso/internal/backup"
+	"sso/internal/config"
+	"sso/internal/lib/envelope"
+	"sso/internal/storage/schema"
+	"testing"
+	"time"
+)
+
+func newDB(t *testing.T) string {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "sso.db")
+	require.NoError(t, schema.Up(config.StorageDriverSQLite, path))
+	return path
+}
+
+func exec(t *testing.T, path string, query string, args ...any) {
+	t.Helper()
+
+	db, err := sql.Open("sqlite3", path)
+	require.NoError(t, err)
+	defer db.Close()
+
+	_, err = db.Exec(query, args...)
+	require.NoError(t, err)
+}
+
+func appNames(t *testing.T, path string) []string {
+	t.Helper()
+
+	db, err := sql.Open("sqlite3", path)
+	require.NoError(t, err)
+	defer db.Close()
+
+	rows, err := db.Query("SELECT name FROM apps ORDER BY id")
+	require.NoError(t, err)
+	defer rows.Close()
+
+	var names []string
+	for rows.Next() {
+		var name string
+		require.NoError(t, rows.Scan(&name))
+		names = append(names, name)
+	}
+	require.NoError(t, rows.Err())
+	return names
+}
+
+func newKeyring(t *testing.T) *envelope.Keyring {
+	t.Helper()
+
+	key := make([]byte, envelope.KeySize)
+	_, err := rand.Read(key)
+	require.NoError(t, err)
+
+	keyring, err := envelope.New(key)
+	require.NoError(t, err)
+	return keyring
+}
+
+func TestCreateAndRestore(t *testing.T) {
+	tests := []struct {
+		name     string
+		compress bool
+		encrypt  bool
+	}{
+		{name: "plain"},
+		{name: "compressed", compress: true},
+		{name: "encrypted", encrypt: true},
+		{name: "compressed and encrypted", compress: true, encrypt: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			storagePath := newDB(t)
+			exec(t, storagePath, "INSERT INTO apps (id, name, secret) VALUES (1, 'before', 'secret-1')")
+
+			opts := backup.Options{Dir: t.TempDir(), Compress: tt.compress}
+			if tt.encrypt {
+				opts.Keyring = newKeyring(t)
+			}
+
+			snapshot, err := backup.Create(ctx, storagePath, opts)
+			require.NoError(t, err)
+
+			exec(t, storagePath, "INSERT INTO apps (id, name, secret) VALUES (2, 'after', 'secret-2')")
+
+			previous, err := backup.Restore(ctx, snapshot, storagePath, backup.RestoreOptions{Keyring: opts.Keyring})
+			require.NoError(t, err)
+
+			assert.Equal(t, []string{"before"}, appNames(t, storagePath))
+			assert.Equal(t, []string{"before", "after"}, appNames(t, previous), "replaced database must be kept")
+		})
+	}
+}
+
+func TestRestore_WrongKey(t *testing.T) {
+	ctx := context.Background()
+	storagePath := newDB(t)
+
+	snapshot, err := backup.Create(ctx, storagePath, backup.Options{Dir: t.TempDir(), Keyring: newKeyring(t)})
+	require.NoError(t, err)
+
+	_, err = backup.Restore(ctx, snapshot, storagePath, backup.RestoreOptions{})
+	assert.ErrorIs(t, err, backup.ErrKeyringRequired)
+
+	_, err = backup.Restore(ctx, snapshot, storagePath, backup.RestoreOptions{Keyring: newKeyring(t)})
+	assert.ErrorIs(t, err, envelope.ErrMalformedCipher)
+}
+
+func TestRestore_SchemaBehind(t *testing.T) {
+	ctx := context.Background()
+	storagePath := newDB(t)
+
+	old := filepath.Join(t.TempDir(), "old.db")
+	m, err := schema.New(config.StorageDriverSQLite, old)
+	require.NoError(t, err)
+	require.NoError(t, m.Steps(1))
+	_, _ = m.Close()
+
+	snapshot, err := backup.Create(ctx, old, backup.Options{Dir: t.TempDir()})
+	require.NoError(t, err)
+
+	_, err = backup.Restore(ctx, snapshot, storagePath, backup.RestoreOptions{})
+	assert.ErrorIs(t, err, backup.ErrSchemaMismatch)
+
+	_, err = backup.Restore(ctx, snapshot, storagePath, backup.RestoreOptions{Migrate: true})
+	assert.NoError(t, err)
+}
+
+func TestRestore_NotASnapshot(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "garbage.db")
+	require.NoError(t, os.WriteFile(path, []byte("not a database"), 0o600))
+
+	_, err := backup.Restore(context.Background(), path, newDB(t), backup.RestoreOptions{})
+	assert.ErrorIs(t, err, backup.ErrMalformedSnapshot)
+}
+
+func TestPrune(t *testing.T) {
+	ctx := context.Background()
+	storagePath := newDB(t)
+	dir := t.TempDir()
+
+	var snapshots []string
+	for i := 0; i < 4; i++ {
+		snapshot, err := backup.Create(ctx, storagePath, backup.Options{Dir: dir})
+		require.NoError(t, err)
+		snapshots = append(snapshots, snapshot)
+		// snapshots are named by the millisecond
+		time.Sleep(2 * time.Millisecond)
+	}
+
+	removed, err := backup.Prune(dir, 2)
+	require.NoError(t, err)
+	assert.Equal(t, snapshots[:2], removed)
+
+	left, err := backup.List(dir)
+	require.NoError(t, err)
+	assert.Equal(t, snapshots[2:], left)
+}
@@ -0,0 +1,72 @@
+package backup
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func write(t *testing.T, path string, content string) {
+	t.Helper()
+	require.NoError(t, os.WriteFile(path, []byte(content), 0o600))
+}
+
+func assertContent(t *testing.T, path string, want string) {
+	t.Helper()
+	got, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, want, string(got))
+}
+
+func TestSwap(t *testing.T) {
+	dir := t.TempDir()
+	storagePath := filepath.Join(dir, "sso.db")
+	snapshot := filepath.Join(dir, ".snapshot")
+	write(t, storagePath, "old")
+	write(t, storagePath+"-wal", "old wal")
+	write(t, snapshot, "new")
+
+	previous, err := swap(snapshot, storagePath)
+	require.NoError(t, err)
+
+	assertContent(t, storagePath, "new")
+	assertContent(t, previous, "old")
+	assertContent(t, previous+"-wal", "old wal")
+	assert.NoFileExists(t, storagePath+"-wal", "the old wal must not be replayed into the restored database")
+}
+
+func TestSwap_NoDatabase(t *testing.T) {
+	dir := t.TempDir()
+	storagePath := filepath.Join(dir, "sso.db")
+	snapshot := filepath.Join(dir, ".snapshot")
+	write(t, snapshot, "new")
+
+	previous, err := swap(snapshot, storagePath)
+	require.NoError(t, err)
+	assert.Empty(t, previous)
+	assertContent(t, storagePath, "new")
+}
+
+func TestSwap_RollsBack(t *testing.T) {
+	dir := t.TempDir()
+	storagePath := filepath.Join(dir, "sso.db")
+	write(t, storagePath, "old")
+	write(t, storagePath+"-wal", "old wal")
+	write(t, storagePath+"-shm", "old shm")
+
+	// the snapshot is missing, so the last rename fails after the database
+	// and its journal files have been moved aside
+	previous, err := swap(filepath.Join(dir, ".missing"), storagePath)
+	require.ErrorIs(t, err, os.ErrNotExist)
+	assert.Empty(t, previous)
+
+	assertContent(t, storagePath, "old")
+	assertContent(t, storagePath+"-wal", "old wal")
+	assertContent(t, storagePath+"-shm", "old shm")
+
+	entries, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	assert.Len(t, entries, 3, "no files may be left under the pre-restore name")
+}
@@ -8,10 +8,67 @@ import (
 )
 
 type Config struct {
-	Env         string        `yaml:"env" env-default:"local"`
-	StoragePath string        `yaml:"storage_path" env-required:"true"`
-	TokenTTL    time.Duration `yaml:"token_ttl" env-default:"24h"`
-	GRPC        GRPCConfig    `yaml:"grpc"`
+	Env         string           `yaml:"env" env-default:"local"`
+	StoragePath string           `yaml:"storage_path"`
+	Storage     StorageConfig    `yaml:"storage"`
+	TokenTTL    time.Duration    `yaml:"token_ttl" env-default:"24h"`
+	TokenIssuer string           `yaml:"token_issuer" env-default:"sso"`
+	GRPC        GRPCConfig       `yaml:"grpc"`
+	Biometrics  BiometricsConfig `yaml:"biometrics"`
+	Risk        RiskConfig       `yaml:"risk"`
+	Backup      BackupConfig     `yaml:"backup"`
+	Account     AccountConfig    `yaml:"account"`
+	Mail        MailConfig       `yaml:"mail"`
+	// AdminAppID is the app of the sso admin console; admin RPCs only
+	// accept tokens issued for it.
+	AdminAppID int `yaml:"admin_app_id" env-default:"1"`
+}
+
+const (
+	StorageDriverSQLite   = "sqlite"
+	StorageDriverPostgres = "postgres"
+	StorageDriverMemory   = "memory"
+
+	// MemoryStoragePath selects the in-memory storage like the memory driver.
+	MemoryStoragePath = ":memory:"
+)
+
+// StorageConfig selects the storage backend. sqlite reads the database from
+// StoragePath; postgres connects with DSN, which may hold a password and is
+// therefore never logged. memory keeps everything in process and starts with
+// Apps only. AutoMigrate applies the embedded migrations on startup; without
+// it the service refuses to start on an outdated schema.
+type StorageConfig struct {
+	Driver      string       `yaml:"driver" env:"STORAGE_DRIVER" env-default:"sqlite"`
+	DSN         string       `yaml:"dsn" env:"STORAGE_DSN" json:"-"`
+	AutoMigrate bool         `yaml:"auto_migrate" env:"STORAGE_AUTO_MIGRATE"`
+	SQLite      SQLiteConfig `yaml:"sqlite"`
+	Apps        []AppConfig  `yaml:"apps"`
+}
+
+// SQLiteConfig holds the pragmas applied to every sqlite connection and the
+// connection pool limits, which follow database/sql.
+type SQLiteConfig struct {
+	JournalMode     string        `yaml:"journal_mode" env:"SQLITE_JOURNAL_MODE" env-default:"WAL"`
+	BusyTimeout     time.Duration `yaml:"busy_timeout" env:"SQLITE_BUSY_TIMEOUT" env-default:"5s"`
+	ForeignKeys     string        `yaml:"foreign_keys" env:"SQLITE_FOREIGN_KEYS" env-default:"on"`
+	Synchronous     string        `yaml:"synchronous" env:"SQLITE_SYNCHRONOUS" env-default:"NORMAL"`
+	MaxOpenConns    int           `yaml:"max_open_conns" env-default:"16"`
+	MaxIdleConns    int           `yaml:"max_idle_conns" env-default:"16"`
+	ConnMaxLifetime time.Duration `yaml:"conn_max_lifetime"`
+}
+
+// AppConfig is an app seeded into the in-memory storage. Token settings
+// left unset fall back to the service defaults.
+type AppConfig struct {
+	ID       int           `yaml:"id"`
+	Name     string        `yaml:"name"`
+	Secret   string        `yaml:"secret" json:"-"`
+	TokenTTL time.Duration `yaml:"token_ttl"`
+	Audience string        `yaml:"audience"`
+	Issuer   string        `yaml:"issuer"`
+	// Claims is the claims template; unset keeps the default one.
+	Claims []string `yaml:"claims"`
 }
 
 type GRPCConfig struct {
@@ -19,6 +76,74 @@ type GRPCConfig struct {
 	Timeout time.Duration `yaml:"timeout" env-default:"5s"`
 }
 
+// BiometricsConfig holds the master key that wraps per-user data keys of
+// biometric templates and the keystroke matcher thresholds. The key is base64
+// encoded, either inline or in a file.
+type BiometricsConfig struct {
+	MasterKey      string  `yaml:"master_key" env:"BIOMETRICS_MASTER_KEY" json:"-"`
+	MasterKeyFile  string  `yaml:"master_key_file" env:"BIOMETRICS_MASTER_KEY_FILE"`
+	LowerThreshold float64 `yaml:"lower_threshold" env-default:"0.5"`
+	UpperThreshold float64 `yaml:"upper_threshold" env-default:"1.5"`
+	// FeatureTolerance is the relative deviation allowed for key-identity
+	// features (dwell, flight and digraph latencies).
+	FeatureTolerance float64 `yaml:"feature_tolerance" env-default:"0.35"`
+}
+
+// RiskConfig holds the thresholds of the combined login risk score: from
+// StepUpThreshold on a reduced token is issued, from DenyThreshold on the
+// login is rejected.
+type RiskConfig struct {
+	StepUpThreshold float64 `yaml:"step_up_threshold" env-default:"0.5"`
+	DenyThreshold   float64 `yaml:"deny_threshold" env-default:"0.8"`
+}
+
+// BackupConfig configures the sso backup command: snapshots of the sqlite
+// storage are written to Dir and only the Keep newest are kept (0 keeps all).
+// Encrypted snapshots are sealed with the biometrics master key.
+type BackupConfig struct {
+	Dir      string `yaml:"dir" env:"BACKUP_DIR" env-default:"./backups"`
+	Keep     int    `yaml:"keep" env:"BACKUP_KEEP" env-default:"7"`
+	Compress bool   `yaml:"compress" env:"BACKUP_COMPRESS"`
+	Encrypt  bool   `yaml:"encrypt" env:"BACKUP_ENCRYPT"`
+}
+
+// AccountConfig configures account deletion. Deleted accounts are kept for
+// DeletionGracePeriod, during which logging in cancels the deletion, and are
+// purged every PurgeInterval once it is over. A zero grace period deletes
+// accounts at once.
+type AccountConfig struct {
+	DeletionGracePeriod time.Duration `yaml:"deletion_grace_period" env:"ACCOUNT_DELETION_GRACE_PERIOD"`
+	PurgeInterval       time.Duration `yaml:"purge_interval" env:"ACCOUNT_PURGE_INTERVAL" env-default:"1h"`
+}
+
+// MailConfig configures the SMTP server verification codes are sent
+// through. Without SMTPHost codes are written to the log instead, which is
+// only suitable for local development.
+type MailConfig struct {
+	SMTPHost string `yaml:"smtp_host" env:"MAIL_SMTP_HOST"`
+	SMTPPort int    `yaml:"smtp_port" env:"MAIL_SMTP_PORT" env-default:"587"`
+	From     string `yaml:"from" env:"MAIL_FROM"`
+	Username string `yaml:"username" env:"MAIL_USERNAME"`
+	Password string `yaml:"password" env:"MAIL_PASSWORD" json:"-"`
+}
+
+// StorageDriver returns the selected storage driver; a ":memory:" storage
+// path selects the memory driver whatever the driver setting says.
+func (c *Config) StorageDriver() string {
+	if c.StoragePath == MemoryStoragePath {
+		return StorageDriverMemory
+	}
+	return c.Storage.Driver
+}
+
+// StorageSource returns what the selected storage driver connects to.
+func (c *Config) StorageSource() string {
+	if c.StorageDriver() == StorageDriverPostgres {
+		return c.Storage.DSN
+	}
+	return c.StoragePath
+}
+
 // MustLoad loads the configuration from the specified path and returns it.
 //
 // It fetches the config path and checks if it's empty. If it is, it panics with
@@ -1,7 +1,84 @@
 package models
 
+import "time"
+
+const (
+	SensitivityLow = iota
+	SensitivityMedium
+	SensitivityHigh
+)
+
+// User attributes an app can map into its tokens with a claims template.
+const (
+	ClaimEmail       = "email"
+	ClaimDisplayName = "display_name"
+	ClaimLocale      = "locale"
+	ClaimTimezone    = "timezone"
+	ClaimIsAdmin     = "is_admin"
+	// ClaimKeystrokes is the enrolled keystroke timing template. It is a
+	// biometric secret readable by anyone holding the token, so it is never
+	// in the default template: apps must list it explicitly.
+	ClaimKeystrokes = "keystrokes"
+)
+
+// DefaultClaimsTemplate is used by apps without a claims template.
+var DefaultClaimsTemplate = []string{ClaimEmail}
+
+// ValidClaim reports whether name is a user attribute a claims template may
+// map.
+func ValidClaim(name string) bool {
+	switch name {
+	case ClaimEmail, ClaimDisplayName, ClaimLocale, ClaimTimezone, ClaimIsAdmin, ClaimKeystrokes:
+		return true
+	}
+	return false
+}
+
 type App struct {
 	ID     int
 	Name   string
 	Secret string
+	// PreviousSecret is the secret replaced by the last rotation. Tokens
+	// signed with it are accepted until PreviousSecretExpiresAt.
+	PreviousSecret          string
+	PreviousSecretExpiresAt time.Time
+	// ContinuousAuthThreshold is the free-text typing score below which a
+	// continuously verified session of the app is revoked.
+	ContinuousAuthThreshold float64
+	// Sensitivity raises the login risk for apps guarding valuable data.
+	Sensitivity int
+	// TokenTTL is the lifetime of the tokens and sessions of the app; zero
+	// uses the service default.
+	TokenTTL time.Duration
+	// Audience is put in the aud claim of the tokens when set.
+	Audience string
+	// Issuer replaces the issuer of the service in the tokens when set.
+	Issuer string
+	// ClaimsTemplate lists the user attributes put in the tokens. Nil uses
+	// DefaultClaimsTemplate; an empty template leaves every attribute out.
+	ClaimsTemplate []string
+	// CreatedAt is zero for apps created before it was recorded.
+	CreatedAt time.Time
+	// DisabledAt is set while an admin has disabled the app: no one can log
+	// in to it and its tokens are rejected.
+	DisabledAt *time.Time
+}
+
+// Claims returns the claims template of the app.
+func (a App) Claims() []string {
+	if a.ClaimsTemplate == nil {
+		return DefaultClaimsTemplate
+	}
+	return a.ClaimsTemplate
+}
+
+// Secrets returns the secrets tokens of the app are verified with at now:
+// the current one and, during the grace period of a rotation, the previous
+// one.
+func (a App) Secrets(now time.Time) []string {
+	secrets := []string{a.Secret}
+	if a.PreviousSecret != "" && now.Before(a.PreviousSecretExpiresAt) {
+		secrets = append(secrets, a.PreviousSecret)
+	}
+	return secrets
 }
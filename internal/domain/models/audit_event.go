@@ -0,0 +1,15 @@
+package models
+
+import "time"
+
+// AuditEvent records an action taken on an account. Events are kept after
+// the account is deleted, so deletions remain accountable.
+type AuditEvent struct {
+	UserID int64
+	// ActorID is the user who took the action: the account owner, an admin,
+	// or 0 for the service itself.
+	ActorID   int64
+	Action    string
+	IP        string
+	CreatedAt time.Time
+}
@@ -0,0 +1,13 @@
+package models
+
+import "time"
+
+// LoginAttempt is a single login of a known user, successful or not.
+type LoginAttempt struct {
+	UserID    int64
+	AppID     int
+	IP        string
+	Device    string
+	Success   bool
+	CreatedAt time.Time
+}
@@ -0,0 +1,18 @@
+package models
+
+import "time"
+
+// Session is issued on every successful login and referenced by the token.
+type Session struct {
+	ID        string
+	UserID    int64
+	AppID     int
+	CreatedAt time.Time
+	ExpiresAt time.Time
+	RevokedAt *time.Time
+}
+
+// Active reports whether the session is neither revoked nor expired at now.
+func (s Session) Active(now time.Time) bool {
+	return s.RevokedAt == nil && now.Before(s.ExpiresAt)
+}
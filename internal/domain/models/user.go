@@ -1,9 +1,70 @@
 package models
 
+import "time"
+
 type User struct {
 	ID             int64
 	Email          string
 	PassHash       []byte
 	PressTimes     []float32
 	PressIntervals []float32
+	// KeyEvents is the enrolled keystroke sequence with key identity. It is
+	// empty for users enrolled with flat timing arrays only.
+	KeyEvents []KeyEvent
+	IsAdmin   bool
+	// CreatedAt is zero for users registered before it was recorded.
+	CreatedAt time.Time
+	// DisabledAt is set while an admin has disabled the account; disabled
+	// users cannot log in.
+	DisabledAt *time.Time
+	// EnrollmentPending is set by an admin biometric reset; the next
+	// successful login captures a fresh keystroke template.
+	EnrollmentPending bool
+	// DeleteAfter is set while the account waits out the deletion grace
+	// period; it is purged after this time unless the owner logs in again.
+	DeleteAfter *time.Time
+	Profile
+	// EmailChange is set while a change of the email address waits for the
+	// new address to be verified.
+	EmailChange *EmailChange
+}
+
+// UserFilter selects users for admin listings. Zero fields match every user.
+type UserFilter struct {
+	EmailPrefix string
+	IsAdmin     *bool
+	Disabled    *bool
+	// CreatedFrom and CreatedTo bound the creation time; CreatedTo is
+	// exclusive.
+	CreatedFrom time.Time
+	CreatedTo   time.Time
+	// AfterID is the cursor: only users with a greater ID match.
+	AfterID int64
+	// Limit caps the number of users returned; 0 means no limit.
+	Limit int
+}
+
+// Profile is what users may change about themselves.
+type Profile struct {
+	DisplayName string
+	// Locale is a BCP 47 language tag.
+	Locale string
+	// Timezone is an IANA time zone name.
+	Timezone string
+}
+
+// EmailChange is a requested change of the email address.
+type EmailChange struct {
+	Email string
+	// CodeHash is the SHA-256 of the verification code sent to Email.
+	CodeHash  []byte
+	ExpiresAt time.Time
+}
+
+// KeyEvent is a single keystroke: the key identifier and the timestamps of
+// its press and release, in milliseconds from any fixed origin.
+type KeyEvent struct {
+	Key        string  `json:"key"`
+	PressedAt  float64 `json:"pressed_at"`
+	ReleasedAt float64 `json:"released_at"`
 }
@@ -0,0 +1,173 @@
+package admin
+
+import (
+	"context"
+	"errors"
+	ssov1 "github.com/some-kikikiss/protos-sso/gen/go/sso"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"sso/internal/domain/models"
+	authgrpc "sso/internal/grpc/auth"
+	"sso/internal/services/auth"
+	"time"
+)
+
+type serverAPI struct {
+	ssov1.UnimplementedAdminServer
+	admin Admin
+}
+
+// Admin is the user management the admin service exposes. Every method
+// takes the token of an admin.
+type Admin interface {
+	ListUsers(ctx context.Context, adminToken string, filter models.UserFilter, pageToken string) (users []models.User, nextPageToken string, err error)
+	GetUser(ctx context.Context, adminToken string, userID int64) (models.User, error)
+	SetAdmin(ctx context.Context, adminToken string, userID int64, isAdmin bool) error
+	DisableUser(ctx context.Context, adminToken string, userID int64) error
+	EnableUser(ctx context.Context, adminToken string, userID int64) error
+	ForceLogout(ctx context.Context, adminToken string, userID int64) error
+}
+
+// Register registers the admin service on the gRPC server.
+func Register(gRPCServer *grpc.Server, admin Admin) {
+	ssov1.RegisterAdminServer(gRPCServer, &serverAPI{admin: admin})
+}
+
+// ListUsers returns a page of users ordered by ID. Filters left unset match
+// every user; created_from and created_to are unix seconds, created_to
+// exclusive. Locked users are those disabled with DisableUser.
+func (s *serverAPI) ListUsers(ctx context.Context, req *ssov1.ListUsersRequest) (*ssov1.ListUsersResponse, error) {
+	if err := validateListUsers(req); err != nil {
+		return nil, err
+	}
+
+	filter := models.UserFilter{
+		EmailPrefix: req.GetEmailPrefix(),
+		IsAdmin:     req.IsAdmin,
+		Disabled:    req.Locked,
+		Limit:       int(req.GetPageSize()),
+	}
+	if req.GetCreatedFrom() != 0 {
+		filter.CreatedFrom = time.Unix(req.GetCreatedFrom(), 0)
+	}
+	if req.GetCreatedTo() != 0 {
+		filter.CreatedTo = time.Unix(req.GetCreatedTo(), 0)
+	}
+
+	users, next, err := s.admin.ListUsers(ctx, req.GetToken(), filter, req.GetPageToken())
+	if err != nil {
+		if errors.Is(err, auth.ErrInvalidPageToken) {
+			return nil, status.Error(codes.InvalidArgument, "invalid page token")
+		}
+		return nil, adminError(err)
+	}
+
+	resp := &ssov1.ListUsersResponse{
+		Users:         make([]*ssov1.User, 0, len(users)),
+		NextPageToken: next,
+	}
+	for _, user := range users {
+		resp.Users = append(resp.Users, toUser(user))
+	}
+	return resp, nil
+}
+
+func (s *serverAPI) GetUser(ctx context.Context, req *ssov1.GetUserRequest) (*ssov1.GetUserResponse, error) {
+	if err := validateUserRequest(req); err != nil {
+		return nil, err
+	}
+
+	user, err := s.admin.GetUser(ctx, req.GetToken(), req.GetUserId())
+	if err != nil {
+		return nil, adminError(err)
+	}
+	return &ssov1.GetUserResponse{User: toUser(user)}, nil
+}
+
+// SetAdmin grants or revokes admin rights. Admins cannot revoke their own.
+func (s *serverAPI) SetAdmin(ctx context.Context, req *ssov1.SetAdminRequest) (*ssov1.SetAdminResponse, error) {
+	if err := validateUserRequest(req); err != nil {
+		return nil, err
+	}
+
+	ctx = auth.ContextWithClient(ctx, authgrpc.ClientInfo(ctx))
+	if err := s.admin.SetAdmin(ctx, req.GetToken(), req.GetUserId(), req.GetIsAdmin()); err != nil {
+		return nil, adminError(err)
+	}
+	return &ssov1.SetAdminResponse{}, nil
+}
+
+// DisableUser locks the user out and revokes every session of the user.
+// Admins cannot disable themselves.
+func (s *serverAPI) DisableUser(ctx context.Context, req *ssov1.DisableUserRequest) (*ssov1.DisableUserResponse, error) {
+	if err := validateUserRequest(req); err != nil {
+		return nil, err
+	}
+
+	ctx = auth.ContextWithClient(ctx, authgrpc.ClientInfo(ctx))
+	if err := s.admin.DisableUser(ctx, req.GetToken(), req.GetUserId()); err != nil {
+		return nil, adminError(err)
+	}
+	return &ssov1.DisableUserResponse{}, nil
+}
+
+func (s *serverAPI) EnableUser(ctx context.Context, req *ssov1.EnableUserRequest) (*ssov1.EnableUserResponse, error) {
+	if err := validateUserRequest(req); err != nil {
+		return nil, err
+	}
+
+	ctx = auth.ContextWithClient(ctx, authgrpc.ClientInfo(ctx))
+	if err := s.admin.EnableUser(ctx, req.GetToken(), req.GetUserId()); err != nil {
+		return nil, adminError(err)
+	}
+	return &ssov1.EnableUserResponse{}, nil
+}
+
+// ForceLogout revokes every session of the user, who may log in again.
+func (s *serverAPI) ForceLogout(ctx context.Context, req *ssov1.ForceLogoutRequest) (*ssov1.ForceLogoutResponse, error) {
+	if err := validateUserRequest(req); err != nil {
+		return nil, err
+	}
+
+	ctx = auth.ContextWithClient(ctx, authgrpc.ClientInfo(ctx))
+	if err := s.admin.ForceLogout(ctx, req.GetToken(), req.GetUserId()); err != nil {
+		return nil, adminError(err)
+	}
+	return &ssov1.ForceLogoutResponse{}, nil
+}
+
+// adminError maps the errors shared by every admin call to a status.
+func adminError(err error) error {
+	switch {
+	case errors.Is(err, auth.ErrInvalidToken):
+		return status.Error(codes.Unauthenticated, "invalid token")
+	case errors.Is(err, auth.ErrPermissionDenied):
+		return status.Error(codes.PermissionDenied, "admin rights required")
+	case errors.Is(err, auth.ErrUserNotFound):
+		return status.Error(codes.NotFound, "user not found")
+	case errors.Is(err, auth.ErrSelfAdminAction):
+		return status.Error(codes.FailedPrecondition, "admins cannot disable or demote themselves")
+	}
+	return status.Error(codes.Internal, "internal error")
+}
+
+func toUser(user models.User) *ssov1.User {
+	u := &ssov1.User{
+		UserId:      user.ID,
+		Email:       user.Email,
+		DisplayName: user.DisplayName,
+		IsAdmin:     user.IsAdmin,
+		Locked:      user.DisabledAt != nil,
+	}
+	if !user.CreatedAt.IsZero() {
+		u.CreatedAt = user.CreatedAt.Unix()
+	}
+	if user.DisabledAt != nil {
+		u.DisabledAt = user.DisabledAt.Unix()
+	}
+	if user.DeleteAfter != nil {
+		u.DeleteAfter = user.DeleteAfter.Unix()
+	}
+	return u
+}
@@ -0,0 +1,40 @@
+package admin
+
+import (
+	ssov1 "github.com/some-kikikiss/protos-sso/gen/go/sso"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"sso/internal/services/auth"
+)
+
+func validateListUsers(req *ssov1.ListUsersRequest) error {
+	if req.GetToken() == "" {
+		return status.Error(codes.InvalidArgument, "token is required")
+	}
+	if req.GetPageSize() < 0 || req.GetPageSize() > auth.MaxPageSize {
+		return status.Errorf(codes.InvalidArgument, "page_size must be between 0 and %d", auth.MaxPageSize)
+	}
+	if req.GetCreatedFrom() < 0 || req.GetCreatedTo() < 0 {
+		return status.Error(codes.InvalidArgument, "created_from and created_to must be unix seconds")
+	}
+	if req.GetCreatedFrom() != 0 && req.GetCreatedTo() != 0 && req.GetCreatedTo() <= req.GetCreatedFrom() {
+		return status.Error(codes.InvalidArgument, "created_to must be after created_from")
+	}
+	return nil
+}
+
+// userRequest is implemented by the requests naming a single user.
+type userRequest interface {
+	GetToken() string
+	GetUserId() int64
+}
+
+func validateUserRequest(req userRequest) error {
+	if req.GetToken() == "" {
+		return status.Error(codes.InvalidArgument, "token is required")
+	}
+	if req.GetUserId() <= 0 {
+		return status.Error(codes.InvalidArgument, "user_id is required")
+	}
+	return nil
+}
@@ -0,0 +1,217 @@
+package apps
+
+import (
+	"context"
+	"errors"
+	ssov1 "github.com/some-kikikiss/protos-sso/gen/go/sso"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"sso/internal/domain/models"
+	"sso/internal/services/auth"
+	"time"
+)
+
+type serverAPI struct {
+	ssov1.UnimplementedAppServiceServer
+	apps Apps
+}
+
+// Apps is the app management the app service exposes. Every method takes
+// the token of an admin.
+type Apps interface {
+	CreateApp(ctx context.Context, adminToken string, app models.App) (models.App, error)
+	ListApps(ctx context.Context, adminToken string) ([]models.App, error)
+	UpdateApp(ctx context.Context, adminToken string, appID int, update auth.AppUpdate) (models.App, error)
+	DisableApp(ctx context.Context, adminToken string, appID int) error
+	EnableApp(ctx context.Context, adminToken string, appID int) error
+	DeleteApp(ctx context.Context, adminToken string, appID int) error
+	RotateSecret(ctx context.Context, adminToken string, appID int, grace time.Duration) (secret string, previousExpiresAt time.Time, err error)
+}
+
+// Register registers the app service on the gRPC server.
+func Register(gRPCServer *grpc.Server, apps Apps) {
+	ssov1.RegisterAppServiceServer(gRPCServer, &serverAPI{apps: apps})
+}
+
+// CreateApp registers an app. The response carries the generated secret,
+// which cannot be read again: it has to be stored by the caller. Unset token
+// settings use the service defaults; without a claims template tokens carry
+// the email of the user. The keystroke template is only put in tokens of
+// apps listing "keystrokes" in their template.
+func (s *serverAPI) CreateApp(ctx context.Context, req *ssov1.CreateAppRequest) (*ssov1.CreateAppResponse, error) {
+	if err := validateCreateApp(req); err != nil {
+		return nil, err
+	}
+
+	app := models.App{
+		Name:                    req.GetName(),
+		ContinuousAuthThreshold: req.GetContinuousAuthThreshold(),
+		Sensitivity:             int(req.GetSensitivity()),
+		TokenTTL:                time.Duration(req.GetTokenTtlSeconds()) * time.Second,
+		Audience:                req.GetAudience(),
+		Issuer:                  req.GetIssuer(),
+	}
+	if req.GetClaimsTemplate() != nil {
+		app.ClaimsTemplate = claimsTemplate(req.GetClaimsTemplate())
+	}
+
+	app, err := s.apps.CreateApp(ctx, req.GetToken(), app)
+	if err != nil {
+		return nil, appError(err)
+	}
+	return &ssov1.CreateAppResponse{App: toApp(app), Secret: app.Secret}, nil
+}
+
+func (s *serverAPI) ListApps(ctx context.Context, req *ssov1.ListAppsRequest) (*ssov1.ListAppsResponse, error) {
+	if req.GetToken() == "" {
+		return nil, status.Error(codes.InvalidArgument, "token is required")
+	}
+
+	apps, err := s.apps.ListApps(ctx, req.GetToken())
+	if err != nil {
+		return nil, appError(err)
+	}
+
+	resp := &ssov1.ListAppsResponse{Apps: make([]*ssov1.App, 0, len(apps))}
+	for _, app := range apps {
+		resp.Apps = append(resp.Apps, toApp(app))
+	}
+	return resp, nil
+}
+
+// UpdateApp changes the settings set in the request; unset fields are kept.
+// A zero token_ttl_seconds and empty audience or issuer restore the service
+// defaults.
+func (s *serverAPI) UpdateApp(ctx context.Context, req *ssov1.UpdateAppRequest) (*ssov1.UpdateAppResponse, error) {
+	if err := validateUpdateApp(req); err != nil {
+		return nil, err
+	}
+
+	update := auth.AppUpdate{
+		Name:                    req.Name,
+		ContinuousAuthThreshold: req.ContinuousAuthThreshold,
+	}
+	if req.Sensitivity != nil {
+		sensitivity := int(req.GetSensitivity())
+		update.Sensitivity = &sensitivity
+	}
+	if req.TokenTtlSeconds != nil {
+		ttl := time.Duration(req.GetTokenTtlSeconds()) * time.Second
+		update.TokenTTL = &ttl
+	}
+	update.Audience, update.Issuer = req.Audience, req.Issuer
+	if req.GetClaimsTemplate() != nil {
+		template := claimsTemplate(req.GetClaimsTemplate())
+		update.ClaimsTemplate = &template
+	}
+
+	app, err := s.apps.UpdateApp(ctx, req.GetToken(), int(req.GetAppId()), update)
+	if err != nil {
+		return nil, appError(err)
+	}
+	return &ssov1.UpdateAppResponse{App: toApp(app)}, nil
+}
+
+// DisableApp rejects logins to the app and every token issued for it.
+func (s *serverAPI) DisableApp(ctx context.Context, req *ssov1.DisableAppRequest) (*ssov1.DisableAppResponse, error) {
+	if err := validateAppRequest(req); err != nil {
+		return nil, err
+	}
+
+	if err := s.apps.DisableApp(ctx, req.GetToken(), int(req.GetAppId())); err != nil {
+		return nil, appError(err)
+	}
+	return &ssov1.DisableAppResponse{}, nil
+}
+
+func (s *serverAPI) EnableApp(ctx context.Context, req *ssov1.EnableAppRequest) (*ssov1.EnableAppResponse, error) {
+	if err := validateAppRequest(req); err != nil {
+		return nil, err
+	}
+
+	if err := s.apps.EnableApp(ctx, req.GetToken(), int(req.GetAppId())); err != nil {
+		return nil, appError(err)
+	}
+	return &ssov1.EnableAppResponse{}, nil
+}
+
+// DeleteApp removes the app and its sessions.
+func (s *serverAPI) DeleteApp(ctx context.Context, req *ssov1.DeleteAppRequest) (*ssov1.DeleteAppResponse, error) {
+	if err := validateAppRequest(req); err != nil {
+		return nil, err
+	}
+
+	if err := s.apps.DeleteApp(ctx, req.GetToken(), int(req.GetAppId())); err != nil {
+		return nil, appError(err)
+	}
+	return &ssov1.DeleteAppResponse{}, nil
+}
+
+// RotateSecret replaces the secret of the app. Tokens signed with the old
+// secret are accepted for grace_seconds; the new secret is only returned
+// here.
+func (s *serverAPI) RotateSecret(ctx context.Context, req *ssov1.RotateSecretRequest) (*ssov1.RotateSecretResponse, error) {
+	if err := validateRotateSecret(req); err != nil {
+		return nil, err
+	}
+
+	grace := time.Duration(req.GetGraceSeconds()) * time.Second
+	secret, expiresAt, err := s.apps.RotateSecret(ctx, req.GetToken(), int(req.GetAppId()), grace)
+	if err != nil {
+		return nil, appError(err)
+	}
+
+	resp := &ssov1.RotateSecretResponse{Secret: secret}
+	if grace > 0 {
+		resp.PreviousSecretExpiresAt = expiresAt.Unix()
+	}
+	return resp, nil
+}
+
+// appError maps the errors shared by every app call to a status.
+func appError(err error) error {
+	switch {
+	case errors.Is(err, auth.ErrInvalidToken):
+		return status.Error(codes.Unauthenticated, "invalid token")
+	case errors.Is(err, auth.ErrPermissionDenied):
+		return status.Error(codes.PermissionDenied, "admin rights required")
+	case errors.Is(err, auth.ErrInvalidAppID):
+		return status.Error(codes.NotFound, "app not found")
+	case errors.Is(err, auth.ErrAppExists):
+		return status.Error(codes.AlreadyExists, "app already exists")
+	}
+	return status.Error(codes.Internal, "internal error")
+}
+
+// claimsTemplate returns the claims of a template set in a request; an
+// empty template is kept empty rather than selecting the default one.
+func claimsTemplate(template *ssov1.ClaimsTemplate) []string {
+	return append([]string{}, template.GetClaims()...)
+}
+
+// toApp converts an app without its secrets, which only CreateApp and
+// RotateSecret return.
+func toApp(app models.App) *ssov1.App {
+	a := &ssov1.App{
+		AppId:                   int32(app.ID),
+		Name:                    app.Name,
+		ContinuousAuthThreshold: app.ContinuousAuthThreshold,
+		Sensitivity:             int32(app.Sensitivity),
+		Disabled:                app.DisabledAt != nil,
+		TokenTtlSeconds:         int64(app.TokenTTL / time.Second),
+		Audience:                app.Audience,
+		Issuer:                  app.Issuer,
+		Claims:                  app.Claims(),
+	}
+	if !app.CreatedAt.IsZero() {
+		a.CreatedAt = app.CreatedAt.Unix()
+	}
+	if app.DisabledAt != nil {
+		a.DisabledAt = app.DisabledAt.Unix()
+	}
+	if !app.PreviousSecretExpiresAt.IsZero() && time.Now().Before(app.PreviousSecretExpiresAt) {
+		a.PreviousSecretExpiresAt = app.PreviousSecretExpiresAt.Unix()
+	}
+	return a
+}
@@ -0,0 +1,131 @@
+package apps
+
+import (
+	ssov1 "github.com/some-kikikiss/protos-sso/gen/go/sso"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"sso/internal/domain/models"
+	"strings"
+	"unicode/utf8"
+)
+
+// maxNameLength is the maximum length of an app name in runes.
+const maxNameLength = 64
+
+// maxGraceSeconds caps the grace period of a rotated secret at 30 days.
+const maxGraceSeconds = 30 * 24 * 60 * 60
+
+// maxTokenTTLSeconds caps the token lifetime of an app at 90 days.
+const maxTokenTTLSeconds = 90 * 24 * 60 * 60
+
+// maxClaimLength is the maximum length of an audience or issuer.
+const maxClaimLength = 255
+
+func validateCreateApp(req *ssov1.CreateAppRequest) error {
+	if req.GetToken() == "" {
+		return status.Error(codes.InvalidArgument, "token is required")
+	}
+	if err := validateName(req.GetName()); err != nil {
+		return err
+	}
+	if err := validateThreshold(req.GetContinuousAuthThreshold()); err != nil {
+		return err
+	}
+	if err := validateSensitivity(req.GetSensitivity()); err != nil {
+		return err
+	}
+	return validateTokenSettings(req.GetTokenTtlSeconds(), req.GetAudience(), req.GetIssuer(), req.GetClaimsTemplate())
+}
+
+func validateUpdateApp(req *ssov1.UpdateAppRequest) error {
+	if err := validateAppRequest(req); err != nil {
+		return err
+	}
+	if req.Name != nil {
+		if err := validateName(req.GetName()); err != nil {
+			return err
+		}
+	}
+	if req.ContinuousAuthThreshold != nil {
+		if err := validateThreshold(req.GetContinuousAuthThreshold()); err != nil {
+			return err
+		}
+	}
+	if req.Sensitivity != nil {
+		if err := validateSensitivity(req.GetSensitivity()); err != nil {
+			return err
+		}
+	}
+	// unset settings validate as their zero values
+	return validateTokenSettings(req.GetTokenTtlSeconds(), req.GetAudience(), req.GetIssuer(), req.GetClaimsTemplate())
+}
+
+func validateRotateSecret(req *ssov1.RotateSecretRequest) error {
+	if err := validateAppRequest(req); err != nil {
+		return err
+	}
+	if req.GetGraceSeconds() < 0 || req.GetGraceSeconds() > maxGraceSeconds {
+		return status.Errorf(codes.InvalidArgument, "grace_seconds must be between 0 and %d", maxGraceSeconds)
+	}
+	return nil
+}
+
+// appRequest is implemented by the requests naming a single app.
+type appRequest interface {
+	GetToken() string
+	GetAppId() int32
+}
+
+func validateAppRequest(req appRequest) error {
+	if req.GetToken() == "" {
+		return status.Error(codes.InvalidArgument, "token is required")
+	}
+	if req.GetAppId() <= 0 {
+		return status.Error(codes.InvalidArgument, "app_id is required")
+	}
+	return nil
+}
+
+func validateName(name string) error {
+	if strings.TrimSpace(name) == "" {
+		return status.Error(codes.InvalidArgument, "name is required")
+	}
+	if utf8.RuneCountInString(name) > maxNameLength {
+		return status.Errorf(codes.InvalidArgument, "name must be at most %d characters", maxNameLength)
+	}
+	return nil
+}
+
+func validateThreshold(threshold float64) error {
+	if threshold < 0 || threshold > 1 {
+		return status.Error(codes.InvalidArgument, "continuous_auth_threshold must be between 0 and 1")
+	}
+	return nil
+}
+
+func validateSensitivity(sensitivity int32) error {
+	if sensitivity < models.SensitivityLow || sensitivity > models.SensitivityHigh {
+		return status.Error(codes.InvalidArgument, "sensitivity must be low, medium or high")
+	}
+	return nil
+}
+
+func validateTokenSettings(ttlSeconds int64, audience string, issuer string, template *ssov1.ClaimsTemplate) error {
+	if ttlSeconds < 0 || ttlSeconds > maxTokenTTLSeconds {
+		return status.Errorf(codes.InvalidArgument, "token_ttl_seconds must be between 0 and %d", maxTokenTTLSeconds)
+	}
+	if len(audience) > maxClaimLength || len(issuer) > maxClaimLength {
+		return status.Errorf(codes.InvalidArgument, "audience and issuer must be at most %d bytes", maxClaimLength)
+	}
+	seen := make(map[string]bool, len(template.GetClaims()))
+	for _, claim := range template.GetClaims() {
+		if !models.ValidClaim(claim) {
+			return status.Errorf(codes.InvalidArgument, "unknown claim %q in claims template", claim)
+		}
+		if seen[claim] {
+			return status.Errorf(codes.InvalidArgument, "claim %q is listed twice in claims template", claim)
+		}
+		seen[claim] = true
+	}
+	return nil
+}
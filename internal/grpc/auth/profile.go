@@ -0,0 +1,134 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	ssov1 "github.com/some-kikikiss/protos-sso/gen/go/sso"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"sso/internal/domain/models"
+	"sso/internal/services/auth"
+)
+
+// GetProfile returns the profile of the token owner.
+func (s *serverAPI) GetProfile(ctx context.Context, req *ssov1.GetProfileRequest) (*ssov1.GetProfileResponse, error) {
+	if req.GetToken() == "" {
+		return nil, status.Error(codes.InvalidArgument, "token is required")
+	}
+	user, err := s.auth.GetProfile(ctx, req.GetToken())
+	if err != nil {
+		if errors.Is(err, auth.ErrInvalidToken) {
+			return nil, status.Error(codes.Unauthenticated, "invalid token")
+		}
+		return nil, status.Error(codes.Internal, "internal error")
+	}
+	return &ssov1.GetProfileResponse{Profile: profile(user)}, nil
+}
+
+// UpdateProfile changes the display name, locale and time zone of the token
+// owner. Fields missing from the request are kept; empty ones are cleared.
+func (s *serverAPI) UpdateProfile(ctx context.Context, req *ssov1.UpdateProfileRequest) (*ssov1.UpdateProfileResponse, error) {
+	if err := validateUpdateProfile(req); err != nil {
+		return nil, err
+	}
+	ctx = auth.ContextWithClient(ctx, ClientInfo(ctx))
+	user, err := s.auth.UpdateProfile(ctx, req.GetToken(), auth.ProfileUpdate{
+		DisplayName: req.DisplayName,
+		Locale:      req.Locale,
+		Timezone:    req.Timezone,
+	})
+	if err != nil {
+		if errors.Is(err, auth.ErrInvalidToken) {
+			return nil, status.Error(codes.Unauthenticated, "invalid token")
+		}
+		return nil, status.Error(codes.Internal, "internal error")
+	}
+	return &ssov1.UpdateProfileResponse{Profile: profile(user)}, nil
+}
+
+// ChangePassword replaces the password of the token owner.
+//
+// It requires the current password and the keystroke timings of the new
+// one, which replace the enrolled template. Other sessions of the user are
+// revoked; the session of the token stays valid.
+func (s *serverAPI) ChangePassword(ctx context.Context, req *ssov1.ChangePasswordRequest) (*ssov1.ChangePasswordResponse, error) {
+	if err := validateChangePassword(req); err != nil {
+		return nil, err
+	}
+	ctx = auth.ContextWithClient(ctx, ClientInfo(ctx))
+	err := s.auth.ChangePassword(ctx, req.GetToken(), req.GetCurrentPassword(), req.GetNewPassword(), milliseconds(req.GetKeyPressTimes()), milliseconds(req.GetKeyPressIntervals()), keyEvents(req.GetKeyEvents()))
+	if err != nil {
+		if errors.Is(err, auth.ErrInvalidToken) {
+			return nil, status.Error(codes.Unauthenticated, "invalid token")
+		}
+		if errors.Is(err, auth.ErrInvalidCredentials) {
+			return nil, status.Error(codes.InvalidArgument, "invalid credentials")
+		}
+		return nil, status.Error(codes.Internal, "internal error")
+	}
+	return &ssov1.ChangePasswordResponse{}, nil
+}
+
+// ChangeEmail sends a verification code to the new address of the token
+// owner. The address changes once the code is confirmed with
+// ConfirmEmailChange before expires_at, in unix seconds.
+func (s *serverAPI) ChangeEmail(ctx context.Context, req *ssov1.ChangeEmailRequest) (*ssov1.ChangeEmailResponse, error) {
+	if err := validateChangeEmail(req); err != nil {
+		return nil, err
+	}
+	ctx = auth.ContextWithClient(ctx, ClientInfo(ctx))
+	expiresAt, err := s.auth.ChangeEmail(ctx, req.GetToken(), req.GetPassword(), req.GetNewEmail())
+	if err != nil {
+		if errors.Is(err, auth.ErrInvalidToken) {
+			return nil, status.Error(codes.Unauthenticated, "invalid token")
+		}
+		if errors.Is(err, auth.ErrInvalidCredentials) {
+			return nil, status.Error(codes.InvalidArgument, "invalid credentials")
+		}
+		if errors.Is(err, auth.ErrUserExist) {
+			return nil, status.Error(codes.AlreadyExists, "email already taken")
+		}
+		return nil, status.Error(codes.Internal, "internal error")
+	}
+	return &ssov1.ChangeEmailResponse{ExpiresAt: expiresAt.Unix()}, nil
+}
+
+// ConfirmEmailChange completes a change of the email address with the code
+// sent to the new address.
+func (s *serverAPI) ConfirmEmailChange(ctx context.Context, req *ssov1.ConfirmEmailChangeRequest) (*ssov1.ConfirmEmailChangeResponse, error) {
+	if err := validateConfirmEmailChange(req); err != nil {
+		return nil, err
+	}
+	ctx = auth.ContextWithClient(ctx, ClientInfo(ctx))
+	err := s.auth.ConfirmEmailChange(ctx, req.GetToken(), req.GetCode())
+	if err != nil {
+		if errors.Is(err, auth.ErrInvalidToken) {
+			return nil, status.Error(codes.Unauthenticated, "invalid token")
+		}
+		if errors.Is(err, auth.ErrNoEmailChange) || errors.Is(err, auth.ErrEmailChangeExpired) {
+			return nil, status.Error(codes.FailedPrecondition, "no pending email change")
+		}
+		if errors.Is(err, auth.ErrInvalidCode) {
+			return nil, status.Error(codes.InvalidArgument, "invalid verification code")
+		}
+		if errors.Is(err, auth.ErrUserExist) {
+			return nil, status.Error(codes.AlreadyExists, "email already taken")
+		}
+		return nil, status.Error(codes.Internal, "internal error")
+	}
+	return &ssov1.ConfirmEmailChangeResponse{}, nil
+}
+
+func profile(user models.User) *ssov1.Profile {
+	p := &ssov1.Profile{
+		UserId:      user.ID,
+		Email:       user.Email,
+		DisplayName: user.DisplayName,
+		Locale:      user.Locale,
+		Timezone:    user.Timezone,
+	}
+	if user.EmailChange != nil {
+		p.PendingEmail = user.EmailChange.Email
+	}
+	return p
+}
@@ -2,24 +2,50 @@ package auth
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
 	ssov1 "github.com/some-kikikiss/protos-sso/gen/go/sso"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
 	"google.golang.org/grpc/status"
+	"io"
+	"net"
+	"sso/internal/domain/models"
 	"sso/internal/services/auth"
+	"time"
 )
 
 const emptyValue = 0
 
+// Reasons of the errdetails.ErrorInfo attached to rejected logins, so clients
+// can tell a rejected sample from wrong credentials.
+const (
+	ErrorDomain           = "sso"
+	ReasonSampleReplayed  = "SAMPLE_REPLAYED"
+	ReasonSampleSynthetic = "SAMPLE_SYNTHETIC"
+)
+
 type serverAPI struct {
 	ssov1.UnimplementedAuthServer
 	auth Auth
 }
 type Auth interface {
-	Login(ctx context.Context, email string, password string, pressTimes []float32, intervalTimes []float32, appID int) (token string, err error)
-	RegisterNewUser(ctx context.Context, email string, password string, pressTimes []float32, intervalTimes []float32) (userID int64, err error)
+	Login(ctx context.Context, email string, password string, pressTimes []float32, intervalTimes []float32, keyEvents []models.KeyEvent, appID int) (token string, err error)
+	RegisterNewUser(ctx context.Context, email string, password string, pressTimes []float32, intervalTimes []float32, keyEvents []models.KeyEvent) (userID int64, err error)
 	IsAdmin(ctx context.Context, userID int64) (bool, error)
+	ReenrollBiometrics(ctx context.Context, token string, password string, pressTimes []float32, intervalTimes []float32, keyEvents []models.KeyEvent) error
+	ResetBiometrics(ctx context.Context, adminToken string, userID int64) error
+	StartContinuousAuth(ctx context.Context, token string) (*auth.ContinuousSession, error)
+	DeleteAccount(ctx context.Context, token string, password string, userID int64) (deleteAfter time.Time, err error)
+	ExportMyData(ctx context.Context, token string) (auth.DataExport, error)
+	GetProfile(ctx context.Context, token string) (models.User, error)
+	UpdateProfile(ctx context.Context, token string, update auth.ProfileUpdate) (models.User, error)
+	ChangePassword(ctx context.Context, token string, currentPassword string, newPassword string, pressTimes []float32, intervalTimes []float32, keyEvents []models.KeyEvent) error
+	ChangeEmail(ctx context.Context, token string, password string, newEmail string) (expiresAt time.Time, err error)
+	ConfirmEmailChange(ctx context.Context, token string, code string) error
 }
 
 // Register is a function that registers a new user in the serverAPI.
@@ -43,7 +69,7 @@ func (s *serverAPI) Register(ctx context.Context, request *ssov1.RegisterRequest
 	if err := validateRegister(request); err != nil {
 		return nil, err
 	}
-	userID, err := s.auth.RegisterNewUser(ctx, request.GetEmail(), request.GetPassword(), request.GetKeyPressTimes(), request.GetKeyPressIntervals())
+	userID, err := s.auth.RegisterNewUser(ctx, request.GetEmail(), request.GetPassword(), milliseconds(request.GetKeyPressTimes()), milliseconds(request.GetKeyPressIntervals()), keyEvents(request.GetKeyEvents()))
 	if err != nil {
 		if errors.Is(err, auth.ErrUserExist) {
 			return nil, status.Error(codes.AlreadyExists, "user already exists")
@@ -78,62 +104,238 @@ func (s *serverAPI) Login(ctx context.Context, req *ssov1.LoginRequest) (*ssov1.
 	if err := validateLogin(req); err != nil {
 		return nil, err
 	}
-	token, err := s.auth.Login(ctx, req.GetEmail(), req.GetPassword(), req.GetKeyPressTimes(), req.GetKeyPressIntervals(), int(req.GetAppId()))
+	ctx = auth.ContextWithClient(ctx, ClientInfo(ctx))
+	token, err := s.auth.Login(ctx, req.GetEmail(), req.GetPassword(), milliseconds(req.GetKeyPressTimes()), milliseconds(req.GetKeyPressIntervals()), keyEvents(req.GetKeyEvents()), int(req.GetAppId()))
 	if err != nil {
 		if errors.Is(err, auth.ErrInvalidCredentials) {
 			return nil, status.Error(codes.InvalidArgument, "invalid credentials")
 		}
+		if errors.Is(err, auth.ErrInvalidBiometrics) {
+			return nil, status.Error(codes.Unauthenticated, "invalid biometrics")
+		}
+		if errors.Is(err, auth.ErrReplayedSample) {
+			return nil, sampleRejected(ReasonSampleReplayed, "biometric sample replayed")
+		}
+		if errors.Is(err, auth.ErrSyntheticSample) {
+			return nil, sampleRejected(ReasonSampleSynthetic, "biometric sample synthetic")
+		}
+		if errors.Is(err, auth.ErrLoginDenied) {
+			return nil, status.Error(codes.PermissionDenied, "login denied")
+		}
+		if errors.Is(err, auth.ErrUserDisabled) {
+			return nil, status.Error(codes.PermissionDenied, "user disabled")
+		}
+		if errors.Is(err, auth.ErrAppDisabled) {
+			return nil, status.Error(codes.PermissionDenied, "app disabled")
+		}
 		return nil, status.Error(codes.Internal, "internal error")
 	}
 	return &ssov1.LoginResponse{Token: token}, nil
 }
 
-func validateLogin(req *ssov1.LoginRequest) error {
-	if req.GetEmail() == "" {
-		return status.Error(codes.InvalidArgument, "email is required")
+// ReenrollBiometrics replaces the keystroke template of the token owner.
+//
+// It requires both a valid token and the user's password.
+func (s *serverAPI) ReenrollBiometrics(ctx context.Context, req *ssov1.ReenrollBiometricsRequest) (*ssov1.ReenrollBiometricsResponse, error) {
+	if err := validateReenrollBiometrics(req); err != nil {
+		return nil, err
 	}
+	err := s.auth.ReenrollBiometrics(ctx, req.GetToken(), req.GetPassword(), milliseconds(req.GetKeyPressTimes()), milliseconds(req.GetKeyPressIntervals()), keyEvents(req.GetKeyEvents()))
+	if err != nil {
+		if errors.Is(err, auth.ErrInvalidToken) {
+			return nil, status.Error(codes.Unauthenticated, "invalid token")
+		}
+		if errors.Is(err, auth.ErrInvalidCredentials) {
+			return nil, status.Error(codes.InvalidArgument, "invalid credentials")
+		}
+		return nil, status.Error(codes.Internal, "internal error")
+	}
+	return &ssov1.ReenrollBiometricsResponse{}, nil
+}
 
-	if req.GetPassword() == "" {
-		return status.Error(codes.InvalidArgument, "password is required")
+// ResetBiometrics puts the user into enrollment pending state.
+//
+// It is allowed for admins only; the next successful login of the user
+// captures a fresh keystroke template.
+func (s *serverAPI) ResetBiometrics(ctx context.Context, req *ssov1.ResetBiometricsRequest) (*ssov1.ResetBiometricsResponse, error) {
+	if err := validateResetBiometrics(req); err != nil {
+		return nil, err
 	}
+	err := s.auth.ResetBiometrics(ctx, req.GetToken(), req.GetUserId())
+	if err != nil {
+		if errors.Is(err, auth.ErrInvalidToken) {
+			return nil, status.Error(codes.Unauthenticated, "invalid token")
+		}
+		if errors.Is(err, auth.ErrPermissionDenied) {
+			return nil, status.Error(codes.PermissionDenied, "permission denied")
+		}
+		if errors.Is(err, auth.ErrUserNotFound) {
+			return nil, status.Error(codes.NotFound, "user not found")
+		}
+		return nil, status.Error(codes.Internal, "internal error")
+	}
+	return &ssov1.ResetBiometricsResponse{}, nil
+}
 
-	if len(req.GetKeyPressIntervals()) < 1 {
-		return status.Error(codes.InvalidArgument, "keyPressIntervals is required")
+// DeleteAccount deletes the token owner's account, confirmed with the
+// password, or with an admin token the account of user_id.
+//
+// delete_after is the unix time the account will be purged at when the
+// server keeps deleted accounts for a grace period, during which logging in
+// cancels the deletion; it is 0 if the account was deleted at once.
+func (s *serverAPI) DeleteAccount(ctx context.Context, req *ssov1.DeleteAccountRequest) (*ssov1.DeleteAccountResponse, error) {
+	if err := validateDeleteAccount(req); err != nil {
+		return nil, err
+	}
+	ctx = auth.ContextWithClient(ctx, ClientInfo(ctx))
+	deleteAfter, err := s.auth.DeleteAccount(ctx, req.GetToken(), req.GetPassword(), req.GetUserId())
+	if err != nil {
+		if errors.Is(err, auth.ErrInvalidToken) {
+			return nil, status.Error(codes.Unauthenticated, "invalid token")
+		}
+		if errors.Is(err, auth.ErrInvalidCredentials) {
+			return nil, status.Error(codes.InvalidArgument, "invalid credentials")
+		}
+		if errors.Is(err, auth.ErrPermissionDenied) {
+			return nil, status.Error(codes.PermissionDenied, "permission denied")
+		}
+		if errors.Is(err, auth.ErrUserNotFound) {
+			return nil, status.Error(codes.NotFound, "user not found")
+		}
+		return nil, status.Error(codes.Internal, "internal error")
+	}
+
+	resp := &ssov1.DeleteAccountResponse{}
+	if !deleteAfter.IsZero() {
+		resp.DeleteAfter = deleteAfter.Unix()
+	}
+	return resp, nil
+}
+
+// ExportMyData returns everything the service keeps about the token owner
+// as a JSON document.
+func (s *serverAPI) ExportMyData(ctx context.Context, req *ssov1.ExportMyDataRequest) (*ssov1.ExportMyDataResponse, error) {
+	if req.GetToken() == "" {
+		return nil, status.Error(codes.InvalidArgument, "token is required")
+	}
+	ctx = auth.ContextWithClient(ctx, ClientInfo(ctx))
+	export, err := s.auth.ExportMyData(ctx, req.GetToken())
+	if err != nil {
+		if errors.Is(err, auth.ErrInvalidToken) {
+			return nil, status.Error(codes.Unauthenticated, "invalid token")
+		}
+		return nil, status.Error(codes.Internal, "internal error")
+	}
+
+	data, err := json.Marshal(export)
+	if err != nil {
+		return nil, status.Error(codes.Internal, "internal error")
+	}
+	return &ssov1.ExportMyDataResponse{Data: data, ContentType: "application/json"}, nil
+}
+
+// ContinuousAuth re-verifies an active session from free typing.
+//
+// The first message must carry the session token. Every batch of key events
+// is answered with a risk update; when the running score drops below the
+// app threshold the session is revoked and the stream is closed.
+func (s *serverAPI) ContinuousAuth(stream ssov1.Auth_ContinuousAuthServer) error {
+	ctx := stream.Context()
 
+	req, err := stream.Recv()
+	if err != nil {
+		return err
+	}
+	if req.GetToken() == "" {
+		return status.Error(codes.InvalidArgument, "token is required")
 	}
 
-	if len(req.GetKeyPressTimes()) < 1 {
-		return status.Error(codes.InvalidArgument, "keyPressTimes is required")
+	session, err := s.auth.StartContinuousAuth(ctx, req.GetToken())
+	if err != nil {
+		if errors.Is(err, auth.ErrInvalidToken) {
+			return status.Error(codes.Unauthenticated, "invalid token")
+		}
+		if errors.Is(err, auth.ErrNoKeystrokeProfile) {
+			return status.Error(codes.FailedPrecondition, "no keystroke profile")
+		}
+		return status.Error(codes.Internal, "internal error")
 	}
 
-	if req.GetAppId() == emptyValue {
-		return status.Error(codes.InvalidArgument, "app_id is required")
+	for {
+		update, err := session.Observe(ctx, keyEvents(req.GetKeyEvents()))
+		if err != nil {
+			return status.Error(codes.Internal, "internal error")
+		}
+
+		err = stream.Send(&ssov1.ContinuousAuthResponse{
+			Score:   update.Score,
+			Risk:    update.Risk,
+			Revoked: update.Revoked,
+		})
+		if err != nil {
+			return err
+		}
+		if update.Revoked {
+			return status.Error(codes.Unauthenticated, "session revoked")
+		}
+
+		req, err = stream.Recv()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
 	}
-	return nil
 }
 
-func validateRegister(req *ssov1.RegisterRequest) error {
-	if req.GetEmail() == "" {
-		return status.Error(codes.InvalidArgument, "email is required")
+func keyEvents(events []*ssov1.KeyEvent) []models.KeyEvent {
+	if len(events) == 0 {
+		return nil
 	}
-	if req.GetPassword() == "" {
-		return status.Error(codes.InvalidArgument, "password is required")
+
+	result := make([]models.KeyEvent, 0, len(events))
+	for _, e := range events {
+		result = append(result, models.KeyEvent{
+			Key:        e.GetKey(),
+			PressedAt:  e.GetPressedAt(),
+			ReleasedAt: e.GetReleasedAt(),
+		})
 	}
+	return result
+}
 
-	if len(req.GetKeyPressIntervals()) < 1 {
-		return status.Error(codes.InvalidArgument, "keyPressIntervals is required")
+// ClientInfo takes the client address from the peer and the device from the
+// x-device-id header, falling back to the user agent.
+func ClientInfo(ctx context.Context) auth.ClientInfo {
+	var client auth.ClientInfo
 
+	if p, ok := peer.FromContext(ctx); ok && p.Addr != nil {
+		client.IP = p.Addr.String()
+		if host, _, err := net.SplitHostPort(client.IP); err == nil {
+			client.IP = host
+		}
 	}
 
-	if len(req.GetKeyPressTimes()) < 1 {
-		return status.Error(codes.InvalidArgument, "keyPressTimes is required")
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		for _, key := range []string{"x-device-id", "user-agent"} {
+			if values := md.Get(key); len(values) > 0 && values[0] != "" {
+				client.Device = values[0]
+				break
+			}
+		}
 	}
-	return nil
+
+	return client
 }
 
-func validateIsAdmin(req *ssov1.IsAdminRequest) error {
-	if req.GetUserId() == emptyValue {
-		return status.Error(codes.InvalidArgument, "user_id is required")
+// sampleRejected returns a PermissionDenied status carrying reason in an
+// errdetails.ErrorInfo.
+func sampleRejected(reason string, msg string) error {
+	st := status.New(codes.PermissionDenied, msg)
+	detailed, err := st.WithDetails(&errdetails.ErrorInfo{Reason: reason, Domain: ErrorDomain})
+	if err != nil {
+		return st.Err()
 	}
-	return nil
+	return detailed.Err()
 }
@@ -0,0 +1,245 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	ssov1 "github.com/some-kikikiss/protos-sso/gen/go/sso"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"io"
+	"sso/internal/domain/models"
+	"sso/internal/lib/biometrics"
+	"sso/internal/lib/logger/handlers/slogdiscard"
+	"sso/internal/lib/mailer"
+	"sso/internal/services/auth"
+	"sso/internal/services/risk"
+	"sso/internal/storage/memory"
+	"testing"
+	"time"
+)
+
+// loginAuth fails every login with err.
+type loginAuth struct {
+	Auth
+	err error
+}
+
+func (a loginAuth) Login(context.Context, string, string, []float32, []float32, []models.KeyEvent, int) (string, error) {
+	return "", a.err
+}
+
+func TestLogin_RejectedSampleReasons(t *testing.T) {
+	req := &ssov1.LoginRequest{
+		Email:             "user@example.com",
+		Password:          "secret",
+		KeyPressTimes:     []float32{110, 95, 130, 120, 105, 98},
+		KeyPressIntervals: []float32{210, 180, 250, 190, 230},
+		AppId:             1,
+	}
+
+	for _, tt := range []struct {
+		err    error
+		code   codes.Code
+		reason string
+	}{
+		{err: auth.ErrReplayedSample, code: codes.PermissionDenied, reason: ReasonSampleReplayed},
+		{err: auth.ErrSyntheticSample, code: codes.PermissionDenied, reason: ReasonSampleSynthetic},
+		{err: auth.ErrLoginDenied, code: codes.PermissionDenied},
+		{err: auth.ErrInvalidCredentials, code: codes.InvalidArgument},
+		{err: auth.ErrInvalidBiometrics, code: codes.Unauthenticated},
+	} {
+		t.Run(tt.err.Error(), func(t *testing.T) {
+			s := &serverAPI{auth: loginAuth{err: fmt.Errorf("auth.Login: %w", tt.err)}}
+
+			_, err := s.Login(context.Background(), req)
+			require.Error(t, err)
+			st := status.Convert(err)
+			assert.Equal(t, tt.code, st.Code())
+
+			var info *errdetails.ErrorInfo
+			for _, d := range st.Details() {
+				if i, ok := d.(*errdetails.ErrorInfo); ok {
+					info = i
+				}
+			}
+			if tt.reason == "" {
+				assert.Nil(t, info)
+				return
+			}
+			require.NotNil(t, info)
+			assert.Equal(t, tt.reason, info.GetReason())
+			assert.Equal(t, ErrorDomain, info.GetDomain())
+		})
+	}
+}
+
+// timingsAuth records the timings a login reaches the service with.
+type timingsAuth struct {
+	Auth
+	pressTimes, intervalTimes *[]float32
+}
+
+func (a timingsAuth) Login(_ context.Context, _ string, _ string, pressTimes []float32, intervalTimes []float32, _ []models.KeyEvent, _ int) (string, error) {
+	*a.pressTimes, *a.intervalTimes = pressTimes, intervalTimes
+	return "token", nil
+}
+
+func TestLogin_TimingUnits(t *testing.T) {
+	for name, tt := range map[string]struct {
+		pressTimes, intervalTimes []float32
+	}{
+		"milliseconds": {
+			pressTimes:    []float32{110, 95, 130, 120, 105, 98},
+			intervalTimes: []float32{210, 180, 250, 190, 230},
+		},
+		"seconds": {
+			pressTimes:    []float32{0.11, 0.095, 0.13, 0.12, 0.105, 0.098},
+			intervalTimes: []float32{0.21, 0.18, 0.25, 0.19, 0.23},
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			var pressTimes, intervalTimes []float32
+			s := &serverAPI{auth: timingsAuth{pressTimes: &pressTimes, intervalTimes: &intervalTimes}}
+
+			_, err := s.Login(context.Background(), &ssov1.LoginRequest{
+				Email:             "user@example.com",
+				Password:          "secret",
+				KeyPressTimes:     tt.pressTimes,
+				KeyPressIntervals: tt.intervalTimes,
+				AppId:             1,
+			})
+			require.NoError(t, err)
+
+			assert.InDeltaSlice(t, []float32{110, 95, 130, 120, 105, 98}, pressTimes, 1e-3)
+			assert.InDeltaSlice(t, []float32{210, 180, 250, 190, 230}, intervalTimes, 1e-3)
+		})
+	}
+}
+
+func TestLogin_MixedTimingUnits(t *testing.T) {
+	s := &serverAPI{auth: loginAuth{}}
+
+	_, err := s.Login(context.Background(), &ssov1.LoginRequest{
+		Email:             "user@example.com",
+		Password:          "secret",
+		KeyPressTimes:     []float32{0.11, 0.095, 0.13, 0.12, 0.105, 0.098},
+		KeyPressIntervals: []float32{210, 180, 250, 190, 230},
+		AppId:             1,
+	})
+	require.Error(t, err)
+	st := status.Convert(err)
+	assert.Equal(t, codes.InvalidArgument, st.Code())
+
+	var fields []string
+	for _, d := range st.Details() {
+		if br, ok := d.(*errdetails.BadRequest); ok {
+			for _, fv := range br.GetFieldViolations() {
+				fields = append(fields, fv.GetField())
+			}
+		}
+	}
+	assert.Contains(t, fields, "key_press_intervals")
+}
+
+// continuousAuth starts continuous auth with the given result.
+type continuousAuth struct {
+	Auth
+	session *auth.ContinuousSession
+	err     error
+}
+
+func (a continuousAuth) StartContinuousAuth(context.Context, string) (*auth.ContinuousSession, error) {
+	return a.session, a.err
+}
+
+// continuousStream replays requests and records the responses.
+type continuousStream struct {
+	grpc.ServerStream
+	requests  []*ssov1.ContinuousAuthRequest
+	responses []*ssov1.ContinuousAuthResponse
+}
+
+func (s *continuousStream) Context() context.Context { return context.Background() }
+
+func (s *continuousStream) Recv() (*ssov1.ContinuousAuthRequest, error) {
+	if len(s.requests) == 0 {
+		return nil, io.EOF
+	}
+	req := s.requests[0]
+	s.requests = s.requests[1:]
+	return req, nil
+}
+
+func (s *continuousStream) Send(resp *ssov1.ContinuousAuthResponse) error {
+	s.responses = append(s.responses, resp)
+	return nil
+}
+
+func TestContinuousAuth_StartErrors(t *testing.T) {
+	for name, tt := range map[string]struct {
+		token string
+		err   error
+		code  codes.Code
+	}{
+		"no token":      {code: codes.InvalidArgument},
+		"invalid token": {token: "token", err: auth.ErrInvalidToken, code: codes.Unauthenticated},
+		"no profile":    {token: "token", err: auth.ErrNoKeystrokeProfile, code: codes.FailedPrecondition},
+		"storage error": {token: "token", err: errors.New("disk full"), code: codes.Internal},
+	} {
+		t.Run(name, func(t *testing.T) {
+			s := &serverAPI{auth: continuousAuth{err: fmt.Errorf("auth.StartContinuousAuth: %w", tt.err)}}
+			stream := &continuousStream{requests: []*ssov1.ContinuousAuthRequest{{Token: tt.token}}}
+
+			err := s.ContinuousAuth(stream)
+			assert.Equal(t, tt.code, status.Code(err))
+			assert.Empty(t, stream.responses)
+		})
+	}
+}
+
+func TestContinuousAuth_RevokesAndCloses(t *testing.T) {
+	ctx := context.Background()
+	log := slogdiscard.NewDiscardLogger()
+	storage := memory.New()
+	require.NoError(t, storage.SaveApp(ctx, models.App{ID: 1, Name: "test", Secret: "secret", ContinuousAuthThreshold: 0.5}))
+	matcher := biometrics.NewMatcher(biometrics.DefaultLowerThreshold, biometrics.DefaultUpperThreshold, biometrics.DefaultFeatureTolerance)
+	engine := risk.New(log, risk.Thresholds{StepUp: 0.5, Deny: 0.8}).UseDefaultSignals()
+	a := auth.New(log, storage, storage, storage, storage, storage, storage, storage, storage, mailer.NewLog(log), matcher, engine, time.Hour, "sso", 1, 0)
+
+	// typed returns "password" typed with a constant dwell and gap from offset
+	typed := func(offset, dwell, gap float64) []*ssov1.KeyEvent {
+		var events []*ssov1.KeyEvent
+		for i, key := range "password" {
+			at := offset + float64(i)*gap
+			events = append(events, &ssov1.KeyEvent{Key: string(key), PressedAt: at, ReleasedAt: at + dwell})
+		}
+		return events
+	}
+
+	_, err := a.RegisterNewUser(ctx, "user@example.com", "password", nil, nil, keyEvents(typed(0, 100, 200)))
+	require.NoError(t, err)
+	token, err := a.Login(ctx, "user@example.com", "password", nil, nil, keyEvents(typed(0, 104, 207)), 1)
+	require.NoError(t, err)
+
+	stream := &continuousStream{requests: []*ssov1.ContinuousAuthRequest{
+		{Token: token, KeyEvents: typed(10000, 102, 203)},
+	}}
+	for i := 1; i <= 10; i++ {
+		stream.requests = append(stream.requests, &ssov1.ContinuousAuthRequest{KeyEvents: typed(float64(i+1)*10000, 300, 600)})
+	}
+
+	err = (&serverAPI{auth: a}).ContinuousAuth(stream)
+	assert.Equal(t, codes.Unauthenticated, status.Code(err), "the stream must close once the session is revoked")
+
+	require.Greater(t, len(stream.responses), 1)
+	assert.False(t, stream.responses[0].GetRevoked(), "the owner's typing must keep the session")
+	last := stream.responses[len(stream.responses)-1]
+	assert.True(t, last.GetRevoked())
+	assert.Less(t, last.GetScore(), 0.5)
+	assert.NotEmpty(t, stream.requests, "no batches may be read after the revocation")
+}
@@ -0,0 +1,348 @@
+package auth
+
+import (
+	"fmt"
+	ssov1 "github.com/some-kikikiss/protos-sso/gen/go/sso"
+	"golang.org/x/text/language"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"math"
+	"net/mail"
+	"sort"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Physiological bounds of keystroke timings, in milliseconds. Arrays may
+// also be sent in seconds; they are checked against the same bounds scaled
+// and converted to milliseconds before they reach the service.
+const (
+	maxPressTime    = 2000
+	maxIntervalTime = 5000
+
+	// secondsBoundary separates the units: no one holds a key for 5ms, and
+	// no one holds it for 5s either.
+	secondsBoundary = 5
+)
+
+// violations collects field violations of a request, so a client gets all
+// of them at once in errdetails.BadRequest.
+type violations []*errdetails.BadRequest_FieldViolation
+
+func (v *violations) add(field, description string) {
+	*v = append(*v, &errdetails.BadRequest_FieldViolation{
+		Field:       field,
+		Description: description,
+	})
+}
+
+// err returns an InvalidArgument status carrying the violations, or nil.
+func (v violations) err() error {
+	if len(v) == 0 {
+		return nil
+	}
+
+	descriptions := make([]string, 0, len(v))
+	for _, fv := range v {
+		descriptions = append(descriptions, fv.GetDescription())
+	}
+
+	st := status.New(codes.InvalidArgument, strings.Join(descriptions, "; "))
+	detailed, err := st.WithDetails(&errdetails.BadRequest{FieldViolations: v})
+	if err != nil {
+		return st.Err()
+	}
+	return detailed.Err()
+}
+
+func validateLogin(req *ssov1.LoginRequest) error {
+	var v violations
+
+	if req.GetEmail() == "" {
+		v.add("email", "email is required")
+	}
+
+	if req.GetPassword() == "" {
+		v.add("password", "password is required")
+	}
+
+	validateTimings(&v, req.GetPassword(), req.GetKeyPressTimes(), req.GetKeyPressIntervals(), req.GetKeyEvents())
+
+	if req.GetAppId() == emptyValue {
+		v.add("app_id", "app_id is required")
+	}
+	return v.err()
+}
+
+func validateRegister(req *ssov1.RegisterRequest) error {
+	var v violations
+
+	if req.GetEmail() == "" {
+		v.add("email", "email is required")
+	}
+	if req.GetPassword() == "" {
+		v.add("password", "password is required")
+	}
+
+	validateTimings(&v, req.GetPassword(), req.GetKeyPressTimes(), req.GetKeyPressIntervals(), req.GetKeyEvents())
+
+	return v.err()
+}
+
+func validateIsAdmin(req *ssov1.IsAdminRequest) error {
+	if req.GetUserId() == emptyValue {
+		return status.Error(codes.InvalidArgument, "user_id is required")
+	}
+	return nil
+}
+
+func validateReenrollBiometrics(req *ssov1.ReenrollBiometricsRequest) error {
+	var v violations
+
+	if req.GetToken() == "" {
+		v.add("token", "token is required")
+	}
+	if req.GetPassword() == "" {
+		v.add("password", "password is required")
+	}
+
+	validateTimings(&v, req.GetPassword(), req.GetKeyPressTimes(), req.GetKeyPressIntervals(), req.GetKeyEvents())
+
+	return v.err()
+}
+
+func validateResetBiometrics(req *ssov1.ResetBiometricsRequest) error {
+	if req.GetToken() == "" {
+		return status.Error(codes.InvalidArgument, "token is required")
+	}
+	if req.GetUserId() == emptyValue {
+		return status.Error(codes.InvalidArgument, "user_id is required")
+	}
+	return nil
+}
+
+// validateDeleteAccount requires the password for self-service deletion;
+// admins deleting another account name it by user_id instead.
+func validateDeleteAccount(req *ssov1.DeleteAccountRequest) error {
+	var v violations
+
+	if req.GetToken() == "" {
+		v.add("token", "token is required")
+	}
+	if req.GetUserId() < 0 {
+		v.add("user_id", "user_id must be positive")
+	}
+	if req.GetUserId() == emptyValue && req.GetPassword() == "" {
+		v.add("password", "password is required")
+	}
+	return v.err()
+}
+
+// maxDisplayNameLength is the longest display name accepted, in characters.
+const maxDisplayNameLength = 64
+
+// validateUpdateProfile checks the fields present in the request; empty
+// values clear them.
+func validateUpdateProfile(req *ssov1.UpdateProfileRequest) error {
+	var v violations
+
+	if req.GetToken() == "" {
+		v.add("token", "token is required")
+	}
+	if name := req.GetDisplayName(); utf8.RuneCountInString(name) > maxDisplayNameLength {
+		v.add("display_name", fmt.Sprintf("display_name must be at most %d characters", maxDisplayNameLength))
+	} else if strings.ContainsFunc(name, unicode.IsControl) {
+		v.add("display_name", "display_name must not contain control characters")
+	}
+	if locale := req.GetLocale(); locale != "" {
+		if _, err := language.Parse(locale); err != nil {
+			v.add("locale", "locale must be a BCP 47 language tag")
+		}
+	}
+	if tz := req.GetTimezone(); tz != "" {
+		// "Local" is the zone of the server, not one a user can live in.
+		if _, err := time.LoadLocation(tz); err != nil || tz == "Local" {
+			v.add("timezone", "timezone must be an IANA time zone name")
+		}
+	}
+	return v.err()
+}
+
+func validateChangePassword(req *ssov1.ChangePasswordRequest) error {
+	var v violations
+
+	if req.GetToken() == "" {
+		v.add("token", "token is required")
+	}
+	if req.GetCurrentPassword() == "" {
+		v.add("current_password", "current_password is required")
+	}
+	if req.GetNewPassword() == "" {
+		v.add("new_password", "new_password is required")
+	}
+
+	validateTimings(&v, req.GetNewPassword(), req.GetKeyPressTimes(), req.GetKeyPressIntervals(), req.GetKeyEvents())
+
+	return v.err()
+}
+
+func validateChangeEmail(req *ssov1.ChangeEmailRequest) error {
+	var v violations
+
+	if req.GetToken() == "" {
+		v.add("token", "token is required")
+	}
+	if req.GetPassword() == "" {
+		v.add("password", "password is required")
+	}
+	if req.GetNewEmail() == "" {
+		v.add("new_email", "new_email is required")
+	} else if addr, err := mail.ParseAddress(req.GetNewEmail()); err != nil || addr.Address != req.GetNewEmail() {
+		// A bare address only: names and comments would end up in headers.
+		v.add("new_email", "new_email must be an email address")
+	}
+	return v.err()
+}
+
+func validateConfirmEmailChange(req *ssov1.ConfirmEmailChangeRequest) error {
+	var v violations
+
+	if req.GetToken() == "" {
+		v.add("token", "token is required")
+	}
+	if req.GetCode() == "" {
+		v.add("code", "code is required")
+	}
+	return v.err()
+}
+
+// validateTimings requires either key events or both timing arrays, never
+// both, and checks that they could have been produced by a human typing the
+// password. The arrays of a request with key events are derived from them.
+func validateTimings(v *violations, password string, pressTimes []float32, intervalTimes []float32, events []*ssov1.KeyEvent) {
+	length := utf8.RuneCountInString(password)
+
+	if len(events) > 0 {
+		if len(pressTimes) > 0 || len(intervalTimes) > 0 {
+			v.add("key_events", "keyEvents must not be sent along with keyPressTimes and keyPressIntervals")
+			return
+		}
+		validateKeyEvents(v, length, events)
+		return
+	}
+
+	if len(intervalTimes) < 1 {
+		v.add("key_press_intervals", "keyPressIntervals is required")
+	}
+	if len(pressTimes) < 1 {
+		v.add("key_press_times", "keyPressTimes is required")
+	}
+	if len(pressTimes) < 1 || len(intervalTimes) < 1 {
+		return
+	}
+
+	if length > 0 && len(pressTimes) != length {
+		v.add("key_press_times", fmt.Sprintf("keyPressTimes must have %d values, one per password character", length))
+	}
+	if length > 0 && len(intervalTimes) != length && len(intervalTimes) != length-1 {
+		v.add("key_press_intervals", fmt.Sprintf("keyPressIntervals must have %d or %d values", length-1, length))
+	}
+
+	pressScale := unitScale(pressTimes)
+	intervalScale := unitScale(intervalTimes)
+	if pressScale != intervalScale {
+		v.add("key_press_intervals", "keyPressTimes and keyPressIntervals must use the same unit")
+	}
+
+	validateRange(v, "key_press_times", pressTimes, maxPressTime/pressScale)
+	validateRange(v, "key_press_intervals", intervalTimes, maxIntervalTime/intervalScale)
+}
+
+func validateKeyEvents(v *violations, length int, events []*ssov1.KeyEvent) {
+	if len(events) < length {
+		v.add("key_events", fmt.Sprintf("keyEvents must have at least %d events, one per password character", length))
+	}
+
+	sorted := append([]*ssov1.KeyEvent(nil), events...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].GetPressedAt() < sorted[j].GetPressedAt()
+	})
+
+	for i, e := range sorted {
+		field := fmt.Sprintf("key_events[%d]", i)
+
+		if e.GetKey() == "" {
+			v.add(field+".key", "keyEvents.key is required")
+		}
+		if !isFinite(e.GetPressedAt()) || !isFinite(e.GetReleasedAt()) || e.GetPressedAt() < 0 {
+			v.add(field, "keyEvents timestamps must be finite and non-negative")
+			continue
+		}
+		if dwell := e.GetReleasedAt() - e.GetPressedAt(); dwell <= 0 || dwell > maxPressTime {
+			v.add(field+".released_at", fmt.Sprintf("key must be held for (0, %d] ms", maxPressTime))
+		}
+		if i > 0 && e.GetPressedAt()-sorted[i-1].GetPressedAt() > maxIntervalTime {
+			v.add(field+".pressed_at", fmt.Sprintf("keys must follow each other within %d ms", maxIntervalTime))
+		}
+	}
+}
+
+// validateRange checks every value is finite and in (0, limit].
+func validateRange(v *violations, field string, values []float32, limit float64) {
+	for i, value := range values {
+		f := float64(value)
+		if !isFinite(f) {
+			v.add(fmt.Sprintf("%s[%d]", field, i), "value must be a finite number")
+			continue
+		}
+		if f <= 0 {
+			v.add(fmt.Sprintf("%s[%d]", field, i), "value must be positive")
+			continue
+		}
+		if f > limit {
+			v.add(fmt.Sprintf("%s[%d]", field, i), fmt.Sprintf("value must not exceed %g", limit))
+		}
+	}
+}
+
+// unitScale guesses the unit of an array from its median: 1 for
+// milliseconds, 1000 for seconds.
+func unitScale(values []float32) float64 {
+	sorted := make([]float64, 0, len(values))
+	for _, value := range values {
+		if isFinite(float64(value)) {
+			sorted = append(sorted, float64(value))
+		}
+	}
+	if len(sorted) == 0 {
+		return 1
+	}
+	sort.Float64s(sorted)
+
+	if sorted[len(sorted)/2] < secondsBoundary {
+		return 1000
+	}
+	return 1
+}
+
+// milliseconds returns the array converted to milliseconds, so templates and
+// samples compare in one unit whatever a client sends.
+func milliseconds(values []float32) []float32 {
+	scale := unitScale(values)
+	if scale == 1 {
+		return values
+	}
+
+	converted := make([]float32, len(values))
+	for i, value := range values {
+		converted[i] = float32(float64(value) * scale)
+	}
+	return converted
+}
+
+func isFinite(f float64) bool {
+	return !math.IsNaN(f) && !math.IsInf(f, 0)
+}
@@ -0,0 +1,188 @@
+// Package interceptors holds the interceptors every call of the gRPC server
+// goes through. Chain them in the order RequestID, Logging, Recovery: the
+// access log then carries the request ID and sees recovered panics as
+// Internal.
+package interceptors
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	"log/slog"
+	"regexp"
+	"runtime/debug"
+	"sso/internal/lib/logger/sl"
+	"time"
+)
+
+// RequestIDHeader is the metadata key of the request ID. An ID sent by the
+// client is kept, otherwise one is generated; either way it is returned in
+// the response header.
+const RequestIDHeader = "x-request-id"
+
+// maxRequestIDLen caps request IDs taken from clients.
+const maxRequestIDLen = 64
+
+// RequestID puts the request ID of every unary call into the context, see
+// sl.WithRequestID.
+func RequestID() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, _ *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		id := incomingRequestID(ctx)
+		_ = grpc.SetHeader(ctx, metadata.Pairs(RequestIDHeader, id))
+		return handler(sl.WithRequestID(ctx, id), req)
+	}
+}
+
+// StreamRequestID is RequestID for streams.
+func StreamRequestID() grpc.StreamServerInterceptor {
+	return func(srv any, ss grpc.ServerStream, _ *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		id := incomingRequestID(ss.Context())
+		_ = ss.SetHeader(metadata.Pairs(RequestIDHeader, id))
+		return handler(srv, &contextStream{ServerStream: ss, ctx: sl.WithRequestID(ss.Context(), id)})
+	}
+}
+
+func incomingRequestID(ctx context.Context) string {
+	md, _ := metadata.FromIncomingContext(ctx)
+	if values := md.Get(RequestIDHeader); len(values) > 0 && validRequestID(values[0]) {
+		return values[0]
+	}
+
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "unknown"
+	}
+	return hex.EncodeToString(b)
+}
+
+// validRequestID accepts short IDs of URL-safe characters only, so a client
+// cannot forge log lines through its request ID.
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLen {
+		return false
+	}
+	for _, c := range id {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9', c == '-', c == '_', c == '.':
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// Logging logs the method, peer, status code and latency of every unary
+// call. Neither requests nor responses are logged: they carry passwords,
+// emails, tokens and keystroke samples. Status messages are redacted.
+func Logging(log *slog.Logger) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		start := time.Now()
+		resp, err := handler(ctx, req)
+		logCall(ctx, log, info.FullMethod, start, err)
+		return resp, err
+	}
+}
+
+// StreamLogging is Logging for streams.
+func StreamLogging(log *slog.Logger) grpc.StreamServerInterceptor {
+	return func(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		start := time.Now()
+		err := handler(srv, ss)
+		logCall(ss.Context(), log, info.FullMethod, start, err)
+		return err
+	}
+}
+
+func logCall(ctx context.Context, log *slog.Logger, method string, start time.Time, err error) {
+	st := status.Convert(err)
+
+	attrs := []slog.Attr{
+		slog.String("method", method),
+		slog.String("peer", peerAddr(ctx)),
+		slog.String("code", st.Code().String()),
+		slog.Duration("latency", time.Since(start)),
+	}
+	if err != nil {
+		attrs = append(attrs, slog.String("error", Redact(st.Message())))
+	}
+
+	log.LogAttrs(ctx, callLevel(st.Code()), "grpc call", attrs...)
+}
+
+// callLevel logs server faults as errors; client errors are routine.
+func callLevel(code codes.Code) slog.Level {
+	switch code {
+	case codes.Unknown, codes.Internal, codes.DataLoss, codes.Unimplemented:
+		return slog.LevelError
+	}
+	return slog.LevelInfo
+}
+
+func peerAddr(ctx context.Context) string {
+	p, ok := peer.FromContext(ctx)
+	if !ok || p.Addr == nil {
+		return "unknown"
+	}
+	return p.Addr.String()
+}
+
+// Recovery turns a panic of a unary handler into an Internal error instead of
+// crashing the server, and logs the panic with its stack.
+func Recovery(log *slog.Logger) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (resp any, err error) {
+		defer func() {
+			if p := recover(); p != nil {
+				err = recovered(ctx, log, info.FullMethod, p)
+			}
+		}()
+		return handler(ctx, req)
+	}
+}
+
+// StreamRecovery is Recovery for streams.
+func StreamRecovery(log *slog.Logger) grpc.StreamServerInterceptor {
+	return func(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) (err error) {
+		defer func() {
+			if p := recover(); p != nil {
+				err = recovered(ss.Context(), log, info.FullMethod, p)
+			}
+		}()
+		return handler(srv, ss)
+	}
+}
+
+func recovered(ctx context.Context, log *slog.Logger, method string, p any) error {
+	log.LogAttrs(ctx, slog.LevelError, "recovered from panic",
+		slog.String("method", method),
+		slog.String("panic", Redact(fmt.Sprint(p))),
+		slog.String("stack", string(debug.Stack())),
+	)
+	return status.Error(codes.Internal, "internal error")
+}
+
+var (
+	emailRe = regexp.MustCompile(`[A-Za-z0-9._%+\-]+@[A-Za-z0-9.\-]+\.[A-Za-z]{2,}`)
+	tokenRe = regexp.MustCompile(`eyJ[A-Za-z0-9_\-]*\.[A-Za-z0-9_\-]*\.[A-Za-z0-9_\-]*`)
+)
+
+// Redact masks emails and JWTs in s.
+func Redact(s string) string {
+	s = emailRe.ReplaceAllString(s, "[email]")
+	return tokenRe.ReplaceAllString(s, "[token]")
+}
+
+// contextStream replaces the context of a stream.
+type contextStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *contextStream) Context() context.Context {
+	return s.ctx
+}
@@ -0,0 +1,167 @@
+package interceptors_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+	"log/slog"
+	"net"
+	"sso/internal/grpc/interceptors"
+	"sso/internal/lib/logger/sl"
+	"strings"
+	"testing"
+)
+
+// newLogger returns a logger writing JSON records into buf, with request
+// IDs taken from the context as in the service.
+func newLogger(buf *bytes.Buffer) *slog.Logger {
+	return slog.New(sl.NewContextHandler(slog.NewJSONHandler(buf, &slog.HandlerOptions{Level: slog.LevelDebug})))
+}
+
+// records decodes the JSON records written into buf.
+func records(t *testing.T, buf *bytes.Buffer) []map[string]any {
+	t.Helper()
+
+	var recs []map[string]any
+	for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		if line == "" {
+			continue
+		}
+		var rec map[string]any
+		require.NoError(t, json.Unmarshal([]byte(line), &rec))
+		recs = append(recs, rec)
+	}
+	return recs
+}
+
+// chain runs handler through the interceptors in the order of the server.
+func chain(log *slog.Logger, handler grpc.UnaryHandler) grpc.UnaryHandler {
+	info := &grpc.UnaryServerInfo{FullMethod: "/auth.Auth/Login"}
+	for _, ic := range []grpc.UnaryServerInterceptor{interceptors.Recovery(log), interceptors.Logging(log), interceptors.RequestID()} {
+		ic, next := ic, handler
+		handler = func(ctx context.Context, req any) (any, error) {
+			return ic(ctx, req, info, next)
+		}
+	}
+	return handler
+}
+
+func TestRedact(t *testing.T) {
+	for in, want := range map[string]string{
+		"user not found": "user not found",
+		"user john.doe+sso@mail.example.com gone":            "user [email] gone",
+		"token eyJhbGciOi.eyJ1aWQiOjF9.c2lnbmF0dXJl invalid": "token [token] invalid",
+		"a@b.io and c@d.io":                                  "[email] and [email]",
+	} {
+		assert.Equal(t, want, interceptors.Redact(in), in)
+	}
+}
+
+func TestRecovery(t *testing.T) {
+	var buf bytes.Buffer
+	log := newLogger(&buf)
+
+	handler := chain(log, func(context.Context, any) (any, error) {
+		var pressTimes []float32
+		return pressTimes[3], nil
+	})
+
+	ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs(interceptors.RequestIDHeader, "req-1"))
+	_, err := handler(ctx, nil)
+	require.Error(t, err)
+	assert.Equal(t, codes.Internal, status.Code(err))
+	assert.Equal(t, "internal error", status.Convert(err).Message(), "panic details must not reach the client")
+
+	recs := records(t, &buf)
+	require.Len(t, recs, 2)
+	assert.Equal(t, "recovered from panic", recs[0]["msg"])
+	assert.Contains(t, recs[0]["panic"], "index out of range")
+	assert.Contains(t, recs[0]["stack"], "runtime/debug.Stack")
+	assert.Equal(t, "grpc call", recs[1]["msg"])
+	assert.Equal(t, codes.Internal.String(), recs[1]["code"])
+	for _, rec := range recs {
+		assert.Equal(t, "req-1", rec[sl.RequestIDKey])
+	}
+}
+
+func TestLogging_RedactsErrors(t *testing.T) {
+	var buf bytes.Buffer
+
+	handler := chain(newLogger(&buf), func(context.Context, any) (any, error) {
+		return nil, status.Error(codes.NotFound, "no user user@example.com")
+	})
+	_, err := handler(context.Background(), nil)
+	assert.Equal(t, codes.NotFound, status.Code(err))
+
+	recs := records(t, &buf)
+	require.Len(t, recs, 1)
+	assert.Equal(t, "/auth.Auth/Login", recs[0]["method"])
+	assert.Equal(t, "NotFound", recs[0]["code"])
+	assert.Equal(t, "no user [email]", recs[0]["error"])
+	assert.Contains(t, recs[0], "latency")
+	assert.NotContains(t, buf.String(), "user@example.com")
+}
+
+func TestRequestID(t *testing.T) {
+	var buf bytes.Buffer
+	log := newLogger(&buf)
+
+	lis := bufconn.Listen(1 << 20)
+	srv := grpc.NewServer(grpc.ChainUnaryInterceptor(
+		interceptors.RequestID(),
+		interceptors.Logging(log),
+		interceptors.Recovery(log),
+	))
+	healthpb.RegisterHealthServer(srv, health.NewServer())
+	go func() { _ = srv.Serve(lis) }()
+	defer srv.Stop()
+
+	conn, err := grpc.Dial("bufnet",
+		grpc.WithContextDialer(func(context.Context, string) (net.Conn, error) { return lis.Dial() }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	require.NoError(t, err)
+	defer conn.Close()
+	client := healthpb.NewHealthClient(conn)
+
+	call := func(requestID string) string {
+		t.Helper()
+
+		ctx := context.Background()
+		if requestID != "" {
+			ctx = metadata.AppendToOutgoingContext(ctx, interceptors.RequestIDHeader, requestID)
+		}
+		var header metadata.MD
+		_, err := client.Check(ctx, &healthpb.HealthCheckRequest{}, grpc.Header(&header))
+		require.NoError(t, err)
+		require.Len(t, header.Get(interceptors.RequestIDHeader), 1)
+		return header.Get(interceptors.RequestIDHeader)[0]
+	}
+
+	assert.Equal(t, "client-id-1", call("client-id-1"), "the request ID of the client must be kept")
+
+	generated := call("")
+	assert.Len(t, generated, 32)
+	assert.NotEqual(t, generated, call(""), "generated request IDs must be unique")
+
+	forged := call(`id" level=ERROR msg="forged`)
+	assert.Len(t, forged, 32, "request IDs forging log fields must be replaced")
+
+	recs := records(t, &buf)
+	require.Len(t, recs, 4)
+	assert.Equal(t, "client-id-1", recs[0][sl.RequestIDKey])
+	assert.Equal(t, generated, recs[1][sl.RequestIDKey])
+	assert.Equal(t, forged, recs[3][sl.RequestIDKey])
+	assert.Equal(t, "/grpc.health.v1.Health/Check", recs[0]["method"])
+	assert.Contains(t, recs[0]["peer"], "bufconn")
+}
@@ -0,0 +1,146 @@
+package biometrics
+
+import (
+	"errors"
+	"fmt"
+	"math"
+
+	"sso/internal/domain/models"
+)
+
+const (
+	DefaultLowerThreshold = 0.5
+	DefaultUpperThreshold = 1.5
+)
+
+var (
+	ErrPressTimesInvalid    = errors.New("invalid press times")
+	ErrIntervalTimesInvalid = errors.New("invalid interval times")
+	ErrKeyFeaturesInvalid   = errors.New("invalid key features")
+	ErrModalityMismatch     = errors.New("sample lacks the key events of the template")
+)
+
+// Sample is a single keystroke timing capture of the login phrase.
+type Sample struct {
+	PressTimes    []float32
+	IntervalTimes []float32
+	KeyEvents     []models.KeyEvent
+}
+
+// Matcher compares keystroke samples against an enrolled template. It is
+// shared by the auth service and the offline evaluation tools, so both make
+// exactly the same decisions.
+//
+// When both the template and the sample carry key events, they are compared
+// by key-identity features; otherwise by the index-aligned arrays. A template
+// with key events only accepts samples with key events, so a sample cannot
+// dodge the feature comparison by leaving them out.
+type Matcher struct {
+	LowerThreshold float64
+	UpperThreshold float64
+	// FeatureTolerance is the relative deviation a key feature may have.
+	FeatureTolerance float64
+	// MinCommonFeatures is how many features the template and the sample
+	// must share for the feature comparison to be used.
+	MinCommonFeatures int
+}
+
+// NewMatcher returns a matcher with the given deviation thresholds.
+func NewMatcher(lowerThreshold, upperThreshold, featureTolerance float64) Matcher {
+	return Matcher{
+		LowerThreshold:    lowerThreshold,
+		UpperThreshold:    upperThreshold,
+		FeatureTolerance:  featureTolerance,
+		MinCommonFeatures: DefaultMinCommonFeatures,
+	}
+}
+
+// Match decides whether the sample belongs to the owner of the template.
+func (m Matcher) Match(template, sample Sample) (bool, error) {
+	const op = "biometrics.Matcher.Match"
+
+	if len(template.KeyEvents) > 0 && len(sample.KeyEvents) == 0 {
+		return false, fmt.Errorf("%s: %w", op, ErrModalityMismatch)
+	}
+
+	if matches, common, ok := m.compareFeatures(template, sample); ok {
+		if matches < common/2 {
+			return false, fmt.Errorf("%s: %w", op, ErrKeyFeaturesInvalid)
+		}
+		return true, nil
+	}
+
+	if m.countMatches(template.PressTimes, sample.PressTimes) < len(template.PressTimes)/2 {
+		return false, fmt.Errorf("%s: %w", op, ErrPressTimesInvalid)
+	}
+
+	if m.countMatches(template.IntervalTimes, sample.IntervalTimes) < len(template.IntervalTimes)/2 {
+		return false, fmt.Errorf("%s: %w", op, ErrIntervalTimesInvalid)
+	}
+
+	return true, nil
+}
+
+// Score returns the share of matching features, or of press and interval
+// times, whichever is lower. A sample is accepted by Match when its score is
+// about 0.5 or above.
+func (m Matcher) Score(template, sample Sample) float64 {
+	if len(template.KeyEvents) > 0 && len(sample.KeyEvents) == 0 {
+		return 0
+	}
+
+	if matches, common, ok := m.compareFeatures(template, sample); ok {
+		return ratio(matches, common)
+	}
+
+	pressScore := ratio(m.countMatches(template.PressTimes, sample.PressTimes), len(template.PressTimes))
+	intervalScore := ratio(m.countMatches(template.IntervalTimes, sample.IntervalTimes), len(template.IntervalTimes))
+
+	return math.Min(pressScore, intervalScore)
+}
+
+// compareFeatures compares key-identity features; ok is false when either
+// side has no key events or they share too few features.
+func (m Matcher) compareFeatures(template, sample Sample) (matches int, common int, ok bool) {
+	if len(template.KeyEvents) == 0 || len(sample.KeyEvents) == 0 {
+		return 0, 0, false
+	}
+
+	matches, common = featureMatches(ExtractFeatures(template.KeyEvents), ExtractFeatures(sample.KeyEvents), m.FeatureTolerance)
+	if common < m.MinCommonFeatures {
+		return 0, 0, false
+	}
+	return matches, common, true
+}
+
+// countMatches counts sample values whose deviation from the template falls
+// outside of the (lower, upper) band. NaN and infinite values never match.
+func (m Matcher) countMatches(template, sample []float32) int {
+	matches := 0
+	for i := 0; i < len(sample) && i < len(template); i++ {
+		if !isFinite(float64(sample[i])) || !isFinite(float64(template[i])) {
+			continue
+		}
+		if !checkDifference(float64(sample[i]), float64(template[i]), m.LowerThreshold, m.UpperThreshold) {
+			matches++
+		}
+	}
+	return matches
+}
+
+func checkDifference(input, needed, lowerThreshold, upperThreshold float64) bool {
+
+	x := math.Abs(input - needed)
+	return x > lowerThreshold && x < upperThreshold
+}
+
+func isFinite(f float64) bool {
+	return !math.IsNaN(f) && !math.IsInf(f, 0)
+}
+
+func ratio(n, total int) float64 {
+	if total == 0 {
+		return 1
+	}
+	return float64(n) / float64(total)
+}
@@ -0,0 +1,75 @@
+package biometrics_test
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"math"
+	"sso/internal/lib/biometrics"
+	"testing"
+)
+
+var matcher = biometrics.NewMatcher(biometrics.DefaultLowerThreshold, biometrics.DefaultUpperThreshold, biometrics.DefaultFeatureTolerance)
+
+// shift returns values with the first n of them moved by delta.
+func shift(values []float32, n int, delta float32) []float32 {
+	shifted := append([]float32(nil), values...)
+	for i := 0; i < n && i < len(shifted); i++ {
+		shifted[i] += delta
+	}
+	return shifted
+}
+
+func TestMatch_Arrays(t *testing.T) {
+	nan, inf := float32(math.NaN()), float32(math.Inf(1))
+	template := biometrics.Sample{
+		PressTimes:    []float32{100, 100, 100, 100},
+		IntervalTimes: []float32{200, 200, 200},
+	}
+
+	for name, tt := range map[string]struct {
+		pressTimes    []float32
+		intervalTimes []float32
+		wantErr       error
+	}{
+		"identical":                    {pressTimes: template.PressTimes, intervalTimes: template.IntervalTimes},
+		"half of presses deviate":      {pressTimes: shift(template.PressTimes, 2, 1), intervalTimes: template.IntervalTimes},
+		"most presses deviate":         {pressTimes: shift(template.PressTimes, 3, 1), intervalTimes: template.IntervalTimes, wantErr: biometrics.ErrPressTimesInvalid},
+		"intervals deviate":            {pressTimes: template.PressTimes, intervalTimes: shift(template.IntervalTimes, 3, 1), wantErr: biometrics.ErrIntervalTimesInvalid},
+		"longer than the template":     {pressTimes: append(template.PressTimes, 100, 100), intervalTimes: append(template.IntervalTimes, 200)},
+		"NaN presses":                  {pressTimes: []float32{nan, nan, nan, nan}, intervalTimes: template.IntervalTimes, wantErr: biometrics.ErrPressTimesInvalid},
+		"infinite intervals":           {pressTimes: template.PressTimes, intervalTimes: []float32{inf, inf, inf}, wantErr: biometrics.ErrIntervalTimesInvalid},
+		"NaN among otherwise matching": {pressTimes: []float32{100, nan, nan, nan}, intervalTimes: template.IntervalTimes, wantErr: biometrics.ErrPressTimesInvalid},
+	} {
+		t.Run(name, func(t *testing.T) {
+			ok, err := matcher.Match(template, biometrics.Sample{PressTimes: tt.pressTimes, IntervalTimes: tt.intervalTimes})
+			if tt.wantErr != nil {
+				assert.ErrorIs(t, err, tt.wantErr)
+				assert.False(t, ok)
+				return
+			}
+			require.NoError(t, err)
+			assert.True(t, ok)
+		})
+	}
+}
+
+func TestScore_Arrays(t *testing.T) {
+	template := biometrics.Sample{
+		PressTimes:    []float32{100, 100, 100, 100},
+		IntervalTimes: []float32{200, 200, 200, 200},
+	}
+
+	for name, tt := range map[string]struct {
+		sample biometrics.Sample
+		want   float64
+	}{
+		"identical":         {sample: template, want: 1},
+		"half deviate":      {sample: biometrics.Sample{PressTimes: shift(template.PressTimes, 2, 1), IntervalTimes: template.IntervalTimes}, want: 0.5},
+		"lower of the two":  {sample: biometrics.Sample{PressTimes: shift(template.PressTimes, 1, 1), IntervalTimes: shift(template.IntervalTimes, 3, 1)}, want: 0.25},
+		"NaN never matches": {sample: biometrics.Sample{PressTimes: []float32{float32(math.NaN())}, IntervalTimes: template.IntervalTimes}, want: 0},
+	} {
+		t.Run(name, func(t *testing.T) {
+			assert.InDelta(t, tt.want, matcher.Score(template, tt.sample), 1e-9)
+		})
+	}
+}
@@ -0,0 +1,115 @@
+package biometrics
+
+import (
+	"math"
+	"sort"
+
+	"sso/internal/domain/models"
+)
+
+const (
+	DefaultFeatureTolerance  = 0.35
+	DefaultMinCommonFeatures = 4
+)
+
+// Feature name prefixes: dwell of a key, flight (release to next press) and
+// digraph latency (press to next press) of a key pair.
+const (
+	featureDwell   = "H:"
+	featureFlight  = "UD:"
+	featureDigraph = "DD:"
+)
+
+// editKeys are corrections; timings around them describe fixing a typo rather
+// than the typing rhythm, so they are left out of the features.
+var editKeys = map[string]bool{
+	"Backspace": true,
+	"Delete":    true,
+}
+
+// Features are keystroke timings keyed by key identity, e.g. "H:a" or
+// "DD:a:b". Repeated keys and pairs are averaged. Unlike index-aligned
+// arrays they survive typos, corrections and changes of the phrase length.
+type Features map[string]float64
+
+// ExtractFeatures computes dwell, flight and digraph latencies from key events.
+func ExtractFeatures(events []models.KeyEvent) Features {
+	if len(events) == 0 {
+		return nil
+	}
+
+	sorted := sortedEvents(events)
+
+	sums := make(map[string]float64)
+	counts := make(map[string]int)
+	add := func(name string, v float64) {
+		sums[name] += v
+		counts[name]++
+	}
+
+	for i, e := range sorted {
+		if editKeys[e.Key] {
+			continue
+		}
+		if e.ReleasedAt > e.PressedAt {
+			add(featureDwell+e.Key, e.ReleasedAt-e.PressedAt)
+		}
+
+		if i == 0 || editKeys[sorted[i-1].Key] {
+			continue
+		}
+		prev := sorted[i-1]
+		pair := prev.Key + ":" + e.Key
+		add(featureDigraph+pair, e.PressedAt-prev.PressedAt)
+		if prev.ReleasedAt > prev.PressedAt {
+			add(featureFlight+pair, e.PressedAt-prev.ReleasedAt)
+		}
+	}
+
+	features := make(Features, len(sums))
+	for name, sum := range sums {
+		features[name] = sum / float64(counts[name])
+	}
+	return features
+}
+
+// FlatTimings derives the legacy index-aligned arrays from key events: press
+// durations and press-to-press intervals.
+func FlatTimings(events []models.KeyEvent) (pressTimes []float32, intervalTimes []float32) {
+	sorted := sortedEvents(events)
+	for i, e := range sorted {
+		pressTimes = append(pressTimes, float32(e.ReleasedAt-e.PressedAt))
+		if i > 0 {
+			intervalTimes = append(intervalTimes, float32(e.PressedAt-sorted[i-1].PressedAt))
+		}
+	}
+	return pressTimes, intervalTimes
+}
+
+// featureMatches counts common features of the sample that deviate from the
+// template by no more than the relative tolerance. NaN and infinite values
+// never match.
+func featureMatches(template, sample Features, tolerance float64) (matches int, common int) {
+	for name, want := range template {
+		got, ok := sample[name]
+		if !ok {
+			continue
+		}
+		common++
+		if !isFinite(got) || !isFinite(want) {
+			continue
+		}
+		if math.Abs(got-want) <= tolerance*math.Max(math.Abs(want), 1e-6) {
+			matches++
+		}
+	}
+	return matches, common
+}
+
+func sortedEvents(events []models.KeyEvent) []models.KeyEvent {
+	sorted := append([]models.KeyEvent(nil), events...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].PressedAt < sorted[j].PressedAt
+	})
+	return sorted
+}
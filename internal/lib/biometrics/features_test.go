@@ -0,0 +1,171 @@
+package biometrics_test
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"math"
+	"sso/internal/domain/models"
+	"sso/internal/lib/biometrics"
+	"testing"
+)
+
+// typed returns the key events of text typed with a constant dwell and a
+// constant gap between presses.
+func typed(text string, dwell float64, gap float64) []models.KeyEvent {
+	events := make([]models.KeyEvent, 0, len(text))
+	for i, key := range text {
+		pressedAt := float64(i) * gap
+		events = append(events, models.KeyEvent{Key: string(key), PressedAt: pressedAt, ReleasedAt: pressedAt + dwell})
+	}
+	return events
+}
+
+// sampleOf returns a sample of events carrying the arrays derived from them,
+// as the auth service builds it.
+func sampleOf(events []models.KeyEvent) biometrics.Sample {
+	pressTimes, intervalTimes := biometrics.FlatTimings(events)
+	return biometrics.Sample{PressTimes: pressTimes, IntervalTimes: intervalTimes, KeyEvents: events}
+}
+
+func TestExtractFeatures(t *testing.T) {
+	for name, tt := range map[string]struct {
+		events []models.KeyEvent
+		want   biometrics.Features
+	}{
+		"dwell, flight and digraph": {
+			events: []models.KeyEvent{{Key: "a", PressedAt: 0, ReleasedAt: 100}, {Key: "b", PressedAt: 150, ReleasedAt: 240}},
+			want:   biometrics.Features{"H:a": 100, "H:b": 90, "DD:a:b": 150, "UD:a:b": 50},
+		},
+		"unsorted events": {
+			events: []models.KeyEvent{{Key: "b", PressedAt: 150, ReleasedAt: 240}, {Key: "a", PressedAt: 0, ReleasedAt: 100}},
+			want:   biometrics.Features{"H:a": 100, "H:b": 90, "DD:a:b": 150, "UD:a:b": 50},
+		},
+		"repeats are averaged": {
+			events: []models.KeyEvent{{Key: "a", PressedAt: 0, ReleasedAt: 100}, {Key: "a", PressedAt: 200, ReleasedAt: 260}},
+			want:   biometrics.Features{"H:a": 80, "DD:a:a": 200, "UD:a:a": 100},
+		},
+		"corrections are left out": {
+			events: []models.KeyEvent{
+				{Key: "a", PressedAt: 0, ReleasedAt: 100},
+				{Key: "Backspace", PressedAt: 150, ReleasedAt: 200},
+				{Key: "b", PressedAt: 300, ReleasedAt: 390},
+			},
+			want: biometrics.Features{"H:a": 100, "H:b": 90},
+		},
+		"zero dwell has no hold or flight": {
+			events: []models.KeyEvent{{Key: "a", PressedAt: 0, ReleasedAt: 0}, {Key: "b", PressedAt: 150, ReleasedAt: 240}},
+			want:   biometrics.Features{"H:b": 90, "DD:a:b": 150},
+		},
+		"no events": {},
+	} {
+		t.Run(name, func(t *testing.T) {
+			got := biometrics.ExtractFeatures(tt.events)
+			require.Len(t, got, len(tt.want))
+			for feature, want := range tt.want {
+				assert.InDelta(t, want, got[feature], 1e-9, feature)
+			}
+		})
+	}
+}
+
+func TestMatch_Features(t *testing.T) {
+	template := sampleOf(typed("password", 100, 200))
+
+	// arraysOff leaves the features of events intact but breaks every
+	// value of the arrays, so only the feature comparison can accept it.
+	arraysOff := func(events []models.KeyEvent) biometrics.Sample {
+		s := sampleOf(events)
+		s.PressTimes = shift(s.PressTimes, len(s.PressTimes), 1)
+		s.IntervalTimes = shift(s.IntervalTimes, len(s.IntervalTimes), 1)
+		return s
+	}
+	withNaN := func(events []models.KeyEvent) []models.KeyEvent {
+		for i := range events {
+			events[i].ReleasedAt = math.NaN()
+			events[i].PressedAt = math.NaN()
+		}
+		return events
+	}
+	// "passx", Backspace, then "word": the corrected password
+	corrected := append(typed("passx", 105, 210), models.KeyEvent{Key: "Backspace", PressedAt: 1050, ReleasedAt: 1100})
+	corrected = append(corrected, shiftEvents(typed("word", 105, 210), 1300)...)
+
+	for name, tt := range map[string]struct {
+		sample  biometrics.Sample
+		wantErr error
+	}{
+		"within tolerance":               {sample: arraysOff(typed("password", 110, 220))},
+		"beyond tolerance":               {sample: sampleOf(typed("password", 200, 400)), wantErr: biometrics.ErrKeyFeaturesInvalid},
+		"robust to a corrected typo":     {sample: arraysOff(corrected)},
+		"other keys fall back to arrays": {sample: biometrics.Sample{PressTimes: template.PressTimes, IntervalTimes: template.IntervalTimes, KeyEvents: typed("12345678", 300, 600)}},
+		"NaN timings never match":        {sample: sampleOf(withNaN(typed("password", 100, 200))), wantErr: biometrics.ErrKeyFeaturesInvalid},
+	} {
+		t.Run(name, func(t *testing.T) {
+			ok, err := matcher.Match(template, tt.sample)
+			if tt.wantErr != nil {
+				assert.ErrorIs(t, err, tt.wantErr)
+				assert.False(t, ok)
+				return
+			}
+			require.NoError(t, err)
+			assert.True(t, ok)
+		})
+	}
+}
+
+// shiftEvents moves events by offset.
+func shiftEvents(events []models.KeyEvent, offset float64) []models.KeyEvent {
+	for i := range events {
+		events[i].PressedAt += offset
+		events[i].ReleasedAt += offset
+	}
+	return events
+}
+
+func TestMatch_MinCommonFeatures(t *testing.T) {
+	template := sampleOf(typed("ab", 100, 200))
+	// Shares the 4 features of "ab" with the template and deviates in every
+	// one of them, while its arrays match the template.
+	sample := biometrics.Sample{
+		PressTimes:    template.PressTimes,
+		IntervalTimes: template.IntervalTimes,
+		KeyEvents:     typed("abxy", 300, 600),
+	}
+
+	for name, tt := range map[string]struct {
+		minCommon int
+		wantErr   error
+	}{
+		"at the threshold features decide":  {minCommon: 4, wantErr: biometrics.ErrKeyFeaturesInvalid},
+		"below the threshold arrays decide": {minCommon: 5},
+		"default threshold":                 {minCommon: biometrics.DefaultMinCommonFeatures, wantErr: biometrics.ErrKeyFeaturesInvalid},
+	} {
+		t.Run(name, func(t *testing.T) {
+			m := matcher
+			m.MinCommonFeatures = tt.minCommon
+
+			ok, err := m.Match(template, sample)
+			if tt.wantErr != nil {
+				assert.ErrorIs(t, err, tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			assert.True(t, ok)
+		})
+	}
+}
+
+func TestMatch_MixedModalities(t *testing.T) {
+	events := typed("password", 100, 200)
+	withEvents := sampleOf(events)
+	arraysOnly := biometrics.Sample{PressTimes: withEvents.PressTimes, IntervalTimes: withEvents.IntervalTimes}
+
+	_, err := matcher.Match(withEvents, arraysOnly)
+	assert.ErrorIs(t, err, biometrics.ErrModalityMismatch, "leaving the key events out must not fall back to the arrays")
+	assert.Zero(t, matcher.Score(withEvents, arraysOnly))
+
+	ok, err := matcher.Match(arraysOnly, withEvents)
+	require.NoError(t, err, "templates enrolled with arrays compare the arrays derived from key events")
+	assert.True(t, ok)
+	assert.Equal(t, 1.0, matcher.Score(arraysOnly, withEvents))
+}
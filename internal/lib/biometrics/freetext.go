@@ -0,0 +1,53 @@
+package biometrics
+
+import "sso/internal/domain/models"
+
+const (
+	// freeTextWindow is how many recent key events a score update looks at.
+	freeTextWindow = 60
+	// freeTextSmoothing is the weight of the newest window in the running score.
+	freeTextSmoothing = 0.3
+)
+
+// FreeText keeps a running similarity score of free typing against a user's
+// keystroke profile. Text is arbitrary, so only the features the window
+// shares with the profile are compared.
+type FreeText struct {
+	matcher Matcher
+	profile Features
+	window  []models.KeyEvent
+	score   float64
+}
+
+// NewFreeText starts scoring against the profile built from enrolled key events.
+func (m Matcher) NewFreeText(profile []models.KeyEvent) *FreeText {
+	return &FreeText{
+		matcher: m,
+		profile: ExtractFeatures(profile),
+		score:   1,
+	}
+}
+
+// Observe adds typed events and returns the running score in [0, 1]. updated
+// is false while the recent window shares too few features with the profile
+// to say anything, in which case the score is left as it was.
+func (f *FreeText) Observe(events []models.KeyEvent) (score float64, updated bool) {
+	f.window = append(f.window, events...)
+	if len(f.window) > freeTextWindow {
+		f.window = f.window[len(f.window)-freeTextWindow:]
+	}
+
+	matches, common := featureMatches(f.profile, ExtractFeatures(f.window), f.matcher.FeatureTolerance)
+	if common < f.matcher.MinCommonFeatures {
+		return f.score, false
+	}
+
+	f.score = (1-freeTextSmoothing)*f.score + freeTextSmoothing*ratio(matches, common)
+
+	return f.score, true
+}
+
+// Score returns the current running score.
+func (f *FreeText) Score() float64 {
+	return f.score
+}
@@ -0,0 +1,69 @@
+package biometrics_test
+
+import (
+	"github.com/stretchr/testify/assert"
+	"math"
+	"sso/internal/domain/models"
+	"testing"
+)
+
+const profileText = "the quick brown fox jumps over the lazy dog"
+
+func TestFreeText(t *testing.T) {
+	profile := typed(profileText, 100, 180)
+
+	for name, tt := range map[string]struct {
+		events      []models.KeyEvent
+		wantUpdated bool
+		// score bounds after observing events three times
+		minScore, maxScore float64
+	}{
+		"owner":            {events: typed("the lazy fox", 105, 190), wantUpdated: true, minScore: 0.99, maxScore: 1},
+		"impostor":         {events: typed("the lazy fox", 250, 500), wantUpdated: true, minScore: 0, maxScore: 0.35},
+		"too few features": {events: typed("zz", 250, 500), wantUpdated: false, minScore: 1, maxScore: 1},
+		"NaN timings":      {events: nanEvents(typed("the lazy fox", 100, 180)), wantUpdated: true, minScore: 0, maxScore: 0.35},
+	} {
+		t.Run(name, func(t *testing.T) {
+			scorer := matcher.NewFreeText(profile)
+
+			var score float64
+			var updated bool
+			for i := 0; i < 3; i++ {
+				// every batch continues where the previous one stopped
+				score, updated = scorer.Observe(shiftEvents(clone(tt.events), float64(i)*10000))
+			}
+
+			assert.Equal(t, tt.wantUpdated, updated)
+			assert.GreaterOrEqual(t, score, tt.minScore)
+			assert.LessOrEqual(t, score, tt.maxScore)
+			assert.Equal(t, score, scorer.Score())
+		})
+	}
+}
+
+func TestFreeText_RecoversWithinWindow(t *testing.T) {
+	scorer := matcher.NewFreeText(typed(profileText, 100, 180))
+
+	impostor, _ := scorer.Observe(typed("the lazy fox", 250, 500))
+	assert.Less(t, impostor, 1.0)
+
+	// Old events leave the window, so the owner typing on brings the score
+	// back up instead of being dragged down by the intruder forever.
+	var score float64
+	for i := 1; i <= 20; i++ {
+		score, _ = scorer.Observe(shiftEvents(typed("the lazy fox", 100, 180), float64(i)*10000))
+	}
+	assert.Greater(t, score, 0.95)
+}
+
+func clone(events []models.KeyEvent) []models.KeyEvent {
+	return append([]models.KeyEvent(nil), events...)
+}
+
+func nanEvents(events []models.KeyEvent) []models.KeyEvent {
+	for i := range events {
+		events[i].PressedAt = math.NaN()
+		events[i].ReleasedAt = math.NaN()
+	}
+	return events
+}
@@ -0,0 +1,223 @@
+package envelope
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// KeySize is the size of master and data keys in bytes (AES-256).
+const KeySize = 32
+
+var (
+	ErrInvalidKey        = errors.New("invalid key")
+	ErrMalformedCipher   = errors.New("malformed ciphertext")
+	ErrMasterKeyRequired = errors.New("master key is required")
+)
+
+// Keyring wraps and unwraps per-record data keys with a master key.
+type Keyring struct {
+	master cipher.AEAD
+}
+
+// New creates a Keyring from a raw master key of KeySize bytes.
+func New(masterKey []byte) (*Keyring, error) {
+	const op = "envelope.New"
+
+	aead, err := newAEAD(masterKey)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return &Keyring{master: aead}, nil
+}
+
+// NewDataKey generates a fresh data key and returns it together with its
+// wrapped (master-key encrypted) form, which is the one to be persisted.
+func (k *Keyring) NewDataKey() (dataKey []byte, wrapped []byte, err error) {
+	const op = "envelope.Keyring.NewDataKey"
+
+	dataKey = make([]byte, KeySize)
+	if _, err := io.ReadFull(rand.Reader, dataKey); err != nil {
+		return nil, nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	wrapped, err = k.Wrap(dataKey)
+	if err != nil {
+		return nil, nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return dataKey, wrapped, nil
+}
+
+// Wrap encrypts a data key with the master key.
+func (k *Keyring) Wrap(dataKey []byte) ([]byte, error) {
+	return seal(k.master, dataKey)
+}
+
+// Unwrap decrypts a data key previously wrapped with the master key.
+func (k *Keyring) Unwrap(wrapped []byte) ([]byte, error) {
+	const op = "envelope.Keyring.Unwrap"
+
+	dataKey, err := open(k.master, wrapped)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return dataKey, nil
+}
+
+// Seal encrypts plaintext with a data key and returns it base64 encoded,
+// so it can be stored in TEXT columns.
+func Seal(dataKey []byte, plaintext string) (string, error) {
+	const op = "envelope.Seal"
+
+	aead, err := newAEAD(dataKey)
+	if err != nil {
+		return "", fmt.Errorf("%s: %w", op, err)
+	}
+
+	ciphertext, err := seal(aead, []byte(plaintext))
+	if err != nil {
+		return "", fmt.Errorf("%s: %w", op, err)
+	}
+
+	return base64.StdEncoding.EncodeToString(ciphertext), nil
+}
+
+// Open decrypts a value produced by Seal.
+func Open(dataKey []byte, ciphertext string) (string, error) {
+	const op = "envelope.Open"
+
+	aead, err := newAEAD(dataKey)
+	if err != nil {
+		return "", fmt.Errorf("%s: %w", op, err)
+	}
+
+	raw, err := base64.StdEncoding.DecodeString(ciphertext)
+	if err != nil {
+		return "", fmt.Errorf("%s: %w", op, ErrMalformedCipher)
+	}
+
+	plaintext, err := open(aead, raw)
+	if err != nil {
+		return "", fmt.Errorf("%s: %w", op, err)
+	}
+
+	return string(plaintext), nil
+}
+
+// SealBytes encrypts plaintext with a data key; it is Seal for binary data
+// that is not stored in TEXT columns.
+func SealBytes(dataKey []byte, plaintext []byte) ([]byte, error) {
+	const op = "envelope.SealBytes"
+
+	aead, err := newAEAD(dataKey)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	ciphertext, err := seal(aead, plaintext)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return ciphertext, nil
+}
+
+// OpenBytes decrypts a value produced by SealBytes.
+func OpenBytes(dataKey []byte, ciphertext []byte) ([]byte, error) {
+	const op = "envelope.OpenBytes"
+
+	aead, err := newAEAD(dataKey)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	plaintext, err := open(aead, ciphertext)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return plaintext, nil
+}
+
+// ReadKey decodes a base64 master key given either inline or as a path to a
+// file holding it; the file takes precedence.
+func ReadKey(encoded string, path string) ([]byte, error) {
+	const op = "envelope.ReadKey"
+
+	if path != "" {
+		content, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", op, err)
+		}
+		encoded = string(content)
+	}
+
+	encoded = strings.TrimSpace(encoded)
+	if encoded == "" {
+		return nil, fmt.Errorf("%s: %w", op, ErrMasterKeyRequired)
+	}
+
+	key, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil || len(key) != KeySize {
+		return nil, fmt.Errorf("%s: %w", op, ErrInvalidKey)
+	}
+
+	return key, nil
+}
+
+// MustReadKey is like ReadKey but panics on error.
+func MustReadKey(encoded string, path string) []byte {
+	key, err := ReadKey(encoded, path)
+	if err != nil {
+		panic(err)
+	}
+
+	return key
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	if len(key) != KeySize {
+		return nil, ErrInvalidKey
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return cipher.NewGCM(block)
+}
+
+// seal returns nonce || ciphertext.
+func seal(aead cipher.AEAD, plaintext []byte) ([]byte, error) {
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+
+	return aead.Seal(nonce, nonce, plaintext, nil), nil
+}
+
+func open(aead cipher.AEAD, data []byte) ([]byte, error) {
+	if len(data) < aead.NonceSize() {
+		return nil, ErrMalformedCipher
+	}
+
+	nonce, ciphertext := data[:aead.NonceSize()], data[aead.NonceSize():]
+
+	plaintext, err := aead.Open(nil, nonce, ciphertext, nil)
+	if err != nil {
+		return nil, ErrMalformedCipher
+	}
+
+	return plaintext, nil
+}
@@ -0,0 +1,233 @@
+package envelope_test
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/base64"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"os"
+	"path/filepath"
+	"sso/internal/lib/envelope"
+	"testing"
+)
+
+func newKey(t *testing.T) []byte {
+	t.Helper()
+
+	key := make([]byte, envelope.KeySize)
+	_, err := rand.Read(key)
+	require.NoError(t, err)
+	return key
+}
+
+func newKeyring(t *testing.T) *envelope.Keyring {
+	t.Helper()
+
+	k, err := envelope.New(newKey(t))
+	require.NoError(t, err)
+	return k
+}
+
+// tamper returns a copy of data with the bit at i flipped.
+func tamper(data []byte, i int) []byte {
+	c := bytes.Clone(data)
+	c[i] ^= 0x01
+	return c
+}
+
+func TestNew(t *testing.T) {
+	for name, tt := range map[string]struct {
+		key     []byte
+		wantErr error
+	}{
+		"valid":     {key: make([]byte, envelope.KeySize)},
+		"empty":     {key: nil, wantErr: envelope.ErrInvalidKey},
+		"too short": {key: make([]byte, 16), wantErr: envelope.ErrInvalidKey},
+		"too long":  {key: make([]byte, 64), wantErr: envelope.ErrInvalidKey},
+	} {
+		t.Run(name, func(t *testing.T) {
+			_, err := envelope.New(tt.key)
+			if tt.wantErr != nil {
+				assert.ErrorIs(t, err, tt.wantErr)
+				return
+			}
+			assert.NoError(t, err)
+		})
+	}
+}
+
+func TestKeyring_WrapUnwrap(t *testing.T) {
+	k := newKeyring(t)
+
+	dataKey, wrapped, err := k.NewDataKey()
+	require.NoError(t, err)
+	require.Len(t, dataKey, envelope.KeySize)
+	assert.NotContains(t, string(wrapped), string(dataKey), "the wrapped key must not hold the data key in clear")
+
+	unwrapped, err := k.Unwrap(wrapped)
+	require.NoError(t, err)
+	assert.Equal(t, dataKey, unwrapped)
+
+	again, err := k.Wrap(dataKey)
+	require.NoError(t, err)
+	assert.NotEqual(t, wrapped, again, "every wrap must use a fresh nonce")
+}
+
+func TestKeyring_UnwrapErrors(t *testing.T) {
+	k := newKeyring(t)
+	_, wrapped, err := k.NewDataKey()
+	require.NoError(t, err)
+
+	for name, tt := range map[string]struct {
+		keyring *envelope.Keyring
+		wrapped []byte
+	}{
+		"wrong master key":   {keyring: newKeyring(t), wrapped: wrapped},
+		"tampered nonce":     {keyring: k, wrapped: tamper(wrapped, 0)},
+		"tampered cipher":    {keyring: k, wrapped: tamper(wrapped, len(wrapped)/2)},
+		"tampered tag":       {keyring: k, wrapped: tamper(wrapped, len(wrapped)-1)},
+		"truncated":          {keyring: k, wrapped: wrapped[:len(wrapped)-1]},
+		"shorter than nonce": {keyring: k, wrapped: wrapped[:4]},
+		"empty":              {keyring: k, wrapped: nil},
+	} {
+		t.Run(name, func(t *testing.T) {
+			_, err := tt.keyring.Unwrap(tt.wrapped)
+			assert.ErrorIs(t, err, envelope.ErrMalformedCipher)
+		})
+	}
+}
+
+func TestSealOpen(t *testing.T) {
+	dataKey := newKey(t)
+
+	for name, plaintext := range map[string]string{
+		"empty":   "",
+		"timings": "110.5,95,130.25",
+		"unicode": "ключ 🔑",
+	} {
+		t.Run(name, func(t *testing.T) {
+			sealed, err := envelope.Seal(dataKey, plaintext)
+			require.NoError(t, err)
+			if plaintext != "" {
+				assert.NotContains(t, sealed, plaintext)
+			}
+
+			opened, err := envelope.Open(dataKey, sealed)
+			require.NoError(t, err)
+			assert.Equal(t, plaintext, opened)
+		})
+	}
+}
+
+func TestOpenErrors(t *testing.T) {
+	dataKey := newKey(t)
+	sealed, err := envelope.Seal(dataKey, "110,95,130")
+	require.NoError(t, err)
+	raw, err := base64.StdEncoding.DecodeString(sealed)
+	require.NoError(t, err)
+
+	for name, tt := range map[string]struct {
+		key        []byte
+		ciphertext string
+		wantErr    error
+	}{
+		"wrong data key":  {key: newKey(t), ciphertext: sealed, wantErr: envelope.ErrMalformedCipher},
+		"invalid key":     {key: dataKey[:16], ciphertext: sealed, wantErr: envelope.ErrInvalidKey},
+		"tampered":        {key: dataKey, ciphertext: base64.StdEncoding.EncodeToString(tamper(raw, len(raw)/2)), wantErr: envelope.ErrMalformedCipher},
+		"not base64":      {key: dataKey, ciphertext: "not base64!", wantErr: envelope.ErrMalformedCipher},
+		"plaintext value": {key: dataKey, ciphertext: "110,95,130", wantErr: envelope.ErrMalformedCipher},
+	} {
+		t.Run(name, func(t *testing.T) {
+			_, err := envelope.Open(tt.key, tt.ciphertext)
+			assert.ErrorIs(t, err, tt.wantErr)
+		})
+	}
+}
+
+func TestSealBytesOpenBytes(t *testing.T) {
+	dataKey := newKey(t)
+	plaintext := []byte{0, 1, 2, 0xff}
+
+	sealed, err := envelope.SealBytes(dataKey, plaintext)
+	require.NoError(t, err)
+
+	opened, err := envelope.OpenBytes(dataKey, sealed)
+	require.NoError(t, err)
+	assert.Equal(t, plaintext, opened)
+
+	_, err = envelope.OpenBytes(newKey(t), sealed)
+	assert.ErrorIs(t, err, envelope.ErrMalformedCipher)
+	_, err = envelope.OpenBytes(dataKey, tamper(sealed, len(sealed)-1))
+	assert.ErrorIs(t, err, envelope.ErrMalformedCipher)
+}
+
+// TestRewrap rotates the master key the way the storages do: data keys are
+// unwrapped with the current keyring and wrapped with the next one, while
+// the values sealed with them stay untouched.
+func TestRewrap(t *testing.T) {
+	current, next := newKeyring(t), newKeyring(t)
+
+	dataKey, wrapped, err := current.NewDataKey()
+	require.NoError(t, err)
+	sealed, err := envelope.Seal(dataKey, "110,95,130")
+	require.NoError(t, err)
+
+	unwrapped, err := current.Unwrap(wrapped)
+	require.NoError(t, err)
+	rewrapped, err := next.Wrap(unwrapped)
+	require.NoError(t, err)
+
+	rotated, err := next.Unwrap(rewrapped)
+	require.NoError(t, err)
+	assert.Equal(t, dataKey, rotated)
+	opened, err := envelope.Open(rotated, sealed)
+	require.NoError(t, err)
+	assert.Equal(t, "110,95,130", opened)
+
+	_, err = current.Unwrap(rewrapped)
+	assert.ErrorIs(t, err, envelope.ErrMalformedCipher, "the old master key must not open rewrapped keys")
+	_, err = next.Unwrap(wrapped)
+	assert.ErrorIs(t, err, envelope.ErrMalformedCipher, "the new master key must not open keys left unwrapped")
+}
+
+func TestReadKey(t *testing.T) {
+	key := newKey(t)
+	encoded := base64.StdEncoding.EncodeToString(key)
+	other := base64.StdEncoding.EncodeToString(newKey(t))
+
+	dir := t.TempDir()
+	write := func(name, content string) string {
+		path := filepath.Join(dir, name)
+		require.NoError(t, os.WriteFile(path, []byte(content), 0o600))
+		return path
+	}
+	keyFile := write("key", encoded+"\n")
+
+	for name, tt := range map[string]struct {
+		encoded string
+		path    string
+		wantErr error
+	}{
+		"inline":                {encoded: encoded},
+		"file":                  {path: keyFile},
+		"file takes precedence": {encoded: other, path: keyFile},
+		"missing":               {wantErr: envelope.ErrMasterKeyRequired},
+		"blank file":            {path: write("blank", " \n"), wantErr: envelope.ErrMasterKeyRequired},
+		"not base64":            {encoded: "not base64!", wantErr: envelope.ErrInvalidKey},
+		"wrong size":            {encoded: base64.StdEncoding.EncodeToString(key[:16]), wantErr: envelope.ErrInvalidKey},
+	} {
+		t.Run(name, func(t *testing.T) {
+			got, err := envelope.ReadKey(tt.encoded, tt.path)
+			if tt.wantErr != nil {
+				assert.ErrorIs(t, err, tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, key, got)
+		})
+	}
+
+	_, err := envelope.ReadKey("", filepath.Join(dir, "absent"))
+	assert.ErrorIs(t, err, os.ErrNotExist)
+}
@@ -1,27 +1,165 @@
 package jwt
 
 import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
 	"github.com/golang-jwt/jwt/v5"
 	"sso/internal/domain/models"
+	"strconv"
 	"time"
 )
 
-func NewToken(user models.User, app models.App, timeTTL time.Duration) (string, error) {
-	token := jwt.New(jwt.SigningMethodHS256)
+var (
+	ErrInvalidToken = errors.New("invalid token")
+	ErrUnknownClaim = errors.New("unknown claim in claims template")
+)
+
+// Claims is the payload of a token issued by NewToken: the registered
+// claims, the identity the token was issued for and the user attributes
+// selected by the claims template of the app.
+type Claims struct {
+	jwt.RegisteredClaims
 
-	claims := token.Claims.(jwt.MapClaims)
+	UserID int64 `json:"uid"`
+	AppID  int   `json:"app_id"`
+	// SessionID is the session the token was issued with; ParseToken requires
+	// it, so every token can be revoked with its session.
+	SessionID string `json:"sid,omitempty"`
+	// ACR is the authentication context class reference the token was
+	// issued with.
+	ACR string `json:"acr,omitempty"`
+
+	// Email is empty if the claims template of the app leaves it out.
+	Email       string    `json:"email,omitempty"`
+	DisplayName *string   `json:"name,omitempty"`
+	Locale      *string   `json:"locale,omitempty"`
+	Timezone    *string   `json:"zoneinfo,omitempty"`
+	IsAdmin     *bool     `json:"admin,omitempty"`
+	Intervals   []float32 `json:"intervals,omitempty"`
+	Times       []float32 `json:"times,omitempty"`
+}
 
-	claims["exp"] = time.Now().Add(timeTTL).Unix()
-	claims["uid"] = user.ID
-	claims["email"] = user.Email
-	claims["app_id"] = app.ID
-	claims["intervals"] = user.PressIntervals
-	claims["times"] = user.PressTimes
+// jtiSize is the number of random bytes of a token ID.
+const jtiSize = 16
+
+// NewToken issues a token for the user signed with the secret of the app.
+// The user attributes in the token follow the claims template of the app;
+// the issuer of the app, if set, replaces issuer.
+func NewToken(user models.User, app models.App, sessionID string, acr string, issuer string, timeTTL time.Duration) (string, error) {
+	jti := make([]byte, jtiSize)
+	if _, err := rand.Read(jti); err != nil {
+		return "", err
+	}
 
-	tokenString, err := token.SignedString([]byte(app.Secret))
+	now := time.Now()
+	claims := Claims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        hex.EncodeToString(jti),
+			Issuer:    appIssuer(app, issuer),
+			Subject:   strconv.FormatInt(user.ID, 10),
+			Audience:  jwt.ClaimStrings{appAudience(app)},
+			IssuedAt:  jwt.NewNumericDate(now),
+			NotBefore: jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(timeTTL)),
+		},
+		UserID:    user.ID,
+		AppID:     app.ID,
+		SessionID: sessionID,
+		ACR:       acr,
+	}
+
+	for _, name := range app.Claims() {
+		switch name {
+		case models.ClaimEmail:
+			claims.Email = user.Email
+		case models.ClaimDisplayName:
+			claims.DisplayName = &user.DisplayName
+		case models.ClaimLocale:
+			claims.Locale = &user.Locale
+		case models.ClaimTimezone:
+			claims.Timezone = &user.Timezone
+		case models.ClaimIsAdmin:
+			claims.IsAdmin = &user.IsAdmin
+		case models.ClaimKeystrokes:
+			claims.Intervals = user.PressIntervals
+			claims.Times = user.PressTimes
+		default:
+			return "", fmt.Errorf("%w: %q", ErrUnknownClaim, name)
+		}
+	}
+
+	tokenString, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte(app.Secret))
 	if err != nil {
 		return "", err
 	}
 
 	return tokenString, nil
 }
+
+// ParseToken verifies a token issued by NewToken. The app the token claims
+// to be issued for is looked up with app; the token must be signed with one
+// of its current secrets, which lets a rotated secret keep working for a
+// grace period. Every registered claim is required: iss must be the issuer
+// of the app or the given one, aud the app, sub the user, and the token must
+// be within its iat, nbf and exp and carry a jti. The sid is required as
+// well, so every token can be revoked with its session.
+func ParseToken(tokenString string, issuer string, app func(appID int) (models.App, error)) (Claims, error) {
+	const op = "jwt.ParseToken"
+
+	var issuedFor models.App
+	var claims Claims
+	_, err := jwt.ParseWithClaims(tokenString, &claims, func(token *jwt.Token) (interface{}, error) {
+		var err error
+		issuedFor, err = app(token.Claims.(*Claims).AppID)
+		if err != nil {
+			return nil, err
+		}
+		secrets := issuedFor.Secrets(time.Now())
+		keys := make([]jwt.VerificationKey, len(secrets))
+		for i, secret := range secrets {
+			keys[i] = []byte(secret)
+		}
+		return jwt.VerificationKeySet{Keys: keys}, nil
+	}, jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg()}), jwt.WithExpirationRequired(), jwt.WithIssuedAt())
+	if err != nil {
+		return Claims{}, fmt.Errorf("%s: %w: %w", op, ErrInvalidToken, err)
+	}
+
+	// The expected issuer and audience depend on the app, which is only
+	// known once the token is parsed.
+	validator := jwt.NewValidator(
+		jwt.WithIssuer(appIssuer(issuedFor, issuer)),
+		jwt.WithAudience(appAudience(issuedFor)),
+		jwt.WithSubject(strconv.FormatInt(claims.UserID, 10)),
+	)
+	if err := validator.Validate(claims); err != nil {
+		return Claims{}, fmt.Errorf("%s: %w: %w", op, ErrInvalidToken, err)
+	}
+	switch {
+	case claims.IssuedAt == nil, claims.NotBefore == nil, claims.ID == "":
+		return Claims{}, fmt.Errorf("%s: %w: missing iat, nbf or jti", op, ErrInvalidToken)
+	case claims.UserID == 0, claims.AppID == 0, claims.SessionID == "":
+		return Claims{}, fmt.Errorf("%s: %w: missing uid, app_id or sid", op, ErrInvalidToken)
+	}
+
+	return claims, nil
+}
+
+// appIssuer returns the iss of the tokens of the app.
+func appIssuer(app models.App, issuer string) string {
+	if app.Issuer != "" {
+		return app.Issuer
+	}
+	return issuer
+}
+
+// appAudience returns the aud of the tokens of the app: its audience if set,
+// its ID otherwise.
+func appAudience(app models.App) string {
+	if app.Audience != "" {
+		return app.Audience
+	}
+	return strconv.Itoa(app.ID)
+}
@@ -0,0 +1,150 @@
+package jwt_test
+
+import (
+	jwtlib "github.com/golang-jwt/jwt/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"sso/internal/domain/models"
+	"sso/internal/lib/jwt"
+	"testing"
+	"time"
+)
+
+const issuer = "sso"
+
+var (
+	app  = models.App{ID: 7, Secret: "current-secret"}
+	user = models.User{ID: 42, Email: "user@example.com"}
+)
+
+// lookup returns the app the token claims to be issued for.
+func lookup(app models.App) func(int) (models.App, error) {
+	return func(int) (models.App, error) { return app, nil }
+}
+
+func newToken(t *testing.T, app models.App) string {
+	t.Helper()
+
+	token, err := jwt.NewToken(user, app, "session", "keystroke", issuer, time.Hour)
+	require.NoError(t, err)
+	return token
+}
+
+func TestNewTokenParseToken(t *testing.T) {
+	claims, err := jwt.ParseToken(newToken(t, app), issuer, lookup(app))
+	require.NoError(t, err)
+
+	assert.Equal(t, user.ID, claims.UserID)
+	assert.Equal(t, app.ID, claims.AppID)
+	assert.Equal(t, "session", claims.SessionID)
+	assert.Equal(t, "keystroke", claims.ACR)
+	assert.Equal(t, issuer, claims.Issuer)
+	assert.Equal(t, jwtlib.ClaimStrings{"7"}, claims.Audience)
+	assert.Equal(t, "42", claims.Subject)
+	assert.Len(t, claims.ID, 32)
+	assert.Equal(t, user.Email, claims.Email, "the default template maps the email")
+
+	custom := app
+	custom.Issuer, custom.Audience = "billing-sso", "billing"
+	claims, err = jwt.ParseToken(newToken(t, custom), issuer, lookup(custom))
+	require.NoError(t, err)
+	assert.Equal(t, "billing-sso", claims.Issuer, "the issuer of the app replaces the default one")
+	assert.Equal(t, jwtlib.ClaimStrings{"billing"}, claims.Audience)
+}
+
+func TestParseToken_RegisteredClaims(t *testing.T) {
+	valid, err := jwt.ParseToken(newToken(t, app), issuer, lookup(app))
+	require.NoError(t, err)
+
+	for name, tt := range map[string]struct {
+		forge  func(c *jwt.Claims)
+		secret string
+	}{
+		"no iat":           {forge: func(c *jwt.Claims) { c.IssuedAt = nil }},
+		"no nbf":           {forge: func(c *jwt.Claims) { c.NotBefore = nil }},
+		"no jti":           {forge: func(c *jwt.Claims) { c.ID = "" }},
+		"no exp":           {forge: func(c *jwt.Claims) { c.ExpiresAt = nil }},
+		"no sid":           {forge: func(c *jwt.Claims) { c.SessionID = "" }},
+		"no uid":           {forge: func(c *jwt.Claims) { c.UserID, c.Subject = 0, "0" }},
+		"sub is not uid":   {forge: func(c *jwt.Claims) { c.Subject = "999" }},
+		"wrong audience":   {forge: func(c *jwt.Claims) { c.Audience = jwtlib.ClaimStrings{"8"} }},
+		"wrong issuer":     {forge: func(c *jwt.Claims) { c.Issuer = "evil" }},
+		"issued in future": {forge: func(c *jwt.Claims) { c.IssuedAt = jwtlib.NewNumericDate(time.Now().Add(time.Hour)) }},
+		"not yet valid":    {forge: func(c *jwt.Claims) { c.NotBefore = jwtlib.NewNumericDate(time.Now().Add(time.Hour)) }},
+		"expired":          {forge: func(c *jwt.Claims) { c.ExpiresAt = jwtlib.NewNumericDate(time.Now().Add(-time.Minute)) }},
+		"other secret":     {secret: "other-secret"},
+	} {
+		t.Run(name, func(t *testing.T) {
+			c := valid
+			if tt.forge != nil {
+				tt.forge(&c)
+			}
+			secret := app.Secret
+			if tt.secret != "" {
+				secret = tt.secret
+			}
+			token, err := jwtlib.NewWithClaims(jwtlib.SigningMethodHS256, c).SignedString([]byte(secret))
+			require.NoError(t, err)
+
+			_, err = jwt.ParseToken(token, issuer, lookup(app))
+			assert.ErrorIs(t, err, jwt.ErrInvalidToken)
+		})
+	}
+
+	token, err := jwtlib.NewWithClaims(jwtlib.SigningMethodHS256, valid).SignedString([]byte(app.Secret))
+	require.NoError(t, err)
+	_, err = jwt.ParseToken(token, issuer, lookup(app))
+	assert.NoError(t, err, "re-signing unchanged claims must keep the token valid")
+}
+
+func TestParseToken_RejectsOtherAlgorithms(t *testing.T) {
+	claims, err := jwt.ParseToken(newToken(t, app), issuer, lookup(app))
+	require.NoError(t, err)
+
+	token, err := jwtlib.NewWithClaims(jwtlib.SigningMethodNone, claims).SignedString(jwtlib.UnsafeAllowNoneSignatureType)
+	require.NoError(t, err)
+	_, err = jwt.ParseToken(token, issuer, lookup(app))
+	assert.ErrorIs(t, err, jwt.ErrInvalidToken)
+
+	token, err = jwtlib.NewWithClaims(jwtlib.SigningMethodHS512, claims).SignedString([]byte(app.Secret))
+	require.NoError(t, err)
+	_, err = jwt.ParseToken(token, issuer, lookup(app))
+	assert.ErrorIs(t, err, jwt.ErrInvalidToken)
+}
+
+func TestParseToken_SecretRotation(t *testing.T) {
+	old := app
+	old.Secret = "previous-secret"
+	token := newToken(t, old)
+
+	for name, tt := range map[string]struct {
+		expiresAt time.Time
+		wantErr   bool
+	}{
+		"within the grace period": {expiresAt: time.Now().Add(time.Hour)},
+		"after the grace period":  {expiresAt: time.Now().Add(-time.Second), wantErr: true},
+	} {
+		t.Run(name, func(t *testing.T) {
+			rotated := app
+			rotated.PreviousSecret, rotated.PreviousSecretExpiresAt = old.Secret, tt.expiresAt
+
+			_, err := jwt.ParseToken(token, issuer, lookup(rotated))
+			if tt.wantErr {
+				assert.ErrorIs(t, err, jwt.ErrInvalidToken)
+				return
+			}
+			assert.NoError(t, err)
+		})
+	}
+
+	_, err := jwt.ParseToken(token, issuer, lookup(app))
+	assert.ErrorIs(t, err, jwt.ErrInvalidToken, "a secret rotated out without grace must not verify")
+}
+
+func TestNewToken_UnknownClaim(t *testing.T) {
+	withUnknown := app
+	withUnknown.ClaimsTemplate = []string{"ssn"}
+
+	_, err := jwt.NewToken(user, withUnknown, "session", "", issuer, time.Hour)
+	assert.ErrorIs(t, err, jwt.ErrUnknownClaim)
+}
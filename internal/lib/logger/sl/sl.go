@@ -2,6 +2,7 @@ package sl
 
 import (
 	"log/slog"
+	"strings"
 )
 
 func Err(err error) slog.Attr {
@@ -10,3 +11,18 @@ func Err(err error) slog.Attr {
 		Value: slog.StringValue(err.Error()),
 	}
 }
+
+// Email logs an address with its local part masked, e.g. "j***@example.com":
+// enough to tell domains apart, but not to identify the user.
+func Email(email string) slog.Attr {
+	masked := "***"
+	if at := strings.LastIndexByte(email, '@'); at >= 0 {
+		local, domain := email[:at], email[at+1:]
+		if local != "" {
+			masked = local[:1] + masked
+		}
+		masked += "@" + domain
+	}
+
+	return slog.String("email", masked)
+}
@@ -0,0 +1,210 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"golang.org/x/crypto/bcrypt"
+	"log/slog"
+	"slices"
+	"sso/internal/domain/models"
+	"sso/internal/lib/logger/sl"
+	"sso/internal/storage"
+	"time"
+)
+
+// Actions of audit events.
+const (
+	AuditAccountDeletionScheduled = "account.deletion_scheduled"
+	AuditAccountDeletionCancelled = "account.deletion_cancelled"
+	AuditAccountDeleted           = "account.deleted"
+	AuditDataExported             = "account.data_exported"
+	AuditProfileUpdated           = "account.profile_updated"
+	AuditPasswordChanged          = "account.password_changed"
+	AuditEmailChangeRequested     = "account.email_change_requested"
+	AuditEmailChanged             = "account.email_changed"
+)
+
+// SystemActorID is the actor of audit events recorded by the service itself,
+// such as purges of accounts whose deletion grace period is over.
+const SystemActorID = 0
+
+// DeleteAccount deletes the account of userID, or of the token owner when
+// userID is 0. Owners confirm with their password; deleting another account
+// takes a token of the admin app owned by an admin. The keystroke template, sessions and login history
+// are deleted with the account.
+//
+// With a deletion grace period the account is only scheduled for deletion
+// and its sessions are revoked; logging in again before the returned time
+// cancels the deletion. Without one the account is deleted at once and the
+// zero time is returned.
+func (a *Auth) DeleteAccount(ctx context.Context, token string, password string, userID int64) (time.Time, error) {
+	const op = "auth.DeleteAccount"
+
+	log := a.log.With(slog.String("op", op))
+
+	claims, err := a.parseToken(ctx, token)
+	if err != nil {
+		log.WarnContext(ctx, "invalid token", sl.Err(err))
+		return time.Time{}, fmt.Errorf("%s: %w", op, ErrInvalidToken)
+	}
+
+	self := userID == 0 || userID == claims.UserID
+	if self {
+		userID = claims.UserID
+	}
+	log = log.With(slog.Int64("user_id", userID), slog.Int64("actor_id", claims.UserID))
+
+	if self {
+		user, err := a.usrProvider.UserByID(ctx, claims.UserID)
+		if err != nil {
+			if errors.Is(err, storage.ErrUserNotFound) {
+				log.WarnContext(ctx, "token does not match a user", sl.Err(err))
+				return time.Time{}, fmt.Errorf("%s: %w", op, ErrInvalidToken)
+			}
+			log.ErrorContext(ctx, "failed to get user", sl.Err(err))
+			return time.Time{}, fmt.Errorf("%s: %w", op, err)
+		}
+		if err := bcrypt.CompareHashAndPassword(user.PassHash, []byte(password)); err != nil {
+			log.InfoContext(ctx, "invalid credentials", sl.Err(err))
+			return time.Time{}, fmt.Errorf("%s: %w", op, ErrInvalidCredentials)
+		}
+	} else if err := a.checkAdmin(ctx, log, claims); err != nil {
+		return time.Time{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	event := models.AuditEvent{
+		UserID:    userID,
+		ActorID:   claims.UserID,
+		IP:        ClientFromContext(ctx).IP,
+		CreatedAt: time.Now(),
+	}
+
+	var deleteAfter time.Time
+	if a.deletionGrace > 0 {
+		deleteAfter = event.CreatedAt.Add(a.deletionGrace)
+		err = a.scheduleDeletion(ctx, event, deleteAfter)
+	} else {
+		err = a.deleteAccount(ctx, event)
+	}
+	if err != nil {
+		if errors.Is(err, storage.ErrUserNotFound) {
+			log.WarnContext(ctx, "user not found", sl.Err(err))
+			return time.Time{}, fmt.Errorf("%s: %w", op, ErrUserNotFound)
+		}
+		log.ErrorContext(ctx, "failed to delete account", sl.Err(err))
+		return time.Time{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	if deleteAfter.IsZero() {
+		log.InfoContext(ctx, "account deleted")
+	} else {
+		log.InfoContext(ctx, "account deletion scheduled", slog.Time("delete_after", deleteAfter))
+	}
+
+	return deleteAfter, nil
+}
+
+// PurgeDeletedAccounts deletes the accounts whose deletion grace period was
+// over at now and returns how many were deleted.
+func (a *Auth) PurgeDeletedAccounts(ctx context.Context, now time.Time) (int, error) {
+	const op = "auth.PurgeDeletedAccounts"
+
+	log := a.log.With(slog.String("op", op))
+
+	ids, err := a.usrProvider.UsersDueForDeletion(ctx, now)
+	if err != nil {
+		log.ErrorContext(ctx, "failed to get accounts due for deletion", sl.Err(err))
+		return 0, fmt.Errorf("%s: %w", op, err)
+	}
+
+	purged := 0
+	for _, id := range ids {
+		event := models.AuditEvent{UserID: id, ActorID: SystemActorID, CreatedAt: now}
+
+		// The owner may have logged in since the accounts were listed, so
+		// the schedule is checked again together with the deletion.
+		var deleted bool
+		err := a.tx.WithTx(ctx, func(ctx context.Context) error {
+			due, err := a.usrProvider.UsersDueForDeletion(ctx, now)
+			if err != nil {
+				return err
+			}
+			if !slices.Contains(due, id) {
+				return nil
+			}
+			deleted = true
+			return a.deleteAccount(ctx, event)
+		})
+		if err != nil {
+			if errors.Is(err, storage.ErrUserNotFound) {
+				continue
+			}
+			log.ErrorContext(ctx, "failed to delete account", slog.Int64("user_id", id), sl.Err(err))
+			return purged, fmt.Errorf("%s: %w", op, err)
+		}
+		if deleted {
+			purged++
+			log.InfoContext(ctx, "account purged", slog.Int64("user_id", id))
+		}
+	}
+
+	return purged, nil
+}
+
+// RunPurge calls PurgeDeletedAccounts every interval until ctx is done.
+func (a *Auth) RunPurge(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			// errors are logged by PurgeDeletedAccounts; the next tick retries
+			_, _ = a.PurgeDeletedAccounts(ctx, now)
+		}
+	}
+}
+
+// scheduleDeletion marks the account for deletion after deleteAfter and
+// revokes its sessions, so it cannot be used until the owner logs in again.
+func (a *Auth) scheduleDeletion(ctx context.Context, event models.AuditEvent, deleteAfter time.Time) error {
+	event.Action = AuditAccountDeletionScheduled
+
+	return a.tx.WithTx(ctx, func(ctx context.Context) error {
+		if err := a.usrSaver.SetDeleteAfter(ctx, event.UserID, &deleteAfter); err != nil {
+			return err
+		}
+		if err := a.sessions.RevokeUserSessions(ctx, event.UserID, ""); err != nil {
+			return err
+		}
+		return a.audit.SaveAuditEvent(ctx, event)
+	})
+}
+
+// cancelDeletion clears a scheduled deletion after the owner logged in.
+func (a *Auth) cancelDeletion(ctx context.Context, userID int64) error {
+	event := a.auditEvent(ctx, userID, AuditAccountDeletionCancelled)
+
+	return a.tx.WithTx(ctx, func(ctx context.Context) error {
+		if err := a.usrSaver.SetDeleteAfter(ctx, userID, nil); err != nil {
+			return err
+		}
+		return a.audit.SaveAuditEvent(ctx, event)
+	})
+}
+
+// deleteAccount deletes the account and records the audit event in the same
+// transaction.
+func (a *Auth) deleteAccount(ctx context.Context, event models.AuditEvent) error {
+	event.Action = AuditAccountDeleted
+
+	return a.tx.WithTx(ctx, func(ctx context.Context) error {
+		if err := a.usrSaver.DeleteUser(ctx, event.UserID); err != nil {
+			return err
+		}
+		return a.audit.SaveAuditEvent(ctx, event)
+	})
+}
@@ -0,0 +1,297 @@
+package auth
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sso/internal/domain/models"
+	"sso/internal/lib/jwt"
+	"sso/internal/lib/logger/sl"
+	"sso/internal/storage"
+	"strconv"
+	"time"
+)
+
+var (
+	ErrInvalidPageToken = errors.New("invalid page token")
+	// ErrSelfAdminAction is returned when admins try to disable or demote
+	// themselves, which could leave the service without an admin.
+	ErrSelfAdminAction = errors.New("admins cannot disable or demote themselves")
+)
+
+// Page sizes of ListUsers.
+const (
+	DefaultPageSize = 50
+	MaxPageSize     = 500
+)
+
+// Actions of audit events recorded by admins.
+const (
+	AuditAdminGranted   = "admin.granted"
+	AuditAdminRevoked   = "admin.revoked"
+	AuditUserDisabled   = "admin.user_disabled"
+	AuditUserEnabled    = "admin.user_enabled"
+	AuditSessionsForced = "admin.sessions_revoked"
+)
+
+// ListUsers returns a page of the users matching filter, ordered by ID,
+// together with the token of the next page; the token is empty on the last
+// page. filter.Limit is the page size and filter.AfterID is ignored: pages
+// are selected with pageToken.
+func (a *Auth) ListUsers(ctx context.Context, adminToken string, filter models.UserFilter, pageToken string) ([]models.User, string, error) {
+	const op = "auth.ListUsers"
+
+	log := a.log.With(slog.String("op", op))
+
+	if _, err := a.requireAdmin(ctx, log, adminToken); err != nil {
+		return nil, "", fmt.Errorf("%s: %w", op, err)
+	}
+
+	afterID, err := parsePageToken(pageToken)
+	if err != nil {
+		log.WarnContext(ctx, "invalid page token", sl.Err(err))
+		return nil, "", fmt.Errorf("%s: %w", op, ErrInvalidPageToken)
+	}
+	pageSize := filter.Limit
+	switch {
+	case pageSize <= 0:
+		pageSize = DefaultPageSize
+	case pageSize > MaxPageSize:
+		pageSize = MaxPageSize
+	}
+
+	// One user more than asked for tells whether there is a next page.
+	filter.AfterID = afterID
+	filter.Limit = pageSize + 1
+	users, err := a.usrProvider.ListUsers(ctx, filter)
+	if err != nil {
+		log.ErrorContext(ctx, "failed to list users", sl.Err(err))
+		return nil, "", fmt.Errorf("%s: %w", op, err)
+	}
+
+	var next string
+	if len(users) > pageSize {
+		users = users[:pageSize]
+		next = newPageToken(users[pageSize-1].ID)
+	}
+
+	return users, next, nil
+}
+
+// GetUser returns the user with userID to an admin.
+func (a *Auth) GetUser(ctx context.Context, adminToken string, userID int64) (models.User, error) {
+	const op = "auth.GetUser"
+
+	log := a.log.With(slog.String("op", op), slog.Int64("user_id", userID))
+
+	if _, err := a.requireAdmin(ctx, log, adminToken); err != nil {
+		return models.User{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	user, err := a.usrProvider.UserByID(ctx, userID)
+	if err != nil {
+		if errors.Is(err, storage.ErrUserNotFound) {
+			log.WarnContext(ctx, "user not found", sl.Err(err))
+			return models.User{}, fmt.Errorf("%s: %w", op, ErrUserNotFound)
+		}
+		log.ErrorContext(ctx, "failed to get user", sl.Err(err))
+		return models.User{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return user, nil
+}
+
+// SetAdmin grants or revokes admin rights of the user.
+func (a *Auth) SetAdmin(ctx context.Context, adminToken string, userID int64, isAdmin bool) error {
+	const op = "auth.SetAdmin"
+
+	log := a.log.With(slog.String("op", op), slog.Int64("user_id", userID), slog.Bool("is_admin", isAdmin))
+
+	action := AuditAdminGranted
+	if !isAdmin {
+		action = AuditAdminRevoked
+	}
+
+	err := a.adminAction(ctx, log, adminToken, userID, action, !isAdmin, func(ctx context.Context) error {
+		return a.usrSaver.SetAdmin(ctx, userID, isAdmin)
+	})
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	log.InfoContext(ctx, "admin rights changed")
+
+	return nil
+}
+
+// DisableUser stops the user from logging in and revokes every session of
+// the user until EnableUser is called.
+func (a *Auth) DisableUser(ctx context.Context, adminToken string, userID int64) error {
+	const op = "auth.DisableUser"
+
+	log := a.log.With(slog.String("op", op), slog.Int64("user_id", userID))
+
+	disabledAt := time.Now()
+	err := a.adminAction(ctx, log, adminToken, userID, AuditUserDisabled, true, func(ctx context.Context) error {
+		if err := a.usrSaver.SetDisabledAt(ctx, userID, &disabledAt); err != nil {
+			return err
+		}
+		return a.sessions.RevokeUserSessions(ctx, userID, "")
+	})
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	log.InfoContext(ctx, "user disabled")
+
+	return nil
+}
+
+// EnableUser lets a disabled user log in again.
+func (a *Auth) EnableUser(ctx context.Context, adminToken string, userID int64) error {
+	const op = "auth.EnableUser"
+
+	log := a.log.With(slog.String("op", op), slog.Int64("user_id", userID))
+
+	err := a.adminAction(ctx, log, adminToken, userID, AuditUserEnabled, false, func(ctx context.Context) error {
+		return a.usrSaver.SetDisabledAt(ctx, userID, nil)
+	})
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	log.InfoContext(ctx, "user enabled")
+
+	return nil
+}
+
+// ForceLogout revokes every session of the user. Unlike DisableUser it does
+// not stop the user from logging in again.
+func (a *Auth) ForceLogout(ctx context.Context, adminToken string, userID int64) error {
+	const op = "auth.ForceLogout"
+
+	log := a.log.With(slog.String("op", op), slog.Int64("user_id", userID))
+
+	err := a.adminAction(ctx, log, adminToken, userID, AuditSessionsForced, false, func(ctx context.Context) error {
+		// RevokeUserSessions does not report missing users.
+		if _, err := a.usrProvider.UserByID(ctx, userID); err != nil {
+			return err
+		}
+		return a.sessions.RevokeUserSessions(ctx, userID, "")
+	})
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	log.InfoContext(ctx, "user logged out")
+
+	return nil
+}
+
+// adminAction checks adminToken and runs fn with an audit event about the
+// user in one transaction. notSelf refuses actions of admins on their own
+// account.
+func (a *Auth) adminAction(ctx context.Context, log *slog.Logger, adminToken string, userID int64, action string, notSelf bool, fn func(ctx context.Context) error) error {
+	claims, err := a.requireAdmin(ctx, log, adminToken)
+	if err != nil {
+		return err
+	}
+	if notSelf && claims.UserID == userID {
+		log.WarnContext(ctx, "admin action on own account", slog.String("action", action))
+		return ErrSelfAdminAction
+	}
+
+	event := models.AuditEvent{
+		UserID:    userID,
+		ActorID:   claims.UserID,
+		Action:    action,
+		IP:        ClientFromContext(ctx).IP,
+		CreatedAt: time.Now(),
+	}
+
+	err = a.tx.WithTx(ctx, func(ctx context.Context) error {
+		if err := fn(ctx); err != nil {
+			return err
+		}
+		return a.audit.SaveAuditEvent(ctx, event)
+	})
+	if err != nil {
+		if errors.Is(err, storage.ErrUserNotFound) {
+			log.WarnContext(ctx, "user not found", sl.Err(err))
+			return ErrUserNotFound
+		}
+		log.ErrorContext(ctx, "failed to run admin action", slog.String("action", action), sl.Err(err))
+		return err
+	}
+
+	return nil
+}
+
+// requireAdmin verifies adminToken and checks with checkAdmin that it
+// authorizes admin actions. Failures are logged.
+func (a *Auth) requireAdmin(ctx context.Context, log *slog.Logger, adminToken string) (jwt.Claims, error) {
+	claims, err := a.parseToken(ctx, adminToken)
+	if err != nil {
+		log.WarnContext(ctx, "invalid token", sl.Err(err))
+		return jwt.Claims{}, ErrInvalidToken
+	}
+
+	if err := a.checkAdmin(ctx, log, claims); err != nil {
+		return jwt.Claims{}, err
+	}
+
+	return claims, nil
+}
+
+// checkAdmin checks that verified claims were issued for the admin app and
+// that their owner is still an admin. Tokens of other apps are refused:
+// relying services hold their app secrets and could sign their own.
+// Failures are logged.
+func (a *Auth) checkAdmin(ctx context.Context, log *slog.Logger, claims jwt.Claims) error {
+	if claims.AppID != a.adminAppID {
+		log.WarnContext(ctx, "admin action with token of another app", slog.Int64("caller_id", claims.UserID), slog.Int("app_id", claims.AppID))
+		return ErrPermissionDenied
+	}
+
+	isAdmin, err := a.usrProvider.IsAdmin(ctx, claims.UserID)
+	if err != nil {
+		if errors.Is(err, storage.ErrAppNotFound) {
+			log.WarnContext(ctx, "admin not found", sl.Err(err))
+			return ErrInvalidToken
+		}
+		log.ErrorContext(ctx, "failed to check if user is admin", sl.Err(err))
+		return err
+	}
+	if !isAdmin {
+		log.WarnContext(ctx, "admin action by non-admin", slog.Int64("caller_id", claims.UserID))
+		return ErrPermissionDenied
+	}
+
+	return nil
+}
+
+// Page tokens are opaque to clients; they hold the ID of the last user of
+// the previous page.
+func newPageToken(lastID int64) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(strconv.FormatInt(lastID, 10)))
+}
+
+func parsePageToken(token string) (int64, error) {
+	if token == "" {
+		return 0, nil
+	}
+	b, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return 0, err
+	}
+	id, err := strconv.ParseInt(string(b), 10, 64)
+	if err != nil {
+		return 0, err
+	}
+	if id < 0 {
+		return 0, errors.New("negative user id")
+	}
+	return id, nil
+}
@@ -0,0 +1,267 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sso/internal/domain/models"
+	"sso/internal/lib/logger/sl"
+	"sso/internal/storage"
+	"time"
+)
+
+var ErrAppExists = errors.New("app already exists")
+
+// appSecretSize is the number of random bytes of a generated app secret.
+const appSecretSize = 32
+
+// AppUpdate holds the app settings to change; nil fields are left as they
+// are.
+type AppUpdate struct {
+	Name                    *string
+	ContinuousAuthThreshold *float64
+	Sensitivity             *int
+	TokenTTL                *time.Duration
+	Audience                *string
+	Issuer                  *string
+	// ClaimsTemplate pointing to a nil template restores the default one.
+	ClaimsTemplate *[]string
+}
+
+// CreateApp registers a new app and returns it with its generated secret.
+// The secret is only ever returned here and by RotateSecret.
+func (a *Auth) CreateApp(ctx context.Context, adminToken string, app models.App) (models.App, error) {
+	const op = "auth.CreateApp"
+
+	log := a.log.With(slog.String("op", op), slog.String("name", app.Name))
+
+	claims, err := a.requireAdmin(ctx, log, adminToken)
+	if err != nil {
+		return models.App{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	app.Secret, err = newAppSecret()
+	if err != nil {
+		log.ErrorContext(ctx, "failed to generate app secret", sl.Err(err))
+		return models.App{}, fmt.Errorf("%s: %w", op, err)
+	}
+	app.PreviousSecret, app.PreviousSecretExpiresAt, app.DisabledAt = "", time.Time{}, nil
+
+	app.ID, err = a.apps.CreateApp(ctx, app)
+	if err != nil {
+		if errors.Is(err, storage.ErrAppExists) {
+			log.WarnContext(ctx, "app already exists", sl.Err(err))
+			return models.App{}, fmt.Errorf("%s: %w", op, ErrAppExists)
+		}
+		log.ErrorContext(ctx, "failed to create app", sl.Err(err))
+		return models.App{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	created, err := a.appProvider.App(ctx, app.ID)
+	if err != nil {
+		log.ErrorContext(ctx, "failed to get app", sl.Err(err))
+		return models.App{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	log.InfoContext(ctx, "app created", slog.Int("app_id", created.ID), slog.Int64("admin_id", claims.UserID))
+
+	return created, nil
+}
+
+// ListApps returns every app ordered by ID. Secrets are left out.
+func (a *Auth) ListApps(ctx context.Context, adminToken string) ([]models.App, error) {
+	const op = "auth.ListApps"
+
+	log := a.log.With(slog.String("op", op))
+
+	if _, err := a.requireAdmin(ctx, log, adminToken); err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	apps, err := a.apps.Apps(ctx)
+	if err != nil {
+		log.ErrorContext(ctx, "failed to list apps", sl.Err(err))
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	for i := range apps {
+		apps[i].Secret, apps[i].PreviousSecret = "", ""
+	}
+
+	return apps, nil
+}
+
+// UpdateApp changes the name, login and token settings of the app and
+// returns it without its secrets.
+func (a *Auth) UpdateApp(ctx context.Context, adminToken string, appID int, update AppUpdate) (models.App, error) {
+	const op = "auth.UpdateApp"
+
+	log := a.log.With(slog.String("op", op), slog.Int("app_id", appID))
+
+	var app models.App
+	err := a.appAction(ctx, log, adminToken, func(ctx context.Context) error {
+		var err error
+		app, err = a.appProvider.App(ctx, appID)
+		if err != nil {
+			return err
+		}
+		if update.Name != nil {
+			app.Name = *update.Name
+		}
+		if update.ContinuousAuthThreshold != nil {
+			app.ContinuousAuthThreshold = *update.ContinuousAuthThreshold
+		}
+		if update.Sensitivity != nil {
+			app.Sensitivity = *update.Sensitivity
+		}
+		if update.TokenTTL != nil {
+			app.TokenTTL = *update.TokenTTL
+		}
+		if update.Audience != nil {
+			app.Audience = *update.Audience
+		}
+		if update.Issuer != nil {
+			app.Issuer = *update.Issuer
+		}
+		if update.ClaimsTemplate != nil {
+			app.ClaimsTemplate = *update.ClaimsTemplate
+		}
+		return a.apps.UpdateApp(ctx, app)
+	})
+	if err != nil {
+		return models.App{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	log.InfoContext(ctx, "app updated")
+
+	app.Secret, app.PreviousSecret = "", ""
+	return app, nil
+}
+
+// DisableApp rejects logins to the app and the tokens it was issued until
+// EnableApp is called.
+func (a *Auth) DisableApp(ctx context.Context, adminToken string, appID int) error {
+	const op = "auth.DisableApp"
+
+	log := a.log.With(slog.String("op", op), slog.Int("app_id", appID))
+
+	disabledAt := time.Now()
+	err := a.appAction(ctx, log, adminToken, func(ctx context.Context) error {
+		return a.apps.SetAppDisabledAt(ctx, appID, &disabledAt)
+	})
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	log.InfoContext(ctx, "app disabled")
+
+	return nil
+}
+
+// EnableApp lets users log in to a disabled app again.
+func (a *Auth) EnableApp(ctx context.Context, adminToken string, appID int) error {
+	const op = "auth.EnableApp"
+
+	log := a.log.With(slog.String("op", op), slog.Int("app_id", appID))
+
+	err := a.appAction(ctx, log, adminToken, func(ctx context.Context) error {
+		return a.apps.SetAppDisabledAt(ctx, appID, nil)
+	})
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	log.InfoContext(ctx, "app enabled")
+
+	return nil
+}
+
+// DeleteApp removes the app and its sessions.
+func (a *Auth) DeleteApp(ctx context.Context, adminToken string, appID int) error {
+	const op = "auth.DeleteApp"
+
+	log := a.log.With(slog.String("op", op), slog.Int("app_id", appID))
+
+	err := a.appAction(ctx, log, adminToken, func(ctx context.Context) error {
+		return a.apps.DeleteApp(ctx, appID)
+	})
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	log.InfoContext(ctx, "app deleted")
+
+	return nil
+}
+
+// RotateSecret replaces the secret of the app with a generated one, which is
+// returned once. Tokens signed with the replaced secret stay valid for
+// grace; with zero grace they are rejected at once. A secret still in its
+// own grace period is dropped. The returned time is when the replaced
+// secret expires.
+func (a *Auth) RotateSecret(ctx context.Context, adminToken string, appID int, grace time.Duration) (string, time.Time, error) {
+	const op = "auth.RotateSecret"
+
+	log := a.log.With(slog.String("op", op), slog.Int("app_id", appID), slog.Duration("grace", grace))
+
+	secret, err := newAppSecret()
+	if err != nil {
+		log.ErrorContext(ctx, "failed to generate app secret", sl.Err(err))
+		return "", time.Time{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	expiresAt := time.Now().Add(grace)
+	err = a.appAction(ctx, log, adminToken, func(ctx context.Context) error {
+		app, err := a.appProvider.App(ctx, appID)
+		if err != nil {
+			return err
+		}
+		previous := app.Secret
+		if grace <= 0 {
+			previous = ""
+		}
+		return a.apps.SetAppSecrets(ctx, appID, secret, previous, expiresAt)
+	})
+	if err != nil {
+		return "", time.Time{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	log.InfoContext(ctx, "app secret rotated")
+
+	return secret, expiresAt, nil
+}
+
+// appAction checks adminToken and runs fn in one transaction, mapping
+// storage errors about the app.
+func (a *Auth) appAction(ctx context.Context, log *slog.Logger, adminToken string, fn func(ctx context.Context) error) error {
+	claims, err := a.requireAdmin(ctx, log, adminToken)
+	if err != nil {
+		return err
+	}
+	log = log.With(slog.Int64("admin_id", claims.UserID))
+
+	err = a.tx.WithTx(ctx, fn)
+	switch {
+	case err == nil:
+		return nil
+	case errors.Is(err, storage.ErrAppNotFound):
+		log.WarnContext(ctx, "app not found", sl.Err(err))
+		return ErrInvalidAppID
+	case errors.Is(err, storage.ErrAppExists):
+		log.WarnContext(ctx, "app already exists", sl.Err(err))
+		return ErrAppExists
+	default:
+		log.ErrorContext(ctx, "failed to run app action", sl.Err(err))
+		return err
+	}
+}
+
+func newAppSecret() (string, error) {
+	b := make([]byte, appSecretSize)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
@@ -2,141 +2,380 @@ package auth
 
 import (
 	"context"
+	"crypto/rand"
+	"encoding/hex"
 	"errors"
 	"fmt"
 	"golang.org/x/crypto/bcrypt"
 	"log/slog"
 	"sso/internal/domain/models"
+	"sso/internal/lib/biometrics"
 	"sso/internal/lib/jwt"
 	"sso/internal/lib/logger/sl"
+	"sso/internal/services/risk"
 	"sso/internal/storage"
 	"time"
 )
 
 var (
 	ErrInvalidCredentials   = errors.New("invalid credentials")
-	ErrPressTimesInvalid    = errors.New("invalid press times")
-	ErrIntervalTimesInvalid = errors.New("invalid interval times")
+	ErrPressTimesInvalid    = biometrics.ErrPressTimesInvalid
+	ErrIntervalTimesInvalid = biometrics.ErrIntervalTimesInvalid
 	ErrInvalidBiometrics    = errors.New("invalid biometrics")
+	ErrReplayedSample       = errors.New("replayed biometric sample")
+	ErrSyntheticSample      = errors.New("synthetic biometric sample")
 	ErrInvalidAppID         = errors.New("invalid app")
 	ErrUserExist            = errors.New("user already exists")
 	ErrUserNotFound         = errors.New("user not found")
+	ErrInvalidToken         = errors.New("invalid token")
+	ErrPermissionDenied     = errors.New("permission denied")
+	ErrSessionRevoked       = errors.New("session revoked")
+	ErrLoginDenied          = errors.New("login denied")
+	ErrUserDisabled         = errors.New("user disabled")
+	ErrAppDisabled          = errors.New("app disabled")
 )
 
+// Authentication context class references reported in the acr claim.
+const (
+	// ACRReduced is issued for password-only logins (biometric enrollment)
+	// and for logins the risk engine asks to step up. Relying parties must
+	// re-authenticate the user before sensitive operations.
+	ACRReduced = "1"
+	// ACRKeystroke is issued for low-risk logins verified by password and
+	// keystroke dynamics.
+	ACRKeystroke = "2"
+)
+
+// loginHistoryWindow is how far back login attempts feed the risk signals.
+const loginHistoryWindow = 90 * 24 * time.Hour
+
 type Auth struct {
 	log         *slog.Logger
 	usrSaver    UserSaver
 	usrProvider UserProvider
 	tokenTTL    time.Duration
+	// issuer is put in the iss claim of tokens of apps without their own.
+	issuer string
+	// adminAppID is the app whose tokens are accepted for admin actions.
+	adminAppID  int
 	appProvider AppProvider
+	apps        AppManager
+	sessions    SessionStorage
+	history     LoginHistory
+	tx          Transactor
+	audit       AuditLog
+	mailer      EmailSender
+	matcher     biometrics.Matcher
+	risk        *risk.Engine
+	replay      *replayGuard
+	// deletionGrace is how long a deleted account can still be restored by
+	// logging in; zero deletes accounts at once.
+	deletionGrace time.Duration
 }
 
 type UserSaver interface {
-	SaveUser(ctx context.Context, email string, passHash []byte, pressTimes []float32, intervalTimes []float32) (userID int64, err error)
+	SaveUser(ctx context.Context, email string, passHash []byte, pressTimes []float32, intervalTimes []float32, keyEvents []models.KeyEvent) (userID int64, err error)
+	UpdateBiometrics(ctx context.Context, userID int64, pressTimes []float32, intervalTimes []float32, keyEvents []models.KeyEvent) error
+	SetEnrollmentPending(ctx context.Context, userID int64) error
+	UpdateProfile(ctx context.Context, userID int64, profile models.Profile) error
+	UpdatePassword(ctx context.Context, userID int64, passHash []byte) error
+	SetEmail(ctx context.Context, userID int64, email string) error
+	SetEmailChange(ctx context.Context, userID int64, change *models.EmailChange) error
+	SetDeleteAfter(ctx context.Context, userID int64, deleteAfter *time.Time) error
+	SetAdmin(ctx context.Context, userID int64, isAdmin bool) error
+	SetDisabledAt(ctx context.Context, userID int64, disabledAt *time.Time) error
+	DeleteUser(ctx context.Context, userID int64) error
 }
 
 type UserProvider interface {
 	User(ctx context.Context, email string) (models.User, error)
+	UserByID(ctx context.Context, userID int64) (models.User, error)
 	IsAdmin(ctx context.Context, userID int64) (bool, error)
+	UsersDueForDeletion(ctx context.Context, now time.Time) ([]int64, error)
+	ListUsers(ctx context.Context, filter models.UserFilter) ([]models.User, error)
 }
 
 type AppProvider interface {
 	App(ctx context.Context, appID int) (models.App, error)
 }
 
+// AppManager stores the apps registered by admins. Secrets are passed in
+// plain text; the storage encrypts them.
+type AppManager interface {
+	Apps(ctx context.Context) ([]models.App, error)
+	CreateApp(ctx context.Context, app models.App) (int, error)
+	UpdateApp(ctx context.Context, app models.App) error
+	SetAppDisabledAt(ctx context.Context, appID int, disabledAt *time.Time) error
+	SetAppSecrets(ctx context.Context, appID int, secret string, previous string, previousExpiresAt time.Time) error
+	DeleteApp(ctx context.Context, appID int) error
+}
+
+type SessionStorage interface {
+	SaveSession(ctx context.Context, session models.Session) error
+	Session(ctx context.Context, sessionID string) (models.Session, error)
+	RevokeSession(ctx context.Context, sessionID string) error
+	UserSessions(ctx context.Context, userID int64) ([]models.Session, error)
+	RevokeUserSessions(ctx context.Context, userID int64, exceptSessionID string) error
+}
+
+// Transactor runs multi-step writes atomically: storage calls made with the
+// context passed to fn are rolled back together if fn returns an error.
+type Transactor interface {
+	WithTx(ctx context.Context, fn func(ctx context.Context) error) error
+}
+
+type LoginHistory interface {
+	SaveLoginAttempt(ctx context.Context, attempt models.LoginAttempt) error
+	LoginAttempts(ctx context.Context, userID int64, since time.Time) ([]models.LoginAttempt, error)
+}
+
+// AuditLog records actions taken on accounts. Events are kept after the
+// account is deleted.
+type AuditLog interface {
+	SaveAuditEvent(ctx context.Context, event models.AuditEvent) error
+	AuditEvents(ctx context.Context, userID int64) ([]models.AuditEvent, error)
+}
+
+// EmailSender delivers verification codes to email addresses.
+type EmailSender interface {
+	SendEmailVerification(ctx context.Context, email string, code string) error
+}
+
 // New returns a new instance of the Auth service.
 func New(
 	log *slog.Logger,
 	saver UserSaver,
 	provider UserProvider,
 	appProvider AppProvider,
+	apps AppManager,
+	sessions SessionStorage,
+	history LoginHistory,
+	tx Transactor,
+	audit AuditLog,
+	mailer EmailSender,
+	matcher biometrics.Matcher,
+	riskEngine *risk.Engine,
 	tokenTTL time.Duration,
+	issuer string,
+	adminAppID int,
+	deletionGrace time.Duration,
 ) *Auth {
 	return &Auth{
-		usrSaver:    saver,
-		usrProvider: provider,
-		appProvider: appProvider,
-		tokenTTL:    tokenTTL,
-		log:         log,
+		usrSaver:      saver,
+		usrProvider:   provider,
+		appProvider:   appProvider,
+		apps:          apps,
+		sessions:      sessions,
+		history:       history,
+		tx:            tx,
+		audit:         audit,
+		mailer:        mailer,
+		matcher:       matcher,
+		risk:          riskEngine,
+		tokenTTL:      tokenTTL,
+		issuer:        issuer,
+		adminAppID:    adminAppID,
+		deletionGrace: deletionGrace,
+		log:           log,
+		replay:        newReplayGuard(replayHistorySize, replayTrackedUsers),
 	}
 }
 
-func (a *Auth) Login(ctx context.Context, email string, password string, pressTimes []float32, intervalTimes []float32, appID int) (string, error) {
+func (a *Auth) Login(ctx context.Context, email string, password string, pressTimes []float32, intervalTimes []float32, keyEvents []models.KeyEvent, appID int) (string, error) {
 	const op = "auth.Login"
 
 	log := a.log.With(
 		slog.String("op", op),
-		// fixme опасно хранить почту в логах
-		slog.String("email", email),
+		sl.Email(email),
 	)
 
 	user, err := a.usrProvider.User(ctx, email)
 	if err != nil {
 		if errors.Is(err, storage.ErrUserNotFound) {
-			a.log.Warn("user not found", sl.Err(err))
+			log.WarnContext(ctx, "user not found", sl.Err(err))
 
 			return "", fmt.Errorf("%s: %w", op, ErrInvalidCredentials)
 		}
-		a.log.Error("failed to get user", sl.Err(err))
+		log.ErrorContext(ctx, "failed to get user", sl.Err(err))
 
 		return "", fmt.Errorf("%s: %w", op, err)
 	}
 
+	client := ClientFromContext(ctx)
+	attempt := models.LoginAttempt{
+		UserID:    user.ID,
+		AppID:     appID,
+		IP:        client.IP,
+		Device:    client.Device,
+		CreatedAt: time.Now(),
+	}
+
 	if err := bcrypt.CompareHashAndPassword(user.PassHash, []byte(password)); err != nil {
-		a.log.Error("invalid credentials", sl.Err(err))
+		log.InfoContext(ctx, "invalid credentials", sl.Err(err))
+		a.recordAttempt(ctx, log, attempt)
+
+		return "", fmt.Errorf("%s: %w", op, ErrInvalidCredentials)
 	}
 
-	biometricCheck, err := a.checkBiometrics(ctx, user, pressTimes, intervalTimes)
+	// Checked after the password, so it does not tell whether an account
+	// exists.
+	if user.DisabledAt != nil {
+		log.WarnContext(ctx, "login of disabled user", slog.Int64("user_id", user.ID))
+		a.recordAttempt(ctx, log, attempt)
 
-	if !biometricCheck || err != nil {
-		a.log.Error("invalid biometrics", sl.Err(err))
-		return "", fmt.Errorf("%s: %w", op, ErrInvalidBiometrics)
+		return "", fmt.Errorf("%s: %w", op, ErrUserDisabled)
 	}
 
+	sample := newSample(pressTimes, intervalTimes, keyEvents)
+	acr := ACRKeystroke
+	biometricScore := 1.0
+
+	if user.EnrollmentPending {
+		// The sample becomes the template only once the login passed every
+		// check below.
+		acr = ACRReduced
+	} else {
+		if a.replay.seen(user.ID, sample) {
+			log.WarnContext(ctx, "replayed biometric sample", slog.Int64("user_id", user.ID))
+			a.recordAttempt(ctx, log, attempt)
+			return "", fmt.Errorf("%s: %w", op, ErrReplayedSample)
+		}
+
+		if isNear(fingerprint(sample), fingerprint(templateOf(user)), syntheticTolerance) {
+			log.WarnContext(ctx, "synthetic biometric sample", slog.Int64("user_id", user.ID))
+			a.recordAttempt(ctx, log, attempt)
+			return "", fmt.Errorf("%s: %w", op, ErrSyntheticSample)
+		}
+
+		biometricCheck, err := a.checkBiometrics(ctx, user, sample)
+
+		if !biometricCheck || err != nil {
+			log.WarnContext(ctx, "invalid biometrics", slog.Int64("user_id", user.ID), sl.Err(err))
+			a.recordAttempt(ctx, log, attempt)
+			return "", fmt.Errorf("%s: %w", op, ErrInvalidBiometrics)
+		}
+		biometricScore = a.matcher.Score(templateOf(user), sample)
+	}
+
+	a.replay.remember(user.ID, sample)
+
 	app, err := a.appProvider.App(ctx, appID)
 	if err != nil {
-		a.log.Error("failed to get app", sl.Err(err))
+		log.ErrorContext(ctx, "failed to get app", sl.Err(err))
+		return "", fmt.Errorf("%s: %w", op, err)
+	}
+	if app.DisabledAt != nil {
+		log.WarnContext(ctx, "login to disabled app", slog.Int("app_id", app.ID))
+		a.recordAttempt(ctx, log, attempt)
+		return "", fmt.Errorf("%s: %w", op, ErrAppDisabled)
+	}
+
+	history, err := a.history.LoginAttempts(ctx, user.ID, attempt.CreatedAt.Add(-loginHistoryWindow))
+	if err != nil {
+		log.ErrorContext(ctx, "failed to get login history", sl.Err(err))
+		return "", fmt.Errorf("%s: %w", op, err)
+	}
+
+	decision := a.risk.Evaluate(ctx, risk.Attempt{
+		UserID:         user.ID,
+		App:            app,
+		IP:             client.IP,
+		Device:         client.Device,
+		Time:           attempt.CreatedAt,
+		BiometricScore: biometricScore,
+		History:        history,
+	})
+	switch decision.Action {
+	case risk.ActionDeny:
+		log.WarnContext(ctx, "login denied by risk engine", slog.Int64("user_id", user.ID), slog.Float64("risk", decision.Score))
+		a.recordAttempt(ctx, log, attempt)
+		return "", fmt.Errorf("%s: %w", op, ErrLoginDenied)
+	case risk.ActionStepUp:
+		acr = ACRReduced
+	}
+
+	if user.EnrollmentPending {
+		if err := a.usrSaver.UpdateBiometrics(ctx, user.ID, sample.PressTimes, sample.IntervalTimes, sample.KeyEvents); err != nil {
+			log.ErrorContext(ctx, "failed to enroll biometrics", sl.Err(err))
+			return "", fmt.Errorf("%s: %w", op, err)
+		}
+		user.PressTimes, user.PressIntervals, user.KeyEvents = sample.PressTimes, sample.IntervalTimes, sample.KeyEvents
+		user.EnrollmentPending = false
+
+		log.InfoContext(ctx, "biometrics enrolled", slog.Int64("user_id", user.ID))
+	}
+
+	attempt.Success = true
+	a.recordAttempt(ctx, log, attempt)
+
+	if user.DeleteAfter != nil {
+		if err := a.cancelDeletion(ctx, user.ID); err != nil {
+			log.ErrorContext(ctx, "failed to cancel account deletion", sl.Err(err))
+			return "", fmt.Errorf("%s: %w", op, err)
+		}
+		log.InfoContext(ctx, "account deletion cancelled", slog.Int64("user_id", user.ID))
+	}
+
+	session, err := a.newSession(ctx, user, app)
+	if err != nil {
+		log.ErrorContext(ctx, "failed to create session", sl.Err(err))
 		return "", fmt.Errorf("%s: %w", op, err)
 	}
-	log.Info("user logged in", slog.Int64("user_id", user.ID), slog.Int("app_id", app.ID))
+	log.InfoContext(ctx, "user logged in", slog.Int64("user_id", user.ID), slog.Int("app_id", app.ID), slog.String("acr", acr))
 
-	token, err := jwt.NewToken(user, app, a.tokenTTL)
+	token, err := jwt.NewToken(user, app, session.ID, acr, a.issuer, a.appTokenTTL(app))
 	if err != nil {
-		a.log.Error("failed to create token", sl.Err(err))
+		log.ErrorContext(ctx, "failed to create token", sl.Err(err))
 		return "", fmt.Errorf("%s: %w", op, err)
 	}
 
 	return token, nil
 }
 
-func (a *Auth) RegisterNewUser(ctx context.Context, email string, password string, pressTimes []float32, intervalTimes []float32) (int64, error) {
+// recordAttempt stores a login attempt for the risk signals. Failing to do
+// so must not fail the login itself.
+func (a *Auth) recordAttempt(ctx context.Context, log *slog.Logger, attempt models.LoginAttempt) {
+	if err := a.history.SaveLoginAttempt(ctx, attempt); err != nil {
+		log.ErrorContext(ctx, "failed to save login attempt", sl.Err(err))
+	}
+}
+
+func (a *Auth) RegisterNewUser(ctx context.Context, email string, password string, pressTimes []float32, intervalTimes []float32, keyEvents []models.KeyEvent) (int64, error) {
 	const op = "auth.RegisterNewUser"
 
 	log := a.log.With(
 		slog.String("op", op),
-		// fixme опасно хранить почту в логах
-		slog.String("email", email),
+		sl.Email(email),
 	)
 
-	log.Info("registering new user")
+	log.InfoContext(ctx, "registering new user")
 
 	passHash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
 	if err != nil {
-		log.Error("failed to hash password", sl.Err(err))
+		log.ErrorContext(ctx, "failed to hash password", sl.Err(err))
 		return 0, fmt.Errorf("%s: %w", op, err)
 	}
 
-	id, err := a.usrSaver.SaveUser(ctx, email, passHash, pressTimes, intervalTimes)
+	sample := newSample(pressTimes, intervalTimes, keyEvents)
+
+	// The user row and the template are written together: a user without a
+	// template could never log in again.
+	var id int64
+	err = a.tx.WithTx(ctx, func(ctx context.Context) error {
+		var err error
+		id, err = a.usrSaver.SaveUser(ctx, email, passHash, sample.PressTimes, sample.IntervalTimes, sample.KeyEvents)
+		return err
+	})
 	if err != nil {
 		if errors.Is(err, storage.ErrUserExists) {
-			log.Warn("user already exists", sl.Err(err))
+			log.WarnContext(ctx, "user already exists", sl.Err(err))
 
 			return 0, fmt.Errorf("%s: %w", op, ErrUserExist)
 		}
-		log.Error("failed to save user", sl.Err(err))
+		log.ErrorContext(ctx, "failed to save user", sl.Err(err))
 		return 0, fmt.Errorf("%s: %w", op, err)
 	}
-	log.Info("user registered", slog.Int64("user_id", id))
+	log.InfoContext(ctx, "user registered", slog.Int64("user_id", id))
 	return id, nil
 }
 
@@ -148,20 +387,52 @@ func (a *Auth) IsAdmin(ctx context.Context, userID int64) (bool, error) {
 		slog.Int64("user_id", userID),
 	)
 
-	log.Info("checking if user is admin")
+	log.InfoContext(ctx, "checking if user is admin")
 
 	isAdmin, err := a.usrProvider.IsAdmin(ctx, userID)
 	if err != nil {
 		if errors.Is(err, storage.ErrAppNotFound) {
-			log.Warn("user not found", sl.Err(err))
+			log.WarnContext(ctx, "user not found", sl.Err(err))
 
 			return false, fmt.Errorf("%s: %w", op, ErrInvalidAppID)
 		}
-		a.log.Error("failed to check if user is admin", sl.Err(err))
+		log.ErrorContext(ctx, "failed to check if user is admin", sl.Err(err))
 		return false, fmt.Errorf("%s: %w", op, err)
 	}
 
-	log.Info("checked if user is admin", slog.Bool("is_admin", isAdmin))
+	log.InfoContext(ctx, "checked if user is admin", slog.Bool("is_admin", isAdmin))
 
 	return isAdmin, nil
 }
+
+// appTokenTTL returns the lifetime of the tokens of the app.
+func (a *Auth) appTokenTTL(app models.App) time.Duration {
+	if app.TokenTTL > 0 {
+		return app.TokenTTL
+	}
+	return a.tokenTTL
+}
+
+// newSession stores a session for a successful login; it lives as long as
+// the token issued for it.
+func (a *Auth) newSession(ctx context.Context, user models.User, app models.App) (models.Session, error) {
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return models.Session{}, err
+	}
+
+	now := time.Now()
+	session := models.Session{
+		ID:        hex.EncodeToString(id),
+		UserID:    user.ID,
+		AppID:     app.ID,
+		CreatedAt: now,
+		ExpiresAt: now.Add(a.appTokenTTL(app)),
+	}
+
+	if err := a.sessions.SaveSession(ctx, session); err != nil {
+		return models.Session{}, err
+	}
+
+	return session, nil
+}
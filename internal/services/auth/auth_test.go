@@ -0,0 +1,1043 @@
+package auth_test
+
+import (
+	"context"
+	jwtlib "github.com/golang-jwt/jwt/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"sso/internal/domain/models"
+	"sso/internal/lib/biometrics"
+	"sso/internal/lib/jwt"
+	"sso/internal/lib/logger/handlers/slogdiscard"
+	"sso/internal/lib/mailer"
+	"sso/internal/services/auth"
+	"sso/internal/services/risk"
+	"sso/internal/storage/memory"
+	"strconv"
+	"testing"
+	"time"
+)
+
+const (
+	appID     = 1
+	appSecret = "test-secret"
+	password  = "correct horse"
+	tokenTTL  = time.Hour
+	issuer    = "sso"
+)
+
+var (
+	presses   = []float32{110, 95, 130, 120, 105, 98, 140, 115, 101, 125, 99, 133, 108}
+	intervals = []float32{210, 180, 250, 190, 230, 205, 260, 175, 220, 240, 185, 200, 215}
+)
+
+func newAuth(t *testing.T) (*auth.Auth, *memory.Storage) {
+	t.Helper()
+
+	return newAuthWithGrace(t, 0)
+}
+
+// newAuthWithGrace returns a service that keeps deleted accounts for the
+// given grace period.
+func newAuthWithGrace(t *testing.T, deletionGrace time.Duration) (*auth.Auth, *memory.Storage) {
+	t.Helper()
+
+	return newTestAuth(t, deletionGrace, nil)
+}
+
+// newTestAuth returns a service sending mail through sender, or writing it
+// to the log when sender is nil.
+func newTestAuth(t *testing.T, deletionGrace time.Duration, sender auth.EmailSender) (*auth.Auth, *memory.Storage) {
+	t.Helper()
+
+	log := slogdiscard.NewDiscardLogger()
+	storage := memory.New()
+	require.NoError(t, storage.SaveApp(context.Background(), models.App{
+		ID:                      appID,
+		Name:                    "test",
+		Secret:                  appSecret,
+		ContinuousAuthThreshold: 0.5,
+	}))
+
+	matcher := biometrics.NewMatcher(biometrics.DefaultLowerThreshold, biometrics.DefaultUpperThreshold, biometrics.DefaultFeatureTolerance)
+	engine := risk.New(log, risk.Thresholds{StepUp: 0.5, Deny: 0.8}).UseDefaultSignals()
+
+	if sender == nil {
+		sender = mailer.NewLog(log)
+	}
+
+	return auth.New(log, storage, storage, storage, storage, storage, storage, storage, storage, sender, matcher, engine, tokenTTL, issuer, appID, deletionGrace), storage
+}
+
+// mailbox records the verification codes sent to each address.
+type mailbox map[string]string
+
+func (m mailbox) SendEmailVerification(_ context.Context, email string, code string) error {
+	m[email] = code
+	return nil
+}
+
+// jitter returns a sample a genuine user could type: close to the template,
+// but never identical.
+func jitter(values []float32, factor float32) []float32 {
+	result := make([]float32, len(values))
+	for i, v := range values {
+		result[i] = v - v*factor
+	}
+	return result
+}
+
+// keyEvents types password with the given press and interval times.
+func keyEvents(pressTimes, intervalTimes []float32) []models.KeyEvent {
+	events := make([]models.KeyEvent, 0, len(pressTimes))
+	at := 0.0
+	for i, key := range password {
+		if i > 0 {
+			at += float64(intervalTimes[i-1])
+		}
+		events = append(events, models.KeyEvent{Key: string(key), PressedAt: at, ReleasedAt: at + float64(pressTimes[i])})
+	}
+	return events
+}
+
+func parseToken(t *testing.T, token string) jwt.Claims {
+	t.Helper()
+
+	return parseTokenFor(t, token, models.App{ID: appID, Secret: appSecret})
+}
+
+// parseTokenFor verifies a token issued for app.
+func parseTokenFor(t *testing.T, token string, app models.App) jwt.Claims {
+	t.Helper()
+
+	claims, err := jwt.ParseToken(token, issuer, func(int) (models.App, error) { return app, nil })
+	require.NoError(t, err)
+	return claims
+}
+
+func TestLogin_HappyPath(t *testing.T) {
+	a, _ := newAuth(t)
+	ctx := context.Background()
+
+	id, err := a.RegisterNewUser(ctx, "user@example.com", password, presses, intervals, nil)
+	require.NoError(t, err)
+
+	token, err := a.Login(ctx, "user@example.com", password, jitter(presses, 0.1), jitter(intervals, 0.1), nil, appID)
+	require.NoError(t, err)
+
+	claims := parseToken(t, token)
+	assert.Equal(t, id, claims.UserID)
+	assert.Equal(t, appID, claims.AppID)
+	assert.NotEmpty(t, claims.SessionID)
+	assert.Equal(t, auth.ACRKeystroke, claims.ACR)
+}
+
+func TestLogin_InvalidCredentials(t *testing.T) {
+	a, _ := newAuth(t)
+	ctx := context.Background()
+
+	_, err := a.RegisterNewUser(ctx, "user@example.com", password, presses, intervals, nil)
+	require.NoError(t, err)
+
+	_, err = a.Login(ctx, "user@example.com", "wrong password", jitter(presses, 0.1), jitter(intervals, 0.1), nil, appID)
+	assert.ErrorIs(t, err, auth.ErrInvalidCredentials)
+
+	_, err = a.Login(ctx, "missing@example.com", password, jitter(presses, 0.1), jitter(intervals, 0.1), nil, appID)
+	assert.ErrorIs(t, err, auth.ErrInvalidCredentials)
+}
+
+func TestRegister_Duplicate(t *testing.T) {
+	a, _ := newAuth(t)
+	ctx := context.Background()
+
+	_, err := a.RegisterNewUser(ctx, "user@example.com", password, presses, intervals, nil)
+	require.NoError(t, err)
+
+	_, err = a.RegisterNewUser(ctx, "user@example.com", password, presses, intervals, nil)
+	assert.ErrorIs(t, err, auth.ErrUserExist)
+}
+
+func TestLogin_ReplayedAndSyntheticSamples(t *testing.T) {
+	a, _ := newAuth(t)
+	ctx := context.Background()
+
+	_, err := a.RegisterNewUser(ctx, "user@example.com", password, presses, intervals, nil)
+	require.NoError(t, err)
+
+	_, err = a.Login(ctx, "user@example.com", password, presses, intervals, nil, appID)
+	assert.ErrorIs(t, err, auth.ErrSyntheticSample)
+
+	sample, sampleIntervals := jitter(presses, 0.15), jitter(intervals, 0.15)
+	_, err = a.Login(ctx, "user@example.com", password, sample, sampleIntervals, nil, appID)
+	require.NoError(t, err)
+
+	_, err = a.Login(ctx, "user@example.com", password, sample, sampleIntervals, nil, appID)
+	assert.ErrorIs(t, err, auth.ErrReplayedSample)
+}
+
+func TestLogin_ReplayAcrossModalities(t *testing.T) {
+	a, _ := newAuth(t)
+	ctx := context.Background()
+
+	_, err := a.RegisterNewUser(ctx, "user@example.com", password, nil, nil, keyEvents(presses, intervals))
+	require.NoError(t, err)
+
+	events := keyEvents(jitter(presses, 0.15), jitter(intervals, 0.15))
+	_, err = a.Login(ctx, "user@example.com", password, nil, nil, events, appID)
+	require.NoError(t, err)
+
+	replayedPresses, replayedIntervals := biometrics.FlatTimings(events)
+	_, err = a.Login(ctx, "user@example.com", password, replayedPresses, replayedIntervals, nil, appID)
+	assert.ErrorIs(t, err, auth.ErrReplayedSample, "dropping the key events must not hide a replay")
+
+	_, err = a.Login(ctx, "user@example.com", password, jitter(presses, 0.2), jitter(intervals, 0.2), nil, appID)
+	assert.ErrorIs(t, err, auth.ErrInvalidBiometrics, "a template with key events must not match flat arrays")
+}
+
+func TestLogin_EnrollmentPending(t *testing.T) {
+	a, storage := newAuth(t)
+	ctx := context.Background()
+
+	id, err := a.RegisterNewUser(ctx, "user@example.com", password, presses, intervals, nil)
+	require.NoError(t, err)
+	require.NoError(t, storage.SetEnrollmentPending(ctx, id))
+
+	enrolled, enrolledIntervals := jitter(presses, 0.3), jitter(intervals, 0.3)
+	token, err := a.Login(ctx, "user@example.com", password, enrolled, enrolledIntervals, nil, appID)
+	require.NoError(t, err)
+	assert.Equal(t, auth.ACRReduced, parseToken(t, token).ACR)
+
+	user, err := storage.User(ctx, "user@example.com")
+	require.NoError(t, err)
+	assert.False(t, user.EnrollmentPending)
+	assert.Equal(t, enrolled, user.PressTimes)
+}
+
+func TestLogin_EnrollmentPendingRejectedLogin(t *testing.T) {
+	a, storage := newAuth(t)
+	ctx := context.Background()
+
+	_, adminToken := newAdmin(t, a, storage)
+	app, err := a.CreateApp(ctx, adminToken, models.App{Name: "billing"})
+	require.NoError(t, err)
+	require.NoError(t, a.DisableApp(ctx, adminToken, app.ID))
+
+	id, err := a.RegisterNewUser(ctx, "user@example.com", password, presses, intervals, nil)
+	require.NoError(t, err)
+	require.NoError(t, storage.SetEnrollmentPending(ctx, id))
+
+	_, err = a.Login(ctx, "user@example.com", password, jitter(presses, 0.3), jitter(intervals, 0.3), nil, app.ID)
+	require.ErrorIs(t, err, auth.ErrAppDisabled)
+
+	user, err := storage.User(ctx, "user@example.com")
+	require.NoError(t, err)
+	assert.True(t, user.EnrollmentPending, "a rejected login must not enroll its sample")
+	assert.Equal(t, presses, user.PressTimes)
+}
+
+func TestReenrollBiometrics(t *testing.T) {
+	a, storage := newAuth(t)
+	ctx := context.Background()
+
+	_, err := a.RegisterNewUser(ctx, "user@example.com", password, presses, intervals, nil)
+	require.NoError(t, err)
+	token, err := a.Login(ctx, "user@example.com", password, jitter(presses, 0.1), jitter(intervals, 0.1), nil, appID)
+	require.NoError(t, err)
+
+	events := keyEvents(jitter(presses, -1), jitter(intervals, -1))
+	err = a.ReenrollBiometrics(ctx, token, "wrong password", nil, nil, events)
+	assert.ErrorIs(t, err, auth.ErrInvalidCredentials)
+	user, err := storage.User(ctx, "user@example.com")
+	require.NoError(t, err)
+	assert.Equal(t, presses, user.PressTimes, "a wrong password must keep the template")
+
+	err = a.ReenrollBiometrics(ctx, "not a token", password, nil, nil, events)
+	assert.ErrorIs(t, err, auth.ErrInvalidToken)
+
+	require.NoError(t, a.ReenrollBiometrics(ctx, token, password, nil, nil, events))
+	user, err = storage.User(ctx, "user@example.com")
+	require.NoError(t, err)
+	assert.Equal(t, events, user.KeyEvents)
+
+	// the new template, twice as slow, now decides
+	_, err = a.Login(ctx, "user@example.com", password, nil, nil, keyEvents(jitter(presses, -0.9), jitter(intervals, -0.9)), appID)
+	require.NoError(t, err)
+	_, err = a.Login(ctx, "user@example.com", password, nil, nil, keyEvents(jitter(presses, 0.12), jitter(intervals, 0.12)), appID)
+	assert.ErrorIs(t, err, auth.ErrInvalidBiometrics)
+}
+
+func TestResetBiometrics(t *testing.T) {
+	a, storage := newAuth(t)
+	ctx := context.Background()
+
+	_, adminToken := newAdmin(t, a, storage)
+	userID, err := a.RegisterNewUser(ctx, "user@example.com", password, presses, intervals, nil)
+	require.NoError(t, err)
+	userToken, err := a.Login(ctx, "user@example.com", password, jitter(presses, 0.1), jitter(intervals, 0.1), nil, appID)
+	require.NoError(t, err)
+
+	assert.ErrorIs(t, a.ResetBiometrics(ctx, userToken, userID), auth.ErrPermissionDenied, "only admins may reset biometrics")
+	assert.ErrorIs(t, a.ResetBiometrics(ctx, adminToken, -1), auth.ErrUserNotFound)
+	user, err := storage.User(ctx, "user@example.com")
+	require.NoError(t, err)
+	assert.False(t, user.EnrollmentPending)
+
+	require.NoError(t, a.ResetBiometrics(ctx, adminToken, userID))
+	user, err = storage.User(ctx, "user@example.com")
+	require.NoError(t, err)
+	assert.True(t, user.EnrollmentPending)
+
+	// the next login enrolls its sample, however far from the old template
+	enrolled, enrolledIntervals := jitter(presses, -1), jitter(intervals, -1)
+	token, err := a.Login(ctx, "user@example.com", password, enrolled, enrolledIntervals, nil, appID)
+	require.NoError(t, err)
+	assert.Equal(t, auth.ACRReduced, parseToken(t, token).ACR)
+
+	user, err = storage.User(ctx, "user@example.com")
+	require.NoError(t, err)
+	assert.False(t, user.EnrollmentPending)
+	assert.Equal(t, enrolled, user.PressTimes)
+}
+
+func TestDeleteUser(t *testing.T) {
+	a, _ := newAuth(t)
+	ctx := context.Background()
+
+	id, err := a.RegisterNewUser(ctx, "user@example.com", password, presses, intervals, nil)
+	require.NoError(t, err)
+
+	require.NoError(t, a.DeleteUser(ctx, id))
+	assert.ErrorIs(t, a.DeleteUser(ctx, id), auth.ErrUserNotFound)
+
+	_, err = a.Login(ctx, "user@example.com", password, jitter(presses, 0.1), jitter(intervals, 0.1), nil, appID)
+	assert.ErrorIs(t, err, auth.ErrInvalidCredentials)
+
+	_, err = a.RegisterNewUser(ctx, "user@example.com", password, presses, intervals, nil)
+	assert.NoError(t, err)
+}
+
+func TestDeleteAccount_Immediate(t *testing.T) {
+	a, storage := newAuth(t)
+	ctx := context.Background()
+
+	id, err := a.RegisterNewUser(ctx, "user@example.com", password, presses, intervals, nil)
+	require.NoError(t, err)
+	token, err := a.Login(ctx, "user@example.com", password, jitter(presses, 0.1), jitter(intervals, 0.1), nil, appID)
+	require.NoError(t, err)
+
+	_, err = a.DeleteAccount(ctx, token, "wrong password", 0)
+	assert.ErrorIs(t, err, auth.ErrInvalidCredentials)
+
+	deleteAfter, err := a.DeleteAccount(ctx, token, password, 0)
+	require.NoError(t, err)
+	assert.True(t, deleteAfter.IsZero())
+
+	_, err = a.Login(ctx, "user@example.com", password, jitter(presses, 0.12), jitter(intervals, 0.12), nil, appID)
+	assert.ErrorIs(t, err, auth.ErrInvalidCredentials)
+
+	events, err := storage.AuditEvents(ctx, id)
+	require.NoError(t, err)
+	require.Len(t, events, 1, "audit events must outlive the account")
+	assert.Equal(t, auth.AuditAccountDeleted, events[0].Action)
+	assert.Equal(t, id, events[0].ActorID)
+}
+
+func TestDeleteAccount_GracePeriod(t *testing.T) {
+	a, storage := newAuthWithGrace(t, 24*time.Hour)
+	ctx := context.Background()
+
+	id, err := a.RegisterNewUser(ctx, "user@example.com", password, presses, intervals, nil)
+	require.NoError(t, err)
+	token, err := a.Login(ctx, "user@example.com", password, jitter(presses, 0.1), jitter(intervals, 0.1), nil, appID)
+	require.NoError(t, err)
+
+	deleteAfter, err := a.DeleteAccount(ctx, token, password, 0)
+	require.NoError(t, err)
+	assert.WithinDuration(t, time.Now().Add(24*time.Hour), deleteAfter, time.Minute)
+
+	_, err = a.ExportMyData(ctx, token)
+	assert.ErrorIs(t, err, auth.ErrInvalidToken, "sessions must be revoked")
+
+	// logging in during the grace period cancels the deletion
+	token, err = a.Login(ctx, "user@example.com", password, jitter(presses, 0.12), jitter(intervals, 0.12), nil, appID)
+	require.NoError(t, err)
+
+	purged, err := a.PurgeDeletedAccounts(ctx, deleteAfter.Add(time.Hour))
+	require.NoError(t, err)
+	assert.Zero(t, purged)
+
+	_, err = a.DeleteAccount(ctx, token, password, 0)
+	require.NoError(t, err)
+
+	purged, err = a.PurgeDeletedAccounts(ctx, time.Now())
+	require.NoError(t, err)
+	assert.Zero(t, purged, "account must be kept during the grace period")
+
+	purged, err = a.PurgeDeletedAccounts(ctx, time.Now().Add(25*time.Hour))
+	require.NoError(t, err)
+	assert.Equal(t, 1, purged)
+
+	_, err = storage.User(ctx, "user@example.com")
+	assert.Error(t, err)
+
+	events, err := storage.AuditEvents(ctx, id)
+	require.NoError(t, err)
+	actions := make([]string, 0, len(events))
+	for _, e := range events {
+		actions = append(actions, e.Action)
+	}
+	assert.Equal(t, []string{
+		auth.AuditAccountDeletionScheduled,
+		auth.AuditAccountDeletionCancelled,
+		auth.AuditAccountDeletionScheduled,
+		auth.AuditAccountDeleted,
+	}, actions)
+	assert.EqualValues(t, auth.SystemActorID, events[3].ActorID)
+}
+
+func TestDeleteAccount_Admin(t *testing.T) {
+	a, storage := newAuth(t)
+	ctx := context.Background()
+
+	adminID, err := a.RegisterNewUser(ctx, "admin@example.com", password, presses, intervals, nil)
+	require.NoError(t, err)
+	userID, err := a.RegisterNewUser(ctx, "user@example.com", password, presses, intervals, nil)
+	require.NoError(t, err)
+
+	token, err := a.Login(ctx, "admin@example.com", password, jitter(presses, 0.1), jitter(intervals, 0.1), nil, appID)
+	require.NoError(t, err)
+
+	_, err = a.DeleteAccount(ctx, token, "", userID)
+	assert.ErrorIs(t, err, auth.ErrPermissionDenied)
+
+	require.NoError(t, storage.SetAdmin(ctx, adminID, true))
+
+	app, err := a.CreateApp(ctx, token, models.App{Name: "billing"})
+	require.NoError(t, err)
+	otherToken, err := a.Login(ctx, "admin@example.com", password, jitter(presses, 0.12), jitter(intervals, 0.12), nil, app.ID)
+	require.NoError(t, err)
+	_, err = a.DeleteAccount(ctx, otherToken, "", userID)
+	assert.ErrorIs(t, err, auth.ErrPermissionDenied, "tokens of relying apps must not delete other accounts")
+	_, err = storage.UserByID(ctx, userID)
+	require.NoError(t, err)
+
+	_, err = a.DeleteAccount(ctx, token, "", userID)
+	require.NoError(t, err)
+
+	_, err = a.DeleteAccount(ctx, token, "", userID)
+	assert.ErrorIs(t, err, auth.ErrUserNotFound)
+
+	events, err := storage.AuditEvents(ctx, userID)
+	require.NoError(t, err)
+	require.Len(t, events, 1)
+	assert.Equal(t, adminID, events[0].ActorID)
+}
+
+func TestExportMyData(t *testing.T) {
+	a, storage := newAuth(t)
+	ctx := auth.ContextWithClient(context.Background(), auth.ClientInfo{IP: "203.0.113.7", Device: "laptop"})
+
+	id, err := a.RegisterNewUser(ctx, "user@example.com", password, presses, intervals, nil)
+	require.NoError(t, err)
+	_, err = a.Login(ctx, "user@example.com", "wrong password", jitter(presses, 0.1), jitter(intervals, 0.1), nil, appID)
+	require.ErrorIs(t, err, auth.ErrInvalidCredentials)
+	token, err := a.Login(ctx, "user@example.com", password, jitter(presses, 0.1), jitter(intervals, 0.1), nil, appID)
+	require.NoError(t, err)
+
+	export, err := a.ExportMyData(ctx, token)
+	require.NoError(t, err)
+
+	assert.Equal(t, id, export.Profile.ID)
+	assert.Equal(t, "user@example.com", export.Profile.Email)
+	assert.True(t, export.Biometrics.Enrolled)
+	assert.Equal(t, len(presses), export.Biometrics.PressTimes)
+	assert.Len(t, export.Sessions, 1)
+	require.Len(t, export.LoginHistory, 2)
+	assert.True(t, export.LoginHistory[0].Success)
+	assert.Equal(t, "laptop", export.LoginHistory[0].Device)
+	assert.NotNil(t, export.Consents)
+
+	events, err := storage.AuditEvents(ctx, id)
+	require.NoError(t, err)
+	require.Len(t, events, 1)
+	assert.Equal(t, auth.AuditDataExported, events[0].Action)
+	assert.Equal(t, "203.0.113.7", events[0].IP)
+}
+
+func TestUpdateProfile(t *testing.T) {
+	a, _ := newAuth(t)
+	ctx := context.Background()
+
+	id, err := a.RegisterNewUser(ctx, "user@example.com", password, presses, intervals, nil)
+	require.NoError(t, err)
+	token, err := a.Login(ctx, "user@example.com", password, jitter(presses, 0.1), jitter(intervals, 0.1), nil, appID)
+	require.NoError(t, err)
+
+	user, err := a.GetProfile(ctx, token)
+	require.NoError(t, err)
+	assert.Equal(t, id, user.ID)
+	assert.Empty(t, user.DisplayName)
+
+	name, locale, tz := "Ada", "en-GB", "Europe/London"
+	_, err = a.UpdateProfile(ctx, token, auth.ProfileUpdate{DisplayName: &name, Locale: &locale, Timezone: &tz})
+	require.NoError(t, err)
+
+	// fields missing from the update are kept
+	name = "Ada L."
+	user, err = a.UpdateProfile(ctx, token, auth.ProfileUpdate{DisplayName: &name})
+	require.NoError(t, err)
+	assert.Equal(t, models.Profile{DisplayName: "Ada L.", Locale: "en-GB", Timezone: "Europe/London"}, user.Profile)
+
+	user, err = a.GetProfile(ctx, token)
+	require.NoError(t, err)
+	assert.Equal(t, "Ada L.", user.DisplayName)
+	assert.Equal(t, "en-GB", user.Locale)
+
+	_, err = a.GetProfile(ctx, "not a token")
+	assert.ErrorIs(t, err, auth.ErrInvalidToken)
+}
+
+func TestChangePassword(t *testing.T) {
+	a, storage := newAuth(t)
+	ctx := context.Background()
+
+	id, err := a.RegisterNewUser(ctx, "user@example.com", password, presses, intervals, nil)
+	require.NoError(t, err)
+	token, err := a.Login(ctx, "user@example.com", password, jitter(presses, 0.1), jitter(intervals, 0.1), nil, appID)
+	require.NoError(t, err)
+	other, err := a.Login(ctx, "user@example.com", password, jitter(presses, 0.12), jitter(intervals, 0.12), nil, appID)
+	require.NoError(t, err)
+
+	const newPassword = "battery staple"
+	newPresses, newIntervals := jitter(presses, -0.2), jitter(intervals, -0.2)
+
+	err = a.ChangePassword(ctx, token, "wrong password", newPassword, newPresses, newIntervals, nil)
+	assert.ErrorIs(t, err, auth.ErrInvalidCredentials)
+
+	require.NoError(t, a.ChangePassword(ctx, token, password, newPassword, newPresses, newIntervals, nil))
+
+	_, err = a.GetProfile(ctx, other)
+	assert.ErrorIs(t, err, auth.ErrInvalidToken, "other sessions must be revoked")
+	_, err = a.GetProfile(ctx, token)
+	assert.NoError(t, err, "the current session must be kept")
+
+	_, err = a.Login(ctx, "user@example.com", password, jitter(presses, 0.1), jitter(intervals, 0.1), nil, appID)
+	assert.ErrorIs(t, err, auth.ErrInvalidCredentials)
+	_, err = a.Login(ctx, "user@example.com", newPassword, jitter(newPresses, 0.1), jitter(newIntervals, 0.1), nil, appID)
+	assert.NoError(t, err)
+
+	events, err := storage.AuditEvents(ctx, id)
+	require.NoError(t, err)
+	require.Len(t, events, 1)
+	assert.Equal(t, auth.AuditPasswordChanged, events[0].Action)
+}
+
+func TestChangeEmail(t *testing.T) {
+	sent := mailbox{}
+	a, _ := newTestAuth(t, 0, sent)
+	ctx := context.Background()
+
+	_, err := a.RegisterNewUser(ctx, "user@example.com", password, presses, intervals, nil)
+	require.NoError(t, err)
+	_, err = a.RegisterNewUser(ctx, "taken@example.com", password, presses, intervals, nil)
+	require.NoError(t, err)
+	token, err := a.Login(ctx, "user@example.com", password, jitter(presses, 0.1), jitter(intervals, 0.1), nil, appID)
+	require.NoError(t, err)
+
+	_, err = a.ChangeEmail(ctx, token, password, "taken@example.com")
+	assert.ErrorIs(t, err, auth.ErrUserExist)
+	_, err = a.ChangeEmail(ctx, token, "wrong password", "new@example.com")
+	assert.ErrorIs(t, err, auth.ErrInvalidCredentials)
+	err = a.ConfirmEmailChange(ctx, token, "code")
+	assert.ErrorIs(t, err, auth.ErrNoEmailChange)
+
+	expiresAt, err := a.ChangeEmail(ctx, token, password, "new@example.com")
+	require.NoError(t, err)
+	assert.True(t, expiresAt.After(time.Now()))
+	require.NotEmpty(t, sent["new@example.com"])
+
+	user, err := a.GetProfile(ctx, token)
+	require.NoError(t, err)
+	assert.Equal(t, "user@example.com", user.Email, "the address must not change before it is confirmed")
+	require.NotNil(t, user.EmailChange)
+	assert.Equal(t, "new@example.com", user.EmailChange.Email)
+
+	err = a.ConfirmEmailChange(ctx, token, "wrong code")
+	assert.ErrorIs(t, err, auth.ErrInvalidCode)
+
+	require.NoError(t, a.ConfirmEmailChange(ctx, token, sent["new@example.com"]))
+
+	// the token still carries the old address
+	user, err = a.GetProfile(ctx, token)
+	require.NoError(t, err)
+	assert.Equal(t, "new@example.com", user.Email)
+	assert.Nil(t, user.EmailChange)
+
+	_, err = a.Login(ctx, "user@example.com", password, jitter(presses, 0.1), jitter(intervals, 0.1), nil, appID)
+	assert.ErrorIs(t, err, auth.ErrInvalidCredentials)
+	_, err = a.Login(ctx, "new@example.com", password, jitter(presses, 0.12), jitter(intervals, 0.12), nil, appID)
+	assert.NoError(t, err)
+}
+
+// newAdmin registers an admin and returns the admin's ID and token.
+func newAdmin(t *testing.T, a *auth.Auth, storage *memory.Storage) (int64, string) {
+	t.Helper()
+	ctx := context.Background()
+
+	id, err := a.RegisterNewUser(ctx, "admin@example.com", password, presses, intervals, nil)
+	require.NoError(t, err)
+	require.NoError(t, storage.SetAdmin(ctx, id, true))
+	token, err := a.Login(ctx, "admin@example.com", password, jitter(presses, 0.1), jitter(intervals, 0.1), nil, appID)
+	require.NoError(t, err)
+	return id, token
+}
+
+func TestAdmin_RequiresAdminAppToken(t *testing.T) {
+	a, storage := newAuth(t)
+	ctx := context.Background()
+
+	_, adminToken := newAdmin(t, a, storage)
+	app, err := a.CreateApp(ctx, adminToken, models.App{Name: "billing"})
+	require.NoError(t, err)
+
+	otherToken, err := a.Login(ctx, "admin@example.com", password, jitter(presses, 0.12), jitter(intervals, 0.12), nil, app.ID)
+	require.NoError(t, err)
+
+	_, _, err = a.ListUsers(ctx, otherToken, models.UserFilter{}, "")
+	assert.ErrorIs(t, err, auth.ErrPermissionDenied, "tokens of relying apps must not authorize admin actions")
+	_, _, err = a.ListUsers(ctx, adminToken, models.UserFilter{}, "")
+	assert.NoError(t, err)
+}
+
+func TestAdmin_ListUsers(t *testing.T) {
+	a, storage := newAuth(t)
+	ctx := context.Background()
+
+	adminID, token := newAdmin(t, a, storage)
+	var ids []int64
+	for _, email := range []string{"a@example.com", "b@example.com", "c@example.com"} {
+		id, err := a.RegisterNewUser(ctx, email, password, presses, intervals, nil)
+		require.NoError(t, err)
+		ids = append(ids, id)
+	}
+
+	userToken, err := a.Login(ctx, "a@example.com", password, jitter(presses, 0.1), jitter(intervals, 0.1), nil, appID)
+	require.NoError(t, err)
+	_, _, err = a.ListUsers(ctx, userToken, models.UserFilter{}, "")
+	assert.ErrorIs(t, err, auth.ErrPermissionDenied)
+
+	var listed []int64
+	pageToken := ""
+	for pages := 0; ; pages++ {
+		require.Less(t, pages, 3)
+		users, next, err := a.ListUsers(ctx, token, models.UserFilter{Limit: 3}, pageToken)
+		require.NoError(t, err)
+		for _, user := range users {
+			listed = append(listed, user.ID)
+		}
+		if next == "" {
+			break
+		}
+		pageToken = next
+	}
+	assert.Equal(t, append([]int64{adminID}, ids...), listed)
+
+	isAdmin := true
+	users, next, err := a.ListUsers(ctx, token, models.UserFilter{IsAdmin: &isAdmin}, "")
+	require.NoError(t, err)
+	assert.Empty(t, next)
+	require.Len(t, users, 1)
+	assert.Equal(t, adminID, users[0].ID)
+
+	_, _, err = a.ListUsers(ctx, token, models.UserFilter{}, "not a page token")
+	assert.ErrorIs(t, err, auth.ErrInvalidPageToken)
+}
+
+func TestAdmin_DisableUser(t *testing.T) {
+	a, storage := newAuth(t)
+	ctx := context.Background()
+
+	adminID, token := newAdmin(t, a, storage)
+	id, err := a.RegisterNewUser(ctx, "user@example.com", password, presses, intervals, nil)
+	require.NoError(t, err)
+	userToken, err := a.Login(ctx, "user@example.com", password, jitter(presses, 0.1), jitter(intervals, 0.1), nil, appID)
+	require.NoError(t, err)
+
+	assert.ErrorIs(t, a.DisableUser(ctx, token, adminID), auth.ErrSelfAdminAction)
+	assert.ErrorIs(t, a.DisableUser(ctx, token, -1), auth.ErrUserNotFound)
+	require.NoError(t, a.DisableUser(ctx, token, id))
+
+	_, err = a.GetProfile(ctx, userToken)
+	assert.ErrorIs(t, err, auth.ErrInvalidToken, "sessions must be revoked")
+	_, err = a.Login(ctx, "user@example.com", password, jitter(presses, 0.12), jitter(intervals, 0.12), nil, appID)
+	assert.ErrorIs(t, err, auth.ErrUserDisabled)
+	_, err = a.Login(ctx, "user@example.com", "wrong password", jitter(presses, 0.13), jitter(intervals, 0.13), nil, appID)
+	assert.ErrorIs(t, err, auth.ErrInvalidCredentials, "disabled accounts must not be revealed without the password")
+
+	user, err := a.GetUser(ctx, token, id)
+	require.NoError(t, err)
+	assert.NotNil(t, user.DisabledAt)
+
+	require.NoError(t, a.EnableUser(ctx, token, id))
+	_, err = a.Login(ctx, "user@example.com", password, jitter(presses, 0.14), jitter(intervals, 0.14), nil, appID)
+	assert.NoError(t, err)
+
+	events, err := storage.AuditEvents(ctx, id)
+	require.NoError(t, err)
+	require.Len(t, events, 2)
+	assert.Equal(t, auth.AuditUserDisabled, events[0].Action)
+	assert.Equal(t, auth.AuditUserEnabled, events[1].Action)
+	assert.Equal(t, adminID, events[0].ActorID)
+}
+
+func TestAdmin_SetAdminAndForceLogout(t *testing.T) {
+	a, storage := newAuth(t)
+	ctx := context.Background()
+
+	adminID, token := newAdmin(t, a, storage)
+	id, err := a.RegisterNewUser(ctx, "user@example.com", password, presses, intervals, nil)
+	require.NoError(t, err)
+	userToken, err := a.Login(ctx, "user@example.com", password, jitter(presses, 0.1), jitter(intervals, 0.1), nil, appID)
+	require.NoError(t, err)
+
+	assert.ErrorIs(t, a.SetAdmin(ctx, token, adminID, false), auth.ErrSelfAdminAction)
+	require.NoError(t, a.SetAdmin(ctx, token, id, true))
+
+	user, err := a.GetUser(ctx, userToken, adminID)
+	require.NoError(t, err, "new admins must be able to use the admin API")
+	assert.True(t, user.IsAdmin)
+
+	require.NoError(t, a.ForceLogout(ctx, token, id))
+	_, err = a.GetProfile(ctx, userToken)
+	assert.ErrorIs(t, err, auth.ErrInvalidToken)
+	_, err = a.Login(ctx, "user@example.com", password, jitter(presses, 0.12), jitter(intervals, 0.12), nil, appID)
+	assert.NoError(t, err, "forced logout must not disable the user")
+
+	assert.ErrorIs(t, a.ForceLogout(ctx, token, -1), auth.ErrUserNotFound)
+}
+
+func TestApps_CreateAndRotateSecret(t *testing.T) {
+	a, storage := newAuth(t)
+	ctx := context.Background()
+
+	_, adminToken := newAdmin(t, a, storage)
+	_, err := a.RegisterNewUser(ctx, "user@example.com", password, presses, intervals, nil)
+	require.NoError(t, err)
+
+	userToken, err := a.Login(ctx, "user@example.com", password, jitter(presses, 0.1), jitter(intervals, 0.1), nil, appID)
+	require.NoError(t, err)
+	_, err = a.CreateApp(ctx, userToken, models.App{Name: "forbidden"})
+	assert.ErrorIs(t, err, auth.ErrPermissionDenied)
+
+	app, err := a.CreateApp(ctx, adminToken, models.App{Name: "billing", ContinuousAuthThreshold: 0.4, Sensitivity: models.SensitivityHigh})
+	require.NoError(t, err)
+	assert.NotEqual(t, appID, app.ID)
+	assert.Len(t, app.Secret, 43, "secret must be 32 random bytes")
+	_, err = a.CreateApp(ctx, adminToken, models.App{Name: "billing"})
+	assert.ErrorIs(t, err, auth.ErrAppExists)
+
+	apps, err := a.ListApps(ctx, adminToken)
+	require.NoError(t, err)
+	require.Len(t, apps, 2)
+	assert.Equal(t, "billing", apps[1].Name)
+	assert.Empty(t, apps[1].Secret, "secrets must not be listed")
+
+	token, err := a.Login(ctx, "user@example.com", password, jitter(presses, 0.11), jitter(intervals, 0.11), nil, app.ID)
+	require.NoError(t, err)
+	parseTokenFor(t, token, app) // signed with the generated secret
+
+	secret, expiresAt, err := a.RotateSecret(ctx, adminToken, app.ID, time.Hour)
+	require.NoError(t, err)
+	assert.NotEqual(t, app.Secret, secret)
+	assert.WithinDuration(t, time.Now().Add(time.Hour), expiresAt, time.Minute)
+	_, err = a.GetProfile(ctx, token)
+	require.NoError(t, err, "tokens signed with the previous secret must be accepted during the grace period")
+
+	_, _, err = a.RotateSecret(ctx, adminToken, app.ID, 0)
+	require.NoError(t, err)
+	_, err = a.GetProfile(ctx, token)
+	assert.ErrorIs(t, err, auth.ErrInvalidToken, "rotation without grace must reject old tokens")
+
+	_, _, err = a.RotateSecret(ctx, adminToken, 999, time.Hour)
+	assert.ErrorIs(t, err, auth.ErrInvalidAppID)
+}
+
+func TestApps_UpdateDisableAndDelete(t *testing.T) {
+	a, storage := newAuth(t)
+	ctx := context.Background()
+
+	_, adminToken := newAdmin(t, a, storage)
+	_, err := a.RegisterNewUser(ctx, "user@example.com", password, presses, intervals, nil)
+	require.NoError(t, err)
+
+	app, err := a.CreateApp(ctx, adminToken, models.App{Name: "billing"})
+	require.NoError(t, err)
+
+	name := "test"
+	_, err = a.UpdateApp(ctx, adminToken, app.ID, auth.AppUpdate{Name: &name})
+	assert.ErrorIs(t, err, auth.ErrAppExists)
+	name, sensitivity := "payments", models.SensitivityMedium
+	updated, err := a.UpdateApp(ctx, adminToken, app.ID, auth.AppUpdate{Name: &name, Sensitivity: &sensitivity})
+	require.NoError(t, err)
+	assert.Equal(t, "payments", updated.Name)
+	assert.Equal(t, models.SensitivityMedium, updated.Sensitivity)
+	assert.Empty(t, updated.Secret)
+
+	token, err := a.Login(ctx, "user@example.com", password, jitter(presses, 0.1), jitter(intervals, 0.1), nil, app.ID)
+	require.NoError(t, err)
+
+	require.NoError(t, a.DisableApp(ctx, adminToken, app.ID))
+	_, err = a.GetProfile(ctx, token)
+	assert.ErrorIs(t, err, auth.ErrInvalidToken, "tokens of disabled apps must be rejected")
+	_, err = a.Login(ctx, "user@example.com", password, jitter(presses, 0.11), jitter(intervals, 0.11), nil, app.ID)
+	assert.ErrorIs(t, err, auth.ErrAppDisabled)
+
+	require.NoError(t, a.EnableApp(ctx, adminToken, app.ID))
+	_, err = a.GetProfile(ctx, token)
+	require.NoError(t, err)
+
+	require.NoError(t, a.DeleteApp(ctx, adminToken, app.ID))
+	_, err = a.GetProfile(ctx, token)
+	assert.ErrorIs(t, err, auth.ErrInvalidToken)
+	assert.ErrorIs(t, a.DeleteApp(ctx, adminToken, app.ID), auth.ErrInvalidAppID)
+}
+
+func TestLogin_AppTokenSettings(t *testing.T) {
+	a, storage := newAuth(t)
+	ctx := context.Background()
+
+	_, adminToken := newAdmin(t, a, storage)
+	_, err := a.RegisterNewUser(ctx, "user@example.com", password, presses, intervals, nil)
+	require.NoError(t, err)
+
+	app, err := a.CreateApp(ctx, adminToken, models.App{
+		Name:           "console",
+		TokenTTL:       15 * time.Minute,
+		Audience:       "console",
+		Issuer:         "sso-console",
+		ClaimsTemplate: []string{models.ClaimDisplayName, models.ClaimIsAdmin},
+	})
+	require.NoError(t, err)
+
+	rawClaims := func(token string, secret string) jwtlib.MapClaims {
+		t.Helper()
+		claims := jwtlib.MapClaims{}
+		_, err := jwtlib.ParseWithClaims(token, claims, func(*jwtlib.Token) (interface{}, error) { return []byte(secret), nil })
+		require.NoError(t, err)
+		return claims
+	}
+
+	token, err := a.Login(ctx, "user@example.com", password, jitter(presses, 0.1), jitter(intervals, 0.1), nil, app.ID)
+	require.NoError(t, err)
+	claims := rawClaims(token, app.Secret)
+	aud, err := claims.GetAudience()
+	require.NoError(t, err)
+	assert.Equal(t, jwtlib.ClaimStrings{"console"}, aud)
+	assert.Equal(t, "sso-console", claims["iss"])
+	assert.Equal(t, false, claims["admin"])
+	assert.Contains(t, claims, "name")
+	assert.NotContains(t, claims, "email", "attributes outside the template must be left out")
+	assert.NotContains(t, claims, "times")
+
+	exp, err := claims.GetExpirationTime()
+	require.NoError(t, err)
+	assert.WithinDuration(t, time.Now().Add(15*time.Minute), exp.Time, time.Minute)
+	session, err := storage.Session(ctx, parseTokenFor(t, token, app).SessionID)
+	require.NoError(t, err)
+	assert.WithinDuration(t, time.Now().Add(15*time.Minute), session.ExpiresAt, time.Minute, "sessions must live as long as the tokens of the app")
+
+	_, err = a.GetProfile(ctx, token)
+	require.NoError(t, err, "tokens without the email claim must be accepted")
+
+	token, err = a.Login(ctx, "user@example.com", password, jitter(presses, 0.11), jitter(intervals, 0.11), nil, appID)
+	require.NoError(t, err)
+	claims = rawClaims(token, appSecret)
+	assert.Equal(t, issuer, claims["iss"], "apps without an issuer must use the one of the service")
+	aud, err = claims.GetAudience()
+	require.NoError(t, err)
+	assert.Equal(t, jwtlib.ClaimStrings{"1"}, aud, "apps without an audience must use their ID")
+	assert.Equal(t, "user@example.com", claims["email"])
+	assert.NotContains(t, claims, "times", "keystrokes must only be mapped by an explicit template")
+	exp, err = claims.GetExpirationTime()
+	require.NoError(t, err)
+	assert.WithinDuration(t, time.Now().Add(tokenTTL), exp.Time, time.Minute)
+}
+
+func TestParseToken_RegisteredClaims(t *testing.T) {
+	a, _ := newAuth(t)
+	ctx := context.Background()
+
+	id, err := a.RegisterNewUser(ctx, "user@example.com", password, presses, intervals, nil)
+	require.NoError(t, err)
+	token, err := a.Login(ctx, "user@example.com", password, jitter(presses, 0.1), jitter(intervals, 0.1), nil, appID)
+	require.NoError(t, err)
+
+	claims := parseToken(t, token)
+	assert.Equal(t, issuer, claims.Issuer)
+	assert.Equal(t, jwtlib.ClaimStrings{"1"}, claims.Audience)
+	assert.Equal(t, "1", claims.Subject)
+	assert.NotEmpty(t, claims.ID)
+	require.NotNil(t, claims.IssuedAt)
+	require.NotNil(t, claims.NotBefore)
+	assert.Equal(t, id, claims.UserID)
+
+	sign := func(claims jwt.Claims) string {
+		t.Helper()
+		token, err := jwtlib.NewWithClaims(jwtlib.SigningMethodHS256, claims).SignedString([]byte(appSecret))
+		require.NoError(t, err)
+		return token
+	}
+	forged := map[string]func(c *jwt.Claims){
+		"other issuer":   func(c *jwt.Claims) { c.Issuer = "evil" },
+		"other audience": func(c *jwt.Claims) { c.Audience = jwtlib.ClaimStrings{"2"} },
+		"other subject":  func(c *jwt.Claims) { c.Subject = "999" },
+		"no jti":         func(c *jwt.Claims) { c.ID = "" },
+		"no iat":         func(c *jwt.Claims) { c.IssuedAt = nil },
+		"no nbf":         func(c *jwt.Claims) { c.NotBefore = nil },
+		"no sid":         func(c *jwt.Claims) { c.SessionID = "" },
+		"not yet valid":  func(c *jwt.Claims) { c.NotBefore = jwtlib.NewNumericDate(time.Now().Add(time.Hour)) },
+		"expired":        func(c *jwt.Claims) { c.ExpiresAt = jwtlib.NewNumericDate(time.Now().Add(-time.Minute)) },
+	}
+	for name, forge := range forged {
+		t.Run(name, func(t *testing.T) {
+			c := claims
+			forge(&c)
+			_, err := a.GetProfile(ctx, sign(c))
+			assert.ErrorIs(t, err, auth.ErrInvalidToken)
+		})
+	}
+
+	_, err = a.GetProfile(ctx, sign(claims))
+	require.NoError(t, err, "re-signing unchanged claims must keep the token valid")
+}
+
+func TestParseToken_ForeignSession(t *testing.T) {
+	a, storage := newAuth(t)
+	ctx := context.Background()
+	other := models.App{ID: 2, Name: "other", Secret: "other-secret"}
+	require.NoError(t, storage.SaveApp(ctx, other))
+
+	victim, err := a.RegisterNewUser(ctx, "victim@example.com", password, presses, intervals, nil)
+	require.NoError(t, err)
+	_, err = a.RegisterNewUser(ctx, "user@example.com", password, presses, intervals, nil)
+	require.NoError(t, err)
+
+	victimToken, err := a.Login(ctx, "victim@example.com", password, jitter(presses, 0.1), jitter(intervals, 0.1), nil, appID)
+	require.NoError(t, err)
+	victimOtherToken, err := a.Login(ctx, "victim@example.com", password, jitter(presses, 0.12), jitter(intervals, 0.12), nil, other.ID)
+	require.NoError(t, err)
+	userToken, err := a.Login(ctx, "user@example.com", password, jitter(presses, 0.1), jitter(intervals, 0.1), nil, other.ID)
+	require.NoError(t, err)
+
+	sign := func(claims jwt.Claims, secret string) string {
+		t.Helper()
+		token, err := jwtlib.NewWithClaims(jwtlib.SigningMethodHS256, claims).SignedString([]byte(secret))
+		require.NoError(t, err)
+		return token
+	}
+
+	// the other app signs a token for the victim with the live session of
+	// one of its own users
+	claims := parseTokenFor(t, userToken, other)
+	claims.UserID, claims.Subject = victim, strconv.FormatInt(victim, 10)
+	_, err = a.GetProfile(ctx, sign(claims, other.Secret))
+	assert.ErrorIs(t, err, auth.ErrInvalidToken, "session of another user")
+
+	// the other app signs a token with the victim's session of the first app
+	claims = parseTokenFor(t, victimOtherToken, other)
+	claims.SessionID = parseToken(t, victimToken).SessionID
+	_, err = a.GetProfile(ctx, sign(claims, other.Secret))
+	assert.ErrorIs(t, err, auth.ErrInvalidToken, "session of another app")
+
+	_, err = a.GetProfile(ctx, victimOtherToken)
+	require.NoError(t, err, "the victim's own tokens stay valid")
+}
+
+// typedAt returns key events of the password typed with the given timings,
+// starting at offset milliseconds, as a continuous auth client reports them.
+func typedAt(offset float64, pressTimes, intervalTimes []float32) []models.KeyEvent {
+	events := keyEvents(pressTimes, intervalTimes)
+	for i := range events {
+		events[i].PressedAt += offset
+		events[i].ReleasedAt += offset
+	}
+	return events
+}
+
+func TestContinuousAuth_RevokesSession(t *testing.T) {
+	a, _ := newAuth(t)
+	ctx := context.Background()
+
+	_, err := a.RegisterNewUser(ctx, "user@example.com", password, nil, nil, keyEvents(presses, intervals))
+	require.NoError(t, err)
+	token, err := a.Login(ctx, "user@example.com", password, nil, nil, keyEvents(jitter(presses, 0.1), jitter(intervals, 0.1)), appID)
+	require.NoError(t, err)
+
+	session, err := a.StartContinuousAuth(ctx, token)
+	require.NoError(t, err)
+
+	update, err := session.Observe(ctx, typedAt(10000, jitter(presses, 0.05), jitter(intervals, 0.05)))
+	require.NoError(t, err)
+	assert.False(t, update.Revoked)
+	assert.Greater(t, update.Score, 0.9)
+	assert.InDelta(t, 1-update.Score, update.Risk, 1e-9)
+
+	// someone typing three times slower takes over the keyboard
+	offset := 20000.0
+	for i := 0; i < 10 && !update.Revoked; i++ {
+		update, err = session.Observe(ctx, typedAt(offset, jitter(presses, -2), jitter(intervals, -2)))
+		require.NoError(t, err)
+		offset += 10000
+	}
+	require.True(t, update.Revoked, "the session must be revoked once the score drops below the app threshold")
+	assert.Less(t, update.Score, 0.5)
+
+	_, err = a.GetProfile(ctx, token)
+	assert.ErrorIs(t, err, auth.ErrInvalidToken, "a revoked session must not authorize requests")
+
+	update, err = session.Observe(ctx, typedAt(offset, presses, intervals))
+	require.NoError(t, err)
+	assert.True(t, update.Revoked, "a revoked session stays revoked")
+	assert.Equal(t, 1.0, update.Risk)
+
+	_, err = a.StartContinuousAuth(ctx, token)
+	assert.ErrorIs(t, err, auth.ErrInvalidToken, "continuous auth must not start on a revoked session")
+}
+
+func TestContinuousAuth_TooFewFeatures(t *testing.T) {
+	a, _ := newAuth(t)
+	ctx := context.Background()
+
+	_, err := a.RegisterNewUser(ctx, "user@example.com", password, nil, nil, keyEvents(presses, intervals))
+	require.NoError(t, err)
+	token, err := a.Login(ctx, "user@example.com", password, nil, nil, keyEvents(jitter(presses, 0.1), jitter(intervals, 0.1)), appID)
+	require.NoError(t, err)
+
+	session, err := a.StartContinuousAuth(ctx, token)
+	require.NoError(t, err)
+
+	// keys the password does not contain say nothing about the user
+	update, err := session.Observe(ctx, []models.KeyEvent{{Key: "z", PressedAt: 10000, ReleasedAt: 10300}, {Key: "q", PressedAt: 11000, ReleasedAt: 11300}})
+	require.NoError(t, err)
+	assert.False(t, update.Revoked)
+	assert.Equal(t, 1.0, update.Score)
+
+	_, err = a.GetProfile(ctx, token)
+	assert.NoError(t, err)
+}
+
+func TestContinuousAuth_NoKeystrokeProfile(t *testing.T) {
+	a, _ := newAuth(t)
+	ctx := context.Background()
+
+	_, err := a.RegisterNewUser(ctx, "user@example.com", password, presses, intervals, nil)
+	require.NoError(t, err)
+	token, err := a.Login(ctx, "user@example.com", password, jitter(presses, 0.1), jitter(intervals, 0.1), nil, appID)
+	require.NoError(t, err)
+
+	_, err = a.StartContinuousAuth(ctx, token)
+	assert.ErrorIs(t, err, auth.ErrNoKeystrokeProfile)
+
+	_, err = a.StartContinuousAuth(ctx, "not a token")
+	assert.ErrorIs(t, err, auth.ErrInvalidToken)
+}
@@ -3,48 +3,38 @@ package auth
 import (
 	"context"
 	"fmt"
-	"math"
 	"sso/internal/domain/models"
+	"sso/internal/lib/biometrics"
 )
 
-var (
-	lowerThreshold = 0.5
-	upperThreshold = 1.5
-)
-
-func (a *Auth) checkBiometrics(ctx context.Context, user models.User, inputPressTimes, inputIntervalTimes []float32) (bool, error) {
+func (a *Auth) checkBiometrics(ctx context.Context, user models.User, sample biometrics.Sample) (bool, error) {
 	const op = "auth.checkBiometrics"
-	pressTimes := user.PressTimes
-	intervalTimes := user.PressIntervals
-	pressIncrement := 0
-	intervalIncrement := 0
-	for i := 0; i < len(inputPressTimes); i++ {
-		inputPressTime := inputPressTimes[i]
-		if !checkDifference(float64(inputPressTime), float64(pressTimes[i]), lowerThreshold, upperThreshold) {
-			pressIncrement++
-		}
-	}
 
-	for i := 0; i < len(inputIntervalTimes); i++ {
-		inputIntervalTime := inputIntervalTimes[i]
-		if !checkDifference(float64(inputIntervalTime), float64(intervalTimes[i]), lowerThreshold, upperThreshold) {
-			intervalIncrement++
-		}
+	ok, err := a.matcher.Match(templateOf(user), sample)
+	if err != nil {
+		return false, fmt.Errorf("%s: %w", op, err)
 	}
+	return ok, nil
+}
 
-	if pressIncrement < len(pressTimes)/2 {
-		return false, fmt.Errorf("%s: %w", op, ErrPressTimesInvalid)
+// newSample builds a sample from the request. Clients that only send key
+// events get the index-aligned arrays derived from them.
+func newSample(pressTimes, intervalTimes []float32, keyEvents []models.KeyEvent) biometrics.Sample {
+	if len(pressTimes) == 0 && len(intervalTimes) == 0 && len(keyEvents) > 0 {
+		pressTimes, intervalTimes = biometrics.FlatTimings(keyEvents)
 	}
 
-	if intervalIncrement < len(intervalTimes)/2 {
-		return false, fmt.Errorf("%s: %w", op, ErrIntervalTimesInvalid)
+	return biometrics.Sample{
+		PressTimes:    pressTimes,
+		IntervalTimes: intervalTimes,
+		KeyEvents:     keyEvents,
 	}
-	return true, nil
-
 }
 
-func checkDifference(input, needed, lowerThreshold, upperThreshold float64) bool {
-
-	x := math.Abs(input - needed)
-	return x > lowerThreshold && x < upperThreshold
+func templateOf(user models.User) biometrics.Sample {
+	return biometrics.Sample{
+		PressTimes:    user.PressTimes,
+		IntervalTimes: user.PressIntervals,
+		KeyEvents:     user.KeyEvents,
+	}
 }
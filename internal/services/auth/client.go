@@ -0,0 +1,22 @@
+package auth
+
+import "context"
+
+// ClientInfo describes where a request comes from.
+type ClientInfo struct {
+	IP     string
+	Device string
+}
+
+type clientInfoKey struct{}
+
+// ContextWithClient attaches client info to the request context.
+func ContextWithClient(ctx context.Context, client ClientInfo) context.Context {
+	return context.WithValue(ctx, clientInfoKey{}, client)
+}
+
+// ClientFromContext returns client info of the request, if known.
+func ClientFromContext(ctx context.Context) ClientInfo {
+	client, _ := ctx.Value(clientInfoKey{}).(ClientInfo)
+	return client
+}
@@ -0,0 +1,99 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sso/internal/domain/models"
+	"sso/internal/lib/biometrics"
+	"sso/internal/lib/logger/sl"
+	"time"
+)
+
+var ErrNoKeystrokeProfile = errors.New("no keystroke profile")
+
+// RiskUpdate is reported to the client after every batch of key events.
+type RiskUpdate struct {
+	Score   float64
+	Risk    float64
+	Revoked bool
+}
+
+// ContinuousSession re-verifies an authenticated session from free typing
+// and revokes it when the running score drops below the app threshold.
+type ContinuousSession struct {
+	a         *Auth
+	log       *slog.Logger
+	sessionID string
+	threshold float64
+	scorer    *biometrics.FreeText
+}
+
+// StartContinuousAuth binds continuous verification to the session of the token.
+func (a *Auth) StartContinuousAuth(ctx context.Context, token string) (*ContinuousSession, error) {
+	const op = "auth.StartContinuousAuth"
+
+	log := a.log.With(slog.String("op", op))
+
+	claims, err := a.parseToken(ctx, token)
+	if err != nil || claims.SessionID == "" {
+		log.WarnContext(ctx, "invalid token", sl.Err(err))
+		return nil, fmt.Errorf("%s: %w", op, ErrInvalidToken)
+	}
+
+	log = log.With(slog.Int64("user_id", claims.UserID), slog.Int("app_id", claims.AppID))
+
+	app, err := a.appProvider.App(ctx, claims.AppID)
+	if err != nil {
+		log.ErrorContext(ctx, "failed to get app", sl.Err(err))
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	user, err := a.usrProvider.UserByID(ctx, claims.UserID)
+	if err != nil {
+		log.ErrorContext(ctx, "failed to get user", sl.Err(err))
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	if len(user.KeyEvents) == 0 {
+		log.WarnContext(ctx, "user has no keystroke profile")
+		return nil, fmt.Errorf("%s: %w", op, ErrNoKeystrokeProfile)
+	}
+
+	log.InfoContext(ctx, "continuous auth started")
+
+	return &ContinuousSession{
+		a:         a,
+		log:       log,
+		sessionID: claims.SessionID,
+		threshold: app.ContinuousAuthThreshold,
+		scorer:    a.matcher.NewFreeText(user.KeyEvents),
+	}, nil
+}
+
+// Observe scores a batch of typed key events.
+func (s *ContinuousSession) Observe(ctx context.Context, events []models.KeyEvent) (RiskUpdate, error) {
+	const op = "auth.ContinuousSession.Observe"
+
+	session, err := s.a.sessions.Session(ctx, s.sessionID)
+	if err != nil {
+		return RiskUpdate{}, fmt.Errorf("%s: %w", op, err)
+	}
+	if !session.Active(time.Now()) {
+		return RiskUpdate{Score: s.scorer.Score(), Risk: 1, Revoked: true}, nil
+	}
+
+	score, updated := s.scorer.Observe(events)
+	update := RiskUpdate{Score: score, Risk: 1 - score}
+
+	if updated && score < s.threshold {
+		if err := s.a.sessions.RevokeSession(ctx, s.sessionID); err != nil {
+			s.log.ErrorContext(ctx, "failed to revoke session", sl.Err(err))
+			return RiskUpdate{}, fmt.Errorf("%s: %w", op, err)
+		}
+		s.log.WarnContext(ctx, "session revoked by continuous auth", slog.Float64("score", score), slog.Float64("threshold", s.threshold))
+		update.Revoked = true
+	}
+
+	return update, nil
+}
@@ -0,0 +1,156 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"golang.org/x/crypto/bcrypt"
+	"log/slog"
+	"sso/internal/domain/models"
+	"sso/internal/lib/jwt"
+	"sso/internal/lib/logger/sl"
+	"sso/internal/storage"
+	"time"
+)
+
+// ReenrollBiometrics replaces the keystroke template of the token owner.
+// The password is required in addition to a valid token.
+func (a *Auth) ReenrollBiometrics(ctx context.Context, token string, password string, pressTimes []float32, intervalTimes []float32, keyEvents []models.KeyEvent) error {
+	const op = "auth.ReenrollBiometrics"
+
+	log := a.log.With(slog.String("op", op))
+
+	claims, err := a.parseToken(ctx, token)
+	if err != nil {
+		log.WarnContext(ctx, "invalid token", sl.Err(err))
+		return fmt.Errorf("%s: %w", op, ErrInvalidToken)
+	}
+
+	log = log.With(slog.Int64("user_id", claims.UserID))
+
+	sample := newSample(pressTimes, intervalTimes, keyEvents)
+
+	// The user is read and the template replaced in one transaction, so a
+	// concurrently deleted user cannot be left with a template.
+	err = a.tx.WithTx(ctx, func(ctx context.Context) error {
+		user, err := a.usrProvider.UserByID(ctx, claims.UserID)
+		if err != nil {
+			return err
+		}
+
+		if err := bcrypt.CompareHashAndPassword(user.PassHash, []byte(password)); err != nil {
+			return ErrInvalidCredentials
+		}
+
+		return a.usrSaver.UpdateBiometrics(ctx, user.ID, sample.PressTimes, sample.IntervalTimes, sample.KeyEvents)
+	})
+	if err != nil {
+		switch {
+		case errors.Is(err, storage.ErrUserNotFound), errors.Is(err, ErrInvalidToken):
+			log.WarnContext(ctx, "token does not match a user", sl.Err(err))
+			return fmt.Errorf("%s: %w", op, ErrInvalidToken)
+		case errors.Is(err, ErrInvalidCredentials):
+			log.InfoContext(ctx, "invalid credentials", sl.Err(err))
+			return fmt.Errorf("%s: %w", op, ErrInvalidCredentials)
+		}
+		log.ErrorContext(ctx, "failed to update biometrics", sl.Err(err))
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	log.InfoContext(ctx, "biometrics re-enrolled")
+
+	return nil
+}
+
+// ResetBiometrics is called by an admin to make the next successful login of
+// the user enroll a new keystroke template.
+func (a *Auth) ResetBiometrics(ctx context.Context, adminToken string, userID int64) error {
+	const op = "auth.ResetBiometrics"
+
+	log := a.log.With(
+		slog.String("op", op),
+		slog.Int64("user_id", userID),
+	)
+
+	claims, err := a.requireAdmin(ctx, log, adminToken)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	if err := a.usrSaver.SetEnrollmentPending(ctx, userID); err != nil {
+		if errors.Is(err, storage.ErrUserNotFound) {
+			log.WarnContext(ctx, "user not found", sl.Err(err))
+			return fmt.Errorf("%s: %w", op, ErrUserNotFound)
+		}
+		log.ErrorContext(ctx, "failed to reset biometrics", sl.Err(err))
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	log.InfoContext(ctx, "biometrics reset", slog.Int64("admin_id", claims.UserID))
+
+	return nil
+}
+
+// DeleteUser removes the user together with the keystroke template, sessions
+// and login history. Either everything is removed or nothing is. The
+// deletion is audited as done by the service itself.
+func (a *Auth) DeleteUser(ctx context.Context, userID int64) error {
+	const op = "auth.DeleteUser"
+
+	log := a.log.With(
+		slog.String("op", op),
+		slog.Int64("user_id", userID),
+	)
+
+	err := a.deleteAccount(ctx, models.AuditEvent{
+		UserID:    userID,
+		ActorID:   SystemActorID,
+		CreatedAt: time.Now(),
+	})
+	if err != nil {
+		if errors.Is(err, storage.ErrUserNotFound) {
+			log.WarnContext(ctx, "user not found", sl.Err(err))
+			return fmt.Errorf("%s: %w", op, ErrUserNotFound)
+		}
+		log.ErrorContext(ctx, "failed to delete user", sl.Err(err))
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	log.InfoContext(ctx, "user deleted")
+
+	return nil
+}
+
+// parseToken verifies a token issued by Login against the secrets of its
+// app and checks that the session belongs to the token and that neither the
+// app is disabled nor the session revoked.
+func (a *Auth) parseToken(ctx context.Context, token string) (jwt.Claims, error) {
+	claims, err := jwt.ParseToken(token, a.issuer, func(appID int) (models.App, error) {
+		app, err := a.appProvider.App(ctx, appID)
+		if err != nil {
+			return models.App{}, err
+		}
+		if app.DisabledAt != nil {
+			return models.App{}, ErrAppDisabled
+		}
+		return app, nil
+	})
+	if err != nil {
+		return jwt.Claims{}, err
+	}
+
+	session, err := a.sessions.Session(ctx, claims.SessionID)
+	if err != nil {
+		return jwt.Claims{}, err
+	}
+	// Apps sign with their own secret, so a token must not borrow the
+	// session of another user or app.
+	if session.UserID != claims.UserID || session.AppID != claims.AppID {
+		return jwt.Claims{}, ErrInvalidToken
+	}
+	if !session.Active(time.Now()) {
+		return jwt.Claims{}, ErrSessionRevoked
+	}
+
+	return claims, nil
+}
@@ -0,0 +1,198 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sso/internal/domain/models"
+	"sso/internal/lib/logger/sl"
+	"sso/internal/storage"
+	"time"
+)
+
+// DataExport is everything the service keeps about a user, as returned by
+// ExportMyData. It is meant to be serialized as JSON.
+type DataExport struct {
+	ExportedAt   time.Time            `json:"exported_at"`
+	Profile      ExportProfile        `json:"profile"`
+	Biometrics   ExportBiometrics     `json:"biometrics"`
+	Sessions     []ExportSession      `json:"sessions"`
+	LoginHistory []ExportLoginAttempt `json:"login_history"`
+	// Consents is empty: the service does not record consents yet. It is
+	// part of the bundle so its format does not change once it does.
+	Consents []ExportConsent    `json:"consents"`
+	AuditLog []ExportAuditEvent `json:"audit_log"`
+}
+
+type ExportProfile struct {
+	ID           int64      `json:"id"`
+	Email        string     `json:"email"`
+	PendingEmail string     `json:"pending_email,omitempty"`
+	DisplayName  string     `json:"display_name"`
+	Locale       string     `json:"locale"`
+	Timezone     string     `json:"timezone"`
+	IsAdmin      bool       `json:"is_admin"`
+	DeleteAfter  *time.Time `json:"delete_after,omitempty"`
+}
+
+// ExportBiometrics describes the stored keystroke template without its
+// timings: they are only meaningful to the matcher and would let anyone
+// holding the export imitate the user's typing.
+type ExportBiometrics struct {
+	Enrolled          bool `json:"enrolled"`
+	EnrollmentPending bool `json:"enrollment_pending"`
+	PressTimes        int  `json:"press_times"`
+	Intervals         int  `json:"intervals"`
+	KeyEvents         int  `json:"key_events"`
+}
+
+type ExportSession struct {
+	AppID     int        `json:"app_id"`
+	CreatedAt time.Time  `json:"created_at"`
+	ExpiresAt time.Time  `json:"expires_at"`
+	RevokedAt *time.Time `json:"revoked_at,omitempty"`
+}
+
+type ExportLoginAttempt struct {
+	AppID     int       `json:"app_id"`
+	IP        string    `json:"ip"`
+	Device    string    `json:"device"`
+	Success   bool      `json:"success"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type ExportConsent struct {
+	Purpose   string    `json:"purpose"`
+	GrantedAt time.Time `json:"granted_at"`
+}
+
+type ExportAuditEvent struct {
+	Action    string    `json:"action"`
+	ActorID   int64     `json:"actor_id"`
+	IP        string    `json:"ip"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// ExportMyData returns everything the service keeps about the token owner.
+// Exports are audited.
+func (a *Auth) ExportMyData(ctx context.Context, token string) (DataExport, error) {
+	const op = "auth.ExportMyData"
+
+	log := a.log.With(slog.String("op", op))
+
+	claims, err := a.parseToken(ctx, token)
+	if err != nil {
+		log.WarnContext(ctx, "invalid token", sl.Err(err))
+		return DataExport{}, fmt.Errorf("%s: %w", op, ErrInvalidToken)
+	}
+
+	log = log.With(slog.Int64("user_id", claims.UserID))
+
+	export, err := a.exportData(ctx, claims.UserID)
+	if err != nil {
+		if errors.Is(err, storage.ErrUserNotFound) {
+			log.WarnContext(ctx, "token does not match a user", sl.Err(err))
+			return DataExport{}, fmt.Errorf("%s: %w", op, ErrInvalidToken)
+		}
+		log.ErrorContext(ctx, "failed to export data", sl.Err(err))
+		return DataExport{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	err = a.audit.SaveAuditEvent(ctx, models.AuditEvent{
+		UserID:    claims.UserID,
+		ActorID:   claims.UserID,
+		Action:    AuditDataExported,
+		IP:        ClientFromContext(ctx).IP,
+		CreatedAt: export.ExportedAt,
+	})
+	if err != nil {
+		log.ErrorContext(ctx, "failed to audit data export", sl.Err(err))
+		return DataExport{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	log.InfoContext(ctx, "data exported")
+
+	return export, nil
+}
+
+func (a *Auth) exportData(ctx context.Context, userID int64) (DataExport, error) {
+	user, err := a.usrProvider.UserByID(ctx, userID)
+	if err != nil {
+		return DataExport{}, err
+	}
+
+	isAdmin, err := a.usrProvider.IsAdmin(ctx, user.ID)
+	if err != nil {
+		return DataExport{}, err
+	}
+
+	sessions, err := a.sessions.UserSessions(ctx, user.ID)
+	if err != nil {
+		return DataExport{}, err
+	}
+
+	attempts, err := a.history.LoginAttempts(ctx, user.ID, time.Time{})
+	if err != nil {
+		return DataExport{}, err
+	}
+
+	events, err := a.audit.AuditEvents(ctx, user.ID)
+	if err != nil {
+		return DataExport{}, err
+	}
+
+	export := DataExport{
+		ExportedAt: time.Now().UTC(),
+		Profile: ExportProfile{
+			ID:          user.ID,
+			Email:       user.Email,
+			DisplayName: user.DisplayName,
+			Locale:      user.Locale,
+			Timezone:    user.Timezone,
+			IsAdmin:     isAdmin,
+			DeleteAfter: user.DeleteAfter,
+		},
+		Biometrics: ExportBiometrics{
+			Enrolled:          len(user.PressTimes) > 0 || len(user.KeyEvents) > 0,
+			EnrollmentPending: user.EnrollmentPending,
+			PressTimes:        len(user.PressTimes),
+			Intervals:         len(user.PressIntervals),
+			KeyEvents:         len(user.KeyEvents),
+		},
+		Sessions:     make([]ExportSession, 0, len(sessions)),
+		LoginHistory: make([]ExportLoginAttempt, 0, len(attempts)),
+		Consents:     []ExportConsent{},
+		AuditLog:     make([]ExportAuditEvent, 0, len(events)),
+	}
+	if user.EmailChange != nil {
+		export.Profile.PendingEmail = user.EmailChange.Email
+	}
+	for _, s := range sessions {
+		export.Sessions = append(export.Sessions, ExportSession{
+			AppID:     s.AppID,
+			CreatedAt: s.CreatedAt,
+			ExpiresAt: s.ExpiresAt,
+			RevokedAt: s.RevokedAt,
+		})
+	}
+	for _, la := range attempts {
+		export.LoginHistory = append(export.LoginHistory, ExportLoginAttempt{
+			AppID:     la.AppID,
+			IP:        la.IP,
+			Device:    la.Device,
+			Success:   la.Success,
+			CreatedAt: la.CreatedAt,
+		})
+	}
+	for _, e := range events {
+		export.AuditLog = append(export.AuditLog, ExportAuditEvent{
+			Action:    e.Action,
+			ActorID:   e.ActorID,
+			IP:        e.IP,
+			CreatedAt: e.CreatedAt,
+		})
+	}
+
+	return export, nil
+}
@@ -0,0 +1,333 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base32"
+	"errors"
+	"fmt"
+	"golang.org/x/crypto/bcrypt"
+	"log/slog"
+	"sso/internal/domain/models"
+	"sso/internal/lib/jwt"
+	"sso/internal/lib/logger/sl"
+	"sso/internal/storage"
+	"time"
+)
+
+var (
+	ErrNoEmailChange      = errors.New("no pending email change")
+	ErrEmailChangeExpired = errors.New("email change expired")
+	ErrInvalidCode        = errors.New("invalid verification code")
+)
+
+// emailChangeTTL is how long the code sent to a new email address is valid.
+const emailChangeTTL = 24 * time.Hour
+
+// ProfileUpdate holds the profile fields to change; nil fields are kept.
+type ProfileUpdate struct {
+	DisplayName *string
+	Locale      *string
+	Timezone    *string
+}
+
+// GetProfile returns the token owner.
+func (a *Auth) GetProfile(ctx context.Context, token string) (models.User, error) {
+	const op = "auth.GetProfile"
+
+	log := a.log.With(slog.String("op", op))
+
+	_, user, err := a.tokenUser(ctx, token)
+	if err != nil {
+		if errors.Is(err, ErrInvalidToken) {
+			log.WarnContext(ctx, "invalid token", sl.Err(err))
+			return models.User{}, fmt.Errorf("%s: %w", op, ErrInvalidToken)
+		}
+		log.ErrorContext(ctx, "failed to get user", sl.Err(err))
+		return models.User{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return user, nil
+}
+
+// UpdateProfile changes the profile fields set in update and returns the
+// updated token owner.
+func (a *Auth) UpdateProfile(ctx context.Context, token string, update ProfileUpdate) (models.User, error) {
+	const op = "auth.UpdateProfile"
+
+	log := a.log.With(slog.String("op", op))
+
+	claims, err := a.parseToken(ctx, token)
+	if err != nil {
+		log.WarnContext(ctx, "invalid token", sl.Err(err))
+		return models.User{}, fmt.Errorf("%s: %w", op, ErrInvalidToken)
+	}
+
+	log = log.With(slog.Int64("user_id", claims.UserID))
+
+	var user models.User
+	err = a.tx.WithTx(ctx, func(ctx context.Context) error {
+		var err error
+		user, err = a.usrProvider.UserByID(ctx, claims.UserID)
+		if err != nil {
+			return err
+		}
+
+		if update.DisplayName != nil {
+			user.DisplayName = *update.DisplayName
+		}
+		if update.Locale != nil {
+			user.Locale = *update.Locale
+		}
+		if update.Timezone != nil {
+			user.Timezone = *update.Timezone
+		}
+
+		if err := a.usrSaver.UpdateProfile(ctx, user.ID, user.Profile); err != nil {
+			return err
+		}
+		return a.audit.SaveAuditEvent(ctx, a.auditEvent(ctx, user.ID, AuditProfileUpdated))
+	})
+	if err != nil {
+		if errors.Is(err, storage.ErrUserNotFound) {
+			log.WarnContext(ctx, "token does not match a user", sl.Err(err))
+			return models.User{}, fmt.Errorf("%s: %w", op, ErrInvalidToken)
+		}
+		log.ErrorContext(ctx, "failed to update profile", sl.Err(err))
+		return models.User{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	log.InfoContext(ctx, "profile updated")
+
+	return user, nil
+}
+
+// ChangePassword replaces the password of the token owner after checking
+// the current one. The keystroke template follows the password, so it is
+// replaced with the sample typed for the new password. Every other session
+// of the user is revoked.
+func (a *Auth) ChangePassword(ctx context.Context, token string, currentPassword string, newPassword string, pressTimes []float32, intervalTimes []float32, keyEvents []models.KeyEvent) error {
+	const op = "auth.ChangePassword"
+
+	log := a.log.With(slog.String("op", op))
+
+	claims, user, err := a.tokenUser(ctx, token)
+	if err != nil {
+		if errors.Is(err, ErrInvalidToken) {
+			log.WarnContext(ctx, "invalid token", sl.Err(err))
+			return fmt.Errorf("%s: %w", op, ErrInvalidToken)
+		}
+		log.ErrorContext(ctx, "failed to get user", sl.Err(err))
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	log = log.With(slog.Int64("user_id", user.ID))
+
+	if err := bcrypt.CompareHashAndPassword(user.PassHash, []byte(currentPassword)); err != nil {
+		log.InfoContext(ctx, "invalid credentials", sl.Err(err))
+		return fmt.Errorf("%s: %w", op, ErrInvalidCredentials)
+	}
+
+	passHash, err := bcrypt.GenerateFromPassword([]byte(newPassword), bcrypt.DefaultCost)
+	if err != nil {
+		log.ErrorContext(ctx, "failed to hash password", sl.Err(err))
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	sample := newSample(pressTimes, intervalTimes, keyEvents)
+
+	err = a.tx.WithTx(ctx, func(ctx context.Context) error {
+		if err := a.usrSaver.UpdatePassword(ctx, user.ID, passHash); err != nil {
+			return err
+		}
+		if err := a.usrSaver.UpdateBiometrics(ctx, user.ID, sample.PressTimes, sample.IntervalTimes, sample.KeyEvents); err != nil {
+			return err
+		}
+		if err := a.sessions.RevokeUserSessions(ctx, user.ID, claims.SessionID); err != nil {
+			return err
+		}
+		return a.audit.SaveAuditEvent(ctx, a.auditEvent(ctx, user.ID, AuditPasswordChanged))
+	})
+	if err != nil {
+		if errors.Is(err, storage.ErrUserNotFound) {
+			log.WarnContext(ctx, "token does not match a user", sl.Err(err))
+			return fmt.Errorf("%s: %w", op, ErrInvalidToken)
+		}
+		log.ErrorContext(ctx, "failed to change password", sl.Err(err))
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	log.InfoContext(ctx, "password changed")
+
+	return nil
+}
+
+// ChangeEmail starts changing the email address of the token owner: a
+// verification code is sent to newEmail, and the address only changes once
+// the code is passed to ConfirmEmailChange before the returned time. A new
+// request replaces a pending one.
+func (a *Auth) ChangeEmail(ctx context.Context, token string, password string, newEmail string) (time.Time, error) {
+	const op = "auth.ChangeEmail"
+
+	log := a.log.With(slog.String("op", op))
+
+	_, user, err := a.tokenUser(ctx, token)
+	if err != nil {
+		if errors.Is(err, ErrInvalidToken) {
+			log.WarnContext(ctx, "invalid token", sl.Err(err))
+			return time.Time{}, fmt.Errorf("%s: %w", op, ErrInvalidToken)
+		}
+		log.ErrorContext(ctx, "failed to get user", sl.Err(err))
+		return time.Time{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	log = log.With(slog.Int64("user_id", user.ID))
+
+	if err := bcrypt.CompareHashAndPassword(user.PassHash, []byte(password)); err != nil {
+		log.InfoContext(ctx, "invalid credentials", sl.Err(err))
+		return time.Time{}, fmt.Errorf("%s: %w", op, ErrInvalidCredentials)
+	}
+
+	if _, err := a.usrProvider.User(ctx, newEmail); err == nil {
+		log.WarnContext(ctx, "email already taken")
+		return time.Time{}, fmt.Errorf("%s: %w", op, ErrUserExist)
+	} else if !errors.Is(err, storage.ErrUserNotFound) {
+		log.ErrorContext(ctx, "failed to get user", sl.Err(err))
+		return time.Time{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	code, err := newVerificationCode()
+	if err != nil {
+		log.ErrorContext(ctx, "failed to generate verification code", sl.Err(err))
+		return time.Time{}, fmt.Errorf("%s: %w", op, err)
+	}
+	codeHash := sha256.Sum256([]byte(code))
+	change := models.EmailChange{
+		Email:     newEmail,
+		CodeHash:  codeHash[:],
+		ExpiresAt: time.Now().Add(emailChangeTTL),
+	}
+
+	err = a.tx.WithTx(ctx, func(ctx context.Context) error {
+		if err := a.usrSaver.SetEmailChange(ctx, user.ID, &change); err != nil {
+			return err
+		}
+		return a.audit.SaveAuditEvent(ctx, a.auditEvent(ctx, user.ID, AuditEmailChangeRequested))
+	})
+	if err != nil {
+		log.ErrorContext(ctx, "failed to save email change", sl.Err(err))
+		return time.Time{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	// Sent after the commit, so a slow mail server does not hold the
+	// transaction open. If sending fails the user asks for a new code.
+	if err := a.mailer.SendEmailVerification(ctx, newEmail, code); err != nil {
+		log.ErrorContext(ctx, "failed to send verification code", sl.Err(err))
+		return time.Time{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	log.InfoContext(ctx, "email change requested")
+
+	return change.ExpiresAt, nil
+}
+
+// ConfirmEmailChange completes a change of the email address started by
+// ChangeEmail with the code sent to the new address.
+func (a *Auth) ConfirmEmailChange(ctx context.Context, token string, code string) error {
+	const op = "auth.ConfirmEmailChange"
+
+	log := a.log.With(slog.String("op", op))
+
+	_, user, err := a.tokenUser(ctx, token)
+	if err != nil {
+		if errors.Is(err, ErrInvalidToken) {
+			log.WarnContext(ctx, "invalid token", sl.Err(err))
+			return fmt.Errorf("%s: %w", op, ErrInvalidToken)
+		}
+		log.ErrorContext(ctx, "failed to get user", sl.Err(err))
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	log = log.With(slog.Int64("user_id", user.ID))
+
+	change := user.EmailChange
+	if change == nil {
+		log.WarnContext(ctx, "no pending email change")
+		return fmt.Errorf("%s: %w", op, ErrNoEmailChange)
+	}
+	if time.Now().After(change.ExpiresAt) {
+		log.WarnContext(ctx, "email change expired")
+		return fmt.Errorf("%s: %w", op, ErrEmailChangeExpired)
+	}
+	codeHash := sha256.Sum256([]byte(code))
+	if subtle.ConstantTimeCompare(codeHash[:], change.CodeHash) != 1 {
+		log.WarnContext(ctx, "invalid verification code")
+		return fmt.Errorf("%s: %w", op, ErrInvalidCode)
+	}
+
+	err = a.tx.WithTx(ctx, func(ctx context.Context) error {
+		if err := a.usrSaver.SetEmail(ctx, user.ID, change.Email); err != nil {
+			return err
+		}
+		if err := a.usrSaver.SetEmailChange(ctx, user.ID, nil); err != nil {
+			return err
+		}
+		return a.audit.SaveAuditEvent(ctx, a.auditEvent(ctx, user.ID, AuditEmailChanged))
+	})
+	if err != nil {
+		if errors.Is(err, storage.ErrUserExists) {
+			log.WarnContext(ctx, "email already taken", sl.Err(err))
+			return fmt.Errorf("%s: %w", op, ErrUserExist)
+		}
+		log.ErrorContext(ctx, "failed to change email", sl.Err(err))
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	log.InfoContext(ctx, "email changed")
+
+	return nil
+}
+
+// tokenUser verifies the token and returns its claims with the user it was
+// issued to. The user is looked up by ID: the email in the claims is stale
+// once the address changes.
+func (a *Auth) tokenUser(ctx context.Context, token string) (jwt.Claims, models.User, error) {
+	claims, err := a.parseToken(ctx, token)
+	if err != nil {
+		return jwt.Claims{}, models.User{}, errors.Join(ErrInvalidToken, err)
+	}
+
+	user, err := a.usrProvider.UserByID(ctx, claims.UserID)
+	if err != nil {
+		if errors.Is(err, storage.ErrUserNotFound) {
+			return jwt.Claims{}, models.User{}, errors.Join(ErrInvalidToken, err)
+		}
+		return jwt.Claims{}, models.User{}, err
+	}
+
+	return claims, user, nil
+}
+
+// auditEvent returns an event about an action users took on their own
+// account.
+func (a *Auth) auditEvent(ctx context.Context, userID int64, action string) models.AuditEvent {
+	return models.AuditEvent{
+		UserID:    userID,
+		ActorID:   userID,
+		Action:    action,
+		IP:        ClientFromContext(ctx).IP,
+		CreatedAt: time.Now(),
+	}
+}
+
+// newVerificationCode returns a random code for email verification, long
+// enough that it cannot be guessed before it expires.
+func newVerificationCode() (string, error) {
+	b := make([]byte, 15)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base32.StdEncoding.EncodeToString(b), nil
+}
@@ -0,0 +1,121 @@
+package auth
+
+import (
+	"container/list"
+	"math"
+	"sso/internal/lib/biometrics"
+	"sync"
+)
+
+var (
+	// replayTolerance is the mean relative deviation below which a sample is
+	// considered a repeat of a recently submitted one.
+	replayTolerance = 0.005
+	// syntheticTolerance is the mean relative deviation from the stored
+	// template below which a sample is considered machine generated.
+	syntheticTolerance = 0.01
+
+	replayHistorySize  = 16
+	replayTrackedUsers = 10000
+)
+
+// replayGuard keeps a bounded history of recent samples per user. Humans
+// never type with identical timings twice, so a repeated vector is a replay.
+type replayGuard struct {
+	mu         sync.Mutex
+	historyLen int
+	maxUsers   int
+	users      map[int64]*list.Element
+	lru        *list.List
+}
+
+type userHistory struct {
+	userID       int64
+	fingerprints [][]float32
+}
+
+func newReplayGuard(historyLen int, maxUsers int) *replayGuard {
+	return &replayGuard{
+		historyLen: historyLen,
+		maxUsers:   maxUsers,
+		users:      make(map[int64]*list.Element),
+		lru:        list.New(),
+	}
+}
+
+// seen reports whether the sample (nearly) repeats one of the user's recent samples.
+func (g *replayGuard) seen(userID int64, s biometrics.Sample) bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	el, ok := g.users[userID]
+	if !ok {
+		return false
+	}
+
+	fp := fingerprint(s)
+	for _, recent := range el.Value.(*userHistory).fingerprints {
+		if isNear(fp, recent, replayTolerance) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// remember adds the sample to the user's history, dropping the oldest sample
+// of the user and the least recently active user when limits are reached.
+func (g *replayGuard) remember(userID int64, s biometrics.Sample) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	fp := fingerprint(s)
+
+	if el, ok := g.users[userID]; ok {
+		h := el.Value.(*userHistory)
+		h.fingerprints = append(h.fingerprints, fp)
+		if len(h.fingerprints) > g.historyLen {
+			h.fingerprints = h.fingerprints[len(h.fingerprints)-g.historyLen:]
+		}
+		g.lru.MoveToFront(el)
+		return
+	}
+
+	g.users[userID] = g.lru.PushFront(&userHistory{userID: userID, fingerprints: [][]float32{fp}})
+
+	if g.lru.Len() > g.maxUsers {
+		oldest := g.lru.Back()
+		g.lru.Remove(oldest)
+		delete(g.users, oldest.Value.(*userHistory).userID)
+	}
+}
+
+// fingerprint flattens the timings of a sample into a single vector. Key
+// events are reduced to the press and interval times derived from them, so a
+// sample replayed as flat arrays fingerprints the same as its key events.
+func fingerprint(s biometrics.Sample) []float32 {
+	pressTimes, intervalTimes := s.PressTimes, s.IntervalTimes
+	if len(s.KeyEvents) > 0 {
+		pressTimes, intervalTimes = biometrics.FlatTimings(s.KeyEvents)
+	}
+
+	fp := make([]float32, 0, len(pressTimes)+len(intervalTimes))
+	fp = append(fp, pressTimes...)
+	return append(fp, intervalTimes...)
+}
+
+// isNear reports whether the mean relative deviation of input from reference
+// is below tolerance. Vectors of different length are never near.
+func isNear(input, reference []float32, tolerance float64) bool {
+	if len(input) != len(reference) || len(input) == 0 {
+		return false
+	}
+
+	var deviation float64
+	for i := range input {
+		diff := math.Abs(float64(input[i]) - float64(reference[i]))
+		deviation += diff / math.Max(math.Abs(float64(reference[i])), 1e-6)
+	}
+
+	return deviation/float64(len(input)) < tolerance
+}
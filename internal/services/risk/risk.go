@@ -0,0 +1,145 @@
+package risk
+
+import (
+	"context"
+	"log/slog"
+	"sso/internal/domain/models"
+	"time"
+)
+
+type Level string
+
+const (
+	LevelLow    Level = "low"
+	LevelMedium Level = "medium"
+	LevelHigh   Level = "high"
+)
+
+type Action string
+
+const (
+	ActionAllow  Action = "allow"
+	ActionStepUp Action = "step_up"
+	ActionDeny   Action = "deny"
+)
+
+// Attempt is everything known about a login at the moment of the decision.
+type Attempt struct {
+	UserID int64
+	App    models.App
+	IP     string
+	Device string
+	Time   time.Time
+	// BiometricScore is the keystroke match score in [0, 1].
+	BiometricScore float64
+	// History holds recent attempts of the user, newest first.
+	History []models.LoginAttempt
+}
+
+// Signal scores one aspect of an attempt with a risk in [0, 1] and explains it.
+type Signal interface {
+	Name() string
+	Evaluate(ctx context.Context, attempt Attempt) (risk float64, reason string)
+}
+
+// Factor is the contribution of a signal to a decision.
+type Factor struct {
+	Signal string
+	Risk   float64
+	Weight float64
+	Reason string
+}
+
+// Decision is the outcome of risk evaluation.
+type Decision struct {
+	Score   float64
+	Level   Level
+	Action  Action
+	Factors []Factor
+}
+
+// Thresholds split the combined score into levels: below StepUp is low,
+// from Deny on is high.
+type Thresholds struct {
+	StepUp float64
+	Deny   float64
+}
+
+type weighted struct {
+	signal Signal
+	weight float64
+}
+
+// Engine combines weighted signals into a decision.
+type Engine struct {
+	log        *slog.Logger
+	thresholds Thresholds
+	signals    []weighted
+}
+
+// New returns an engine without signals; add them with Use.
+func New(log *slog.Logger, thresholds Thresholds) *Engine {
+	return &Engine{
+		log:        log,
+		thresholds: thresholds,
+	}
+}
+
+// Use adds a signal with the given weight.
+func (e *Engine) Use(signal Signal, weight float64) *Engine {
+	e.signals = append(e.signals, weighted{signal: signal, weight: weight})
+	return e
+}
+
+// Evaluate scores the attempt as the weighted mean of the signals and logs
+// the decision together with its contributing factors.
+func (e *Engine) Evaluate(ctx context.Context, attempt Attempt) Decision {
+	const op = "risk.Engine.Evaluate"
+
+	var d Decision
+	var total, weights float64
+	for _, s := range e.signals {
+		r, reason := s.signal.Evaluate(ctx, attempt)
+		total += r * s.weight
+		weights += s.weight
+		d.Factors = append(d.Factors, Factor{
+			Signal: s.signal.Name(),
+			Risk:   r,
+			Weight: s.weight,
+			Reason: reason,
+		})
+	}
+	if weights > 0 {
+		d.Score = total / weights
+	}
+
+	switch {
+	case d.Score >= e.thresholds.Deny:
+		d.Level, d.Action = LevelHigh, ActionDeny
+	case d.Score >= e.thresholds.StepUp:
+		d.Level, d.Action = LevelMedium, ActionStepUp
+	default:
+		d.Level, d.Action = LevelLow, ActionAllow
+	}
+
+	factors := make([]any, 0, len(d.Factors))
+	for _, f := range d.Factors {
+		factors = append(factors, slog.Group(f.Signal,
+			slog.Float64("risk", f.Risk),
+			slog.Float64("weight", f.Weight),
+			slog.String("reason", f.Reason),
+		))
+	}
+
+	e.log.InfoContext(ctx, "risk evaluated",
+		slog.String("op", op),
+		slog.Int64("user_id", attempt.UserID),
+		slog.Int("app_id", attempt.App.ID),
+		slog.Float64("score", d.Score),
+		slog.String("level", string(d.Level)),
+		slog.String("action", string(d.Action)),
+		slog.Group("factors", factors...),
+	)
+
+	return d
+}
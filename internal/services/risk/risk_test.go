@@ -0,0 +1,100 @@
+package risk_test
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"sso/internal/lib/logger/handlers/slogdiscard"
+	"sso/internal/services/risk"
+	"testing"
+	"time"
+)
+
+var thresholds = risk.Thresholds{StepUp: 0.5, Deny: 0.8}
+
+// fixed is a signal with a constant risk.
+type fixed struct {
+	name string
+	risk float64
+}
+
+func (f fixed) Name() string { return f.name }
+
+func (f fixed) Evaluate(context.Context, risk.Attempt) (float64, string) {
+	return f.risk, "fixed"
+}
+
+func TestEngine_Thresholds(t *testing.T) {
+	for name, tt := range map[string]struct {
+		risk       float64
+		wantLevel  risk.Level
+		wantAction risk.Action
+	}{
+		"no risk":            {risk: 0, wantLevel: risk.LevelLow, wantAction: risk.ActionAllow},
+		"below step-up":      {risk: 0.49, wantLevel: risk.LevelLow, wantAction: risk.ActionAllow},
+		"at step-up":         {risk: 0.5, wantLevel: risk.LevelMedium, wantAction: risk.ActionStepUp},
+		"between thresholds": {risk: 0.7, wantLevel: risk.LevelMedium, wantAction: risk.ActionStepUp},
+		"at deny":            {risk: 0.8, wantLevel: risk.LevelHigh, wantAction: risk.ActionDeny},
+		"maximal risk":       {risk: 1, wantLevel: risk.LevelHigh, wantAction: risk.ActionDeny},
+	} {
+		t.Run(name, func(t *testing.T) {
+			e := risk.New(slogdiscard.NewDiscardLogger(), thresholds).Use(fixed{name: "fixed", risk: tt.risk}, 1)
+
+			d := e.Evaluate(context.Background(), risk.Attempt{})
+			assert.InDelta(t, tt.risk, d.Score, 1e-9)
+			assert.Equal(t, tt.wantLevel, d.Level)
+			assert.Equal(t, tt.wantAction, d.Action)
+		})
+	}
+}
+
+func TestEngine_WeightedMean(t *testing.T) {
+	e := risk.New(slogdiscard.NewDiscardLogger(), thresholds).
+		Use(fixed{name: "a", risk: 1}, 3).
+		Use(fixed{name: "b", risk: 0}, 1)
+
+	d := e.Evaluate(context.Background(), risk.Attempt{})
+	assert.InDelta(t, 0.75, d.Score, 1e-9)
+	assert.Equal(t, risk.ActionStepUp, d.Action)
+	require.Len(t, d.Factors, 2)
+	assert.Equal(t, risk.Factor{Signal: "a", Risk: 1, Weight: 3, Reason: "fixed"}, d.Factors[0])
+	assert.Equal(t, risk.Factor{Signal: "b", Risk: 0, Weight: 1, Reason: "fixed"}, d.Factors[1])
+}
+
+func TestEngine_NoSignals(t *testing.T) {
+	d := risk.New(slogdiscard.NewDiscardLogger(), thresholds).Evaluate(context.Background(), risk.Attempt{})
+	assert.Zero(t, d.Score)
+	assert.Equal(t, risk.ActionAllow, d.Action)
+	assert.Empty(t, d.Factors)
+}
+
+func TestEngine_DefaultSignals(t *testing.T) {
+	e := risk.New(slogdiscard.NewDiscardLogger(), thresholds).UseDefaultSignals()
+
+	for name, tt := range map[string]struct {
+		attempt    risk.Attempt
+		wantAction risk.Action
+	}{
+		"owner on a known device": {
+			attempt:    risk.Attempt{IP: "10.0.0.1", Device: "laptop", Time: now, BiometricScore: 1, History: loginsAt(14, 10)},
+			wantAction: risk.ActionAllow,
+		},
+		"no keystroke match from a new device": {
+			attempt:    risk.Attempt{IP: "10.0.0.9", Device: "phone", Time: now, BiometricScore: 0, History: loginsAt(14, 10)},
+			wantAction: risk.ActionStepUp,
+		},
+		"brute force at night": {
+			attempt: risk.Attempt{
+				IP: "10.0.0.9", Device: "phone", Time: now.Add(12 * time.Hour), BiometricScore: 0,
+				History: append(failures(5, time.Minute-12*time.Hour), loginsAt(14, 10)...),
+			},
+			wantAction: risk.ActionDeny,
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			d := e.Evaluate(context.Background(), tt.attempt)
+			assert.Equal(t, tt.wantAction, d.Action, "score %.2f", d.Score)
+			assert.Len(t, d.Factors, 5)
+		})
+	}
+}
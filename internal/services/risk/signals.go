@@ -0,0 +1,121 @@
+package risk
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sso/internal/domain/models"
+	"time"
+)
+
+// Biometric turns a weak keystroke match into risk.
+type Biometric struct{}
+
+func (Biometric) Name() string { return "biometric" }
+
+func (Biometric) Evaluate(_ context.Context, a Attempt) (float64, string) {
+	return clamp(1 - a.BiometricScore), fmt.Sprintf("keystroke score %.2f", a.BiometricScore)
+}
+
+// FailedAttempts counts failed logins within Window; Limit failures are
+// maximal risk.
+type FailedAttempts struct {
+	Window time.Duration
+	Limit  int
+}
+
+func (FailedAttempts) Name() string { return "failed_attempts" }
+
+func (s FailedAttempts) Evaluate(_ context.Context, a Attempt) (float64, string) {
+	failed := 0
+	for _, h := range a.History {
+		if a.Time.Sub(h.CreatedAt) > s.Window {
+			break
+		}
+		if !h.Success {
+			failed++
+		}
+	}
+	return clamp(float64(failed) / float64(s.Limit)), fmt.Sprintf("%d failed attempts in %s", failed, s.Window)
+}
+
+// NewDevice flags an IP or device never used in a successful login. Users
+// without successful logins yet have nothing to compare against.
+type NewDevice struct{}
+
+func (NewDevice) Name() string { return "new_device" }
+
+func (NewDevice) Evaluate(_ context.Context, a Attempt) (float64, string) {
+	knownIP, knownDevice, successes := false, false, 0
+	for _, h := range a.History {
+		if !h.Success {
+			continue
+		}
+		successes++
+		knownIP = knownIP || h.IP == a.IP
+		knownDevice = knownDevice || h.Device == a.Device
+	}
+
+	switch {
+	case successes == 0:
+		return 0, "no login history"
+	case !knownIP && !knownDevice:
+		return 1, "new ip and device"
+	case !knownIP:
+		return 0.5, "new ip"
+	case !knownDevice:
+		return 0.5, "new device"
+	}
+	return 0, "known ip and device"
+}
+
+// TimeOfDay flags logins at an hour the user has never logged in at, once
+// there are at least MinHistory successful logins to learn the habit from.
+type TimeOfDay struct {
+	MinHistory int
+}
+
+func (TimeOfDay) Name() string { return "time_of_day" }
+
+func (s TimeOfDay) Evaluate(_ context.Context, a Attempt) (float64, string) {
+	var hours [24]int
+	successes := 0
+	for _, h := range a.History {
+		if h.Success {
+			hours[h.CreatedAt.UTC().Hour()]++
+			successes++
+		}
+	}
+	if successes < s.MinHistory {
+		return 0, "not enough login history"
+	}
+
+	hour := a.Time.UTC().Hour()
+	if hours[hour] > 0 || hours[(hour+23)%24] > 0 || hours[(hour+1)%24] > 0 {
+		return 0, fmt.Sprintf("usual hour %d", hour)
+	}
+	return 1, fmt.Sprintf("unusual hour %d", hour)
+}
+
+// AppSensitivity raises risk for sensitive apps.
+type AppSensitivity struct{}
+
+func (AppSensitivity) Name() string { return "app_sensitivity" }
+
+func (AppSensitivity) Evaluate(_ context.Context, a Attempt) (float64, string) {
+	return clamp(float64(a.App.Sensitivity) / models.SensitivityHigh), fmt.Sprintf("app sensitivity %d", a.App.Sensitivity)
+}
+
+// UseDefaultSignals adds the built-in signals with their default weights.
+func (e *Engine) UseDefaultSignals() *Engine {
+	return e.
+		Use(Biometric{}, 0.35).
+		Use(FailedAttempts{Window: 15 * time.Minute, Limit: 5}, 0.25).
+		Use(NewDevice{}, 0.2).
+		Use(TimeOfDay{MinHistory: 10}, 0.1).
+		Use(AppSensitivity{}, 0.1)
+}
+
+func clamp(v float64) float64 {
+	return math.Max(0, math.Min(1, v))
+}
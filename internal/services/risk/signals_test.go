@@ -0,0 +1,78 @@
+package risk_test
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"sso/internal/domain/models"
+	"sso/internal/services/risk"
+	"testing"
+	"time"
+)
+
+var now = time.Date(2026, 3, 2, 14, 30, 0, 0, time.UTC)
+
+// login returns a past attempt made ago before now.
+func login(ago time.Duration, success bool, ip, device string) models.LoginAttempt {
+	return models.LoginAttempt{IP: ip, Device: device, Success: success, CreatedAt: now.Add(-ago)}
+}
+
+// loginsAt returns n successful logins from the usual ip and device, one a
+// day at the given hour.
+func loginsAt(hour, n int) []models.LoginAttempt {
+	history := make([]models.LoginAttempt, 0, n)
+	for i := 1; i <= n; i++ {
+		at := time.Date(2026, 3, 2-i, hour, 0, 0, 0, time.UTC)
+		history = append(history, models.LoginAttempt{IP: "10.0.0.1", Device: "laptop", Success: true, CreatedAt: at})
+	}
+	return history
+}
+
+func TestSignals(t *testing.T) {
+	failedAttempts := risk.FailedAttempts{Window: 15 * time.Minute, Limit: 5}
+	timeOfDay := risk.TimeOfDay{MinHistory: 3}
+	known := []models.LoginAttempt{login(time.Hour, true, "10.0.0.1", "laptop")}
+
+	for name, tt := range map[string]struct {
+		signal  risk.Signal
+		attempt risk.Attempt
+		want    float64
+	}{
+		"biometric perfect match":     {signal: risk.Biometric{}, attempt: risk.Attempt{BiometricScore: 1}, want: 0},
+		"biometric weak match":        {signal: risk.Biometric{}, attempt: risk.Attempt{BiometricScore: 0.25}, want: 0.75},
+		"biometric out of range":      {signal: risk.Biometric{}, attempt: risk.Attempt{BiometricScore: -1}, want: 1},
+		"no failed attempts":          {signal: failedAttempts, attempt: risk.Attempt{Time: now, History: known}, want: 0},
+		"some failed attempts":        {signal: failedAttempts, attempt: risk.Attempt{Time: now, History: []models.LoginAttempt{login(time.Minute, false, "", ""), login(2*time.Minute, false, "", "")}}, want: 0.4},
+		"failed attempts at limit":    {signal: failedAttempts, attempt: risk.Attempt{Time: now, History: failures(5, time.Minute)}, want: 1},
+		"failed attempts over limit":  {signal: failedAttempts, attempt: risk.Attempt{Time: now, History: failures(8, time.Minute)}, want: 1},
+		"failed attempts out of time": {signal: failedAttempts, attempt: risk.Attempt{Time: now, History: append(failures(1, time.Minute), failures(4, time.Hour)...)}, want: 0.2},
+		"no login history":            {signal: risk.NewDevice{}, attempt: risk.Attempt{IP: "10.0.0.9", Device: "phone"}, want: 0},
+		"only failed logins":          {signal: risk.NewDevice{}, attempt: risk.Attempt{IP: "10.0.0.9", Device: "phone", History: failures(3, time.Minute)}, want: 0},
+		"known ip and device":         {signal: risk.NewDevice{}, attempt: risk.Attempt{IP: "10.0.0.1", Device: "laptop", History: known}, want: 0},
+		"new ip":                      {signal: risk.NewDevice{}, attempt: risk.Attempt{IP: "10.0.0.9", Device: "laptop", History: known}, want: 0.5},
+		"new device":                  {signal: risk.NewDevice{}, attempt: risk.Attempt{IP: "10.0.0.1", Device: "phone", History: known}, want: 0.5},
+		"new ip and device":           {signal: risk.NewDevice{}, attempt: risk.Attempt{IP: "10.0.0.9", Device: "phone", History: known}, want: 1},
+		"too little history":          {signal: timeOfDay, attempt: risk.Attempt{Time: now, History: loginsAt(3, 2)}, want: 0},
+		"usual hour":                  {signal: timeOfDay, attempt: risk.Attempt{Time: now, History: loginsAt(14, 3)}, want: 0},
+		"neighbouring hour":           {signal: timeOfDay, attempt: risk.Attempt{Time: now, History: loginsAt(13, 3)}, want: 0},
+		"unusual hour":                {signal: timeOfDay, attempt: risk.Attempt{Time: now, History: loginsAt(3, 3)}, want: 1},
+		"around midnight":             {signal: timeOfDay, attempt: risk.Attempt{Time: time.Date(2026, 3, 2, 0, 15, 0, 0, time.UTC), History: loginsAt(23, 3)}, want: 0},
+		"low sensitivity":             {signal: risk.AppSensitivity{}, attempt: risk.Attempt{App: models.App{Sensitivity: models.SensitivityLow}}, want: 0},
+		"medium sensitivity":          {signal: risk.AppSensitivity{}, attempt: risk.Attempt{App: models.App{Sensitivity: models.SensitivityMedium}}, want: 0.5},
+		"high sensitivity":            {signal: risk.AppSensitivity{}, attempt: risk.Attempt{App: models.App{Sensitivity: models.SensitivityHigh}}, want: 1},
+	} {
+		t.Run(name, func(t *testing.T) {
+			got, reason := tt.signal.Evaluate(context.Background(), tt.attempt)
+			assert.InDelta(t, tt.want, got, 1e-9)
+			assert.NotEmpty(t, reason)
+		})
+	}
+}
+
+// failures returns n failed logins, the newest made ago before now.
+func failures(n int, ago time.Duration) []models.LoginAttempt {
+	history := make([]models.LoginAttempt, 0, n)
+	for i := 0; i < n; i++ {
+		history = append(history, login(ago+time.Duration(i)*time.Second, false, "10.0.0.1", "laptop"))
+	}
+	return history
+}
@@ -0,0 +1,64 @@
+package storage
+
+import (
+	"database/sql"
+	"sso/internal/lib/envelope"
+)
+
+// AppSecrets are the secrets of an app in the form stored in apps. Both are
+// encrypted with the same data key.
+type AppSecrets struct {
+	Secret   string
+	Previous sql.NullString
+	DataKey  []byte
+}
+
+// SealAppSecrets encrypts the secrets of an app with a fresh data key. An
+// empty previous secret is stored as NULL.
+func SealAppSecrets(keyring *envelope.Keyring, secret string, previous string) (AppSecrets, error) {
+	dataKey, wrappedKey, err := keyring.NewDataKey()
+	if err != nil {
+		return AppSecrets{}, err
+	}
+
+	s := AppSecrets{DataKey: wrappedKey}
+	s.Secret, err = envelope.Seal(dataKey, secret)
+	if err != nil {
+		return AppSecrets{}, err
+	}
+	if previous != "" {
+		s.Previous.String, err = envelope.Seal(dataKey, previous)
+		if err != nil {
+			return AppSecrets{}, err
+		}
+		s.Previous.Valid = true
+	}
+
+	return s, nil
+}
+
+// OpenAppSecrets decrypts the stored secrets of an app. Rows without a data
+// key predate encryption at rest and are read as plain text until they are
+// rewrapped or the secret is rotated.
+func OpenAppSecrets(keyring *envelope.Keyring, s AppSecrets) (secret string, previous string, err error) {
+	if s.DataKey == nil {
+		return s.Secret, s.Previous.String, nil
+	}
+
+	dataKey, err := keyring.Unwrap(s.DataKey)
+	if err != nil {
+		return "", "", err
+	}
+	secret, err = envelope.Open(dataKey, s.Secret)
+	if err != nil {
+		return "", "", err
+	}
+	if s.Previous.Valid {
+		previous, err = envelope.Open(dataKey, s.Previous.String)
+		if err != nil {
+			return "", "", err
+		}
+	}
+
+	return secret, previous, nil
+}
@@ -0,0 +1,790 @@
+package memory
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sso/internal/domain/models"
+	"sso/internal/storage"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Storage keeps everything in process memory. It is meant for unit tests
+// and local development: data is lost on restart and is not shared between
+// replicas. Templates never leave the process, so they are not encrypted.
+type Storage struct {
+	mu sync.RWMutex
+
+	lastUserID int64
+	users      map[int64]models.User
+	emails     map[string]int64
+
+	apps     map[int]models.App
+	sessions map[string]models.Session
+	attempts map[int64][]models.LoginAttempt
+	audit    []models.AuditEvent
+}
+
+func New() *Storage {
+	return &Storage{
+		users:    make(map[int64]models.User),
+		emails:   make(map[string]int64),
+		apps:     make(map[int]models.App),
+		sessions: make(map[string]models.Session),
+		attempts: make(map[int64][]models.LoginAttempt),
+	}
+}
+
+func (s *Storage) Close() error {
+	return nil
+}
+
+// tx collects what has to be undone when a transaction is rolled back.
+type tx struct {
+	undo []func()
+}
+
+type txKey struct{}
+
+// WithTx runs fn in a transaction. Writes made with the context passed to fn
+// are undone if fn returns an error. Transactions are atomic but not isolated:
+// other callers see their writes before fn returns. Nested calls join the
+// outer transaction.
+func (s *Storage) WithTx(ctx context.Context, fn func(ctx context.Context) error) error {
+	if _, ok := ctx.Value(txKey{}).(*tx); ok {
+		return fn(ctx)
+	}
+
+	t := &tx{}
+	if err := fn(context.WithValue(ctx, txKey{}, t)); err != nil {
+		s.mu.Lock()
+		for i := len(t.undo) - 1; i >= 0; i-- {
+			t.undo[i]()
+		}
+		s.mu.Unlock()
+		return err
+	}
+
+	return nil
+}
+
+// onRollback registers undo with the transaction of ctx, if any. It must be
+// called with s.mu held.
+func onRollback(ctx context.Context, undo func()) {
+	if t, ok := ctx.Value(txKey{}).(*tx); ok {
+		t.undo = append(t.undo, undo)
+	}
+}
+
+// SaveApp adds or replaces an app; it is how apps are seeded.
+func (s *Storage) SaveApp(ctx context.Context, app models.App) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	prev, existed := s.apps[app.ID]
+	onRollback(ctx, func() {
+		if existed {
+			s.apps[app.ID] = prev
+		} else {
+			delete(s.apps, app.ID)
+		}
+	})
+	s.apps[app.ID] = copyApp(app)
+	return nil
+}
+
+// SetAdmin grants or revokes admin rights of a user.
+func (s *Storage) SetAdmin(ctx context.Context, userID int64, isAdmin bool) error {
+	const op = "storage.memory.SetAdmin"
+
+	return s.updateUser(ctx, op, userID, func(user *models.User) error {
+		user.IsAdmin = isAdmin
+		return nil
+	})
+}
+
+func (s *Storage) IsAdmin(_ context.Context, userID int64) (bool, error) {
+	const op = "storage.memory.IsAdmin"
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	// Mirrors the sql backends, which report a missing user as a missing app.
+	user, ok := s.users[userID]
+	if !ok {
+		return false, fmt.Errorf("%s: %w", op, storage.ErrAppNotFound)
+	}
+	return user.IsAdmin, nil
+}
+
+func (s *Storage) App(_ context.Context, appID int) (models.App, error) {
+	const op = "storage.memory.App"
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	app, ok := s.apps[appID]
+	if !ok {
+		return models.App{}, fmt.Errorf("%s: %w", op, storage.ErrAppNotFound)
+	}
+	return copyApp(app), nil
+}
+
+// Apps returns every app ordered by ID.
+func (s *Storage) Apps(_ context.Context) ([]models.App, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	apps := make([]models.App, 0, len(s.apps))
+	for _, app := range s.apps {
+		apps = append(apps, copyApp(app))
+	}
+	sort.Slice(apps, func(i, j int) bool { return apps[i].ID < apps[j].ID })
+
+	return apps, nil
+}
+
+// CreateApp stores a new app and returns its ID; app.ID is ignored. It fails
+// with storage.ErrAppExists if the name is taken.
+func (s *Storage) CreateApp(ctx context.Context, app models.App) (int, error) {
+	const op = "storage.memory.CreateApp"
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	id := 0
+	for _, other := range s.apps {
+		if other.Name == app.Name {
+			return 0, fmt.Errorf("%s: %w", op, storage.ErrAppExists)
+		}
+		id = max(id, other.ID)
+	}
+	id++
+
+	app = copyApp(app)
+	app.ID = id
+	app.PreviousSecret, app.PreviousSecretExpiresAt = "", time.Time{}
+	app.CreatedAt = time.Now()
+	onRollback(ctx, func() { delete(s.apps, id) })
+	s.apps[id] = app
+
+	return id, nil
+}
+
+// UpdateApp replaces the name, login and token settings of the app.
+// Secrets are changed with SetAppSecrets.
+func (s *Storage) UpdateApp(ctx context.Context, app models.App) error {
+	const op = "storage.memory.UpdateApp"
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, other := range s.apps {
+		if other.ID != app.ID && other.Name == app.Name {
+			return fmt.Errorf("%s: %w", op, storage.ErrAppExists)
+		}
+	}
+
+	return s.updateApp(ctx, op, app.ID, func(stored *models.App) {
+		stored.Name = app.Name
+		stored.ContinuousAuthThreshold = app.ContinuousAuthThreshold
+		stored.Sensitivity = app.Sensitivity
+		stored.TokenTTL = app.TokenTTL
+		stored.Audience, stored.Issuer = app.Audience, app.Issuer
+		stored.ClaimsTemplate = copyClaims(app.ClaimsTemplate)
+	})
+}
+
+// SetAppDisabledAt disables the app, or enables it when disabledAt is nil.
+func (s *Storage) SetAppDisabledAt(ctx context.Context, appID int, disabledAt *time.Time) error {
+	const op = "storage.memory.SetAppDisabledAt"
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.updateApp(ctx, op, appID, func(app *models.App) {
+		app.DisabledAt = nil
+		if disabledAt != nil {
+			t := *disabledAt
+			app.DisabledAt = &t
+		}
+	})
+}
+
+// SetAppSecrets replaces the secrets of the app. An empty previous secret
+// clears it.
+func (s *Storage) SetAppSecrets(ctx context.Context, appID int, secret string, previous string, previousExpiresAt time.Time) error {
+	const op = "storage.memory.SetAppSecrets"
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.updateApp(ctx, op, appID, func(app *models.App) {
+		app.Secret = secret
+		app.PreviousSecret, app.PreviousSecretExpiresAt = previous, time.Time{}
+		if previous != "" {
+			app.PreviousSecretExpiresAt = previousExpiresAt
+		}
+	})
+}
+
+// updateApp applies update to the stored app; the change is undone if the
+// transaction of ctx is rolled back. It must be called with s.mu held.
+func (s *Storage) updateApp(ctx context.Context, op string, appID int, update func(app *models.App)) error {
+	app, ok := s.apps[appID]
+	if !ok {
+		return fmt.Errorf("%s: %w", op, storage.ErrAppNotFound)
+	}
+	prev := app
+	app = copyApp(app)
+	update(&app)
+	onRollback(ctx, func() { s.apps[appID] = prev })
+	s.apps[appID] = app
+
+	return nil
+}
+
+// DeleteApp removes the app with its sessions. Login attempts keep the ID
+// of the app for the risk signals.
+func (s *Storage) DeleteApp(ctx context.Context, appID int) error {
+	const op = "storage.memory.DeleteApp"
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	app, ok := s.apps[appID]
+	if !ok {
+		return fmt.Errorf("%s: %w", op, storage.ErrAppNotFound)
+	}
+	sessions := make(map[string]models.Session)
+	for id, session := range s.sessions {
+		if session.AppID == appID {
+			sessions[id] = session
+		}
+	}
+
+	onRollback(ctx, func() {
+		s.apps[appID] = app
+		for id, session := range sessions {
+			s.sessions[id] = session
+		}
+	})
+
+	delete(s.apps, appID)
+	for id := range sessions {
+		delete(s.sessions, id)
+	}
+
+	return nil
+}
+
+func (s *Storage) SaveUser(ctx context.Context, email string, passHash []byte, pressTimes []float32, intervalTimes []float32, keyEvents []models.KeyEvent) (int64, error) {
+	const op = "storage.memory.SaveUser"
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.emails[email]; ok {
+		return 0, fmt.Errorf("%s: %w", op, storage.ErrUserExists)
+	}
+
+	s.lastUserID++
+	id := s.lastUserID
+	s.users[id] = models.User{
+		ID:             id,
+		Email:          email,
+		PassHash:       append([]byte(nil), passHash...),
+		PressTimes:     append([]float32(nil), pressTimes...),
+		PressIntervals: append([]float32(nil), intervalTimes...),
+		KeyEvents:      append([]models.KeyEvent(nil), keyEvents...),
+		CreatedAt:      time.Now(),
+	}
+	s.emails[email] = id
+	onRollback(ctx, func() {
+		delete(s.users, id)
+		delete(s.emails, email)
+	})
+
+	return id, nil
+}
+
+func (s *Storage) User(_ context.Context, email string) (models.User, error) {
+	const op = "storage.memory.User"
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	id, ok := s.emails[email]
+	if !ok {
+		return models.User{}, fmt.Errorf("%s: %w", op, storage.ErrUserNotFound)
+	}
+	return copyUser(s.users[id]), nil
+}
+
+func (s *Storage) UserByID(_ context.Context, userID int64) (models.User, error) {
+	const op = "storage.memory.UserByID"
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	user, ok := s.users[userID]
+	if !ok {
+		return models.User{}, fmt.Errorf("%s: %w", op, storage.ErrUserNotFound)
+	}
+	return copyUser(user), nil
+}
+
+// UpdateBiometrics replaces the user's keystroke template and clears a
+// pending enrollment.
+func (s *Storage) UpdateBiometrics(ctx context.Context, userID int64, pressTimes []float32, intervalTimes []float32, keyEvents []models.KeyEvent) error {
+	const op = "storage.memory.UpdateBiometrics"
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	user, ok := s.users[userID]
+	if !ok {
+		return fmt.Errorf("%s: %w", op, storage.ErrUserNotFound)
+	}
+	prev := user
+	onRollback(ctx, func() { s.users[userID] = prev })
+
+	user.PressTimes = append([]float32(nil), pressTimes...)
+	user.PressIntervals = append([]float32(nil), intervalTimes...)
+	user.KeyEvents = append([]models.KeyEvent(nil), keyEvents...)
+	user.EnrollmentPending = false
+	s.users[userID] = user
+
+	return nil
+}
+
+// SetEnrollmentPending marks the user's keystroke template as stale, so the
+// next successful login enrolls a new one.
+func (s *Storage) SetEnrollmentPending(ctx context.Context, userID int64) error {
+	const op = "storage.memory.SetEnrollmentPending"
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	user, ok := s.users[userID]
+	if !ok {
+		return fmt.Errorf("%s: %w", op, storage.ErrUserNotFound)
+	}
+	prev := user
+	onRollback(ctx, func() { s.users[userID] = prev })
+
+	user.EnrollmentPending = true
+	s.users[userID] = user
+
+	return nil
+}
+
+// DeleteUser removes the user with the keystroke template, sessions and
+// login history.
+func (s *Storage) DeleteUser(ctx context.Context, userID int64) error {
+	const op = "storage.memory.DeleteUser"
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	user, ok := s.users[userID]
+	if !ok {
+		return fmt.Errorf("%s: %w", op, storage.ErrUserNotFound)
+	}
+	attempts := s.attempts[userID]
+	sessions := make(map[string]models.Session)
+	for id, session := range s.sessions {
+		if session.UserID == userID {
+			sessions[id] = session
+		}
+	}
+
+	onRollback(ctx, func() {
+		s.users[userID] = user
+		s.emails[user.Email] = userID
+		s.attempts[userID] = attempts
+		for id, session := range sessions {
+			s.sessions[id] = session
+		}
+	})
+
+	delete(s.users, userID)
+	delete(s.emails, user.Email)
+	delete(s.attempts, userID)
+	for id := range sessions {
+		delete(s.sessions, id)
+	}
+
+	return nil
+}
+
+// UpdateProfile replaces the profile of the user.
+func (s *Storage) UpdateProfile(ctx context.Context, userID int64, profile models.Profile) error {
+	const op = "storage.memory.UpdateProfile"
+
+	return s.updateUser(ctx, op, userID, func(user *models.User) error {
+		user.Profile = profile
+		return nil
+	})
+}
+
+func (s *Storage) UpdatePassword(ctx context.Context, userID int64, passHash []byte) error {
+	const op = "storage.memory.UpdatePassword"
+
+	return s.updateUser(ctx, op, userID, func(user *models.User) error {
+		user.PassHash = append([]byte(nil), passHash...)
+		return nil
+	})
+}
+
+// SetEmail changes the email address of the user; it fails with
+// storage.ErrUserExists if another user has the address.
+func (s *Storage) SetEmail(ctx context.Context, userID int64, email string) error {
+	const op = "storage.memory.SetEmail"
+
+	return s.updateUser(ctx, op, userID, func(user *models.User) error {
+		if id, ok := s.emails[email]; ok && id != userID {
+			return storage.ErrUserExists
+		}
+
+		prev := user.Email
+		onRollback(ctx, func() {
+			delete(s.emails, email)
+			s.emails[prev] = userID
+		})
+		delete(s.emails, prev)
+		s.emails[email] = userID
+		user.Email = email
+		return nil
+	})
+}
+
+// SetEmailChange stores a requested change of the email address, or clears
+// it when change is nil.
+func (s *Storage) SetEmailChange(ctx context.Context, userID int64, change *models.EmailChange) error {
+	const op = "storage.memory.SetEmailChange"
+
+	return s.updateUser(ctx, op, userID, func(user *models.User) error {
+		user.EmailChange = copyEmailChange(change)
+		return nil
+	})
+}
+
+// updateUser applies update to the stored user; the change is undone if the
+// transaction of ctx is rolled back.
+func (s *Storage) updateUser(ctx context.Context, op string, userID int64, update func(user *models.User) error) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	user, ok := s.users[userID]
+	if !ok {
+		return fmt.Errorf("%s: %w", op, storage.ErrUserNotFound)
+	}
+	prev := user
+	if err := update(&user); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	onRollback(ctx, func() { s.users[userID] = prev })
+	s.users[userID] = user
+
+	return nil
+}
+
+// SetDisabledAt disables the user, or enables it when disabledAt is nil.
+func (s *Storage) SetDisabledAt(ctx context.Context, userID int64, disabledAt *time.Time) error {
+	const op = "storage.memory.SetDisabledAt"
+
+	return s.updateUser(ctx, op, userID, func(user *models.User) error {
+		user.DisabledAt = nil
+		if disabledAt != nil {
+			t := *disabledAt
+			user.DisabledAt = &t
+		}
+		return nil
+	})
+}
+
+// ListUsers returns the users matching filter ordered by ID. Keystroke
+// templates are left out, as by the sql backends.
+func (s *Storage) ListUsers(_ context.Context, filter models.UserFilter) ([]models.User, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var users []models.User
+	for _, user := range s.users {
+		if matchUser(user, filter) {
+			user = copyUser(user)
+			user.PressTimes, user.PressIntervals, user.KeyEvents = nil, nil, nil
+			users = append(users, user)
+		}
+	}
+	sort.Slice(users, func(i, j int) bool { return users[i].ID < users[j].ID })
+	if filter.Limit > 0 && len(users) > filter.Limit {
+		users = users[:filter.Limit]
+	}
+
+	return users, nil
+}
+
+func matchUser(user models.User, filter models.UserFilter) bool {
+	switch {
+	case user.ID <= filter.AfterID,
+		!strings.HasPrefix(user.Email, filter.EmailPrefix),
+		filter.IsAdmin != nil && user.IsAdmin != *filter.IsAdmin,
+		filter.Disabled != nil && (user.DisabledAt != nil) != *filter.Disabled:
+		return false
+	}
+	// users without a creation time are outside of every range, as NULLs
+	// are in sql
+	if !filter.CreatedFrom.IsZero() && (user.CreatedAt.IsZero() || user.CreatedAt.Before(filter.CreatedFrom)) {
+		return false
+	}
+	if !filter.CreatedTo.IsZero() && (user.CreatedAt.IsZero() || !user.CreatedAt.Before(filter.CreatedTo)) {
+		return false
+	}
+	return true
+}
+
+// SetDeleteAfter schedules the deletion of the user, or cancels it when
+// deleteAfter is nil.
+func (s *Storage) SetDeleteAfter(ctx context.Context, userID int64, deleteAfter *time.Time) error {
+	const op = "storage.memory.SetDeleteAfter"
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	user, ok := s.users[userID]
+	if !ok {
+		return fmt.Errorf("%s: %w", op, storage.ErrUserNotFound)
+	}
+	prev := user
+	onRollback(ctx, func() { s.users[userID] = prev })
+
+	user.DeleteAfter = nil
+	if deleteAfter != nil {
+		t := *deleteAfter
+		user.DeleteAfter = &t
+	}
+	s.users[userID] = user
+
+	return nil
+}
+
+// UsersDueForDeletion returns the users whose deletion is scheduled at or
+// before now.
+func (s *Storage) UsersDueForDeletion(_ context.Context, now time.Time) ([]int64, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var ids []int64
+	for id, user := range s.users {
+		if user.DeleteAfter != nil && !user.DeleteAfter.After(now) {
+			ids = append(ids, id)
+		}
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+
+	return ids, nil
+}
+
+func (s *Storage) SaveSession(ctx context.Context, session models.Session) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	prev, existed := s.sessions[session.ID]
+	onRollback(ctx, func() {
+		if existed {
+			s.sessions[session.ID] = prev
+		} else {
+			delete(s.sessions, session.ID)
+		}
+	})
+
+	session.RevokedAt = nil
+	s.sessions[session.ID] = session
+	return nil
+}
+
+func (s *Storage) Session(_ context.Context, sessionID string) (models.Session, error) {
+	const op = "storage.memory.Session"
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	session, ok := s.sessions[sessionID]
+	if !ok {
+		return models.Session{}, fmt.Errorf("%s: %w", op, storage.ErrSessionNotFound)
+	}
+	if session.RevokedAt != nil {
+		revokedAt := *session.RevokedAt
+		session.RevokedAt = &revokedAt
+	}
+	return session, nil
+}
+
+func (s *Storage) RevokeSession(ctx context.Context, sessionID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	session, ok := s.sessions[sessionID]
+	if !ok || session.RevokedAt != nil {
+		return nil
+	}
+	onRollback(ctx, func() { s.sessions[sessionID] = session })
+
+	now := time.Now()
+	session.RevokedAt = &now
+	s.sessions[sessionID] = session
+
+	return nil
+}
+
+// UserSessions returns every session of the user, newest first.
+func (s *Storage) UserSessions(_ context.Context, userID int64) ([]models.Session, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var sessions []models.Session
+	for _, session := range s.sessions {
+		if session.UserID != userID {
+			continue
+		}
+		if session.RevokedAt != nil {
+			revokedAt := *session.RevokedAt
+			session.RevokedAt = &revokedAt
+		}
+		sessions = append(sessions, session)
+	}
+	sort.Slice(sessions, func(i, j int) bool {
+		return sessions[i].CreatedAt.After(sessions[j].CreatedAt)
+	})
+
+	return sessions, nil
+}
+
+// RevokeUserSessions revokes every active session of the user but
+// exceptSessionID, which may be empty.
+func (s *Storage) RevokeUserSessions(ctx context.Context, userID int64, exceptSessionID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	for id, session := range s.sessions {
+		if session.UserID != userID || session.RevokedAt != nil || id == exceptSessionID {
+			continue
+		}
+		prev := session
+		onRollback(ctx, func() { s.sessions[id] = prev })
+
+		session.RevokedAt = &now
+		s.sessions[id] = session
+	}
+
+	return nil
+}
+
+func (s *Storage) SaveLoginAttempt(ctx context.Context, attempt models.LoginAttempt) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	n := len(s.attempts[attempt.UserID])
+	onRollback(ctx, func() { s.attempts[attempt.UserID] = s.attempts[attempt.UserID][:n] })
+
+	s.attempts[attempt.UserID] = append(s.attempts[attempt.UserID], attempt)
+	return nil
+}
+
+// LoginAttempts returns the user's attempts since the given time, newest first.
+func (s *Storage) LoginAttempts(_ context.Context, userID int64, since time.Time) ([]models.LoginAttempt, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var attempts []models.LoginAttempt
+	for _, a := range s.attempts[userID] {
+		if !a.CreatedAt.Before(since) {
+			attempts = append(attempts, a)
+		}
+	}
+	sort.SliceStable(attempts, func(i, j int) bool {
+		return attempts[i].CreatedAt.After(attempts[j].CreatedAt)
+	})
+
+	return attempts, nil
+}
+
+func (s *Storage) SaveAuditEvent(ctx context.Context, event models.AuditEvent) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	n := len(s.audit)
+	onRollback(ctx, func() { s.audit = s.audit[:n] })
+
+	s.audit = append(s.audit, event)
+	return nil
+}
+
+// AuditEvents returns the events about the user, oldest first. They are
+// kept after the user is deleted.
+func (s *Storage) AuditEvents(_ context.Context, userID int64) ([]models.AuditEvent, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var events []models.AuditEvent
+	for _, e := range s.audit {
+		if e.UserID == userID {
+			events = append(events, e)
+		}
+	}
+	sort.SliceStable(events, func(i, j int) bool {
+		return events[i].CreatedAt.Before(events[j].CreatedAt)
+	})
+
+	return events, nil
+}
+
+func copyUser(user models.User) models.User {
+	user.PassHash = append([]byte(nil), user.PassHash...)
+	user.PressTimes = append([]float32(nil), user.PressTimes...)
+	user.PressIntervals = append([]float32(nil), user.PressIntervals...)
+	if user.KeyEvents != nil {
+		user.KeyEvents = append([]models.KeyEvent(nil), user.KeyEvents...)
+	}
+	if user.DeleteAfter != nil {
+		deleteAfter := *user.DeleteAfter
+		user.DeleteAfter = &deleteAfter
+	}
+	if user.DisabledAt != nil {
+		disabledAt := *user.DisabledAt
+		user.DisabledAt = &disabledAt
+	}
+	user.EmailChange = copyEmailChange(user.EmailChange)
+	return user
+}
+
+func copyApp(app models.App) models.App {
+	if app.DisabledAt != nil {
+		t := *app.DisabledAt
+		app.DisabledAt = &t
+	}
+	app.ClaimsTemplate = copyClaims(app.ClaimsTemplate)
+	return app
+}
+
+// copyClaims keeps a nil template nil, as it selects the default one.
+func copyClaims(claims []string) []string {
+	if claims == nil {
+		return nil
+	}
+	return append([]string{}, claims...)
+}
+
+func copyEmailChange(change *models.EmailChange) *models.EmailChange {
+	if change == nil {
+		return nil
+	}
+	c := *change
+	c.CodeHash = append([]byte(nil), change.CodeHash...)
+	return &c
+}
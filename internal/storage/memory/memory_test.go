@@ -0,0 +1,13 @@
+package memory_test
+
+import (
+	"sso/internal/storage/memory"
+	"sso/internal/storage/storagetest"
+	"testing"
+)
+
+func TestConformance(t *testing.T) {
+	storagetest.Run(t, func(t *testing.T) storagetest.Storage {
+		return memory.New()
+	})
+}
@@ -0,0 +1,1079 @@
+package postgres
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"sso/internal/domain/models"
+	"sso/internal/lib/envelope"
+	"sso/internal/storage"
+	"strings"
+	"time"
+)
+
+// uniqueViolation is the SQLSTATE of a unique constraint violation.
+const uniqueViolation = "23505"
+
+type Storage struct {
+	pool    *pgxpool.Pool
+	keyring *envelope.Keyring
+}
+
+// querier is implemented by both *pgxpool.Pool and pgx.Tx.
+type querier interface {
+	Exec(ctx context.Context, sql string, arguments ...any) (pgconn.CommandTag, error)
+	Query(ctx context.Context, sql string, args ...any) (pgx.Rows, error)
+	QueryRow(ctx context.Context, sql string, args ...any) pgx.Row
+}
+
+type txKey struct{}
+
+// WithTx runs fn in a transaction. Storage calls made with the context passed
+// to fn take part in it; the transaction is rolled back if fn returns an
+// error and committed otherwise. Nested calls join the outer transaction.
+func (s *Storage) WithTx(ctx context.Context, fn func(ctx context.Context) error) error {
+	const op = "storage.postgres.WithTx"
+
+	if _, ok := ctx.Value(txKey{}).(pgx.Tx); ok {
+		return fn(ctx)
+	}
+
+	tx, err := s.pool.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	defer func() { _ = tx.Rollback(ctx) }()
+
+	if err := fn(context.WithValue(ctx, txKey{}, tx)); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+// conn returns the transaction of ctx, if any, or the pool.
+func (s *Storage) conn(ctx context.Context) querier {
+	if tx, ok := ctx.Value(txKey{}).(pgx.Tx); ok {
+		return tx
+	}
+	return s.pool
+}
+
+// New connects to postgres. Unlike sqlite the database is shared by all SSO
+// replicas, so the schema must be migrated with migrations/postgres.
+// Biometric templates are encrypted with per-user data keys wrapped by the
+// given keyring.
+func New(ctx context.Context, dsn string, keyring *envelope.Keyring) (*Storage, error) {
+	const op = "storage.postgres.New"
+
+	pool, err := pgxpool.New(ctx, dsn)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	if err := pool.Ping(ctx); err != nil {
+		pool.Close()
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return &Storage{
+		pool:    pool,
+		keyring: keyring,
+	}, nil
+}
+
+func (s *Storage) Close() error {
+	s.pool.Close()
+	return nil
+}
+
+func (s *Storage) IsAdmin(ctx context.Context, userID int64) (bool, error) {
+	const op = "storage.postgres.IsAdmin"
+
+	var isAdmin bool
+	err := s.conn(ctx).QueryRow(ctx, "SELECT is_admin FROM users WHERE id = $1", userID).Scan(&isAdmin)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return false, fmt.Errorf("%s: %w", op, storage.ErrAppNotFound)
+		}
+		return false, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return isAdmin, nil
+}
+
+// appColumns are the apps columns scanned by Storage.scanApp.
+const appColumns = "id, name, secret, previous_secret, previous_secret_expires_at, data_key, continuous_auth_threshold, sensitivity, created_at, disabled_at, token_ttl_seconds, audience, issuer, claims_template"
+
+func (s *Storage) App(ctx context.Context, appID int) (models.App, error) {
+	const op = "storage.postgres.App"
+
+	var app models.App
+	err := s.scanApp(s.conn(ctx).QueryRow(ctx, "SELECT "+appColumns+" FROM apps WHERE id = $1", appID), &app)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return models.App{}, fmt.Errorf("%s: %w", op, storage.ErrAppNotFound)
+		}
+		return models.App{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return app, nil
+}
+
+// Apps returns every app ordered by ID.
+func (s *Storage) Apps(ctx context.Context) ([]models.App, error) {
+	const op = "storage.postgres.Apps"
+
+	rows, err := s.conn(ctx).Query(ctx, "SELECT "+appColumns+" FROM apps ORDER BY id")
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	defer rows.Close()
+
+	var apps []models.App
+	for rows.Next() {
+		var app models.App
+		if err := s.scanApp(rows, &app); err != nil {
+			return nil, fmt.Errorf("%s: %w", op, err)
+		}
+		apps = append(apps, app)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return apps, nil
+}
+
+// scanApp scans a row of appColumns and decrypts the secrets.
+func (s *Storage) scanApp(row pgx.Row, app *models.App) error {
+	var secrets storage.AppSecrets
+	var previousExpiresAt, createdAt *time.Time
+	var tokenTTL *int64
+	var audience, issuer *string
+	err := row.Scan(&app.ID, &app.Name, &secrets.Secret, &secrets.Previous, &previousExpiresAt, &secrets.DataKey,
+		&app.ContinuousAuthThreshold, &app.Sensitivity, &createdAt, &app.DisabledAt,
+		&tokenTTL, &audience, &issuer, &app.ClaimsTemplate)
+	if err != nil {
+		return err
+	}
+
+	if tokenTTL != nil {
+		app.TokenTTL = time.Duration(*tokenTTL) * time.Second
+	}
+	if audience != nil {
+		app.Audience = *audience
+	}
+	if issuer != nil {
+		app.Issuer = *issuer
+	}
+
+	app.Secret, app.PreviousSecret, err = storage.OpenAppSecrets(s.keyring, secrets)
+	if err != nil {
+		return fmt.Errorf("app %d: %w", app.ID, err)
+	}
+	if previousExpiresAt != nil {
+		app.PreviousSecretExpiresAt = *previousExpiresAt
+	}
+	if createdAt != nil {
+		app.CreatedAt = *createdAt
+	}
+	return nil
+}
+
+// appTokenSettings returns the token_ttl_seconds, audience and issuer
+// values of the app; unset settings are NULL. A nil claims template is
+// stored as NULL by pgx.
+func appTokenSettings(app models.App) (tokenTTL *int64, audience *string, issuer *string) {
+	if app.TokenTTL > 0 {
+		seconds := int64(app.TokenTTL / time.Second)
+		tokenTTL = &seconds
+	}
+	if app.Audience != "" {
+		audience = &app.Audience
+	}
+	if app.Issuer != "" {
+		issuer = &app.Issuer
+	}
+	return tokenTTL, audience, issuer
+}
+
+// CreateApp stores a new app with an encrypted secret and returns its ID;
+// app.ID is ignored. It fails with storage.ErrAppExists if the name is
+// taken.
+func (s *Storage) CreateApp(ctx context.Context, app models.App) (int, error) {
+	const op = "storage.postgres.CreateApp"
+
+	secrets, err := storage.SealAppSecrets(s.keyring, app.Secret, "")
+	if err != nil {
+		return 0, fmt.Errorf("%s: %w", op, err)
+	}
+
+	tokenTTL, audience, issuer := appTokenSettings(app)
+
+	var id int
+	err = s.conn(ctx).QueryRow(ctx, `
+		INSERT INTO apps (name, secret, data_key, continuous_auth_threshold, sensitivity, created_at,
+		                  token_ttl_seconds, audience, issuer, claims_template)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)
+		RETURNING id`,
+		app.Name, secrets.Secret, secrets.DataKey, app.ContinuousAuthThreshold, app.Sensitivity, time.Now(),
+		tokenTTL, audience, issuer, app.ClaimsTemplate,
+	).Scan(&id)
+	if err != nil {
+		var pgErr *pgconn.PgError
+		if errors.As(err, &pgErr) && pgErr.Code == uniqueViolation {
+			return 0, fmt.Errorf("%s: %w", op, storage.ErrAppExists)
+		}
+		return 0, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return id, nil
+}
+
+// UpdateApp replaces the name, login and token settings of the app.
+// Secrets are changed with SetAppSecrets.
+func (s *Storage) UpdateApp(ctx context.Context, app models.App) error {
+	const op = "storage.postgres.UpdateApp"
+
+	tokenTTL, audience, issuer := appTokenSettings(app)
+	tag, err := s.conn(ctx).Exec(ctx, `
+		UPDATE apps
+		SET name = $1, continuous_auth_threshold = $2, sensitivity = $3,
+		    token_ttl_seconds = $4, audience = $5, issuer = $6, claims_template = $7
+		WHERE id = $8`,
+		app.Name, app.ContinuousAuthThreshold, app.Sensitivity, tokenTTL, audience, issuer, app.ClaimsTemplate, app.ID)
+	if err != nil {
+		var pgErr *pgconn.PgError
+		if errors.As(err, &pgErr) && pgErr.Code == uniqueViolation {
+			return fmt.Errorf("%s: %w", op, storage.ErrAppExists)
+		}
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	if tag.RowsAffected() == 0 {
+		return fmt.Errorf("%s: %w", op, storage.ErrAppNotFound)
+	}
+
+	return nil
+}
+
+// SetAppDisabledAt disables the app, or enables it when disabledAt is nil.
+func (s *Storage) SetAppDisabledAt(ctx context.Context, appID int, disabledAt *time.Time) error {
+	const op = "storage.postgres.SetAppDisabledAt"
+
+	tag, err := s.conn(ctx).Exec(ctx, "UPDATE apps SET disabled_at = $1 WHERE id = $2", disabledAt, appID)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	if tag.RowsAffected() == 0 {
+		return fmt.Errorf("%s: %w", op, storage.ErrAppNotFound)
+	}
+
+	return nil
+}
+
+// SetAppSecrets replaces the secrets of the app. An empty previous secret
+// clears it.
+func (s *Storage) SetAppSecrets(ctx context.Context, appID int, secret string, previous string, previousExpiresAt time.Time) error {
+	const op = "storage.postgres.SetAppSecrets"
+
+	secrets, err := storage.SealAppSecrets(s.keyring, secret, previous)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	var expiresAt *time.Time
+	if secrets.Previous.Valid {
+		expiresAt = &previousExpiresAt
+	}
+
+	tag, err := s.conn(ctx).Exec(ctx,
+		"UPDATE apps SET secret = $1, previous_secret = $2, previous_secret_expires_at = $3, data_key = $4 WHERE id = $5",
+		secrets.Secret, secrets.Previous, expiresAt, secrets.DataKey, appID)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	if tag.RowsAffected() == 0 {
+		return fmt.Errorf("%s: %w", op, storage.ErrAppNotFound)
+	}
+
+	return nil
+}
+
+// DeleteApp removes the app; its sessions are deleted by the foreign key.
+// Login attempts keep the ID of the app for the risk signals.
+func (s *Storage) DeleteApp(ctx context.Context, appID int) error {
+	const op = "storage.postgres.DeleteApp"
+
+	tag, err := s.conn(ctx).Exec(ctx, "DELETE FROM apps WHERE id = $1", appID)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	if tag.RowsAffected() == 0 {
+		return fmt.Errorf("%s: %w", op, storage.ErrAppNotFound)
+	}
+
+	return nil
+}
+
+func (s *Storage) SaveUser(ctx context.Context, email string, passHash []byte, pressTimes []float32, intervalTimes []float32, keyEvents []models.KeyEvent) (int64, error) {
+	const op = "storage.postgres.SaveUser"
+
+	dataKey, wrappedKey, err := s.keyring.NewDataKey()
+	if err != nil {
+		return 0, fmt.Errorf("%s: %w", op, err)
+	}
+	t, err := storage.SealTemplate(dataKey, pressTimes, intervalTimes, keyEvents)
+	if err != nil {
+		return 0, fmt.Errorf("%s: %w", op, err)
+	}
+
+	var id int64
+	err = s.WithTx(ctx, func(ctx context.Context) error {
+		tx := s.conn(ctx)
+
+		err := tx.QueryRow(ctx, "INSERT INTO users (email, pass_hash, created_at) VALUES ($1, $2, $3) RETURNING id", email, passHash, time.Now()).Scan(&id)
+		if err != nil {
+			var pgErr *pgconn.PgError
+			if errors.As(err, &pgErr) && pgErr.Code == uniqueViolation {
+				return fmt.Errorf("%s: %w", op, storage.ErrUserExists)
+			}
+			return fmt.Errorf("%s: %w", op, err)
+		}
+
+		_, err = tx.Exec(ctx,
+			"INSERT INTO key_press_data (user_id, key_press_intervals, key_press_times, key_events, data_key) VALUES ($1, $2, $3, $4, $5)",
+			id, t.Intervals, t.Times, t.KeyEvents, wrappedKey)
+		if err != nil {
+			return fmt.Errorf("%s: %w", op, err)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return id, nil
+}
+
+func (s *Storage) User(ctx context.Context, email string) (models.User, error) {
+	const op = "storage.postgres.User"
+
+	user, err := s.user(ctx, "u.email = $1", email)
+	if err != nil {
+		return models.User{}, fmt.Errorf("%s: %w", op, err)
+	}
+	return user, nil
+}
+
+func (s *Storage) UserByID(ctx context.Context, userID int64) (models.User, error) {
+	const op = "storage.postgres.UserByID"
+
+	user, err := s.user(ctx, "u.id = $1", userID)
+	if err != nil {
+		return models.User{}, fmt.Errorf("%s: %w", op, err)
+	}
+	return user, nil
+}
+
+// userColumns are the users columns scanned by scanUser, for a query
+// aliasing users as u.
+const userColumns = `u.id, u.email, u.pass_hash, u.is_admin, u.created_at, u.disabled_at, u.enrollment_pending, u.delete_after,
+       u.display_name, u.locale, u.timezone, u.pending_email, u.email_code_hash, u.email_code_expires_at`
+
+// user reads the user matching where together with the decrypted keystroke
+// template.
+func (s *Storage) user(ctx context.Context, where string, key any) (models.User, error) {
+	var user models.User
+	var t storage.Template
+	var wrappedKey []byte
+	err := scanUser(s.conn(ctx).QueryRow(ctx, `
+		SELECT `+userColumns+`,
+		       k.key_press_intervals, k.key_press_times, k.key_events, k.data_key
+		FROM users u
+		JOIN key_press_data k ON k.user_id = u.id
+		WHERE `+where, key,
+	), &user, &t.Intervals, &t.Times, &t.KeyEvents, &wrappedKey)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return models.User{}, storage.ErrUserNotFound
+		}
+		return models.User{}, err
+	}
+
+	user.PressTimes, user.PressIntervals, user.KeyEvents, err = storage.OpenTemplate(s.keyring, wrappedKey, t)
+	if err != nil {
+		return models.User{}, err
+	}
+	return user, nil
+}
+
+// scanUser scans a row of userColumns followed by the extra columns.
+func scanUser(row pgx.Row, user *models.User, extra ...any) error {
+	var createdAt *time.Time
+	var pendingEmail *string
+	var codeHash []byte
+	var codeExpiresAt *time.Time
+	dest := append([]any{&user.ID, &user.Email, &user.PassHash, &user.IsAdmin, &createdAt, &user.DisabledAt, &user.EnrollmentPending, &user.DeleteAfter,
+		&user.DisplayName, &user.Locale, &user.Timezone, &pendingEmail, &codeHash, &codeExpiresAt}, extra...)
+	if err := row.Scan(dest...); err != nil {
+		return err
+	}
+
+	if createdAt != nil {
+		user.CreatedAt = *createdAt
+	}
+	if pendingEmail != nil {
+		user.EmailChange = &models.EmailChange{Email: *pendingEmail, CodeHash: codeHash}
+		if codeExpiresAt != nil {
+			user.EmailChange.ExpiresAt = *codeExpiresAt
+		}
+	}
+	return nil
+}
+
+// ListUsers returns the users matching filter ordered by ID. Keystroke
+// templates are not read: listings are for admins, who never see them.
+func (s *Storage) ListUsers(ctx context.Context, filter models.UserFilter) ([]models.User, error) {
+	const op = "storage.postgres.ListUsers"
+
+	args := []any{filter.AfterID}
+	where := []string{"u.id > $1"}
+	add := func(cond string, arg any) {
+		args = append(args, arg)
+		where = append(where, fmt.Sprintf(cond, len(args)))
+	}
+	if filter.EmailPrefix != "" {
+		add("starts_with(u.email, $%d)", filter.EmailPrefix)
+	}
+	if filter.IsAdmin != nil {
+		add("u.is_admin = $%d", *filter.IsAdmin)
+	}
+	if filter.Disabled != nil {
+		add("(u.disabled_at IS NOT NULL) = $%d", *filter.Disabled)
+	}
+	if !filter.CreatedFrom.IsZero() {
+		add("u.created_at >= $%d", filter.CreatedFrom)
+	}
+	if !filter.CreatedTo.IsZero() {
+		add("u.created_at < $%d", filter.CreatedTo)
+	}
+	query := "SELECT " + userColumns + " FROM users u WHERE " + strings.Join(where, " AND ") + " ORDER BY u.id"
+	if filter.Limit > 0 {
+		args = append(args, filter.Limit)
+		query += fmt.Sprintf(" LIMIT $%d", len(args))
+	}
+
+	rows, err := s.conn(ctx).Query(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	defer rows.Close()
+
+	var users []models.User
+	for rows.Next() {
+		var user models.User
+		if err := scanUser(rows, &user); err != nil {
+			return nil, fmt.Errorf("%s: %w", op, err)
+		}
+		users = append(users, user)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return users, nil
+}
+
+// UpdateBiometrics replaces the user's keystroke template with a freshly
+// encrypted one and clears a pending enrollment.
+func (s *Storage) UpdateBiometrics(ctx context.Context, userID int64, pressTimes []float32, intervalTimes []float32, keyEvents []models.KeyEvent) error {
+	const op = "storage.postgres.UpdateBiometrics"
+
+	dataKey, wrappedKey, err := s.keyring.NewDataKey()
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	t, err := storage.SealTemplate(dataKey, pressTimes, intervalTimes, keyEvents)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return s.WithTx(ctx, func(ctx context.Context) error {
+		tx := s.conn(ctx)
+
+		tag, err := tx.Exec(ctx, "UPDATE users SET enrollment_pending = FALSE WHERE id = $1", userID)
+		if err != nil {
+			return fmt.Errorf("%s: %w", op, err)
+		}
+		if tag.RowsAffected() == 0 {
+			return fmt.Errorf("%s: %w", op, storage.ErrUserNotFound)
+		}
+
+		_, err = tx.Exec(ctx, `
+			INSERT INTO key_press_data (user_id, key_press_intervals, key_press_times, key_events, data_key)
+			VALUES ($1, $2, $3, $4, $5)
+			ON CONFLICT (user_id) DO UPDATE
+			SET key_press_intervals = EXCLUDED.key_press_intervals,
+			    key_press_times     = EXCLUDED.key_press_times,
+			    key_events          = EXCLUDED.key_events,
+			    data_key            = EXCLUDED.data_key`,
+			userID, t.Intervals, t.Times, t.KeyEvents, wrappedKey)
+		if err != nil {
+			return fmt.Errorf("%s: %w", op, err)
+		}
+
+		return nil
+	})
+}
+
+// DeleteUser removes the user; the keystroke template, sessions and login
+// history go with it through ON DELETE CASCADE.
+func (s *Storage) DeleteUser(ctx context.Context, userID int64) error {
+	const op = "storage.postgres.DeleteUser"
+
+	tag, err := s.conn(ctx).Exec(ctx, "DELETE FROM users WHERE id = $1", userID)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	if tag.RowsAffected() == 0 {
+		return fmt.Errorf("%s: %w", op, storage.ErrUserNotFound)
+	}
+
+	return nil
+}
+
+// SetEnrollmentPending marks the user's keystroke template as stale, so the
+// next successful login enrolls a new one.
+func (s *Storage) SetEnrollmentPending(ctx context.Context, userID int64) error {
+	const op = "storage.postgres.SetEnrollmentPending"
+
+	tag, err := s.conn(ctx).Exec(ctx, "UPDATE users SET enrollment_pending = TRUE WHERE id = $1", userID)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	if tag.RowsAffected() == 0 {
+		return fmt.Errorf("%s: %w", op, storage.ErrUserNotFound)
+	}
+
+	return nil
+}
+
+// UpdateProfile replaces the profile of the user.
+func (s *Storage) UpdateProfile(ctx context.Context, userID int64, profile models.Profile) error {
+	const op = "storage.postgres.UpdateProfile"
+
+	tag, err := s.conn(ctx).Exec(ctx,
+		"UPDATE users SET display_name = $1, locale = $2, timezone = $3 WHERE id = $4",
+		profile.DisplayName, profile.Locale, profile.Timezone, userID)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	if tag.RowsAffected() == 0 {
+		return fmt.Errorf("%s: %w", op, storage.ErrUserNotFound)
+	}
+
+	return nil
+}
+
+func (s *Storage) UpdatePassword(ctx context.Context, userID int64, passHash []byte) error {
+	const op = "storage.postgres.UpdatePassword"
+
+	tag, err := s.conn(ctx).Exec(ctx, "UPDATE users SET pass_hash = $1 WHERE id = $2", passHash, userID)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	if tag.RowsAffected() == 0 {
+		return fmt.Errorf("%s: %w", op, storage.ErrUserNotFound)
+	}
+
+	return nil
+}
+
+// SetEmail changes the email address of the user; it fails with
+// storage.ErrUserExists if another user has the address.
+func (s *Storage) SetEmail(ctx context.Context, userID int64, email string) error {
+	const op = "storage.postgres.SetEmail"
+
+	tag, err := s.conn(ctx).Exec(ctx, "UPDATE users SET email = $1 WHERE id = $2", email, userID)
+	if err != nil {
+		var pgErr *pgconn.PgError
+		if errors.As(err, &pgErr) && pgErr.Code == uniqueViolation {
+			return fmt.Errorf("%s: %w", op, storage.ErrUserExists)
+		}
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	if tag.RowsAffected() == 0 {
+		return fmt.Errorf("%s: %w", op, storage.ErrUserNotFound)
+	}
+
+	return nil
+}
+
+// SetEmailChange stores a requested change of the email address, or clears
+// it when change is nil.
+func (s *Storage) SetEmailChange(ctx context.Context, userID int64, change *models.EmailChange) error {
+	const op = "storage.postgres.SetEmailChange"
+
+	var email *string
+	var codeHash []byte
+	var expiresAt *time.Time
+	if change != nil {
+		t := change.ExpiresAt.UTC()
+		email, codeHash, expiresAt = &change.Email, change.CodeHash, &t
+	}
+
+	tag, err := s.conn(ctx).Exec(ctx,
+		"UPDATE users SET pending_email = $1, email_code_hash = $2, email_code_expires_at = $3 WHERE id = $4",
+		email, codeHash, expiresAt, userID)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	if tag.RowsAffected() == 0 {
+		return fmt.Errorf("%s: %w", op, storage.ErrUserNotFound)
+	}
+
+	return nil
+}
+
+// SetAdmin grants or revokes admin rights of a user.
+func (s *Storage) SetAdmin(ctx context.Context, userID int64, isAdmin bool) error {
+	const op = "storage.postgres.SetAdmin"
+
+	tag, err := s.conn(ctx).Exec(ctx, "UPDATE users SET is_admin = $1 WHERE id = $2", isAdmin, userID)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	if tag.RowsAffected() == 0 {
+		return fmt.Errorf("%s: %w", op, storage.ErrUserNotFound)
+	}
+
+	return nil
+}
+
+// SetDisabledAt disables the user, or enables it when disabledAt is nil.
+func (s *Storage) SetDisabledAt(ctx context.Context, userID int64, disabledAt *time.Time) error {
+	const op = "storage.postgres.SetDisabledAt"
+
+	tag, err := s.conn(ctx).Exec(ctx, "UPDATE users SET disabled_at = $1 WHERE id = $2", disabledAt, userID)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	if tag.RowsAffected() == 0 {
+		return fmt.Errorf("%s: %w", op, storage.ErrUserNotFound)
+	}
+
+	return nil
+}
+
+// SetDeleteAfter schedules the deletion of the user, or cancels it when
+// deleteAfter is nil.
+func (s *Storage) SetDeleteAfter(ctx context.Context, userID int64, deleteAfter *time.Time) error {
+	const op = "storage.postgres.SetDeleteAfter"
+
+	tag, err := s.conn(ctx).Exec(ctx, "UPDATE users SET delete_after = $1 WHERE id = $2", deleteAfter, userID)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	if tag.RowsAffected() == 0 {
+		return fmt.Errorf("%s: %w", op, storage.ErrUserNotFound)
+	}
+
+	return nil
+}
+
+// UsersDueForDeletion returns the users whose deletion is scheduled at or
+// before now.
+func (s *Storage) UsersDueForDeletion(ctx context.Context, now time.Time) ([]int64, error) {
+	const op = "storage.postgres.UsersDueForDeletion"
+
+	rows, err := s.conn(ctx).Query(ctx, "SELECT id FROM users WHERE delete_after <= $1 ORDER BY id", now.UTC())
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	defer rows.Close()
+
+	var ids []int64
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			return nil, fmt.Errorf("%s: %w", op, err)
+		}
+		ids = append(ids, id)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return ids, nil
+}
+
+func (s *Storage) SaveSession(ctx context.Context, session models.Session) error {
+	const op = "storage.postgres.SaveSession"
+
+	_, err := s.conn(ctx).Exec(ctx,
+		"INSERT INTO sessions (id, user_id, app_id, created_at, expires_at) VALUES ($1, $2, $3, $4, $5)",
+		session.ID, session.UserID, session.AppID, session.CreatedAt.UTC(), session.ExpiresAt.UTC())
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+func (s *Storage) Session(ctx context.Context, sessionID string) (models.Session, error) {
+	const op = "storage.postgres.Session"
+
+	var session models.Session
+	err := s.conn(ctx).QueryRow(ctx,
+		"SELECT id, user_id, app_id, created_at, expires_at, revoked_at FROM sessions WHERE id = $1", sessionID,
+	).Scan(&session.ID, &session.UserID, &session.AppID, &session.CreatedAt, &session.ExpiresAt, &session.RevokedAt)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return models.Session{}, fmt.Errorf("%s: %w", op, storage.ErrSessionNotFound)
+		}
+		return models.Session{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return session, nil
+}
+
+func (s *Storage) RevokeSession(ctx context.Context, sessionID string) error {
+	const op = "storage.postgres.RevokeSession"
+
+	_, err := s.conn(ctx).Exec(ctx, "UPDATE sessions SET revoked_at = $1 WHERE id = $2 AND revoked_at IS NULL", time.Now().UTC(), sessionID)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+// UserSessions returns every session of the user, newest first.
+func (s *Storage) UserSessions(ctx context.Context, userID int64) ([]models.Session, error) {
+	const op = "storage.postgres.UserSessions"
+
+	rows, err := s.conn(ctx).Query(ctx, `
+		SELECT id, user_id, app_id, created_at, expires_at, revoked_at
+		FROM sessions
+		WHERE user_id = $1
+		ORDER BY created_at DESC`, userID)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	defer rows.Close()
+
+	var sessions []models.Session
+	for rows.Next() {
+		var session models.Session
+		if err := rows.Scan(&session.ID, &session.UserID, &session.AppID, &session.CreatedAt, &session.ExpiresAt, &session.RevokedAt); err != nil {
+			return nil, fmt.Errorf("%s: %w", op, err)
+		}
+		sessions = append(sessions, session)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return sessions, nil
+}
+
+// RevokeUserSessions revokes every active session of the user but
+// exceptSessionID, which may be empty.
+func (s *Storage) RevokeUserSessions(ctx context.Context, userID int64, exceptSessionID string) error {
+	const op = "storage.postgres.RevokeUserSessions"
+
+	_, err := s.conn(ctx).Exec(ctx,
+		"UPDATE sessions SET revoked_at = $1 WHERE user_id = $2 AND id <> $3 AND revoked_at IS NULL",
+		time.Now().UTC(), userID, exceptSessionID)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+func (s *Storage) SaveLoginAttempt(ctx context.Context, attempt models.LoginAttempt) error {
+	const op = "storage.postgres.SaveLoginAttempt"
+
+	_, err := s.conn(ctx).Exec(ctx,
+		"INSERT INTO login_attempts (user_id, app_id, ip, device, success, created_at) VALUES ($1, $2, $3, $4, $5, $6)",
+		attempt.UserID, attempt.AppID, attempt.IP, attempt.Device, attempt.Success, attempt.CreatedAt.UTC())
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+// LoginAttempts returns the user's attempts since the given time, newest first.
+func (s *Storage) LoginAttempts(ctx context.Context, userID int64, since time.Time) ([]models.LoginAttempt, error) {
+	const op = "storage.postgres.LoginAttempts"
+
+	rows, err := s.conn(ctx).Query(ctx, `
+		SELECT user_id, app_id, ip, device, success, created_at
+		FROM login_attempts
+		WHERE user_id = $1 AND created_at >= $2
+		ORDER BY created_at DESC`, userID, since.UTC())
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	defer rows.Close()
+
+	var attempts []models.LoginAttempt
+	for rows.Next() {
+		var a models.LoginAttempt
+		if err := rows.Scan(&a.UserID, &a.AppID, &a.IP, &a.Device, &a.Success, &a.CreatedAt); err != nil {
+			return nil, fmt.Errorf("%s: %w", op, err)
+		}
+		attempts = append(attempts, a)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return attempts, nil
+}
+
+func (s *Storage) SaveAuditEvent(ctx context.Context, event models.AuditEvent) error {
+	const op = "storage.postgres.SaveAuditEvent"
+
+	_, err := s.conn(ctx).Exec(ctx,
+		"INSERT INTO audit_events (user_id, actor_id, action, ip, created_at) VALUES ($1, $2, $3, $4, $5)",
+		event.UserID, event.ActorID, event.Action, event.IP, event.CreatedAt.UTC())
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+// AuditEvents returns the events about the user, oldest first. They are
+// kept after the user is deleted.
+func (s *Storage) AuditEvents(ctx context.Context, userID int64) ([]models.AuditEvent, error) {
+	const op = "storage.postgres.AuditEvents"
+
+	rows, err := s.conn(ctx).Query(ctx, `
+		SELECT user_id, actor_id, action, ip, created_at
+		FROM audit_events
+		WHERE user_id = $1
+		ORDER BY created_at, id`, userID)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	defer rows.Close()
+
+	var events []models.AuditEvent
+	for rows.Next() {
+		var e models.AuditEvent
+		if err := rows.Scan(&e.UserID, &e.ActorID, &e.Action, &e.IP, &e.CreatedAt); err != nil {
+			return nil, fmt.Errorf("%s: %w", op, err)
+		}
+		events = append(events, e)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return events, nil
+}
+
+// Profiles returns every user with a keystroke template, ordered by ID, for
+// offline research exports. Only the ID, email and decrypted template are set.
+func (s *Storage) Profiles(ctx context.Context) ([]models.User, error) {
+	const op = "storage.postgres.Profiles"
+
+	rows, err := s.conn(ctx).Query(ctx, `
+		SELECT u.id, u.email, k.key_press_intervals, k.key_press_times, k.key_events, k.data_key
+		FROM users u
+		JOIN key_press_data k ON k.user_id = u.id
+		ORDER BY u.id`)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	defer rows.Close()
+
+	var users []models.User
+	for rows.Next() {
+		var user models.User
+		var t storage.Template
+		var wrappedKey []byte
+		if err := rows.Scan(&user.ID, &user.Email, &t.Intervals, &t.Times, &t.KeyEvents, &wrappedKey); err != nil {
+			return nil, fmt.Errorf("%s: %w", op, err)
+		}
+
+		user.PressTimes, user.PressIntervals, user.KeyEvents, err = storage.OpenTemplate(s.keyring, wrappedKey, t)
+		if err != nil {
+			return nil, fmt.Errorf("%s: user %d: %w", op, user.ID, err)
+		}
+		users = append(users, user)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return users, nil
+}
+
+// Rewrap re-encrypts the data keys of every user and app with the next
+// keyring, so the master key can be rotated, and returns how many records
+// were rewrapped. Templates and app secrets stored in plain text are
+// encrypted on the way. The rows are locked until the rotation commits, so
+// replicas still running with the old key cannot write in between.
+func (s *Storage) Rewrap(ctx context.Context, next *envelope.Keyring) (int, error) {
+	const op = "storage.postgres.Rewrap"
+
+	var n int
+	err := s.WithTx(ctx, func(ctx context.Context) error {
+		templates, err := s.rewrapTemplates(ctx, next)
+		if err != nil {
+			return err
+		}
+		apps, err := s.rewrapApps(ctx, next)
+		if err != nil {
+			return err
+		}
+		n = templates + apps
+		return nil
+	})
+	if err != nil {
+		return 0, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return n, nil
+}
+
+// rewrapTemplates is Rewrap for the data keys of keystroke templates. It
+// must run in a transaction.
+func (s *Storage) rewrapTemplates(ctx context.Context, next *envelope.Keyring) (int, error) {
+	tx := s.conn(ctx)
+
+	rows, err := tx.Query(ctx, "SELECT data_id, key_press_intervals, key_press_times, data_key FROM key_press_data FOR UPDATE")
+	if err != nil {
+		return 0, err
+	}
+
+	type record struct {
+		id         int64
+		t          storage.Template
+		wrappedKey []byte
+	}
+
+	var records []record
+	for rows.Next() {
+		var r record
+		if err := rows.Scan(&r.id, &r.t.Intervals, &r.t.Times, &r.wrappedKey); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		records = append(records, r)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	for _, r := range records {
+		if r.wrappedKey == nil {
+			dataKey, wrappedKey, err := next.NewDataKey()
+			if err != nil {
+				return 0, err
+			}
+			pressTimes, intervalTimes, _, err := storage.OpenTemplate(s.keyring, nil, r.t)
+			if err != nil {
+				return 0, err
+			}
+			t, err := storage.SealTemplate(dataKey, pressTimes, intervalTimes, nil)
+			if err != nil {
+				return 0, err
+			}
+			_, err = tx.Exec(ctx,
+				"UPDATE key_press_data SET key_press_intervals = $1, key_press_times = $2, data_key = $3 WHERE data_id = $4",
+				t.Intervals, t.Times, wrappedKey, r.id)
+			if err != nil {
+				return 0, err
+			}
+			continue
+		}
+
+		dataKey, err := s.keyring.Unwrap(r.wrappedKey)
+		if err != nil {
+			return 0, fmt.Errorf("data_id %d: %w", r.id, err)
+		}
+		wrappedKey, err := next.Wrap(dataKey)
+		if err != nil {
+			return 0, err
+		}
+		if _, err := tx.Exec(ctx, "UPDATE key_press_data SET data_key = $1 WHERE data_id = $2", wrappedKey, r.id); err != nil {
+			return 0, err
+		}
+	}
+
+	return len(records), nil
+}
+
+// rewrapApps is Rewrap for the data keys of app secrets. It must run in a
+// transaction.
+func (s *Storage) rewrapApps(ctx context.Context, next *envelope.Keyring) (int, error) {
+	tx := s.conn(ctx)
+
+	rows, err := tx.Query(ctx, "SELECT id, secret, previous_secret, data_key FROM apps FOR UPDATE")
+	if err != nil {
+		return 0, err
+	}
+
+	type record struct {
+		id      int
+		secrets storage.AppSecrets
+	}
+
+	var records []record
+	for rows.Next() {
+		var r record
+		if err := rows.Scan(&r.id, &r.secrets.Secret, &r.secrets.Previous, &r.secrets.DataKey); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		records = append(records, r)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	for _, r := range records {
+		if r.secrets.DataKey == nil {
+			secrets, err := storage.SealAppSecrets(next, r.secrets.Secret, r.secrets.Previous.String)
+			if err != nil {
+				return 0, err
+			}
+			_, err = tx.Exec(ctx,
+				"UPDATE apps SET secret = $1, previous_secret = $2, data_key = $3 WHERE id = $4",
+				secrets.Secret, secrets.Previous, secrets.DataKey, r.id)
+			if err != nil {
+				return 0, err
+			}
+			continue
+		}
+
+		dataKey, err := s.keyring.Unwrap(r.secrets.DataKey)
+		if err != nil {
+			return 0, fmt.Errorf("app %d: %w", r.id, err)
+		}
+		wrappedKey, err := next.Wrap(dataKey)
+		if err != nil {
+			return 0, err
+		}
+		if _, err := tx.Exec(ctx, "UPDATE apps SET data_key = $1 WHERE id = $2", wrappedKey, r.id); err != nil {
+			return 0, err
+		}
+	}
+
+	return len(records), nil
+}
@@ -0,0 +1,297 @@
+package postgres_test
+
+import (
+	"context"
+	"fmt"
+	"github.com/golang-migrate/migrate/v4"
+	_ "github.com/golang-migrate/migrate/v4/database/pgx/v5"
+	_ "github.com/golang-migrate/migrate/v4/source/file"
+	"github.com/jackc/pgx/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sso/internal/domain/models"
+	"sso/internal/lib/envelope"
+	"sso/internal/storage"
+	"sso/internal/storage/postgres"
+	"sso/internal/storage/storagetest"
+	"strings"
+	"testing"
+	"time"
+)
+
+const migrationsPath = "../../../migrations/postgres"
+
+// dsn of the database under test; empty when no postgres is available.
+var dsn string
+
+// TestMain connects to SSO_TEST_POSTGRES_DSN or, when it is not set, starts
+// a throwaway cluster with initdb and pg_ctl found in POSTGRES_BIN or PATH.
+func TestMain(m *testing.M) {
+	dsn = os.Getenv("SSO_TEST_POSTGRES_DSN")
+
+	var stop func()
+	if dsn == "" {
+		var err error
+		dsn, stop, err = startPostgres()
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "postgres is not available, integration tests are skipped:", err)
+		}
+	}
+
+	if dsn != "" {
+		if err := migrateUp(dsn); err != nil {
+			fmt.Fprintln(os.Stderr, "failed to migrate postgres:", err)
+			if stop != nil {
+				stop()
+			}
+			os.Exit(1)
+		}
+	}
+
+	code := m.Run()
+
+	if stop != nil {
+		stop()
+	}
+	os.Exit(code)
+}
+
+func startPostgres() (string, func(), error) {
+	initdb, err := lookPostgresBin("initdb")
+	if err != nil {
+		return "", nil, err
+	}
+	pgCtl, err := lookPostgresBin("pg_ctl")
+	if err != nil {
+		return "", nil, err
+	}
+
+	dir, err := os.MkdirTemp("", "sso-postgres-")
+	if err != nil {
+		return "", nil, err
+	}
+	dataDir := filepath.Join(dir, "data")
+
+	out, err := exec.Command(initdb, "-D", dataDir, "-U", "sso", "--auth=trust", "--no-sync").CombinedOutput()
+	if err != nil {
+		_ = os.RemoveAll(dir)
+		return "", nil, fmt.Errorf("initdb: %w: %s", err, out)
+	}
+
+	port, err := freePort()
+	if err != nil {
+		_ = os.RemoveAll(dir)
+		return "", nil, err
+	}
+
+	opts := fmt.Sprintf("-p %d -k %s -c listen_addresses=127.0.0.1 -c fsync=off", port, dir)
+	out, err = exec.Command(pgCtl, "-D", dataDir, "-o", opts, "-l", filepath.Join(dir, "postgres.log"), "-w", "start").CombinedOutput()
+	if err != nil {
+		_ = os.RemoveAll(dir)
+		return "", nil, fmt.Errorf("pg_ctl start: %w: %s", err, out)
+	}
+
+	stop := func() {
+		_ = exec.Command(pgCtl, "-D", dataDir, "-m", "immediate", "stop").Run()
+		_ = os.RemoveAll(dir)
+	}
+
+	return fmt.Sprintf("postgres://sso@127.0.0.1:%d/postgres?sslmode=disable", port), stop, nil
+}
+
+func lookPostgresBin(name string) (string, error) {
+	if dir := os.Getenv("POSTGRES_BIN"); dir != "" {
+		return exec.LookPath(filepath.Join(dir, name))
+	}
+	return exec.LookPath(name)
+}
+
+func freePort() (int, error) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return 0, err
+	}
+	defer l.Close()
+
+	return l.Addr().(*net.TCPAddr).Port, nil
+}
+
+func migrateUp(dsn string) error {
+	url := "pgx5://" + strings.TrimPrefix(dsn, "postgres://")
+
+	m, err := migrate.New("file://"+migrationsPath, url)
+	if err != nil {
+		return err
+	}
+	defer m.Close()
+
+	if err := m.Up(); err != nil && err != migrate.ErrNoChange {
+		return err
+	}
+	return nil
+}
+
+func newStorage(t *testing.T) *postgres.Storage {
+	t.Helper()
+
+	if dsn == "" {
+		t.Skip("postgres is not available")
+	}
+
+	keyring, err := envelope.New(make([]byte, envelope.KeySize))
+	require.NoError(t, err)
+
+	s, err := postgres.New(context.Background(), dsn, keyring)
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = s.Close() })
+
+	return s
+}
+
+func uniqueEmail(t *testing.T) string {
+	return fmt.Sprintf("%s-%d@example.com", strings.ToLower(t.Name()), time.Now().UnixNano())
+}
+
+func TestConformance(t *testing.T) {
+	storagetest.Run(t, func(t *testing.T) storagetest.Storage {
+		return newStorage(t)
+	})
+}
+
+func TestSaveUser_User(t *testing.T) {
+	s := newStorage(t)
+	ctx := context.Background()
+
+	email := uniqueEmail(t)
+	press := []float32{110, 95, 120}
+	intervals := []float32{200, 180}
+	events := []models.KeyEvent{{Key: "a", PressedAt: 0, ReleasedAt: 110}}
+
+	id, err := s.SaveUser(ctx, email, []byte("hash"), press, intervals, events)
+	require.NoError(t, err)
+	assert.NotZero(t, id)
+
+	user, err := s.User(ctx, email)
+	require.NoError(t, err)
+	assert.Equal(t, id, user.ID)
+	assert.Equal(t, []byte("hash"), user.PassHash)
+	assert.Equal(t, press, user.PressTimes)
+	assert.Equal(t, intervals, user.PressIntervals)
+	assert.Equal(t, events, user.KeyEvents)
+	assert.False(t, user.EnrollmentPending)
+
+	_, err = s.SaveUser(ctx, email, []byte("hash"), press, intervals, nil)
+	assert.ErrorIs(t, err, storage.ErrUserExists)
+
+	_, err = s.User(ctx, "missing-"+email)
+	assert.ErrorIs(t, err, storage.ErrUserNotFound)
+}
+
+func TestUpdateBiometrics(t *testing.T) {
+	s := newStorage(t)
+	ctx := context.Background()
+
+	email := uniqueEmail(t)
+	id, err := s.SaveUser(ctx, email, []byte("hash"), []float32{1, 2}, []float32{3}, nil)
+	require.NoError(t, err)
+
+	require.NoError(t, s.SetEnrollmentPending(ctx, id))
+	user, err := s.User(ctx, email)
+	require.NoError(t, err)
+	assert.True(t, user.EnrollmentPending)
+
+	require.NoError(t, s.UpdateBiometrics(ctx, id, []float32{5, 6}, []float32{7}, nil))
+	user, err = s.User(ctx, email)
+	require.NoError(t, err)
+	assert.False(t, user.EnrollmentPending)
+	assert.Equal(t, []float32{5, 6}, user.PressTimes)
+	assert.Equal(t, []float32{7}, user.PressIntervals)
+
+	assert.ErrorIs(t, s.UpdateBiometrics(ctx, -1, nil, nil, nil), storage.ErrUserNotFound)
+	assert.ErrorIs(t, s.SetEnrollmentPending(ctx, -1), storage.ErrUserNotFound)
+}
+
+func TestApp_IsAdmin(t *testing.T) {
+	s := newStorage(t)
+	ctx := context.Background()
+
+	_, err := s.App(ctx, -1)
+	assert.ErrorIs(t, err, storage.ErrAppNotFound)
+
+	id, err := s.SaveUser(ctx, uniqueEmail(t), []byte("hash"), []float32{1}, nil, nil)
+	require.NoError(t, err)
+
+	isAdmin, err := s.IsAdmin(ctx, id)
+	require.NoError(t, err)
+	assert.False(t, isAdmin)
+}
+
+func TestSessions(t *testing.T) {
+	s := newStorage(t)
+	ctx := context.Background()
+
+	userID, err := s.SaveUser(ctx, uniqueEmail(t), []byte("hash"), []float32{1}, nil, nil)
+	require.NoError(t, err)
+
+	appID := int(time.Now().UnixNano() % 1_000_000_000)
+	conn, err := pgx.Connect(ctx, dsn)
+	require.NoError(t, err)
+	defer conn.Close(ctx)
+	_, err = conn.Exec(ctx, "INSERT INTO apps (id, name, secret) VALUES ($1, $2, $3)", appID, fmt.Sprint("app-", appID), fmt.Sprint("secret-", appID))
+	require.NoError(t, err)
+
+	now := time.Now().Truncate(time.Microsecond)
+	session := models.Session{
+		ID:        fmt.Sprint("session-", appID),
+		UserID:    userID,
+		AppID:     appID,
+		CreatedAt: now,
+		ExpiresAt: now.Add(time.Hour),
+	}
+	require.NoError(t, s.SaveSession(ctx, session))
+
+	got, err := s.Session(ctx, session.ID)
+	require.NoError(t, err)
+	assert.True(t, got.Active(now))
+	assert.True(t, session.ExpiresAt.Equal(got.ExpiresAt))
+
+	require.NoError(t, s.RevokeSession(ctx, session.ID))
+	got, err = s.Session(ctx, session.ID)
+	require.NoError(t, err)
+	assert.NotNil(t, got.RevokedAt)
+	assert.False(t, got.Active(now))
+
+	_, err = s.Session(ctx, "missing")
+	assert.ErrorIs(t, err, storage.ErrSessionNotFound)
+}
+
+func TestLoginAttempts(t *testing.T) {
+	s := newStorage(t)
+	ctx := context.Background()
+
+	userID, err := s.SaveUser(ctx, uniqueEmail(t), []byte("hash"), []float32{1}, nil, nil)
+	require.NoError(t, err)
+
+	now := time.Now()
+	for i := 0; i < 3; i++ {
+		require.NoError(t, s.SaveLoginAttempt(ctx, models.LoginAttempt{
+			UserID:    userID,
+			AppID:     1,
+			IP:        "127.0.0.1",
+			Device:    "test",
+			Success:   i%2 == 0,
+			CreatedAt: now.Add(-time.Duration(i) * time.Hour),
+		}))
+	}
+
+	attempts, err := s.LoginAttempts(ctx, userID, now.Add(-90*time.Minute))
+	require.NoError(t, err)
+	require.Len(t, attempts, 2)
+	assert.True(t, attempts[0].CreatedAt.After(attempts[1].CreatedAt))
+	assert.True(t, attempts[0].Success)
+	assert.False(t, attempts[1].Success)
+}
@@ -0,0 +1,206 @@
+// Package schema applies the embedded migrations and checks that a database
+// is up to date with them.
+package schema
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"sso/internal/config"
+	"sso/migrations"
+	"strings"
+
+	"github.com/golang-migrate/migrate/v4"
+	// Driver for postgres
+	_ "github.com/golang-migrate/migrate/v4/database/pgx/v5"
+	// Driver for sqlite
+	_ "github.com/golang-migrate/migrate/v4/database/sqlite3"
+	"github.com/golang-migrate/migrate/v4/source/iofs"
+)
+
+// MigrationsTable is the table the applied schema version is recorded in.
+const MigrationsTable = "migrations"
+
+var (
+	ErrSchemaBehind      = errors.New("database schema is behind")
+	ErrSchemaDirty       = errors.New("database schema is dirty")
+	ErrUnsupportedDriver = errors.New("storage driver has no migrations")
+	ErrSchemaAheadOfCode = errors.New("database schema is newer than the binary")
+)
+
+// Status is the schema version of a database against the embedded migrations.
+type Status struct {
+	// Current is 0 when no migration has been applied.
+	Current uint
+	Latest  uint
+	Dirty   bool
+}
+
+func (s Status) Behind() bool {
+	return s.Current < s.Latest
+}
+
+// Migrations returns the embedded migrations of a storage driver.
+func Migrations(driver string) (fs.FS, error) {
+	switch driver {
+	case config.StorageDriverSQLite, "":
+		return migrations.SQLite, nil
+	case config.StorageDriverPostgres:
+		return fs.Sub(migrations.Postgres, "postgres")
+	default:
+		return nil, fmt.Errorf("%w: %q", ErrUnsupportedDriver, driver)
+	}
+}
+
+// DatabaseURL turns a storage source into a URL of the migrate database
+// driver: a file path for sqlite, a DSN for postgres.
+func DatabaseURL(driver string, source string, migrationsTable string) (string, error) {
+	switch driver {
+	case config.StorageDriverSQLite, "":
+		return fmt.Sprintf("sqlite3://%s?x-migrations-table=%s", source, migrationsTable), nil
+	case config.StorageDriverPostgres:
+		dsn := strings.TrimPrefix(strings.TrimPrefix(source, "postgres://"), "postgresql://")
+
+		sep := "?"
+		if strings.Contains(dsn, "?") {
+			sep = "&"
+		}
+
+		return "pgx5://" + dsn + sep + "x-migrations-table=" + migrationsTable, nil
+	default:
+		return "", fmt.Errorf("%w: %q", ErrUnsupportedDriver, driver)
+	}
+}
+
+// New returns a migrate instance over the embedded migrations of driver.
+// The caller must close it.
+func New(driver string, source string) (*migrate.Migrate, error) {
+	const op = "schema.New"
+
+	migrationsFS, err := Migrations(driver)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	src, err := iofs.New(migrationsFS, ".")
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	databaseURL, err := DatabaseURL(driver, source, MigrationsTable)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	m, err := migrate.NewWithSourceInstance("iofs", src, databaseURL)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return m, nil
+}
+
+// Up applies every pending embedded migration.
+func Up(driver string, source string) error {
+	const op = "schema.Up"
+
+	m, err := New(driver, source)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	defer m.Close()
+
+	if err := m.Up(); err != nil && !errors.Is(err, migrate.ErrNoChange) {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+// Check reports the schema version of the database.
+func Check(driver string, source string) (Status, error) {
+	const op = "schema.Check"
+
+	m, err := New(driver, source)
+	if err != nil {
+		return Status{}, fmt.Errorf("%s: %w", op, err)
+	}
+	defer m.Close()
+
+	migrationsFS, err := Migrations(driver)
+	if err != nil {
+		return Status{}, fmt.Errorf("%s: %w", op, err)
+	}
+	latest, err := LatestVersion(migrationsFS)
+	if err != nil {
+		return Status{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	current, dirty, err := m.Version()
+	if err != nil && !errors.Is(err, migrate.ErrNilVersion) {
+		return Status{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return Status{Current: current, Latest: latest, Dirty: dirty}, nil
+}
+
+// Ensure makes sure the schema is up to date before the storage is used. It
+// migrates when autoMigrate is set and fails otherwise, so a stale schema is
+// reported at startup instead of as missing columns later. The memory driver
+// has no schema.
+func Ensure(driver string, source string, autoMigrate bool) (Status, error) {
+	const op = "schema.Ensure"
+
+	if driver == config.StorageDriverMemory {
+		return Status{}, nil
+	}
+
+	if autoMigrate {
+		if err := Up(driver, source); err != nil {
+			return Status{}, fmt.Errorf("%s: %w", op, err)
+		}
+	}
+
+	status, err := Check(driver, source)
+	if err != nil {
+		return Status{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	switch {
+	case status.Dirty:
+		return status, fmt.Errorf("%s: %w: version %d", op, ErrSchemaDirty, status.Current)
+	case status.Behind():
+		return status, fmt.Errorf("%s: %w: version %d, want %d; run with --migrate or set storage.auto_migrate", op, ErrSchemaBehind, status.Current, status.Latest)
+	case status.Current > status.Latest:
+		return status, fmt.Errorf("%s: %w: version %d, binary knows %d", op, ErrSchemaAheadOfCode, status.Current, status.Latest)
+	}
+
+	return status, nil
+}
+
+// LatestVersion returns the version of the last migration in migrationsFS.
+func LatestVersion(migrationsFS fs.FS) (uint, error) {
+	src, err := iofs.New(migrationsFS, ".")
+	if err != nil {
+		return 0, err
+	}
+	defer src.Close()
+
+	version, err := src.First()
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return 0, nil
+		}
+		return 0, err
+	}
+
+	for {
+		next, err := src.Next(version)
+		if errors.Is(err, os.ErrNotExist) {
+			return version, nil
+		}
+		if err != nil {
+			return 0, err
+		}
+		version = next
+	}
+}
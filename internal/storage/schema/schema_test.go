@@ -0,0 +1,44 @@
+package schema_test
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"path/filepath"
+	"sso/internal/config"
+	"sso/internal/storage/schema"
+	"sso/migrations"
+	"testing"
+)
+
+func TestEnsure_SQLite(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "sso.db")
+
+	_, err := schema.Ensure(config.StorageDriverSQLite, path, false)
+	assert.ErrorIs(t, err, schema.ErrSchemaBehind)
+
+	status, err := schema.Ensure(config.StorageDriverSQLite, path, true)
+	require.NoError(t, err)
+	assert.False(t, status.Behind())
+	assert.NotZero(t, status.Current)
+
+	_, err = schema.Ensure(config.StorageDriverSQLite, path, false)
+	assert.NoError(t, err)
+}
+
+func TestEnsure_Memory(t *testing.T) {
+	_, err := schema.Ensure(config.StorageDriverMemory, "", false)
+	assert.NoError(t, err)
+}
+
+func TestLatestVersion(t *testing.T) {
+	latest, err := schema.LatestVersion(migrations.SQLite)
+	require.NoError(t, err)
+
+	postgresFS, err := schema.Migrations(config.StorageDriverPostgres)
+	require.NoError(t, err)
+	postgresLatest, err := schema.LatestVersion(postgresFS)
+	require.NoError(t, err)
+
+	assert.NotZero(t, latest)
+	assert.EqualValues(t, 6, postgresLatest)
+}
@@ -0,0 +1,119 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"github.com/mattn/go-sqlite3"
+	"time"
+)
+
+// backupStepPages is the number of pages copied per backup step. The source
+// is only locked during a step, so the server keeps writing between steps.
+const backupStepPages = 256
+
+// backupRetryDelay is how long a step waits before retrying a busy source.
+const backupRetryDelay = 50 * time.Millisecond
+
+var ErrIntegrityCheck = errors.New("integrity check failed")
+
+// Backup copies the database at srcPath into a new database at destPath with
+// the SQLite online backup API. The copy is a consistent snapshot even while
+// the server writes to the source: if the source changes during the backup,
+// SQLite restarts it.
+func Backup(ctx context.Context, srcPath string, destPath string) error {
+	const op = "storage.sqlite.Backup"
+
+	src, err := sql.Open("sqlite3", srcPath)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	defer src.Close()
+
+	dest, err := sql.Open("sqlite3", destPath)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	defer dest.Close()
+
+	srcConn, err := src.Conn(ctx)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	defer srcConn.Close()
+
+	destConn, err := dest.Conn(ctx)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	defer destConn.Close()
+
+	err = destConn.Raw(func(destDriverConn any) error {
+		return srcConn.Raw(func(srcDriverConn any) error {
+			return backup(ctx, destDriverConn.(*sqlite3.SQLiteConn), srcDriverConn.(*sqlite3.SQLiteConn))
+		})
+	})
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+func backup(ctx context.Context, dest *sqlite3.SQLiteConn, src *sqlite3.SQLiteConn) error {
+	b, err := dest.Backup("main", src, "main")
+	if err != nil {
+		return err
+	}
+
+	for {
+		done, err := b.Step(backupStepPages)
+		if done {
+			break
+		}
+		if err != nil && !isBusy(err) {
+			_ = b.Close()
+			return err
+		}
+
+		select {
+		case <-ctx.Done():
+			_ = b.Close()
+			return ctx.Err()
+		default:
+		}
+		if err != nil {
+			time.Sleep(backupRetryDelay)
+		}
+	}
+
+	return b.Finish()
+}
+
+func isBusy(err error) bool {
+	var sqliteErr sqlite3.Error
+	return errors.As(err, &sqliteErr) &&
+		(sqliteErr.Code == sqlite3.ErrBusy || sqliteErr.Code == sqlite3.ErrLocked)
+}
+
+// IntegrityCheck runs PRAGMA integrity_check on the database at path.
+func IntegrityCheck(ctx context.Context, path string) error {
+	const op = "storage.sqlite.IntegrityCheck"
+
+	db, err := sql.Open("sqlite3", path)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	defer db.Close()
+
+	var result string
+	if err := db.QueryRowContext(ctx, "PRAGMA integrity_check").Scan(&result); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	if result != "ok" {
+		return fmt.Errorf("%s: %w: %s", op, ErrIntegrityCheck, result)
+	}
+
+	return nil
+}
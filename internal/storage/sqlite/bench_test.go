@@ -0,0 +1,150 @@
+package sqlite
+
+import (
+	"context"
+	"fmt"
+	"github.com/stretchr/testify/require"
+	"path/filepath"
+	"sso/internal/config"
+	"sso/internal/domain/models"
+	"sso/internal/lib/envelope"
+	"sso/internal/storage/schema"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+const benchUsers = 64
+
+// baselineOptions are the settings the storage used to open the database
+// with: sqlite and database/sql defaults.
+var baselineOptions = Options{MaxIdleConns: 2}
+
+func newBenchStorage(b *testing.B, opts Options) *Storage {
+	b.Helper()
+
+	path := filepath.Join(b.TempDir(), "sso.db")
+	require.NoError(b, schema.Up(config.StorageDriverSQLite, path))
+
+	keyring, err := envelope.New(make([]byte, envelope.KeySize))
+	require.NoError(b, err)
+
+	s, err := New(path, opts, keyring)
+	require.NoError(b, err)
+	b.Cleanup(func() { _ = s.Close() })
+
+	ctx := context.Background()
+	_, err = s.db.ExecContext(ctx, "INSERT INTO apps (id, name, secret) VALUES (1, 'bench', 'bench-secret')")
+	require.NoError(b, err)
+	for i := 0; i < benchUsers; i++ {
+		_, err := s.SaveUser(ctx, benchEmail(i), []byte("hash"), []float32{110, 95, 130}, []float32{210, 180}, nil)
+		require.NoError(b, err)
+	}
+
+	return s
+}
+
+func benchEmail(i int) string {
+	return fmt.Sprintf("user-%d@bench.test", i)
+}
+
+// BenchmarkConcurrentLogins runs the storage calls of a login in parallel:
+// the user lookup, the risk history and the writes of the attempt and the
+// session. Writes that fail with SQLITE_BUSY are reported as errors/op.
+func BenchmarkConcurrentLogins(b *testing.B) {
+	for _, bc := range []struct {
+		name string
+		opts Options
+	}{
+		{name: "baseline", opts: baselineOptions},
+		{name: "tuned", opts: DefaultOptions()},
+	} {
+		b.Run(bc.name, func(b *testing.B) {
+			s := newBenchStorage(b, bc.opts)
+			ctx := context.Background()
+
+			var n, failed atomic.Int64
+			b.ResetTimer()
+			b.RunParallel(func(pb *testing.PB) {
+				for pb.Next() {
+					i := n.Add(1)
+					if err := login(ctx, s, i); err != nil {
+						failed.Add(1)
+					}
+				}
+			})
+			b.ReportMetric(float64(failed.Load())/float64(b.N), "errors/op")
+		})
+	}
+}
+
+func login(ctx context.Context, s *Storage, i int64) error {
+	user, err := s.User(ctx, benchEmail(int(i%benchUsers)))
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	if _, err := s.LoginAttempts(ctx, user.ID, now.Add(-24*time.Hour)); err != nil {
+		return err
+	}
+	if err := s.SaveLoginAttempt(ctx, models.LoginAttempt{UserID: user.ID, AppID: 1, Success: true, CreatedAt: now}); err != nil {
+		return err
+	}
+
+	return s.SaveSession(ctx, models.Session{
+		ID:        fmt.Sprintf("session-%d-%d", i, now.UnixNano()),
+		UserID:    user.ID,
+		AppID:     1,
+		CreatedAt: now,
+		ExpiresAt: now.Add(time.Hour),
+	})
+}
+
+// BenchmarkUserLookup compares preparing the user query on every call, as
+// the storage used to, with the statement prepared once in New.
+func BenchmarkUserLookup(b *testing.B) {
+	const query = "SELECT " + userColumns + " FROM users WHERE email = ?"
+
+	b.Run("prepare_per_call", func(b *testing.B) {
+		s := newBenchStorage(b, DefaultOptions())
+		ctx := context.Background()
+
+		var n atomic.Int64
+		b.ResetTimer()
+		b.RunParallel(func(pb *testing.PB) {
+			for pb.Next() {
+				stmt, err := s.db.PrepareContext(ctx, query)
+				if err != nil {
+					b.Error(err)
+					return
+				}
+				var user models.User
+				err = scanUser(stmt.QueryRowContext(ctx, benchEmail(int(n.Add(1)%benchUsers))), &user)
+				_ = stmt.Close()
+				if err != nil {
+					b.Error(err)
+					return
+				}
+			}
+		})
+	})
+
+	b.Run("prepared", func(b *testing.B) {
+		s := newBenchStorage(b, DefaultOptions())
+		ctx := context.Background()
+
+		var n atomic.Int64
+		b.ResetTimer()
+		b.RunParallel(func(pb *testing.PB) {
+			for pb.Next() {
+				var user models.User
+				err := scanUser(s.stmts.user.QueryRowContext(ctx, benchEmail(int(n.Add(1)%benchUsers))), &user)
+				if err != nil {
+					b.Error(err)
+					return
+				}
+			}
+		})
+	})
+}
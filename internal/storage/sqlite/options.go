@@ -0,0 +1,80 @@
+package sqlite
+
+import (
+	"database/sql"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Options are the pragmas applied to every connection of the pool and the
+// pool limits, which follow database/sql. Empty pragmas keep the sqlite
+// defaults.
+type Options struct {
+	// JournalMode is the journal_mode pragma; WAL lets readers run while a
+	// transaction writes.
+	JournalMode string
+	// BusyTimeout is how long a connection waits for a lock before failing
+	// with SQLITE_BUSY.
+	BusyTimeout time.Duration
+	// ForeignKeys is the foreign_keys pragma, on or off; on enforces foreign
+	// keys, including ON DELETE CASCADE.
+	ForeignKeys string
+	// Synchronous is the synchronous pragma; NORMAL is durable enough with
+	// WAL and much cheaper than FULL.
+	Synchronous string
+
+	MaxOpenConns    int
+	MaxIdleConns    int
+	ConnMaxLifetime time.Duration
+}
+
+// DefaultOptions returns the settings the server runs with unless configured
+// otherwise.
+func DefaultOptions() Options {
+	return Options{
+		JournalMode:  "WAL",
+		BusyTimeout:  5 * time.Second,
+		ForeignKeys:  "on",
+		Synchronous:  "NORMAL",
+		MaxOpenConns: 16,
+		MaxIdleConns: 16,
+	}
+}
+
+func (o Options) applyPool(db *sql.DB) {
+	db.SetMaxOpenConns(o.MaxOpenConns)
+	db.SetMaxIdleConns(o.MaxIdleConns)
+	db.SetConnMaxLifetime(o.ConnMaxLifetime)
+}
+
+// dsn adds the pragmas of opts to the storage path as go-sqlite3 connection
+// parameters; parameters already in the path win, except _txlock.
+// Transactions always take the write lock when they begin, so two
+// transactions that read before writing, such as Rewrap and re-enrollment,
+// wait for each other instead of failing with SQLITE_BUSY.
+func dsn(storagePath string, opts Options) string {
+	path, rawQuery, _ := strings.Cut(storagePath, "?")
+
+	query, err := url.ParseQuery(rawQuery)
+	if err != nil {
+		// leave a path go-sqlite3 will reject untouched
+		return storagePath
+	}
+
+	set := func(key string, value string) {
+		if value != "" && !query.Has(key) {
+			query.Set(key, value)
+		}
+	}
+	query.Set("_txlock", "immediate")
+	set("_journal_mode", opts.JournalMode)
+	if opts.BusyTimeout > 0 {
+		set("_busy_timeout", strconv.FormatInt(opts.BusyTimeout.Milliseconds(), 10))
+	}
+	set("_foreign_keys", opts.ForeignKeys)
+	set("_synchronous", opts.Synchronous)
+
+	return path + "?" + query.Encode()
+}
@@ -0,0 +1,57 @@
+package sqlite
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"path/filepath"
+	"sso/internal/config"
+	"sso/internal/lib/envelope"
+	"sso/internal/storage/schema"
+	"testing"
+)
+
+func TestNew_AppliesOptions(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "sso.db")
+	require.NoError(t, schema.Up(config.StorageDriverSQLite, path))
+
+	keyring, err := envelope.New(make([]byte, envelope.KeySize))
+	require.NoError(t, err)
+
+	s, err := New(path, DefaultOptions(), keyring)
+	require.NoError(t, err)
+	defer s.Close()
+
+	ctx := context.Background()
+	pragma := func(name string) string {
+		var value string
+		require.NoError(t, s.db.QueryRowContext(ctx, "PRAGMA "+name).Scan(&value))
+		return value
+	}
+
+	assert.Equal(t, "wal", pragma("journal_mode"))
+	assert.Equal(t, "1", pragma("foreign_keys"))
+	assert.Equal(t, "5000", pragma("busy_timeout"))
+	// NORMAL
+	assert.Equal(t, "1", pragma("synchronous"))
+	assert.Equal(t, 16, s.db.Stats().MaxOpenConnections)
+
+	id, err := s.SaveUser(ctx, "user@example.com", []byte("hash"), []float32{100}, nil, nil)
+	require.NoError(t, err)
+	_, err = s.db.ExecContext(ctx, "DELETE FROM users WHERE id = ?", id)
+	require.NoError(t, err)
+
+	var templates int
+	require.NoError(t, s.db.QueryRowContext(ctx, "SELECT COUNT(*) FROM key_press_data WHERE user_id = ?", id).Scan(&templates))
+	assert.Zero(t, templates, "ON DELETE CASCADE must be enforced")
+}
+
+func TestDSN(t *testing.T) {
+	opts := Options{JournalMode: "WAL", ForeignKeys: "on"}
+
+	assert.Equal(t, "sso.db?_foreign_keys=on&_journal_mode=WAL&_txlock=immediate", dsn("sso.db", opts))
+	assert.Equal(t, "sso.db?_foreign_keys=on&_journal_mode=DELETE&_txlock=immediate", dsn("sso.db?_journal_mode=DELETE", opts),
+		"parameters of the storage path must win")
+	assert.Equal(t, "sso.db?_foreign_keys=on&_journal_mode=WAL&_txlock=immediate", dsn("sso.db?_txlock=deferred", opts),
+		"transactions must take the write lock when they begin")
+}
@@ -3,30 +3,67 @@ package sqlite
 import (
 	"context"
 	"database/sql"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"github.com/mattn/go-sqlite3"
 	"sso/internal/domain/models"
 	"sso/internal/lib/converter"
+	"sso/internal/lib/envelope"
 	"sso/internal/storage"
+	"time"
 )
 
 type Storage struct {
-	db *sql.DB
+	db      *sql.DB
+	stmts   *statements
+	keyring *envelope.Keyring
 }
 
-func (s *Storage) IsAdmin(ctx context.Context, userID int64) (bool, error) {
-	const op = "storage.sqlite.isAdmin"
+type txKey struct{}
 
-	stmt, err := s.db.Prepare("SELECT is_admin FROM users WHERE id = ?")
+// WithTx runs fn in a transaction. Storage calls made with the context passed
+// to fn take part in it; the transaction is rolled back if fn returns an
+// error and committed otherwise. Nested calls join the outer transaction.
+func (s *Storage) WithTx(ctx context.Context, fn func(ctx context.Context) error) error {
+	const op = "storage.sqlite.WithTx"
+
+	if _, ok := ctx.Value(txKey{}).(*sql.Tx); ok {
+		return fn(ctx)
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
 	if err != nil {
-		return false, fmt.Errorf("%s: %w", op, err)
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	if err := fn(context.WithValue(ctx, txKey{}, tx)); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
 	}
 
-	row := stmt.QueryRowContext(ctx, userID)
+	return nil
+}
+
+// stmt binds a prepared statement to the transaction of ctx, if any.
+func (s *Storage) stmt(ctx context.Context, stmt *sql.Stmt) *sql.Stmt {
+	if tx, ok := ctx.Value(txKey{}).(*sql.Tx); ok {
+		return tx.StmtContext(ctx, stmt)
+	}
+	return stmt
+}
+
+func (s *Storage) IsAdmin(ctx context.Context, userID int64) (bool, error) {
+	const op = "storage.sqlite.isAdmin"
+
+	row := s.stmt(ctx, s.stmts.isAdmin).QueryRowContext(ctx, userID)
 
 	var isAdmin bool
-	err = row.Scan(&isAdmin)
+	err := row.Scan(&isAdmin)
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			return false, fmt.Errorf("%s: %w", op, storage.ErrAppNotFound)
@@ -40,15 +77,8 @@ func (s *Storage) IsAdmin(ctx context.Context, userID int64) (bool, error) {
 func (s *Storage) App(ctx context.Context, appID int) (models.App, error) {
 	const op = "storage.sqlite.App"
 
-	stmt, err := s.db.Prepare("SELECT id, name, secret FROM apps WHERE id = ?")
-	if err != nil {
-		return models.App{}, fmt.Errorf("%s: %w", op, err)
-	}
-
-	row := stmt.QueryRowContext(ctx, appID)
-
 	var app models.App
-	err = row.Scan(&app.ID, &app.Name, &app.Secret)
+	err := s.scanApp(s.stmt(ctx, s.stmts.app).QueryRowContext(ctx, appID), &app)
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			return models.App{}, fmt.Errorf("%s: %w", op, storage.ErrAppNotFound)
@@ -59,32 +89,268 @@ func (s *Storage) App(ctx context.Context, appID int) (models.App, error) {
 	return app, nil
 }
 
-func New(storagePath string) (*Storage, error) {
+// Apps returns every app ordered by ID.
+func (s *Storage) Apps(ctx context.Context) ([]models.App, error) {
+	const op = "storage.sqlite.Apps"
+
+	rows, err := s.stmt(ctx, s.stmts.apps).QueryContext(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	defer rows.Close()
+
+	var apps []models.App
+	for rows.Next() {
+		var app models.App
+		if err := s.scanApp(rows, &app); err != nil {
+			return nil, fmt.Errorf("%s: %w", op, err)
+		}
+		apps = append(apps, app)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return apps, nil
+}
+
+// scanApp scans a row of appColumns and decrypts the secrets.
+func (s *Storage) scanApp(row interface{ Scan(dest ...any) error }, app *models.App) error {
+	var secrets storage.AppSecrets
+	var previousExpiresAt, createdAt, disabledAt sql.NullTime
+	var tokenTTL sql.NullInt64
+	var audience, issuer, claimsTemplate sql.NullString
+	err := row.Scan(&app.ID, &app.Name, &secrets.Secret, &secrets.Previous, &previousExpiresAt, &secrets.DataKey,
+		&app.ContinuousAuthThreshold, &app.Sensitivity, &createdAt, &disabledAt,
+		&tokenTTL, &audience, &issuer, &claimsTemplate)
+	if err != nil {
+		return err
+	}
+
+	app.TokenTTL = time.Duration(tokenTTL.Int64) * time.Second
+	app.Audience, app.Issuer = audience.String, issuer.String
+	app.ClaimsTemplate = nil
+	if claimsTemplate.Valid {
+		if err := json.Unmarshal([]byte(claimsTemplate.String), &app.ClaimsTemplate); err != nil {
+			return fmt.Errorf("app %d: claims template: %w", app.ID, err)
+		}
+		if app.ClaimsTemplate == nil {
+			app.ClaimsTemplate = []string{}
+		}
+	}
+
+	app.Secret, app.PreviousSecret, err = storage.OpenAppSecrets(s.keyring, secrets)
+	if err != nil {
+		return fmt.Errorf("app %d: %w", app.ID, err)
+	}
+	app.PreviousSecretExpiresAt = previousExpiresAt.Time
+	app.CreatedAt = createdAt.Time
+	if disabledAt.Valid {
+		app.DisabledAt = &disabledAt.Time
+	}
+	return nil
+}
+
+// appTokenSettings returns the token_ttl_seconds, audience, issuer and
+// claims_template values of the app; unset settings are NULL.
+func appTokenSettings(app models.App) ([]any, error) {
+	settings := []any{nil, nullString(app.Audience), nullString(app.Issuer), nil}
+	if app.TokenTTL > 0 {
+		settings[0] = int64(app.TokenTTL / time.Second)
+	}
+	if app.ClaimsTemplate != nil {
+		template, err := json.Marshal(app.ClaimsTemplate)
+		if err != nil {
+			return nil, err
+		}
+		settings[3] = string(template)
+	}
+	return settings, nil
+}
+
+// CreateApp stores a new app with an encrypted secret and returns its ID;
+// app.ID is ignored. It fails with storage.ErrAppExists if the name is
+// taken.
+func (s *Storage) CreateApp(ctx context.Context, app models.App) (int, error) {
+	const op = "storage.sqlite.CreateApp"
+
+	secrets, err := storage.SealAppSecrets(s.keyring, app.Secret, "")
+	if err != nil {
+		return 0, fmt.Errorf("%s: %w", op, err)
+	}
+
+	settings, err := appTokenSettings(app)
+	if err != nil {
+		return 0, fmt.Errorf("%s: %w", op, err)
+	}
+
+	args := append([]any{app.Name, secrets.Secret, secrets.DataKey, app.ContinuousAuthThreshold, app.Sensitivity, time.Now().UTC()}, settings...)
+	res, err := s.stmt(ctx, s.stmts.insertApp).ExecContext(ctx, args...)
+	if err != nil {
+		var sqliteErr sqlite3.Error
+
+		if errors.As(err, &sqliteErr) && sqliteErr.ExtendedCode == sqlite3.ErrConstraintUnique {
+			return 0, fmt.Errorf("%s: %w", op, storage.ErrAppExists)
+		}
+		return 0, fmt.Errorf("%s: %w", op, err)
+	}
+	id, err := res.LastInsertId()
+	if err != nil {
+		return 0, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return int(id), nil
+}
+
+// UpdateApp replaces the name, login and token settings of the app.
+// Secrets are changed with SetAppSecrets.
+func (s *Storage) UpdateApp(ctx context.Context, app models.App) error {
+	const op = "storage.sqlite.UpdateApp"
+
+	settings, err := appTokenSettings(app)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	args := append(append([]any{app.Name, app.ContinuousAuthThreshold, app.Sensitivity}, settings...), app.ID)
+	res, err := s.stmt(ctx, s.stmts.updateApp).ExecContext(ctx, args...)
+	if err != nil {
+		var sqliteErr sqlite3.Error
+
+		if errors.As(err, &sqliteErr) && sqliteErr.ExtendedCode == sqlite3.ErrConstraintUnique {
+			return fmt.Errorf("%s: %w", op, storage.ErrAppExists)
+		}
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	if n, err := res.RowsAffected(); err != nil || n == 0 {
+		return fmt.Errorf("%s: %w", op, storage.ErrAppNotFound)
+	}
+
+	return nil
+}
+
+// SetAppDisabledAt disables the app, or enables it when disabledAt is nil.
+func (s *Storage) SetAppDisabledAt(ctx context.Context, appID int, disabledAt *time.Time) error {
+	const op = "storage.sqlite.SetAppDisabledAt"
+
+	var value any
+	if disabledAt != nil {
+		value = disabledAt.UTC()
+	}
+
+	res, err := s.stmt(ctx, s.stmts.setAppDisabledAt).ExecContext(ctx, value, appID)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	if n, err := res.RowsAffected(); err != nil || n == 0 {
+		return fmt.Errorf("%s: %w", op, storage.ErrAppNotFound)
+	}
+
+	return nil
+}
+
+// SetAppSecrets replaces the secrets of the app. An empty previous secret
+// clears it.
+func (s *Storage) SetAppSecrets(ctx context.Context, appID int, secret string, previous string, previousExpiresAt time.Time) error {
+	const op = "storage.sqlite.SetAppSecrets"
+
+	secrets, err := storage.SealAppSecrets(s.keyring, secret, previous)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	var expiresAt any
+	if secrets.Previous.Valid {
+		expiresAt = previousExpiresAt.UTC()
+	}
+
+	res, err := s.stmt(ctx, s.stmts.setAppSecrets).ExecContext(ctx, secrets.Secret, secrets.Previous, expiresAt, secrets.DataKey, appID)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	if n, err := res.RowsAffected(); err != nil || n == 0 {
+		return fmt.Errorf("%s: %w", op, storage.ErrAppNotFound)
+	}
+
+	return nil
+}
+
+// DeleteApp removes the app with its sessions. Login attempts keep the ID
+// of the app for the risk signals.
+func (s *Storage) DeleteApp(ctx context.Context, appID int) error {
+	const op = "storage.sqlite.DeleteApp"
+
+	return s.WithTx(ctx, func(ctx context.Context) error {
+		if _, err := s.stmt(ctx, s.stmts.deleteAppSessions).ExecContext(ctx, appID); err != nil {
+			return fmt.Errorf("%s: %w", op, err)
+		}
+
+		res, err := s.stmt(ctx, s.stmts.deleteApp).ExecContext(ctx, appID)
+		if err != nil {
+			return fmt.Errorf("%s: %w", op, err)
+		}
+		if n, err := res.RowsAffected(); err != nil || n == 0 {
+			return fmt.Errorf("%s: %w", op, storage.ErrAppNotFound)
+		}
+
+		return nil
+	})
+}
+
+// New opens the sqlite database, which must be migrated, and prepares the
+// statements of the storage. Biometric templates are encrypted with per-user
+// data keys wrapped by the given keyring.
+func New(storagePath string, opts Options, keyring *envelope.Keyring) (*Storage, error) {
 	const op = "storage.sqlite.New"
 
-	db, err := sql.Open("sqlite3", storagePath)
+	db, err := sql.Open("sqlite3", dsn(storagePath, opts))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	opts.applyPool(db)
+
+	stmts, err := prepareStatements(context.Background(), db)
 	if err != nil {
+		_ = db.Close()
 		return nil, fmt.Errorf("%s: %w", op, err)
 	}
+
 	return &Storage{
-		db: db,
+		db:      db,
+		stmts:   stmts,
+		keyring: keyring,
 	}, nil
 }
 
 func (s *Storage) Close() error {
 	const op = "storage.sqlite.Close"
-	return s.db.Close()
-}
 
-func (s *Storage) SaveUser(ctx context.Context, email string, passHash []byte, pressTimes []float32, intervalTimes []float32) (int64, error) {
-	const op = "storage.sqlite.SaveUser"
+	if err := errors.Join(s.stmts.close(), s.db.Close()); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
 
-	stmt, err := s.db.Prepare("INSERT INTO users (email, pass_hash) VALUES (?, ?)")
+	return nil
+}
+
+// SaveUser stores the user together with the keystroke template; both are
+// written in one transaction.
+func (s *Storage) SaveUser(ctx context.Context, email string, passHash []byte, pressTimes []float32, intervalTimes []float32, keyEvents []models.KeyEvent) (int64, error) {
+	var id int64
+	err := s.WithTx(ctx, func(ctx context.Context) error {
+		var err error
+		id, err = s.saveUser(ctx, email, passHash, pressTimes, intervalTimes, keyEvents)
+		return err
+	})
 	if err != nil {
-		return 0, fmt.Errorf("%s: %w", op, err)
+		return 0, err
 	}
 
-	res, err := stmt.ExecContext(ctx, email, passHash)
+	return id, nil
+}
+
+func (s *Storage) saveUser(ctx context.Context, email string, passHash []byte, pressTimes []float32, intervalTimes []float32, keyEvents []models.KeyEvent) (int64, error) {
+	const op = "storage.sqlite.SaveUser"
+
+	res, err := s.stmt(ctx, s.stmts.insertUser).ExecContext(ctx, email, passHash, time.Now().UTC())
 	if err != nil {
 		var sqliteErr sqlite3.Error
 
@@ -98,13 +364,15 @@ func (s *Storage) SaveUser(ctx context.Context, email string, passHash []byte, p
 		return 0, fmt.Errorf("%s: %w", op, err)
 	}
 
-	stmt, err = s.db.Prepare("INSERT INTO key_press_data (user_id, key_press_intervals,key_press_times) VALUES (?, ?, ?)")
+	dataKey, wrappedKey, err := s.keyring.NewDataKey()
 	if err != nil {
 		return 0, fmt.Errorf("%s: %w", op, err)
 	}
-	intervals := converter.ToStringFromFloat32Slice(intervalTimes)
-	times := converter.ToStringFromFloat32Slice(pressTimes)
-	res, err = stmt.ExecContext(ctx, id, intervals, times)
+	t, err := storage.SealTemplate(dataKey, pressTimes, intervalTimes, keyEvents)
+	if err != nil {
+		return 0, fmt.Errorf("%s: %w", op, err)
+	}
+	_, err = s.stmt(ctx, s.stmts.insertTemplate).ExecContext(ctx, id, t.Intervals, t.Times, t.KeyEvents, wrappedKey)
 	if err != nil {
 		return 0, fmt.Errorf("%s: %w", op, err)
 	}
@@ -115,36 +383,719 @@ func (s *Storage) SaveUser(ctx context.Context, email string, passHash []byte, p
 func (s *Storage) User(ctx context.Context, email string) (models.User, error) {
 	const op = "storage.sqlite.User"
 
-	stmt, err := s.db.Prepare("SELECT id, email, pass_hash FROM users WHERE email = ?")
+	user, err := s.user(ctx, s.stmts.user, email)
 	if err != nil {
 		return models.User{}, fmt.Errorf("%s: %w", op, err)
 	}
+	return user, nil
+}
 
-	row := stmt.QueryRowContext(ctx, email)
+func (s *Storage) UserByID(ctx context.Context, userID int64) (models.User, error) {
+	const op = "storage.sqlite.UserByID"
 
+	user, err := s.user(ctx, s.stmts.userByID, userID)
+	if err != nil {
+		return models.User{}, fmt.Errorf("%s: %w", op, err)
+	}
+	return user, nil
+}
+
+// user reads the user selected by stmt together with the decrypted
+// keystroke template.
+func (s *Storage) user(ctx context.Context, stmt *sql.Stmt, key any) (models.User, error) {
 	var user models.User
-	err = row.Scan(&user.ID, &user.Email, &user.PassHash)
+	err := scanUser(s.stmt(ctx, stmt).QueryRowContext(ctx, key), &user)
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
-			return models.User{}, fmt.Errorf("%s: %w", op, storage.ErrUserNotFound)
+			return models.User{}, storage.ErrUserNotFound
 		}
-		return models.User{}, fmt.Errorf("%s: %w", op, err)
+		return models.User{}, err
 	}
-	stmt, err = s.db.Prepare("SELECT key_press_intervals, key_press_times FROM key_press_data WHERE user_id = ?")
+
+	row := s.stmt(ctx, s.stmts.template).QueryRowContext(ctx, user.ID)
+	var t storage.Template
+	var wrappedKey []byte
+	err = row.Scan(&t.Intervals, &t.Times, &t.KeyEvents, &wrappedKey)
 	if err != nil {
-		return models.User{}, fmt.Errorf("%s: %w", op, err)
+		if errors.Is(err, sql.ErrNoRows) {
+			return models.User{}, storage.ErrUserNotFound
+		}
+		return models.User{}, err
+	}
+
+	user.PressTimes, user.PressIntervals, user.KeyEvents, err = storage.OpenTemplate(s.keyring, wrappedKey, t)
+	if err != nil {
+		return models.User{}, err
+	}
+	return user, nil
+}
+
+// scanUser scans a row of userColumns.
+func scanUser(row interface{ Scan(dest ...any) error }, user *models.User) error {
+	var createdAt, disabledAt, deleteAfter, codeExpiresAt sql.NullTime
+	var pendingEmail sql.NullString
+	var codeHash []byte
+	err := row.Scan(&user.ID, &user.Email, &user.PassHash, &user.IsAdmin, &createdAt, &disabledAt, &user.EnrollmentPending, &deleteAfter,
+		&user.DisplayName, &user.Locale, &user.Timezone, &pendingEmail, &codeHash, &codeExpiresAt)
+	if err != nil {
+		return err
+	}
+
+	user.CreatedAt = createdAt.Time
+	if disabledAt.Valid {
+		user.DisabledAt = &disabledAt.Time
+	}
+	if deleteAfter.Valid {
+		user.DeleteAfter = &deleteAfter.Time
 	}
+	if pendingEmail.Valid {
+		user.EmailChange = &models.EmailChange{
+			Email:     pendingEmail.String,
+			CodeHash:  codeHash,
+			ExpiresAt: codeExpiresAt.Time,
+		}
+	}
+	return nil
+}
+
+// ListUsers returns the users matching filter ordered by ID. Keystroke
+// templates are not read: listings are for admins, who never see them.
+func (s *Storage) ListUsers(ctx context.Context, filter models.UserFilter) ([]models.User, error) {
+	const op = "storage.sqlite.ListUsers"
+
+	limit := filter.Limit
+	if limit <= 0 {
+		limit = -1
+	}
+
+	rows, err := s.stmt(ctx, s.stmts.listUsers).QueryContext(ctx,
+		sql.Named("after_id", filter.AfterID),
+		sql.Named("email_prefix", filter.EmailPrefix),
+		sql.Named("is_admin", nullBool(filter.IsAdmin)),
+		sql.Named("disabled", nullBool(filter.Disabled)),
+		sql.Named("created_from", nullTime(filter.CreatedFrom)),
+		sql.Named("created_to", nullTime(filter.CreatedTo)),
+		sql.Named("limit", limit),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	defer rows.Close()
+
+	var users []models.User
+	for rows.Next() {
+		var user models.User
+		if err := scanUser(rows, &user); err != nil {
+			return nil, fmt.Errorf("%s: %w", op, err)
+		}
+		users = append(users, user)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return users, nil
+}
+
+// nullBool binds an unset filter as NULL.
+func nullBool(b *bool) any {
+	if b == nil {
+		return nil
+	}
+	return *b
+}
+
+// nullTime binds an unset filter as NULL.
+func nullTime(t time.Time) any {
+	if t.IsZero() {
+		return nil
+	}
+	return t.UTC()
+}
+
+// nullString binds an unset setting as NULL.
+func nullString(s string) any {
+	if s == "" {
+		return nil
+	}
+	return s
+}
+
+// Profiles returns every user that has a keystroke template, with the
+// template decrypted. It is meant for offline tooling, not for requests.
+func (s *Storage) Profiles(ctx context.Context) ([]models.User, error) {
+	const op = "storage.sqlite.Profiles"
 
-	row = stmt.QueryRowContext(ctx, user.ID)
-	strIntervals, strTimes := "", ""
-	err = row.Scan(&strIntervals, &strTimes)
-	user.PressTimes = converter.ToFloat32SliceFromString(strTimes)
-	user.PressIntervals = converter.ToFloat32SliceFromString(strIntervals)
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT u.id, u.email, k.key_press_intervals, k.key_press_times, k.key_events, k.data_key
+		FROM users u
+		JOIN key_press_data k ON k.user_id = u.id
+		ORDER BY u.id`)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	defer rows.Close()
+
+	var users []models.User
+	for rows.Next() {
+		var user models.User
+		var t storage.Template
+		var wrappedKey []byte
+		if err := rows.Scan(&user.ID, &user.Email, &t.Intervals, &t.Times, &t.KeyEvents, &wrappedKey); err != nil {
+			return nil, fmt.Errorf("%s: %w", op, err)
+		}
+
+		user.PressTimes, user.PressIntervals, user.KeyEvents, err = storage.OpenTemplate(s.keyring, wrappedKey, t)
+		if err != nil {
+			return nil, fmt.Errorf("%s: user %d: %w", op, user.ID, err)
+		}
+		users = append(users, user)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return users, nil
+}
+
+// UpdateBiometrics replaces the user's keystroke template with a freshly
+// encrypted one and clears a pending enrollment.
+func (s *Storage) UpdateBiometrics(ctx context.Context, userID int64, pressTimes []float32, intervalTimes []float32, keyEvents []models.KeyEvent) error {
+	const op = "storage.sqlite.UpdateBiometrics"
+
+	dataKey, wrappedKey, err := s.keyring.NewDataKey()
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	t, err := storage.SealTemplate(dataKey, pressTimes, intervalTimes, keyEvents)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return s.WithTx(ctx, func(ctx context.Context) error {
+		res, err := s.stmt(ctx, s.stmts.clearEnrollmentPending).ExecContext(ctx, userID)
+		if err != nil {
+			return fmt.Errorf("%s: %w", op, err)
+		}
+		if n, err := res.RowsAffected(); err != nil || n == 0 {
+			return fmt.Errorf("%s: %w", op, storage.ErrUserNotFound)
+		}
+
+		res, err = s.stmt(ctx, s.stmts.updateTemplate).ExecContext(ctx, t.Intervals, t.Times, t.KeyEvents, wrappedKey, userID)
+		if err != nil {
+			return fmt.Errorf("%s: %w", op, err)
+		}
+		if n, err := res.RowsAffected(); err == nil && n == 0 {
+			_, err = s.stmt(ctx, s.stmts.insertTemplate).ExecContext(ctx, userID, t.Intervals, t.Times, t.KeyEvents, wrappedKey)
+			if err != nil {
+				return fmt.Errorf("%s: %w", op, err)
+			}
+		}
+
+		return nil
+	})
+}
+
+// DeleteUser removes the user with the keystroke template, sessions and
+// login history in one transaction. Dependent rows are deleted explicitly,
+// so this does not rely on the foreign_keys pragma being on.
+func (s *Storage) DeleteUser(ctx context.Context, userID int64) error {
+	const op = "storage.sqlite.DeleteUser"
+
+	return s.WithTx(ctx, func(ctx context.Context) error {
+		for _, stmt := range []*sql.Stmt{
+			s.stmts.deleteTemplate,
+			s.stmts.deleteUserSessions,
+			s.stmts.deleteUserLoginAttempts,
+		} {
+			if _, err := s.stmt(ctx, stmt).ExecContext(ctx, userID); err != nil {
+				return fmt.Errorf("%s: %w", op, err)
+			}
+		}
+
+		res, err := s.stmt(ctx, s.stmts.deleteUser).ExecContext(ctx, userID)
+		if err != nil {
+			return fmt.Errorf("%s: %w", op, err)
+		}
+		if n, err := res.RowsAffected(); err != nil || n == 0 {
+			return fmt.Errorf("%s: %w", op, storage.ErrUserNotFound)
+		}
+
+		return nil
+	})
+}
+
+// SetEnrollmentPending marks the user's keystroke template as stale, so the
+// next successful login enrolls a new one.
+func (s *Storage) SetEnrollmentPending(ctx context.Context, userID int64) error {
+	const op = "storage.sqlite.SetEnrollmentPending"
+
+	res, err := s.stmt(ctx, s.stmts.setEnrollmentPending).ExecContext(ctx, userID)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	if n == 0 {
+		return fmt.Errorf("%s: %w", op, storage.ErrUserNotFound)
+	}
+
+	return nil
+}
+
+// UpdateProfile replaces the profile of the user.
+func (s *Storage) UpdateProfile(ctx context.Context, userID int64, profile models.Profile) error {
+	const op = "storage.sqlite.UpdateProfile"
+
+	res, err := s.stmt(ctx, s.stmts.updateProfile).ExecContext(ctx, profile.DisplayName, profile.Locale, profile.Timezone, userID)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	if n, err := res.RowsAffected(); err != nil || n == 0 {
+		return fmt.Errorf("%s: %w", op, storage.ErrUserNotFound)
+	}
+
+	return nil
+}
+
+func (s *Storage) UpdatePassword(ctx context.Context, userID int64, passHash []byte) error {
+	const op = "storage.sqlite.UpdatePassword"
+
+	res, err := s.stmt(ctx, s.stmts.updatePassword).ExecContext(ctx, passHash, userID)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	if n, err := res.RowsAffected(); err != nil || n == 0 {
+		return fmt.Errorf("%s: %w", op, storage.ErrUserNotFound)
+	}
+
+	return nil
+}
+
+// SetEmail changes the email address of the user; it fails with
+// storage.ErrUserExists if another user has the address.
+func (s *Storage) SetEmail(ctx context.Context, userID int64, email string) error {
+	const op = "storage.sqlite.SetEmail"
+
+	res, err := s.stmt(ctx, s.stmts.setEmail).ExecContext(ctx, email, userID)
+	if err != nil {
+		var sqliteErr sqlite3.Error
+
+		if errors.As(err, &sqliteErr) && sqliteErr.ExtendedCode == sqlite3.ErrConstraintUnique {
+			return fmt.Errorf("%s: %w", op, storage.ErrUserExists)
+		}
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	if n, err := res.RowsAffected(); err != nil || n == 0 {
+		return fmt.Errorf("%s: %w", op, storage.ErrUserNotFound)
+	}
+
+	return nil
+}
+
+// SetEmailChange stores a requested change of the email address, or clears
+// it when change is nil.
+func (s *Storage) SetEmailChange(ctx context.Context, userID int64, change *models.EmailChange) error {
+	const op = "storage.sqlite.SetEmailChange"
+
+	var email, codeHash, expiresAt any
+	if change != nil {
+		email, codeHash, expiresAt = change.Email, change.CodeHash, change.ExpiresAt.UTC()
+	}
+
+	res, err := s.stmt(ctx, s.stmts.setEmailChange).ExecContext(ctx, email, codeHash, expiresAt, userID)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	if n, err := res.RowsAffected(); err != nil || n == 0 {
+		return fmt.Errorf("%s: %w", op, storage.ErrUserNotFound)
+	}
+
+	return nil
+}
+
+// SetAdmin grants or revokes admin rights of a user.
+func (s *Storage) SetAdmin(ctx context.Context, userID int64, isAdmin bool) error {
+	const op = "storage.sqlite.SetAdmin"
+
+	res, err := s.stmt(ctx, s.stmts.setAdmin).ExecContext(ctx, isAdmin, userID)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	if n, err := res.RowsAffected(); err != nil || n == 0 {
+		return fmt.Errorf("%s: %w", op, storage.ErrUserNotFound)
+	}
+
+	return nil
+}
+
+// SetDisabledAt disables the user, or enables it when disabledAt is nil.
+func (s *Storage) SetDisabledAt(ctx context.Context, userID int64, disabledAt *time.Time) error {
+	const op = "storage.sqlite.SetDisabledAt"
+
+	var value any
+	if disabledAt != nil {
+		value = disabledAt.UTC()
+	}
+
+	res, err := s.stmt(ctx, s.stmts.setDisabledAt).ExecContext(ctx, value, userID)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	if n, err := res.RowsAffected(); err != nil || n == 0 {
+		return fmt.Errorf("%s: %w", op, storage.ErrUserNotFound)
+	}
+
+	return nil
+}
+
+// SetDeleteAfter schedules the deletion of the user, or cancels it when
+// deleteAfter is nil.
+func (s *Storage) SetDeleteAfter(ctx context.Context, userID int64, deleteAfter *time.Time) error {
+	const op = "storage.sqlite.SetDeleteAfter"
+
+	var value any
+	if deleteAfter != nil {
+		value = deleteAfter.UTC()
+	}
+
+	res, err := s.stmt(ctx, s.stmts.setDeleteAfter).ExecContext(ctx, value, userID)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	if n == 0 {
+		return fmt.Errorf("%s: %w", op, storage.ErrUserNotFound)
+	}
+
+	return nil
+}
+
+// UsersDueForDeletion returns the users whose deletion is scheduled at or
+// before now.
+func (s *Storage) UsersDueForDeletion(ctx context.Context, now time.Time) ([]int64, error) {
+	const op = "storage.sqlite.UsersDueForDeletion"
+
+	rows, err := s.stmt(ctx, s.stmts.usersDueForDeletion).QueryContext(ctx, now.UTC())
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	defer rows.Close()
+
+	var ids []int64
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			return nil, fmt.Errorf("%s: %w", op, err)
+		}
+		ids = append(ids, id)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return ids, nil
+}
+
+func (s *Storage) SaveSession(ctx context.Context, session models.Session) error {
+	const op = "storage.sqlite.SaveSession"
+
+	_, err := s.stmt(ctx, s.stmts.insertSession).ExecContext(ctx, session.ID, session.UserID, session.AppID, session.CreatedAt.UTC(), session.ExpiresAt.UTC())
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+func (s *Storage) Session(ctx context.Context, sessionID string) (models.Session, error) {
+	const op = "storage.sqlite.Session"
+
+	row := s.stmt(ctx, s.stmts.session).QueryRowContext(ctx, sessionID)
+
+	var session models.Session
+	var revokedAt sql.NullTime
+	err := row.Scan(&session.ID, &session.UserID, &session.AppID, &session.CreatedAt, &session.ExpiresAt, &revokedAt)
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
-			return models.User{}, fmt.Errorf("%s: %w", op, storage.ErrUserNotFound)
+			return models.Session{}, fmt.Errorf("%s: %w", op, storage.ErrSessionNotFound)
 		}
-		return models.User{}, fmt.Errorf("%s: %w", op, err)
+		return models.Session{}, fmt.Errorf("%s: %w", op, err)
 	}
-	return user, nil
+	if revokedAt.Valid {
+		session.RevokedAt = &revokedAt.Time
+	}
+
+	return session, nil
+}
+
+func (s *Storage) RevokeSession(ctx context.Context, sessionID string) error {
+	const op = "storage.sqlite.RevokeSession"
+
+	if _, err := s.stmt(ctx, s.stmts.revokeSession).ExecContext(ctx, time.Now().UTC(), sessionID); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+// UserSessions returns every session of the user, newest first.
+func (s *Storage) UserSessions(ctx context.Context, userID int64) ([]models.Session, error) {
+	const op = "storage.sqlite.UserSessions"
+
+	rows, err := s.stmt(ctx, s.stmts.userSessions).QueryContext(ctx, userID)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	defer rows.Close()
+
+	var sessions []models.Session
+	for rows.Next() {
+		var session models.Session
+		var revokedAt sql.NullTime
+		if err := rows.Scan(&session.ID, &session.UserID, &session.AppID, &session.CreatedAt, &session.ExpiresAt, &revokedAt); err != nil {
+			return nil, fmt.Errorf("%s: %w", op, err)
+		}
+		if revokedAt.Valid {
+			session.RevokedAt = &revokedAt.Time
+		}
+		sessions = append(sessions, session)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return sessions, nil
+}
+
+// RevokeUserSessions revokes every active session of the user but
+// exceptSessionID, which may be empty.
+func (s *Storage) RevokeUserSessions(ctx context.Context, userID int64, exceptSessionID string) error {
+	const op = "storage.sqlite.RevokeUserSessions"
+
+	if _, err := s.stmt(ctx, s.stmts.revokeUserSessions).ExecContext(ctx, time.Now().UTC(), userID, exceptSessionID); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+func (s *Storage) SaveLoginAttempt(ctx context.Context, attempt models.LoginAttempt) error {
+	const op = "storage.sqlite.SaveLoginAttempt"
+
+	_, err := s.stmt(ctx, s.stmts.insertLoginAttempt).ExecContext(ctx, attempt.UserID, attempt.AppID, attempt.IP, attempt.Device, attempt.Success, attempt.CreatedAt.UTC())
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+// LoginAttempts returns the user's attempts since the given time, newest first.
+func (s *Storage) LoginAttempts(ctx context.Context, userID int64, since time.Time) ([]models.LoginAttempt, error) {
+	const op = "storage.sqlite.LoginAttempts"
+
+	rows, err := s.stmt(ctx, s.stmts.loginAttempts).QueryContext(ctx, userID, since.UTC())
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	defer rows.Close()
+
+	var attempts []models.LoginAttempt
+	for rows.Next() {
+		var a models.LoginAttempt
+		if err := rows.Scan(&a.UserID, &a.AppID, &a.IP, &a.Device, &a.Success, &a.CreatedAt); err != nil {
+			return nil, fmt.Errorf("%s: %w", op, err)
+		}
+		attempts = append(attempts, a)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return attempts, nil
+}
+
+func (s *Storage) SaveAuditEvent(ctx context.Context, event models.AuditEvent) error {
+	const op = "storage.sqlite.SaveAuditEvent"
+
+	_, err := s.stmt(ctx, s.stmts.insertAuditEvent).ExecContext(ctx, event.UserID, event.ActorID, event.Action, event.IP, event.CreatedAt.UTC())
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+// AuditEvents returns the events about the user, oldest first. They are
+// kept after the user is deleted.
+func (s *Storage) AuditEvents(ctx context.Context, userID int64) ([]models.AuditEvent, error) {
+	const op = "storage.sqlite.AuditEvents"
+
+	rows, err := s.stmt(ctx, s.stmts.auditEvents).QueryContext(ctx, userID)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	defer rows.Close()
+
+	var events []models.AuditEvent
+	for rows.Next() {
+		var e models.AuditEvent
+		if err := rows.Scan(&e.UserID, &e.ActorID, &e.Action, &e.IP, &e.CreatedAt); err != nil {
+			return nil, fmt.Errorf("%s: %w", op, err)
+		}
+		events = append(events, e)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return events, nil
+}
+
+// Rewrap re-encrypts the data keys of every user and app with the next
+// keyring, so the master key can be rotated, and returns how many records
+// were rewrapped. Templates and app secrets stored before encryption was
+// introduced are encrypted on the way.
+func (s *Storage) Rewrap(ctx context.Context, next *envelope.Keyring) (int, error) {
+	const op = "storage.sqlite.Rewrap"
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, fmt.Errorf("%s: %w", op, err)
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	rows, err := tx.QueryContext(ctx, "SELECT data_id, key_press_intervals, key_press_times, data_key FROM key_press_data")
+	if err != nil {
+		return 0, fmt.Errorf("%s: %w", op, err)
+	}
+
+	type record struct {
+		id         int64
+		intervals  string
+		times      string
+		wrappedKey []byte
+	}
+
+	var records []record
+	for rows.Next() {
+		var r record
+		if err := rows.Scan(&r.id, &r.intervals, &r.times, &r.wrappedKey); err != nil {
+			_ = rows.Close()
+			return 0, fmt.Errorf("%s: %w", op, err)
+		}
+		records = append(records, r)
+	}
+	_ = rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, fmt.Errorf("%s: %w", op, err)
+	}
+
+	for _, r := range records {
+		if r.wrappedKey == nil {
+			dataKey, wrappedKey, err := next.NewDataKey()
+			if err != nil {
+				return 0, fmt.Errorf("%s: %w", op, err)
+			}
+			pressTimes, intervalTimes := converter.ToFloat32SliceFromString(r.times), converter.ToFloat32SliceFromString(r.intervals)
+			t, err := storage.SealTemplate(dataKey, pressTimes, intervalTimes, nil)
+			if err != nil {
+				return 0, fmt.Errorf("%s: %w", op, err)
+			}
+			_, err = tx.ExecContext(ctx,
+				"UPDATE key_press_data SET key_press_intervals = ?, key_press_times = ?, data_key = ? WHERE data_id = ?",
+				t.Intervals, t.Times, wrappedKey, r.id)
+			if err != nil {
+				return 0, fmt.Errorf("%s: %w", op, err)
+			}
+			continue
+		}
+
+		dataKey, err := s.keyring.Unwrap(r.wrappedKey)
+		if err != nil {
+			return 0, fmt.Errorf("%s: data_id %d: %w", op, r.id, err)
+		}
+		wrappedKey, err := next.Wrap(dataKey)
+		if err != nil {
+			return 0, fmt.Errorf("%s: %w", op, err)
+		}
+		if _, err := tx.ExecContext(ctx, "UPDATE key_press_data SET data_key = ? WHERE data_id = ?", wrappedKey, r.id); err != nil {
+			return 0, fmt.Errorf("%s: %w", op, err)
+		}
+	}
+
+	apps, err := rewrapApps(ctx, tx, s.keyring, next)
+	if err != nil {
+		return 0, fmt.Errorf("%s: %w", op, err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return len(records) + apps, nil
+}
+
+// rewrapApps is Rewrap for the data keys of app secrets. Secrets stored
+// before encryption was introduced are encrypted on the way.
+func rewrapApps(ctx context.Context, tx *sql.Tx, current *envelope.Keyring, next *envelope.Keyring) (int, error) {
+	rows, err := tx.QueryContext(ctx, "SELECT id, secret, previous_secret, data_key FROM apps")
+	if err != nil {
+		return 0, err
+	}
+
+	type record struct {
+		id      int
+		secrets storage.AppSecrets
+	}
+
+	var records []record
+	for rows.Next() {
+		var r record
+		if err := rows.Scan(&r.id, &r.secrets.Secret, &r.secrets.Previous, &r.secrets.DataKey); err != nil {
+			_ = rows.Close()
+			return 0, err
+		}
+		records = append(records, r)
+	}
+	_ = rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	for _, r := range records {
+		if r.secrets.DataKey == nil {
+			secrets, err := storage.SealAppSecrets(next, r.secrets.Secret, r.secrets.Previous.String)
+			if err != nil {
+				return 0, err
+			}
+			_, err = tx.ExecContext(ctx,
+				"UPDATE apps SET secret = ?, previous_secret = ?, data_key = ? WHERE id = ?",
+				secrets.Secret, secrets.Previous, secrets.DataKey, r.id)
+			if err != nil {
+				return 0, err
+			}
+			continue
+		}
+
+		dataKey, err := current.Unwrap(r.secrets.DataKey)
+		if err != nil {
+			return 0, fmt.Errorf("app %d: %w", r.id, err)
+		}
+		wrappedKey, err := next.Wrap(dataKey)
+		if err != nil {
+			return 0, err
+		}
+		if _, err := tx.ExecContext(ctx, "UPDATE apps SET data_key = ? WHERE id = ?", wrappedKey, r.id); err != nil {
+			return 0, err
+		}
+	}
+
+	return len(records), nil
 }
@@ -0,0 +1,97 @@
+package sqlite_test
+
+import (
+	"context"
+	"github.com/golang-migrate/migrate/v4"
+	_ "github.com/golang-migrate/migrate/v4/database/sqlite3"
+	_ "github.com/golang-migrate/migrate/v4/source/file"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"path/filepath"
+	"sso/internal/domain/models"
+	"sso/internal/lib/envelope"
+	"sso/internal/storage/sqlite"
+	"sso/internal/storage/storagetest"
+	"testing"
+)
+
+const migrationsPath = "../../../migrations"
+
+func TestConformance(t *testing.T) {
+	storagetest.Run(t, func(t *testing.T) storagetest.Storage {
+		return newStorage(t)
+	})
+}
+
+func TestRewrap(t *testing.T) {
+	ctx := context.Background()
+	path := migratedPath(t)
+
+	current, err := envelope.New(make([]byte, envelope.KeySize))
+	require.NoError(t, err)
+	nextKey := make([]byte, envelope.KeySize)
+	nextKey[0] = 1
+	next, err := envelope.New(nextKey)
+	require.NoError(t, err)
+
+	s, err := sqlite.New(path, sqlite.DefaultOptions(), current)
+	require.NoError(t, err)
+	events := []models.KeyEvent{{Key: "a", PressedAt: 0, ReleasedAt: 110}, {Key: "b", PressedAt: 210, ReleasedAt: 305}}
+	_, err = s.SaveUser(ctx, "user@example.com", []byte("hash"), []float32{110, 95}, []float32{210}, events)
+	require.NoError(t, err)
+	appID, err := s.CreateApp(ctx, models.App{Name: "test", Secret: "app-secret"})
+	require.NoError(t, err)
+
+	n, err := s.Rewrap(ctx, next)
+	require.NoError(t, err)
+	assert.Equal(t, 2, n)
+	require.NoError(t, s.Close())
+
+	rotated, err := sqlite.New(path, sqlite.DefaultOptions(), next)
+	require.NoError(t, err)
+	defer rotated.Close()
+	user, err := rotated.User(ctx, "user@example.com")
+	require.NoError(t, err, "the next master key must open rewrapped templates")
+	assert.Equal(t, []float32{110, 95}, user.PressTimes)
+	assert.Equal(t, []float32{210}, user.PressIntervals)
+	assert.Equal(t, events, user.KeyEvents)
+	app, err := rotated.App(ctx, appID)
+	require.NoError(t, err, "the next master key must open rewrapped app secrets")
+	assert.Equal(t, "app-secret", app.Secret)
+
+	stale, err := sqlite.New(path, sqlite.DefaultOptions(), current)
+	require.NoError(t, err)
+	defer stale.Close()
+	_, err = stale.User(ctx, "user@example.com")
+	assert.ErrorIs(t, err, envelope.ErrMalformedCipher, "the old master key must not open rewrapped templates")
+}
+
+func newStorage(t *testing.T) *sqlite.Storage {
+	t.Helper()
+
+	keyring, err := envelope.New(make([]byte, envelope.KeySize))
+	require.NoError(t, err)
+
+	s, err := sqlite.New(migratedPath(t), sqlite.DefaultOptions(), keyring)
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = s.Close() })
+
+	return s
+}
+
+// migratedPath returns the path of a fresh database with every migration
+// applied.
+func migratedPath(t *testing.T) string {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "sso.db")
+
+	m, err := migrate.New("file://"+migrationsPath, "sqlite3://"+path)
+	require.NoError(t, err)
+	require.NoError(t, m.Up())
+	srcErr, dbErr := m.Close()
+	require.NoError(t, srcErr)
+	require.NoError(t, dbErr)
+
+	return path
+}
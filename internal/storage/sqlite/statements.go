@@ -0,0 +1,159 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+)
+
+// userColumns are the users columns scanned by scanUser.
+const userColumns = "id, email, pass_hash, is_admin, created_at, disabled_at, enrollment_pending, delete_after, display_name, locale, timezone, pending_email, email_code_hash, email_code_expires_at"
+
+// appColumns are the apps columns scanned by Storage.scanApp.
+const appColumns = "id, name, secret, previous_secret, previous_secret_expires_at, data_key, continuous_auth_threshold, sensitivity, created_at, disabled_at, token_ttl_seconds, audience, issuer, claims_template"
+
+// statements are prepared once when the storage is opened and shared by all
+// requests; transactions use them through Storage.stmt.
+type statements struct {
+	isAdmin *sql.Stmt
+
+	app               *sql.Stmt
+	apps              *sql.Stmt
+	insertApp         *sql.Stmt
+	updateApp         *sql.Stmt
+	setAppDisabledAt  *sql.Stmt
+	setAppSecrets     *sql.Stmt
+	deleteApp         *sql.Stmt
+	deleteAppSessions *sql.Stmt
+
+	insertUser             *sql.Stmt
+	user                   *sql.Stmt
+	userByID               *sql.Stmt
+	setEnrollmentPending   *sql.Stmt
+	clearEnrollmentPending *sql.Stmt
+	deleteUser             *sql.Stmt
+	setDeleteAfter         *sql.Stmt
+	usersDueForDeletion    *sql.Stmt
+	updateProfile          *sql.Stmt
+	updatePassword         *sql.Stmt
+	setEmail               *sql.Stmt
+	setEmailChange         *sql.Stmt
+	setAdmin               *sql.Stmt
+	setDisabledAt          *sql.Stmt
+	listUsers              *sql.Stmt
+
+	insertTemplate *sql.Stmt
+	template       *sql.Stmt
+	updateTemplate *sql.Stmt
+	deleteTemplate *sql.Stmt
+
+	insertSession      *sql.Stmt
+	session            *sql.Stmt
+	revokeSession      *sql.Stmt
+	deleteUserSessions *sql.Stmt
+	userSessions       *sql.Stmt
+	revokeUserSessions *sql.Stmt
+
+	insertLoginAttempt      *sql.Stmt
+	loginAttempts           *sql.Stmt
+	deleteUserLoginAttempts *sql.Stmt
+
+	insertAuditEvent *sql.Stmt
+	auditEvents      *sql.Stmt
+}
+
+// query is a statement together with its SQL.
+type query struct {
+	stmt **sql.Stmt
+	sql  string
+}
+
+func (st *statements) queries() []query {
+	return []query{
+		{&st.isAdmin, "SELECT is_admin FROM users WHERE id = ?"},
+
+		{&st.app, "SELECT " + appColumns + " FROM apps WHERE id = ?"},
+		{&st.apps, "SELECT " + appColumns + " FROM apps ORDER BY id"},
+		{&st.insertApp, "INSERT INTO apps (name, secret, data_key, continuous_auth_threshold, sensitivity, created_at, token_ttl_seconds, audience, issuer, claims_template) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)"},
+		{&st.updateApp, "UPDATE apps SET name = ?, continuous_auth_threshold = ?, sensitivity = ?, token_ttl_seconds = ?, audience = ?, issuer = ?, claims_template = ? WHERE id = ?"},
+		{&st.setAppDisabledAt, "UPDATE apps SET disabled_at = ? WHERE id = ?"},
+		{&st.setAppSecrets, "UPDATE apps SET secret = ?, previous_secret = ?, previous_secret_expires_at = ?, data_key = ? WHERE id = ?"},
+		{&st.deleteApp, "DELETE FROM apps WHERE id = ?"},
+		{&st.deleteAppSessions, "DELETE FROM sessions WHERE app_id = ?"},
+
+		{&st.insertUser, "INSERT INTO users (email, pass_hash, created_at) VALUES (?, ?, ?)"},
+		{&st.user, "SELECT " + userColumns + " FROM users WHERE email = ?"},
+		{&st.userByID, "SELECT " + userColumns + " FROM users WHERE id = ?"},
+		{&st.setEnrollmentPending, "UPDATE users SET enrollment_pending = TRUE WHERE id = ?"},
+		{&st.clearEnrollmentPending, "UPDATE users SET enrollment_pending = FALSE WHERE id = ?"},
+		{&st.deleteUser, "DELETE FROM users WHERE id = ?"},
+		{&st.setDeleteAfter, "UPDATE users SET delete_after = ? WHERE id = ?"},
+		{&st.usersDueForDeletion, "SELECT id FROM users WHERE delete_after <= ? ORDER BY id"},
+		{&st.updateProfile, "UPDATE users SET display_name = ?, locale = ?, timezone = ? WHERE id = ?"},
+		{&st.updatePassword, "UPDATE users SET pass_hash = ? WHERE id = ?"},
+		{&st.setEmail, "UPDATE users SET email = ? WHERE id = ?"},
+		{&st.setEmailChange, "UPDATE users SET pending_email = ?, email_code_hash = ?, email_code_expires_at = ? WHERE id = ?"},
+		{&st.setAdmin, "UPDATE users SET is_admin = ? WHERE id = ?"},
+		{&st.setDisabledAt, "UPDATE users SET disabled_at = ? WHERE id = ?"},
+		// Unset filters are passed as NULL and match every user.
+		{&st.listUsers, `
+			SELECT ` + userColumns + `
+			FROM users
+			WHERE id > :after_id
+			  AND substr(email, 1, length(:email_prefix)) = :email_prefix
+			  AND (:is_admin IS NULL OR is_admin = :is_admin)
+			  AND (:disabled IS NULL OR (disabled_at IS NOT NULL) = :disabled)
+			  AND (:created_from IS NULL OR created_at >= :created_from)
+			  AND (:created_to IS NULL OR created_at < :created_to)
+			ORDER BY id
+			LIMIT :limit`},
+
+		{&st.insertTemplate, "INSERT INTO key_press_data (user_id, key_press_intervals, key_press_times, key_events, data_key) VALUES (?, ?, ?, ?, ?)"},
+		{&st.template, "SELECT key_press_intervals, key_press_times, key_events, data_key FROM key_press_data WHERE user_id = ?"},
+		{&st.updateTemplate, "UPDATE key_press_data SET key_press_intervals = ?, key_press_times = ?, key_events = ?, data_key = ? WHERE user_id = ?"},
+		{&st.deleteTemplate, "DELETE FROM key_press_data WHERE user_id = ?"},
+
+		{&st.insertSession, "INSERT INTO sessions (id, user_id, app_id, created_at, expires_at) VALUES (?, ?, ?, ?, ?)"},
+		{&st.session, "SELECT id, user_id, app_id, created_at, expires_at, revoked_at FROM sessions WHERE id = ?"},
+		{&st.revokeSession, "UPDATE sessions SET revoked_at = ? WHERE id = ? AND revoked_at IS NULL"},
+		{&st.deleteUserSessions, "DELETE FROM sessions WHERE user_id = ?"},
+		{&st.userSessions, "SELECT id, user_id, app_id, created_at, expires_at, revoked_at FROM sessions WHERE user_id = ? ORDER BY created_at DESC"},
+		{&st.revokeUserSessions, "UPDATE sessions SET revoked_at = ? WHERE user_id = ? AND id != ? AND revoked_at IS NULL"},
+
+		{&st.insertLoginAttempt, "INSERT INTO login_attempts (user_id, app_id, ip, device, success, created_at) VALUES (?, ?, ?, ?, ?, ?)"},
+		{&st.loginAttempts, `
+			SELECT user_id, app_id, ip, device, success, created_at
+			FROM login_attempts
+			WHERE user_id = ? AND created_at >= ?
+			ORDER BY created_at DESC`},
+		{&st.deleteUserLoginAttempts, "DELETE FROM login_attempts WHERE user_id = ?"},
+
+		{&st.insertAuditEvent, "INSERT INTO audit_events (user_id, actor_id, action, ip, created_at) VALUES (?, ?, ?, ?, ?)"},
+		{&st.auditEvents, "SELECT user_id, actor_id, action, ip, created_at FROM audit_events WHERE user_id = ? ORDER BY created_at, id"},
+	}
+}
+
+func prepareStatements(ctx context.Context, db *sql.DB) (*statements, error) {
+	st := &statements{}
+	for _, q := range st.queries() {
+		stmt, err := db.PrepareContext(ctx, q.sql)
+		if err != nil {
+			_ = st.close()
+			return nil, fmt.Errorf("prepare %q: %w", q.sql, err)
+		}
+		*q.stmt = stmt
+	}
+
+	return st, nil
+}
+
+func (st *statements) close() error {
+	var errs []error
+	for _, q := range st.queries() {
+		if *q.stmt != nil {
+			errs = append(errs, (*q.stmt).Close())
+		}
+	}
+	return errors.Join(errs...)
+}
@@ -3,8 +3,10 @@ package storage
 import "errors"
 
 var (
-	ErrUserNotFound = errors.New("user not found")
-	ErrAppNotFound  = errors.New("app not found")
+	ErrUserNotFound    = errors.New("user not found")
+	ErrAppNotFound     = errors.New("app not found")
+	ErrSessionNotFound = errors.New("session not found")
 
 	ErrUserExists = errors.New("user already exists")
+	ErrAppExists  = errors.New("app already exists")
 )
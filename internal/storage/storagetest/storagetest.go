@@ -0,0 +1,678 @@
+// Package storagetest is a conformance suite for storage backends. Every
+// backend runs it from its own tests:
+//
+//	func TestConformance(t *testing.T) {
+//		storagetest.Run(t, func(t *testing.T) storagetest.Storage {
+//			return newStorage(t)
+//		})
+//	}
+package storagetest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"sso/internal/domain/models"
+	"sso/internal/storage"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// Storage is the part of a backend the suite checks.
+type Storage interface {
+	SaveUser(ctx context.Context, email string, passHash []byte, pressTimes []float32, intervalTimes []float32, keyEvents []models.KeyEvent) (int64, error)
+	User(ctx context.Context, email string) (models.User, error)
+	UserByID(ctx context.Context, userID int64) (models.User, error)
+	UpdateBiometrics(ctx context.Context, userID int64, pressTimes []float32, intervalTimes []float32, keyEvents []models.KeyEvent) error
+	SetEnrollmentPending(ctx context.Context, userID int64) error
+	DeleteUser(ctx context.Context, userID int64) error
+	WithTx(ctx context.Context, fn func(ctx context.Context) error) error
+	IsAdmin(ctx context.Context, userID int64) (bool, error)
+	App(ctx context.Context, appID int) (models.App, error)
+	Apps(ctx context.Context) ([]models.App, error)
+	CreateApp(ctx context.Context, app models.App) (int, error)
+	UpdateApp(ctx context.Context, app models.App) error
+	SetAppDisabledAt(ctx context.Context, appID int, disabledAt *time.Time) error
+	SetAppSecrets(ctx context.Context, appID int, secret string, previous string, previousExpiresAt time.Time) error
+	DeleteApp(ctx context.Context, appID int) error
+	UpdateProfile(ctx context.Context, userID int64, profile models.Profile) error
+	UpdatePassword(ctx context.Context, userID int64, passHash []byte) error
+	SetEmail(ctx context.Context, userID int64, email string) error
+	SetEmailChange(ctx context.Context, userID int64, change *models.EmailChange) error
+	SetDeleteAfter(ctx context.Context, userID int64, deleteAfter *time.Time) error
+	UsersDueForDeletion(ctx context.Context, now time.Time) ([]int64, error)
+	SetAdmin(ctx context.Context, userID int64, isAdmin bool) error
+	SetDisabledAt(ctx context.Context, userID int64, disabledAt *time.Time) error
+	ListUsers(ctx context.Context, filter models.UserFilter) ([]models.User, error)
+	SaveAuditEvent(ctx context.Context, event models.AuditEvent) error
+	AuditEvents(ctx context.Context, userID int64) ([]models.AuditEvent, error)
+}
+
+// concurrency is the number of goroutines of the concurrent tests.
+const concurrency = 16
+
+// Run runs the suite. newStorage is called once per test and must return a
+// migrated backend; tests use unique emails, so the database may be shared.
+func Run(t *testing.T, newStorage func(t *testing.T) Storage) {
+	t.Run("DuplicateEmail", func(t *testing.T) { testDuplicateEmail(t, newStorage(t)) })
+	t.Run("UserNotFound", func(t *testing.T) { testUserNotFound(t, newStorage(t)) })
+	t.Run("AppNotFound", func(t *testing.T) { testAppNotFound(t, newStorage(t)) })
+	t.Run("ManageApps", func(t *testing.T) { testManageApps(t, newStorage(t)) })
+	t.Run("ConcurrentInserts", func(t *testing.T) { testConcurrentInserts(t, newStorage(t)) })
+	t.Run("ConcurrentDuplicateInserts", func(t *testing.T) { testConcurrentDuplicateInserts(t, newStorage(t)) })
+	t.Run("BiometricsRoundTrip", func(t *testing.T) { testBiometricsRoundTrip(t, newStorage(t)) })
+	t.Run("LegacyBiometricsRoundTrip", func(t *testing.T) { testLegacyBiometricsRoundTrip(t, newStorage(t)) })
+	t.Run("UpdateBiometrics", func(t *testing.T) { testUpdateBiometrics(t, newStorage(t)) })
+	t.Run("DeleteUser", func(t *testing.T) { testDeleteUser(t, newStorage(t)) })
+	t.Run("Profile", func(t *testing.T) { testProfile(t, newStorage(t)) })
+	t.Run("EmailChange", func(t *testing.T) { testEmailChange(t, newStorage(t)) })
+	t.Run("AdminAndDisabled", func(t *testing.T) { testAdminAndDisabled(t, newStorage(t)) })
+	t.Run("ListUsers", func(t *testing.T) { testListUsers(t, newStorage(t)) })
+	t.Run("ScheduledDeletion", func(t *testing.T) { testScheduledDeletion(t, newStorage(t)) })
+	t.Run("AuditEventsOutliveUser", func(t *testing.T) { testAuditEventsOutliveUser(t, newStorage(t)) })
+	t.Run("TxCommit", func(t *testing.T) { testTxCommit(t, newStorage(t)) })
+	t.Run("TxRollback", func(t *testing.T) { testTxRollback(t, newStorage(t)) })
+	t.Run("NestedTxRollback", func(t *testing.T) { testNestedTxRollback(t, newStorage(t)) })
+	t.Run("ConcurrentReadWriteTx", func(t *testing.T) { testConcurrentReadWriteTx(t, newStorage(t)) })
+}
+
+var errRollback = errors.New("rollback")
+
+func testDuplicateEmail(t *testing.T, s Storage) {
+	ctx := context.Background()
+	email := uniqueEmail(t)
+
+	_, err := s.SaveUser(ctx, email, []byte("hash"), []float32{100}, nil, nil)
+	require.NoError(t, err)
+
+	_, err = s.SaveUser(ctx, email, []byte("other"), []float32{200}, nil, nil)
+	assert.ErrorIs(t, err, storage.ErrUserExists)
+
+	user, err := s.User(ctx, email)
+	require.NoError(t, err)
+	assert.Equal(t, []byte("hash"), user.PassHash, "duplicate insert must not overwrite the user")
+}
+
+func testUserNotFound(t *testing.T, s Storage) {
+	ctx := context.Background()
+
+	_, err := s.User(ctx, uniqueEmail(t))
+	assert.ErrorIs(t, err, storage.ErrUserNotFound)
+
+	assert.ErrorIs(t, s.UpdateBiometrics(ctx, -1, []float32{100}, nil, nil), storage.ErrUserNotFound)
+	assert.ErrorIs(t, s.SetEnrollmentPending(ctx, -1), storage.ErrUserNotFound)
+}
+
+func testAppNotFound(t *testing.T, s Storage) {
+	_, err := s.App(context.Background(), -1)
+	assert.ErrorIs(t, err, storage.ErrAppNotFound)
+}
+
+func testManageApps(t *testing.T, s Storage) {
+	ctx := context.Background()
+	name := uniqueEmail(t)
+
+	before := time.Now().Add(-time.Second)
+	id, err := s.CreateApp(ctx, models.App{ID: -5, Name: name, Secret: "first-" + name, ContinuousAuthThreshold: 0.4, Sensitivity: models.SensitivityHigh})
+	require.NoError(t, err)
+	assert.Positive(t, id)
+
+	_, err = s.CreateApp(ctx, models.App{Name: name, Secret: "other-" + name})
+	assert.ErrorIs(t, err, storage.ErrAppExists)
+
+	app, err := s.App(ctx, id)
+	require.NoError(t, err)
+	assert.Equal(t, name, app.Name)
+	assert.Equal(t, "first-"+name, app.Secret)
+	assert.Empty(t, app.PreviousSecret)
+	assert.Equal(t, 0.4, app.ContinuousAuthThreshold)
+	assert.Equal(t, models.SensitivityHigh, app.Sensitivity)
+	assert.WithinDuration(t, before, app.CreatedAt, 5*time.Second)
+	assert.Nil(t, app.DisabledAt)
+	assert.Zero(t, app.TokenTTL)
+	assert.Empty(t, app.Audience)
+	assert.Empty(t, app.Issuer)
+	assert.Nil(t, app.ClaimsTemplate, "apps without a template must use the default one")
+
+	expiresAt := time.Now().Add(time.Hour)
+	require.NoError(t, s.SetAppSecrets(ctx, id, "second-"+name, "first-"+name, expiresAt))
+	app, err = s.App(ctx, id)
+	require.NoError(t, err)
+	assert.Equal(t, "second-"+name, app.Secret)
+	assert.Equal(t, "first-"+name, app.PreviousSecret)
+	assert.WithinDuration(t, expiresAt, app.PreviousSecretExpiresAt, time.Second)
+
+	require.NoError(t, s.SetAppSecrets(ctx, id, "third-"+name, "", time.Time{}))
+	app, err = s.App(ctx, id)
+	require.NoError(t, err)
+	assert.Equal(t, "third-"+name, app.Secret)
+	assert.Empty(t, app.PreviousSecret)
+
+	disabledAt := time.Now()
+	require.NoError(t, s.SetAppDisabledAt(ctx, id, &disabledAt))
+	app, err = s.App(ctx, id)
+	require.NoError(t, err)
+	require.NotNil(t, app.DisabledAt)
+	assert.WithinDuration(t, disabledAt, *app.DisabledAt, time.Second)
+
+	other, err := s.CreateApp(ctx, models.App{Name: "other-" + name, Secret: "other-" + name})
+	require.NoError(t, err)
+	assert.NotEqual(t, id, other)
+
+	app.Name = "other-" + name
+	assert.ErrorIs(t, s.UpdateApp(ctx, app), storage.ErrAppExists)
+	app.Name, app.Sensitivity = "renamed-"+name, models.SensitivityLow
+	app.TokenTTL, app.Audience, app.Issuer = 15*time.Minute, "console", "sso-console"
+	app.ClaimsTemplate = []string{models.ClaimEmail, models.ClaimIsAdmin}
+	require.NoError(t, s.UpdateApp(ctx, app))
+
+	apps, err := s.Apps(ctx)
+	require.NoError(t, err)
+	var found bool
+	for _, a := range apps {
+		if a.ID == id {
+			found = true
+			assert.Equal(t, "renamed-"+name, a.Name)
+			assert.Equal(t, models.SensitivityLow, a.Sensitivity)
+			assert.Equal(t, 15*time.Minute, a.TokenTTL)
+			assert.Equal(t, "console", a.Audience)
+			assert.Equal(t, "sso-console", a.Issuer)
+			assert.Equal(t, []string{models.ClaimEmail, models.ClaimIsAdmin}, a.ClaimsTemplate)
+			assert.Equal(t, "third-"+name, a.Secret, "updates must not touch the secret")
+			assert.NotNil(t, a.DisabledAt)
+		}
+	}
+	assert.True(t, found, "created app must be listed")
+
+	app.ClaimsTemplate = []string{}
+	require.NoError(t, s.UpdateApp(ctx, app))
+	app, err = s.App(ctx, id)
+	require.NoError(t, err)
+	assert.NotNil(t, app.ClaimsTemplate, "an empty template must not fall back to the default one")
+	assert.Empty(t, app.ClaimsTemplate)
+
+	require.NoError(t, s.DeleteApp(ctx, id))
+	_, err = s.App(ctx, id)
+	assert.ErrorIs(t, err, storage.ErrAppNotFound)
+
+	assert.ErrorIs(t, s.DeleteApp(ctx, id), storage.ErrAppNotFound)
+	assert.ErrorIs(t, s.UpdateApp(ctx, models.App{ID: -1, Name: "missing-" + name}), storage.ErrAppNotFound)
+	assert.ErrorIs(t, s.SetAppDisabledAt(ctx, -1, nil), storage.ErrAppNotFound)
+	assert.ErrorIs(t, s.SetAppSecrets(ctx, -1, "secret", "", time.Time{}), storage.ErrAppNotFound)
+}
+
+func testConcurrentInserts(t *testing.T, s Storage) {
+	ctx := context.Background()
+	base := uniqueEmail(t)
+
+	ids := make([]int64, concurrency)
+	errs := make([]error, concurrency)
+
+	var wg sync.WaitGroup
+	for i := 0; i < concurrency; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			ids[i], errs[i] = s.SaveUser(ctx, fmt.Sprintf("%d-%s", i, base), []byte("hash"), []float32{float32(100 + i)}, nil, nil)
+		}(i)
+	}
+	wg.Wait()
+
+	seen := make(map[int64]bool, concurrency)
+	for i := 0; i < concurrency; i++ {
+		require.NoError(t, errs[i])
+		assert.False(t, seen[ids[i]], "user id %d issued twice", ids[i])
+		seen[ids[i]] = true
+
+		user, err := s.User(ctx, fmt.Sprintf("%d-%s", i, base))
+		require.NoError(t, err)
+		assert.Equal(t, ids[i], user.ID)
+		assert.Equal(t, []float32{float32(100 + i)}, user.PressTimes)
+	}
+}
+
+func testConcurrentDuplicateInserts(t *testing.T, s Storage) {
+	ctx := context.Background()
+	email := uniqueEmail(t)
+
+	var saved, exists atomic.Int32
+	var wg sync.WaitGroup
+	for i := 0; i < concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := s.SaveUser(ctx, email, []byte("hash"), []float32{100}, nil, nil)
+			switch {
+			case err == nil:
+				saved.Add(1)
+			case errors.Is(err, storage.ErrUserExists):
+				exists.Add(1)
+			default:
+				t.Errorf("unexpected error: %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	assert.EqualValues(t, 1, saved.Load())
+	assert.EqualValues(t, concurrency-1, exists.Load())
+}
+
+func testBiometricsRoundTrip(t *testing.T, s Storage) {
+	ctx := context.Background()
+	email := uniqueEmail(t)
+
+	press := []float32{112.5, 98.25, 131}
+	intervals := []float32{210.75, 187}
+	events := []models.KeyEvent{
+		{Key: "a", PressedAt: 0, ReleasedAt: 112.5},
+		{Key: "b", PressedAt: 210.75, ReleasedAt: 309},
+		{Key: "c", PressedAt: 397.75, ReleasedAt: 528.75},
+	}
+
+	id, err := s.SaveUser(ctx, email, []byte("hash"), press, intervals, events)
+	require.NoError(t, err)
+
+	user, err := s.User(ctx, email)
+	require.NoError(t, err)
+	assert.Equal(t, id, user.ID)
+	assert.Equal(t, email, user.Email)
+	assert.Equal(t, press, user.PressTimes)
+	assert.Equal(t, intervals, user.PressIntervals)
+	assert.Equal(t, events, user.KeyEvents)
+	assert.False(t, user.EnrollmentPending)
+}
+
+// testLegacyBiometricsRoundTrip covers templates without key events, as
+// sent by clients that only report press times and intervals.
+func testLegacyBiometricsRoundTrip(t *testing.T, s Storage) {
+	ctx := context.Background()
+	email := uniqueEmail(t)
+
+	_, err := s.SaveUser(ctx, email, []byte("hash"), []float32{100, 120}, []float32{250}, nil)
+	require.NoError(t, err)
+
+	user, err := s.User(ctx, email)
+	require.NoError(t, err)
+	assert.Equal(t, []float32{100, 120}, user.PressTimes)
+	assert.Equal(t, []float32{250}, user.PressIntervals)
+	assert.Empty(t, user.KeyEvents)
+}
+
+func testUpdateBiometrics(t *testing.T, s Storage) {
+	ctx := context.Background()
+	email := uniqueEmail(t)
+
+	id, err := s.SaveUser(ctx, email, []byte("hash"), []float32{100}, nil, nil)
+	require.NoError(t, err)
+
+	require.NoError(t, s.SetEnrollmentPending(ctx, id))
+	user, err := s.User(ctx, email)
+	require.NoError(t, err)
+	assert.True(t, user.EnrollmentPending)
+
+	events := []models.KeyEvent{{Key: "x", PressedAt: 0, ReleasedAt: 90}}
+	require.NoError(t, s.UpdateBiometrics(ctx, id, []float32{90}, []float32{}, events))
+
+	user, err = s.User(ctx, email)
+	require.NoError(t, err)
+	assert.False(t, user.EnrollmentPending)
+	assert.Equal(t, []float32{90}, user.PressTimes)
+	assert.Equal(t, events, user.KeyEvents)
+}
+
+func testDeleteUser(t *testing.T, s Storage) {
+	ctx := context.Background()
+	email := uniqueEmail(t)
+
+	id, err := s.SaveUser(ctx, email, []byte("hash"), []float32{100}, nil, nil)
+	require.NoError(t, err)
+
+	require.NoError(t, s.DeleteUser(ctx, id))
+
+	_, err = s.User(ctx, email)
+	assert.ErrorIs(t, err, storage.ErrUserNotFound)
+	assert.ErrorIs(t, s.DeleteUser(ctx, id), storage.ErrUserNotFound)
+
+	_, err = s.SaveUser(ctx, email, []byte("hash"), []float32{100}, nil, nil)
+	assert.NoError(t, err, "email of a deleted user must be reusable")
+}
+
+func testProfile(t *testing.T, s Storage) {
+	ctx := context.Background()
+	email := uniqueEmail(t)
+
+	id, err := s.SaveUser(ctx, email, []byte("hash"), []float32{100}, nil, nil)
+	require.NoError(t, err)
+
+	user, err := s.UserByID(ctx, id)
+	require.NoError(t, err)
+	assert.Equal(t, email, user.Email)
+	assert.Equal(t, models.Profile{}, user.Profile)
+	assert.Equal(t, []float32{100}, user.PressTimes)
+
+	profile := models.Profile{DisplayName: "Ada", Locale: "en-GB", Timezone: "Europe/London"}
+	require.NoError(t, s.UpdateProfile(ctx, id, profile))
+	require.NoError(t, s.UpdatePassword(ctx, id, []byte("new hash")))
+
+	user, err = s.User(ctx, email)
+	require.NoError(t, err)
+	assert.Equal(t, profile, user.Profile)
+	assert.Equal(t, []byte("new hash"), user.PassHash)
+
+	_, err = s.UserByID(ctx, -1)
+	assert.ErrorIs(t, err, storage.ErrUserNotFound)
+	assert.ErrorIs(t, s.UpdateProfile(ctx, -1, profile), storage.ErrUserNotFound)
+	assert.ErrorIs(t, s.UpdatePassword(ctx, -1, []byte("hash")), storage.ErrUserNotFound)
+}
+
+func testEmailChange(t *testing.T, s Storage) {
+	ctx := context.Background()
+	email, newEmail, taken := uniqueEmail(t), "new-"+uniqueEmail(t), "taken-"+uniqueEmail(t)
+
+	id, err := s.SaveUser(ctx, email, []byte("hash"), []float32{100}, nil, nil)
+	require.NoError(t, err)
+	_, err = s.SaveUser(ctx, taken, []byte("hash"), []float32{100}, nil, nil)
+	require.NoError(t, err)
+
+	change := models.EmailChange{Email: newEmail, CodeHash: []byte("code hash"), ExpiresAt: time.Now().Add(time.Hour)}
+	require.NoError(t, s.SetEmailChange(ctx, id, &change))
+
+	user, err := s.UserByID(ctx, id)
+	require.NoError(t, err)
+	require.NotNil(t, user.EmailChange)
+	assert.Equal(t, change.Email, user.EmailChange.Email)
+	assert.Equal(t, change.CodeHash, user.EmailChange.CodeHash)
+	assert.WithinDuration(t, change.ExpiresAt, user.EmailChange.ExpiresAt, time.Second)
+
+	assert.ErrorIs(t, s.SetEmail(ctx, id, taken), storage.ErrUserExists)
+
+	require.NoError(t, s.SetEmail(ctx, id, newEmail))
+	require.NoError(t, s.SetEmailChange(ctx, id, nil))
+
+	user, err = s.User(ctx, newEmail)
+	require.NoError(t, err)
+	assert.Equal(t, id, user.ID)
+	assert.Nil(t, user.EmailChange)
+
+	_, err = s.User(ctx, email)
+	assert.ErrorIs(t, err, storage.ErrUserNotFound, "old email must be released")
+}
+
+func testAdminAndDisabled(t *testing.T, s Storage) {
+	ctx := context.Background()
+	email := uniqueEmail(t)
+
+	before := time.Now().Add(-time.Second)
+	id, err := s.SaveUser(ctx, email, []byte("hash"), []float32{100}, nil, nil)
+	require.NoError(t, err)
+
+	user, err := s.User(ctx, email)
+	require.NoError(t, err)
+	assert.False(t, user.IsAdmin)
+	assert.Nil(t, user.DisabledAt)
+	assert.WithinDuration(t, before, user.CreatedAt, 5*time.Second)
+
+	require.NoError(t, s.SetAdmin(ctx, id, true))
+	disabledAt := time.Now()
+	require.NoError(t, s.SetDisabledAt(ctx, id, &disabledAt))
+
+	user, err = s.UserByID(ctx, id)
+	require.NoError(t, err)
+	assert.True(t, user.IsAdmin)
+	require.NotNil(t, user.DisabledAt)
+	assert.WithinDuration(t, disabledAt, *user.DisabledAt, time.Second)
+
+	isAdmin, err := s.IsAdmin(ctx, id)
+	require.NoError(t, err)
+	assert.True(t, isAdmin)
+
+	require.NoError(t, s.SetDisabledAt(ctx, id, nil))
+	user, err = s.UserByID(ctx, id)
+	require.NoError(t, err)
+	assert.Nil(t, user.DisabledAt)
+
+	assert.ErrorIs(t, s.SetAdmin(ctx, -1, true), storage.ErrUserNotFound)
+	assert.ErrorIs(t, s.SetDisabledAt(ctx, -1, nil), storage.ErrUserNotFound)
+}
+
+func testListUsers(t *testing.T, s Storage) {
+	ctx := context.Background()
+	// every filter includes the prefix, so users of other tests sharing the
+	// database never match
+	prefix := strings.TrimSuffix(uniqueEmail(t), ".test") + "-"
+
+	var ids []int64
+	for _, name := range []string{"admin", "disabled", "plain"} {
+		id, err := s.SaveUser(ctx, prefix+name+"@example.test", []byte("hash"), []float32{100}, nil, nil)
+		require.NoError(t, err)
+		ids = append(ids, id)
+	}
+	require.NoError(t, s.SetAdmin(ctx, ids[0], true))
+	disabledAt := time.Now()
+	require.NoError(t, s.SetDisabledAt(ctx, ids[1], &disabledAt))
+
+	list := func(filter models.UserFilter) []int64 {
+		t.Helper()
+
+		filter.EmailPrefix = prefix + filter.EmailPrefix
+		users, err := s.ListUsers(ctx, filter)
+		require.NoError(t, err)
+		result := make([]int64, 0, len(users))
+		for _, user := range users {
+			assert.Empty(t, user.PressTimes, "listings must not read templates")
+			result = append(result, user.ID)
+		}
+		return result
+	}
+	yes, no := true, false
+
+	assert.Equal(t, ids, list(models.UserFilter{}))
+	assert.Equal(t, ids[2:], list(models.UserFilter{EmailPrefix: "pl"}))
+	assert.Equal(t, ids[:1], list(models.UserFilter{IsAdmin: &yes}))
+	assert.Equal(t, ids[1:], list(models.UserFilter{IsAdmin: &no}))
+	assert.Equal(t, ids[1:2], list(models.UserFilter{Disabled: &yes}))
+	assert.Equal(t, []int64{ids[0], ids[2]}, list(models.UserFilter{Disabled: &no}))
+
+	assert.Equal(t, ids, list(models.UserFilter{CreatedFrom: time.Now().Add(-time.Hour), CreatedTo: time.Now().Add(time.Hour)}))
+	assert.Empty(t, list(models.UserFilter{CreatedFrom: time.Now().Add(time.Hour)}))
+	assert.Empty(t, list(models.UserFilter{CreatedTo: time.Now().Add(-time.Hour)}))
+
+	// pages follow the cursor
+	assert.Equal(t, ids[:2], list(models.UserFilter{Limit: 2}))
+	assert.Equal(t, ids[2:], list(models.UserFilter{AfterID: ids[1], Limit: 2}))
+	assert.Empty(t, list(models.UserFilter{AfterID: ids[2], Limit: 2}))
+}
+
+func testScheduledDeletion(t *testing.T, s Storage) {
+	ctx := context.Background()
+	email := uniqueEmail(t)
+
+	id, err := s.SaveUser(ctx, email, []byte("hash"), []float32{100}, nil, nil)
+	require.NoError(t, err)
+
+	now := time.Now()
+	deleteAfter := now.Add(time.Hour)
+	require.NoError(t, s.SetDeleteAfter(ctx, id, &deleteAfter))
+
+	user, err := s.User(ctx, email)
+	require.NoError(t, err)
+	require.NotNil(t, user.DeleteAfter)
+	assert.WithinDuration(t, deleteAfter, *user.DeleteAfter, time.Second)
+
+	due, err := s.UsersDueForDeletion(ctx, now)
+	require.NoError(t, err)
+	assert.NotContains(t, due, id, "deletion must wait for the grace period")
+
+	due, err = s.UsersDueForDeletion(ctx, deleteAfter.Add(time.Second))
+	require.NoError(t, err)
+	assert.Contains(t, due, id)
+
+	require.NoError(t, s.SetDeleteAfter(ctx, id, nil))
+
+	user, err = s.User(ctx, email)
+	require.NoError(t, err)
+	assert.Nil(t, user.DeleteAfter)
+
+	due, err = s.UsersDueForDeletion(ctx, deleteAfter.Add(time.Second))
+	require.NoError(t, err)
+	assert.NotContains(t, due, id, "cancelled deletion must not be due")
+
+	assert.ErrorIs(t, s.SetDeleteAfter(ctx, -1, &deleteAfter), storage.ErrUserNotFound)
+}
+
+func testAuditEventsOutliveUser(t *testing.T, s Storage) {
+	ctx := context.Background()
+
+	id, err := s.SaveUser(ctx, uniqueEmail(t), []byte("hash"), []float32{100}, nil, nil)
+	require.NoError(t, err)
+
+	now := time.Now()
+	events := []models.AuditEvent{
+		{UserID: id, ActorID: id, Action: "account.deletion_scheduled", IP: "203.0.113.7", CreatedAt: now},
+		{UserID: id, Action: "account.deleted", CreatedAt: now.Add(time.Second)},
+	}
+	for _, e := range events {
+		require.NoError(t, s.SaveAuditEvent(ctx, e))
+	}
+	require.NoError(t, s.DeleteUser(ctx, id))
+
+	got, err := s.AuditEvents(ctx, id)
+	require.NoError(t, err)
+	require.Len(t, got, len(events))
+	for i := range events {
+		assert.Equal(t, events[i].ActorID, got[i].ActorID)
+		assert.Equal(t, events[i].Action, got[i].Action)
+		assert.Equal(t, events[i].IP, got[i].IP)
+		assert.WithinDuration(t, events[i].CreatedAt, got[i].CreatedAt, time.Millisecond)
+	}
+}
+
+func testTxCommit(t *testing.T, s Storage) {
+	ctx := context.Background()
+	email := uniqueEmail(t)
+
+	err := s.WithTx(ctx, func(ctx context.Context) error {
+		id, err := s.SaveUser(ctx, email, []byte("hash"), []float32{100}, nil, nil)
+		if err != nil {
+			return err
+		}
+		return s.SetEnrollmentPending(ctx, id)
+	})
+	require.NoError(t, err)
+
+	user, err := s.User(ctx, email)
+	require.NoError(t, err)
+	assert.True(t, user.EnrollmentPending)
+}
+
+func testTxRollback(t *testing.T, s Storage) {
+	ctx := context.Background()
+	email := uniqueEmail(t)
+
+	err := s.WithTx(ctx, func(ctx context.Context) error {
+		if _, err := s.SaveUser(ctx, email, []byte("hash"), []float32{100}, nil, nil); err != nil {
+			return err
+		}
+		return errRollback
+	})
+	assert.ErrorIs(t, err, errRollback)
+
+	_, err = s.User(ctx, email)
+	assert.ErrorIs(t, err, storage.ErrUserNotFound)
+
+	id, err := s.SaveUser(ctx, email, []byte("hash"), []float32{100}, nil, nil)
+	require.NoError(t, err)
+
+	err = s.WithTx(ctx, func(ctx context.Context) error {
+		if err := s.UpdateBiometrics(ctx, id, []float32{200}, nil, nil); err != nil {
+			return err
+		}
+		if err := s.DeleteUser(ctx, id); err != nil {
+			return err
+		}
+		return errRollback
+	})
+	assert.ErrorIs(t, err, errRollback)
+
+	user, err := s.User(ctx, email)
+	require.NoError(t, err, "rolled back deletion must keep the user")
+	assert.Equal(t, []float32{100}, user.PressTimes, "rolled back update must keep the template")
+}
+
+func testNestedTxRollback(t *testing.T, s Storage) {
+	ctx := context.Background()
+	email := uniqueEmail(t)
+
+	err := s.WithTx(ctx, func(ctx context.Context) error {
+		err := s.WithTx(ctx, func(ctx context.Context) error {
+			_, err := s.SaveUser(ctx, email, []byte("hash"), []float32{100}, nil, nil)
+			return err
+		})
+		if err != nil {
+			return err
+		}
+		return errRollback
+	})
+	assert.ErrorIs(t, err, errRollback)
+
+	_, err = s.User(ctx, email)
+	assert.ErrorIs(t, err, storage.ErrUserNotFound, "inner transaction must join the outer one")
+}
+
+// testConcurrentReadWriteTx runs transactions that read a user before
+// writing it, as re-enrollment does. They must wait for each other rather
+// than fail when one of them upgrades to a write.
+func testConcurrentReadWriteTx(t *testing.T, s Storage) {
+	ctx := context.Background()
+
+	id, err := s.SaveUser(ctx, uniqueEmail(t), []byte("hash"), []float32{100}, nil, nil)
+	require.NoError(t, err)
+
+	errs := make([]error, concurrency)
+	var wg sync.WaitGroup
+	for i := 0; i < concurrency; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			errs[i] = s.WithTx(ctx, func(ctx context.Context) error {
+				user, err := s.UserByID(ctx, id)
+				if err != nil {
+					return err
+				}
+				// let the other transactions read in the meantime
+				time.Sleep(5 * time.Millisecond)
+				return s.UpdateBiometrics(ctx, id, append(user.PressTimes, float32(i)), nil, nil)
+			})
+		}(i)
+	}
+	wg.Wait()
+
+	for _, err := range errs {
+		require.NoError(t, err)
+	}
+}
+
+func uniqueEmail(t *testing.T) string {
+	return fmt.Sprintf("%d@%s.test", time.Now().UnixNano(), sanitize(t.Name()))
+}
+
+func sanitize(name string) string {
+	out := make([]rune, 0, len(name))
+	for _, r := range name {
+		switch {
+		case r >= 'a' && r <= 'z', r >= '0' && r <= '9', r == '-':
+			out = append(out, r)
+		case r >= 'A' && r <= 'Z':
+			out = append(out, r+'a'-'A')
+		default:
+			out = append(out, '-')
+		}
+	}
+	return string(out)
+}
@@ -0,0 +1,78 @@
+package storage
+
+import (
+	"database/sql"
+	"encoding/json"
+	"sso/internal/domain/models"
+	"sso/internal/lib/converter"
+	"sso/internal/lib/envelope"
+)
+
+// Template is a keystroke template in the form stored in key_press_data.
+type Template struct {
+	Intervals string
+	Times     string
+	KeyEvents sql.NullString
+}
+
+// SealTemplate encrypts a keystroke template with a data key.
+func SealTemplate(dataKey []byte, pressTimes []float32, intervalTimes []float32, keyEvents []models.KeyEvent) (Template, error) {
+	var t Template
+	var err error
+
+	t.Intervals, err = envelope.Seal(dataKey, converter.ToStringFromFloat32Slice(intervalTimes))
+	if err != nil {
+		return Template{}, err
+	}
+	t.Times, err = envelope.Seal(dataKey, converter.ToStringFromFloat32Slice(pressTimes))
+	if err != nil {
+		return Template{}, err
+	}
+
+	if len(keyEvents) > 0 {
+		raw, err := json.Marshal(keyEvents)
+		if err != nil {
+			return Template{}, err
+		}
+		t.KeyEvents.String, err = envelope.Seal(dataKey, string(raw))
+		if err != nil {
+			return Template{}, err
+		}
+		t.KeyEvents.Valid = true
+	}
+
+	return t, nil
+}
+
+// OpenTemplate decrypts a stored template. Rows without a data key predate
+// encryption at rest and are read as plain text until they are rewrapped.
+func OpenTemplate(keyring *envelope.Keyring, wrappedKey []byte, t Template) (pressTimes []float32, intervalTimes []float32, keyEvents []models.KeyEvent, err error) {
+	if wrappedKey == nil {
+		return converter.ToFloat32SliceFromString(t.Times), converter.ToFloat32SliceFromString(t.Intervals), nil, nil
+	}
+
+	dataKey, err := keyring.Unwrap(wrappedKey)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	intervals, err := envelope.Open(dataKey, t.Intervals)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	times, err := envelope.Open(dataKey, t.Times)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	if t.KeyEvents.Valid {
+		raw, err := envelope.Open(dataKey, t.KeyEvents.String)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		if err := json.Unmarshal([]byte(raw), &keyEvents); err != nil {
+			return nil, nil, nil, err
+		}
+	}
+
+	return converter.ToFloat32SliceFromString(times), converter.ToFloat32SliceFromString(intervals), keyEvents, nil
+}
@@ -0,0 +1,17 @@
+ALTER TABLE users
+    DROP COLUMN email_code_expires_at;
+
+ALTER TABLE users
+    DROP COLUMN email_code_hash;
+
+ALTER TABLE users
+    DROP COLUMN pending_email;
+
+ALTER TABLE users
+    DROP COLUMN timezone;
+
+ALTER TABLE users
+    DROP COLUMN locale;
+
+ALTER TABLE users
+    DROP COLUMN display_name;
\ No newline at end of file
@@ -0,0 +1,18 @@
+ALTER TABLE users
+    ADD COLUMN display_name TEXT NOT NULL DEFAULT '';
+
+ALTER TABLE users
+    ADD COLUMN locale TEXT NOT NULL DEFAULT '';
+
+ALTER TABLE users
+    ADD COLUMN timezone TEXT NOT NULL DEFAULT '';
+
+-- a requested email change waits here until the new address is verified
+ALTER TABLE users
+    ADD COLUMN pending_email TEXT;
+
+ALTER TABLE users
+    ADD COLUMN email_code_hash BLOB;
+
+ALTER TABLE users
+    ADD COLUMN email_code_expires_at TIMESTAMP;
\ No newline at end of file
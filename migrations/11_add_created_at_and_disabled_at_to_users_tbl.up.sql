@@ -0,0 +1,8 @@
+-- users registered before this migration have no creation time
+ALTER TABLE users
+    ADD COLUMN created_at TIMESTAMP;
+
+ALTER TABLE users
+    ADD COLUMN disabled_at TIMESTAMP;
+
+CREATE INDEX IF NOT EXISTS idx_users_created_at ON users (created_at);
\ No newline at end of file
@@ -0,0 +1,14 @@
+ALTER TABLE apps
+    DROP COLUMN disabled_at;
+
+ALTER TABLE apps
+    DROP COLUMN created_at;
+
+ALTER TABLE apps
+    DROP COLUMN previous_secret_expires_at;
+
+ALTER TABLE apps
+    DROP COLUMN previous_secret;
+
+ALTER TABLE apps
+    DROP COLUMN data_key;
\ No newline at end of file
@@ -0,0 +1,16 @@
+-- secret is encrypted with the data key; apps without one predate
+-- encryption and keep a plain text secret until it is rotated
+ALTER TABLE apps
+    ADD COLUMN data_key BLOB;
+
+ALTER TABLE apps
+    ADD COLUMN previous_secret TEXT;
+
+ALTER TABLE apps
+    ADD COLUMN previous_secret_expires_at TIMESTAMP;
+
+ALTER TABLE apps
+    ADD COLUMN created_at TIMESTAMP;
+
+ALTER TABLE apps
+    ADD COLUMN disabled_at TIMESTAMP;
\ No newline at end of file
@@ -0,0 +1,11 @@
+ALTER TABLE apps
+    DROP COLUMN claims_template;
+
+ALTER TABLE apps
+    DROP COLUMN issuer;
+
+ALTER TABLE apps
+    DROP COLUMN audience;
+
+ALTER TABLE apps
+    DROP COLUMN token_ttl_seconds;
\ No newline at end of file
@@ -0,0 +1,13 @@
+-- token settings of the app; NULL falls back to the service defaults.
+-- claims_template is a JSON array of the user attributes put in tokens
+ALTER TABLE apps
+    ADD COLUMN token_ttl_seconds INTEGER;
+
+ALTER TABLE apps
+    ADD COLUMN audience TEXT;
+
+ALTER TABLE apps
+    ADD COLUMN issuer TEXT;
+
+ALTER TABLE apps
+    ADD COLUMN claims_template TEXT;
\ No newline at end of file
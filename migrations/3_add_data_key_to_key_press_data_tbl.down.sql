@@ -0,0 +1,2 @@
+ALTER TABLE key_press_data
+    DROP COLUMN data_key;
\ No newline at end of file
@@ -0,0 +1,2 @@
+ALTER TABLE key_press_data
+    ADD COLUMN data_key BLOB;
\ No newline at end of file
@@ -0,0 +1,2 @@
+ALTER TABLE users
+    DROP COLUMN enrollment_pending;
\ No newline at end of file
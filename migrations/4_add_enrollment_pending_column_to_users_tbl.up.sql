@@ -0,0 +1,2 @@
+ALTER TABLE users
+    ADD COLUMN enrollment_pending BOOLEAN NOT NULL DEFAULT FALSE;
\ No newline at end of file
@@ -0,0 +1,2 @@
+ALTER TABLE key_press_data
+    DROP COLUMN key_events;
\ No newline at end of file
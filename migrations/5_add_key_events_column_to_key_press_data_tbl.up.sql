@@ -0,0 +1,2 @@
+ALTER TABLE key_press_data
+    ADD COLUMN key_events TEXT;
\ No newline at end of file
@@ -0,0 +1,4 @@
+ALTER TABLE apps
+    DROP COLUMN continuous_auth_threshold;
+
+DROP TABLE IF EXISTS sessions;
\ No newline at end of file
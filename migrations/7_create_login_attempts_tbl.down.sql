@@ -0,0 +1,4 @@
+ALTER TABLE apps
+    DROP COLUMN sensitivity;
+
+DROP TABLE IF EXISTS login_attempts;
\ No newline at end of file
@@ -0,0 +1,15 @@
+CREATE TABLE IF NOT EXISTS login_attempts
+(
+    id         INTEGER PRIMARY KEY AUTOINCREMENT,
+    user_id    INTEGER   NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    app_id     INTEGER   NOT NULL,
+    ip         TEXT      NOT NULL DEFAULT '',
+    device     TEXT      NOT NULL DEFAULT '',
+    success    BOOLEAN   NOT NULL,
+    created_at TIMESTAMP NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_login_attempts_user_id_created_at ON login_attempts (user_id, created_at);
+
+ALTER TABLE apps
+    ADD COLUMN sensitivity INTEGER NOT NULL DEFAULT 0;
\ No newline at end of file
@@ -0,0 +1,4 @@
+-- 2_add_is_admin_column_to_users_tbl shipped with an empty up migration, so
+-- the column was never created.
+ALTER TABLE users
+    ADD COLUMN is_admin BOOLEAN NOT NULL DEFAULT FALSE;
\ No newline at end of file
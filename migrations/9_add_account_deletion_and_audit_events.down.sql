@@ -0,0 +1,6 @@
+DROP TABLE IF EXISTS audit_events;
+
+DROP INDEX IF EXISTS idx_users_delete_after;
+
+ALTER TABLE users
+    DROP COLUMN delete_after;
\ No newline at end of file
@@ -0,0 +1,18 @@
+ALTER TABLE users
+    ADD COLUMN delete_after TIMESTAMP;
+
+CREATE INDEX IF NOT EXISTS idx_users_delete_after ON users (delete_after);
+
+-- audit events outlive the account they are about, so user_id is not a
+-- foreign key
+CREATE TABLE IF NOT EXISTS audit_events
+(
+    id         INTEGER PRIMARY KEY AUTOINCREMENT,
+    user_id    INTEGER   NOT NULL,
+    actor_id   INTEGER   NOT NULL,
+    action     TEXT      NOT NULL,
+    ip         TEXT      NOT NULL DEFAULT '',
+    created_at TIMESTAMP NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_audit_events_user_id ON audit_events (user_id);
\ No newline at end of file
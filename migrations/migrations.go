@@ -0,0 +1,15 @@
+// Package migrations embeds the schema migrations, so the sso binary can
+// migrate its database without the migrations directory being deployed.
+package migrations
+
+import "embed"
+
+// SQLite holds the migrations of the sqlite storage.
+//
+//go:embed *.sql
+var SQLite embed.FS
+
+// Postgres holds the migrations of the postgres storage under "postgres".
+//
+//go:embed postgres/*.sql
+var Postgres embed.FS
@@ -0,0 +1,5 @@
+DROP TABLE IF EXISTS login_attempts;
+DROP TABLE IF EXISTS sessions;
+DROP TABLE IF EXISTS key_press_data;
+DROP TABLE IF EXISTS apps;
+DROP TABLE IF EXISTS users;
\ No newline at end of file
@@ -0,0 +1,52 @@
+CREATE TABLE IF NOT EXISTS users
+(
+    id                 BIGSERIAL PRIMARY KEY,
+    email              TEXT    NOT NULL UNIQUE,
+    pass_hash          BYTEA   NOT NULL,
+    is_admin           BOOLEAN NOT NULL DEFAULT FALSE,
+    enrollment_pending BOOLEAN NOT NULL DEFAULT FALSE
+);
+
+CREATE TABLE IF NOT EXISTS apps
+(
+    id                        INTEGER PRIMARY KEY,
+    name                      TEXT             NOT NULL UNIQUE,
+    secret                    TEXT             NOT NULL UNIQUE,
+    continuous_auth_threshold DOUBLE PRECISION NOT NULL DEFAULT 0.5,
+    sensitivity               INTEGER          NOT NULL DEFAULT 0
+);
+
+CREATE TABLE IF NOT EXISTS key_press_data
+(
+    data_id             BIGSERIAL PRIMARY KEY,
+    user_id             BIGINT NOT NULL UNIQUE REFERENCES users (id) ON DELETE CASCADE,
+    key_press_times     TEXT   NOT NULL,
+    key_press_intervals TEXT   NOT NULL,
+    key_events          TEXT,
+    data_key            BYTEA
+);
+
+CREATE TABLE IF NOT EXISTS sessions
+(
+    id         TEXT PRIMARY KEY,
+    user_id    BIGINT      NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    app_id     INTEGER     NOT NULL REFERENCES apps (id) ON DELETE CASCADE,
+    created_at TIMESTAMPTZ NOT NULL,
+    expires_at TIMESTAMPTZ NOT NULL,
+    revoked_at TIMESTAMPTZ
+);
+
+CREATE INDEX IF NOT EXISTS idx_sessions_user_id ON sessions (user_id);
+
+CREATE TABLE IF NOT EXISTS login_attempts
+(
+    id         BIGSERIAL PRIMARY KEY,
+    user_id    BIGINT      NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    app_id     INTEGER     NOT NULL,
+    ip         TEXT        NOT NULL DEFAULT '',
+    device     TEXT        NOT NULL DEFAULT '',
+    success    BOOLEAN     NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_login_attempts_user_id_created_at ON login_attempts (user_id, created_at);
\ No newline at end of file
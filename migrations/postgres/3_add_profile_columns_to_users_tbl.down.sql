@@ -0,0 +1,7 @@
+ALTER TABLE users
+    DROP COLUMN email_code_expires_at,
+    DROP COLUMN email_code_hash,
+    DROP COLUMN pending_email,
+    DROP COLUMN timezone,
+    DROP COLUMN locale,
+    DROP COLUMN display_name;
\ No newline at end of file
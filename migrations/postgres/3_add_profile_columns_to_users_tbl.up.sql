@@ -0,0 +1,8 @@
+ALTER TABLE users
+    ADD COLUMN display_name          TEXT NOT NULL DEFAULT '',
+    ADD COLUMN locale                TEXT NOT NULL DEFAULT '',
+    ADD COLUMN timezone              TEXT NOT NULL DEFAULT '',
+    -- a requested email change waits here until the new address is verified
+    ADD COLUMN pending_email         TEXT,
+    ADD COLUMN email_code_hash       BYTEA,
+    ADD COLUMN email_code_expires_at TIMESTAMPTZ;
\ No newline at end of file
@@ -0,0 +1,5 @@
+DROP INDEX IF EXISTS idx_users_created_at;
+
+ALTER TABLE users
+    DROP COLUMN disabled_at,
+    DROP COLUMN created_at;
\ No newline at end of file
@@ -0,0 +1,6 @@
+ALTER TABLE users
+    -- users registered before this migration have no creation time
+    ADD COLUMN created_at  TIMESTAMPTZ,
+    ADD COLUMN disabled_at TIMESTAMPTZ;
+
+CREATE INDEX IF NOT EXISTS idx_users_created_at ON users (created_at);
\ No newline at end of file
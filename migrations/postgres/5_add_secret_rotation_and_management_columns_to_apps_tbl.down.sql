@@ -0,0 +1,9 @@
+ALTER TABLE apps
+    DROP COLUMN disabled_at,
+    DROP COLUMN created_at,
+    DROP COLUMN previous_secret_expires_at,
+    DROP COLUMN previous_secret,
+    DROP COLUMN data_key,
+    ALTER COLUMN id DROP DEFAULT;
+
+DROP SEQUENCE IF EXISTS apps_id_seq;
\ No newline at end of file
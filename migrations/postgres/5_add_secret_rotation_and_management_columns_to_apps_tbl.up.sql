@@ -0,0 +1,15 @@
+-- apps were only ever inserted with explicit ids; created apps take the
+-- next one
+CREATE SEQUENCE IF NOT EXISTS apps_id_seq OWNED BY apps.id;
+
+SELECT setval('apps_id_seq', COALESCE((SELECT MAX(id) FROM apps), 0) + 1, false);
+
+ALTER TABLE apps
+    ALTER COLUMN id SET DEFAULT nextval('apps_id_seq'),
+    -- secret is encrypted with the data key; apps without one predate
+    -- encryption and keep a plain text secret until it is rotated
+    ADD COLUMN data_key                   BYTEA,
+    ADD COLUMN previous_secret            TEXT,
+    ADD COLUMN previous_secret_expires_at TIMESTAMPTZ,
+    ADD COLUMN created_at                 TIMESTAMPTZ,
+    ADD COLUMN disabled_at                TIMESTAMPTZ;
\ No newline at end of file
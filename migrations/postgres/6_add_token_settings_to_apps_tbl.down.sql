@@ -0,0 +1,5 @@
+ALTER TABLE apps
+    DROP COLUMN claims_template,
+    DROP COLUMN issuer,
+    DROP COLUMN audience,
+    DROP COLUMN token_ttl_seconds;
\ No newline at end of file
@@ -0,0 +1,6 @@
+-- token settings of the app; NULL falls back to the service defaults
+ALTER TABLE apps
+    ADD COLUMN token_ttl_seconds BIGINT,
+    ADD COLUMN audience          TEXT,
+    ADD COLUMN issuer            TEXT,
+    ADD COLUMN claims_template   TEXT[];
\ No newline at end of file
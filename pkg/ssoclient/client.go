@@ -0,0 +1,146 @@
+// Package ssoclient is the Go SDK for services relying on sso. Client calls
+// the Auth service with deadlines and retries; Verifier checks the tokens it
+// issues, and the interceptors and middleware put their claims into the
+// request context:
+//
+//	verifier := ssoclient.NewHMACVerifier("sso", "1", secret)
+//	server := grpc.NewServer(grpc.UnaryInterceptor(ssoclient.UnaryServerInterceptor(verifier)))
+//	...
+//	claims, ok := ssoclient.ClaimsFromContext(ctx)
+package ssoclient
+
+import (
+	"context"
+	"fmt"
+	ssov1 "github.com/some-kikikiss/protos-sso/gen/go/sso"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+	"time"
+)
+
+// Defaults of Options.
+const (
+	DefaultTimeout      = 5 * time.Second
+	DefaultRetries      = 3
+	DefaultRetryBackoff = 100 * time.Millisecond
+)
+
+// Options tune a Client. Zero fields take the defaults.
+type Options struct {
+	// Timeout is the deadline of each attempt of a call. A shorter deadline
+	// of the caller's context wins.
+	Timeout time.Duration
+	// Retries is how many times a call failing with Unavailable is retried;
+	// negative disables retries. Other errors are never retried: logins are
+	// not idempotent, a replayed keystroke sample is rejected.
+	Retries int
+	// RetryBackoff is the wait before the first retry; it doubles with
+	// every further one.
+	RetryBackoff time.Duration
+	// DialOptions are passed to grpc.DialContext. Without transport
+	// credentials the connection is insecure.
+	DialOptions []grpc.DialOption
+}
+
+// Client is a connection to the Auth service.
+type Client struct {
+	// Auth is the raw client. Its calls go through the deadlines and retries
+	// of the Client as well.
+	Auth ssov1.AuthClient
+
+	conn *grpc.ClientConn
+}
+
+// New connects to the Auth service at addr.
+func New(ctx context.Context, addr string, opts Options) (*Client, error) {
+	const op = "ssoclient.New"
+
+	if opts.Timeout <= 0 {
+		opts.Timeout = DefaultTimeout
+	}
+	if opts.Retries == 0 {
+		opts.Retries = DefaultRetries
+	}
+	if opts.RetryBackoff <= 0 {
+		opts.RetryBackoff = DefaultRetryBackoff
+	}
+
+	dialOpts := append([]grpc.DialOption{
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+		grpc.WithChainUnaryInterceptor(retryInterceptor(opts)),
+	}, opts.DialOptions...)
+
+	conn, err := grpc.DialContext(ctx, addr, dialOpts...)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return &Client{
+		Auth: ssov1.NewAuthClient(conn),
+		conn: conn,
+	}, nil
+}
+
+// Close closes the connection.
+func (c *Client) Close() error {
+	return c.conn.Close()
+}
+
+// Register registers a user and returns the user ID.
+func (c *Client) Register(ctx context.Context, req *ssov1.RegisterRequest) (int64, error) {
+	const op = "ssoclient.Register"
+
+	resp, err := c.Auth.Register(ctx, req)
+	if err != nil {
+		return 0, fmt.Errorf("%s: %w", op, err)
+	}
+	return resp.GetUserId(), nil
+}
+
+// Login logs a user in and returns the token.
+func (c *Client) Login(ctx context.Context, req *ssov1.LoginRequest) (string, error) {
+	const op = "ssoclient.Login"
+
+	resp, err := c.Auth.Login(ctx, req)
+	if err != nil {
+		return "", fmt.Errorf("%s: %w", op, err)
+	}
+	return resp.GetToken(), nil
+}
+
+// IsAdmin reports whether the user is an admin.
+func (c *Client) IsAdmin(ctx context.Context, userID int64) (bool, error) {
+	const op = "ssoclient.IsAdmin"
+
+	resp, err := c.Auth.IsAdmin(ctx, &ssov1.IsAdminRequest{UserId: userID})
+	if err != nil {
+		return false, fmt.Errorf("%s: %w", op, err)
+	}
+	return resp.GetIsAdmin(), nil
+}
+
+// retryInterceptor gives every attempt of a call its own deadline and
+// retries calls failing with Unavailable, which the server has not handled.
+func retryInterceptor(opts Options) grpc.UnaryClientInterceptor {
+	return func(ctx context.Context, method string, req, reply any, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, callOpts ...grpc.CallOption) error {
+		backoff := opts.RetryBackoff
+		for attempt := 0; ; attempt++ {
+			attemptCtx, cancel := context.WithTimeout(ctx, opts.Timeout)
+			err := invoker(attemptCtx, method, req, reply, cc, callOpts...)
+			cancel()
+
+			if err == nil || status.Code(err) != codes.Unavailable || attempt >= opts.Retries {
+				return err
+			}
+
+			select {
+			case <-ctx.Done():
+				return err
+			case <-time.After(backoff):
+			}
+			backoff *= 2
+		}
+	}
+}
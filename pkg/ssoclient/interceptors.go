@@ -0,0 +1,107 @@
+package ssoclient
+
+import (
+	"context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"net/http"
+	"strings"
+)
+
+// authorizationKey is the metadata key and HTTP header carrying the token as
+// "Bearer <token>".
+const authorizationKey = "authorization"
+
+// UnaryServerInterceptor verifies the bearer token of every unary call and
+// puts its claims into the context. Calls without a valid token fail with
+// Unauthenticated.
+func UnaryServerInterceptor(v *Verifier) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, _ *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		ctx, err := verifyIncoming(ctx, v)
+		if err != nil {
+			return nil, err
+		}
+		return handler(ctx, req)
+	}
+}
+
+// StreamServerInterceptor is UnaryServerInterceptor for streams.
+func StreamServerInterceptor(v *Verifier) grpc.StreamServerInterceptor {
+	return func(srv any, ss grpc.ServerStream, _ *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		ctx, err := verifyIncoming(ss.Context(), v)
+		if err != nil {
+			return err
+		}
+		return handler(srv, &claimsStream{ServerStream: ss, ctx: ctx})
+	}
+}
+
+func verifyIncoming(ctx context.Context, v *Verifier) (context.Context, error) {
+	md, _ := metadata.FromIncomingContext(ctx)
+	values := md.Get(authorizationKey)
+	if len(values) == 0 {
+		return nil, status.Error(codes.Unauthenticated, "bearer token is required")
+	}
+	token, ok := bearerToken(values[0])
+	if !ok {
+		return nil, status.Error(codes.Unauthenticated, "bearer token is required")
+	}
+
+	claims, err := v.Verify(ctx, token)
+	if err != nil {
+		return nil, status.Error(codes.Unauthenticated, "invalid token")
+	}
+	return ContextWithClaims(ctx, claims), nil
+}
+
+// claimsStream replaces the context of a stream with one carrying claims.
+type claimsStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *claimsStream) Context() context.Context {
+	return s.ctx
+}
+
+// Middleware verifies the bearer token of every request and puts its claims
+// into the request context. Requests without a valid token are answered with
+// 401 Unauthorized.
+func Middleware(v *Verifier) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			token, ok := bearerToken(r.Header.Get(authorizationKey))
+			if !ok {
+				unauthorized(w, "")
+				return
+			}
+			claims, err := v.Verify(r.Context(), token)
+			if err != nil {
+				unauthorized(w, "invalid_token")
+				return
+			}
+			next.ServeHTTP(w, r.WithContext(ContextWithClaims(r.Context(), claims)))
+		})
+	}
+}
+
+// unauthorized answers with a challenge as described by RFC 6750.
+func unauthorized(w http.ResponseWriter, errCode string) {
+	challenge := "Bearer"
+	if errCode != "" {
+		challenge += ` error="` + errCode + `"`
+	}
+	w.Header().Set("WWW-Authenticate", challenge)
+	http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+}
+
+func bearerToken(header string) (string, bool) {
+	scheme, token, ok := strings.Cut(header, " ")
+	if !ok || !strings.EqualFold(scheme, "Bearer") {
+		return "", false
+	}
+	token = strings.TrimSpace(token)
+	return token, token != ""
+}
@@ -0,0 +1,225 @@
+package ssoclient_test
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	jwtlib "github.com/golang-jwt/jwt/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"sso/internal/domain/models"
+	"sso/internal/lib/jwt"
+	"sso/pkg/ssoclient"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+const issuer = "sso"
+
+var app = models.App{
+	ID:             7,
+	Name:           "billing",
+	Secret:         "billing-secret",
+	ClaimsTemplate: []string{models.ClaimEmail, models.ClaimKeystrokes},
+}
+
+// issue returns a token issued the way the sso service does.
+func issue(t *testing.T, app models.App) string {
+	t.Helper()
+
+	user := models.User{ID: 42, Email: "user@example.com", PressTimes: []float32{100}, PressIntervals: []float32{200}}
+	token, err := jwt.NewToken(user, app, "session", "2", issuer, time.Hour)
+	require.NoError(t, err)
+	return token
+}
+
+func TestHMACVerifier(t *testing.T) {
+	ctx := context.Background()
+	token := issue(t, app)
+
+	claims, err := ssoclient.NewHMACVerifier(issuer, "7", app.Secret).Verify(ctx, token)
+	require.NoError(t, err)
+	assert.EqualValues(t, 42, claims.UserID)
+	assert.Equal(t, 7, claims.AppID)
+	assert.Equal(t, "session", claims.SessionID)
+	assert.Equal(t, "2", claims.ACR)
+	assert.Equal(t, "user@example.com", claims.Email)
+	assert.Equal(t, []float32{100}, claims.Times)
+
+	_, err = ssoclient.NewHMACVerifier(issuer, "7", "previous-secret", app.Secret).Verify(ctx, token)
+	require.NoError(t, err, "any of the secrets must verify the token")
+
+	for name, v := range map[string]*ssoclient.Verifier{
+		"other secret":   ssoclient.NewHMACVerifier(issuer, "7", "other"),
+		"other issuer":   ssoclient.NewHMACVerifier("evil", "7", app.Secret),
+		"other audience": ssoclient.NewHMACVerifier(issuer, "8", app.Secret),
+	} {
+		t.Run(name, func(t *testing.T) {
+			_, err := v.Verify(ctx, token)
+			assert.ErrorIs(t, err, ssoclient.ErrInvalidToken)
+		})
+	}
+}
+
+func TestHMACVerifier_RequiresRegisteredClaims(t *testing.T) {
+	v := ssoclient.NewHMACVerifier(issuer, "", app.Secret)
+	now := time.Now()
+	valid := ssoclient.Claims{
+		RegisteredClaims: jwtlib.RegisteredClaims{
+			ID:        "jti",
+			Issuer:    issuer,
+			Subject:   "42",
+			IssuedAt:  jwtlib.NewNumericDate(now),
+			NotBefore: jwtlib.NewNumericDate(now),
+			ExpiresAt: jwtlib.NewNumericDate(now.Add(time.Hour)),
+		},
+		UserID: 42,
+		AppID:  7,
+	}
+
+	sign := func(claims ssoclient.Claims) string {
+		token, err := jwtlib.NewWithClaims(jwtlib.SigningMethodHS256, claims).SignedString([]byte(app.Secret))
+		require.NoError(t, err)
+		return token
+	}
+
+	_, err := v.Verify(context.Background(), sign(valid))
+	require.NoError(t, err)
+
+	for name, forge := range map[string]func(c *ssoclient.Claims){
+		"no jti":        func(c *ssoclient.Claims) { c.ID = "" },
+		"no exp":        func(c *ssoclient.Claims) { c.ExpiresAt = nil },
+		"no nbf":        func(c *ssoclient.Claims) { c.NotBefore = nil },
+		"other subject": func(c *ssoclient.Claims) { c.Subject = "1" },
+		"expired":       func(c *ssoclient.Claims) { c.ExpiresAt = jwtlib.NewNumericDate(now.Add(-time.Minute)) },
+	} {
+		t.Run(name, func(t *testing.T) {
+			c := valid
+			forge(&c)
+			_, err := v.Verify(context.Background(), sign(c))
+			assert.ErrorIs(t, err, ssoclient.ErrInvalidToken)
+		})
+	}
+}
+
+func TestJWKSVerifier(t *testing.T) {
+	ctx := context.Background()
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	var fetches atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fetches.Add(1)
+		b64 := func(b []byte) string { return base64.RawURLEncoding.EncodeToString(b) }
+		_ = json.NewEncoder(w).Encode(map[string]any{"keys": []map[string]string{
+			{"kty": "RSA", "kid": "rsa", "use": "sig", "n": b64(rsaKey.N.Bytes()), "e": b64(big.NewInt(int64(rsaKey.E)).Bytes())},
+			{"kty": "EC", "kid": "ec", "crv": "P-256", "x": b64(ecKey.X.Bytes()), "y": b64(ecKey.Y.Bytes())},
+			{"kty": "oct", "kid": "ignored", "k": "c2VjcmV0"},
+		}})
+	}))
+	defer srv.Close()
+
+	v := ssoclient.NewJWKSVerifier(ssoclient.NewJWKS(srv.URL, time.Hour, nil), issuer, "7")
+	claims := ssoclient.Claims{
+		RegisteredClaims: jwtlib.RegisteredClaims{
+			ID:        "jti",
+			Issuer:    issuer,
+			Subject:   "42",
+			Audience:  jwtlib.ClaimStrings{"7"},
+			IssuedAt:  jwtlib.NewNumericDate(time.Now()),
+			NotBefore: jwtlib.NewNumericDate(time.Now()),
+			ExpiresAt: jwtlib.NewNumericDate(time.Now().Add(time.Hour)),
+		},
+		UserID: 42,
+		AppID:  7,
+	}
+	sign := func(method jwtlib.SigningMethod, kid string, key any) string {
+		token := jwtlib.NewWithClaims(method, claims)
+		token.Header["kid"] = kid
+		s, err := token.SignedString(key)
+		require.NoError(t, err)
+		return s
+	}
+
+	got, err := v.Verify(ctx, sign(jwtlib.SigningMethodRS256, "rsa", rsaKey))
+	require.NoError(t, err)
+	assert.EqualValues(t, 42, got.UserID)
+	_, err = v.Verify(ctx, sign(jwtlib.SigningMethodES256, "ec", ecKey))
+	require.NoError(t, err)
+	assert.EqualValues(t, 1, fetches.Load(), "keys must be cached")
+
+	_, err = v.Verify(ctx, sign(jwtlib.SigningMethodRS256, "unknown", rsaKey))
+	assert.ErrorIs(t, err, ssoclient.ErrInvalidToken)
+	_, err = v.Verify(ctx, sign(jwtlib.SigningMethodRS256, "ec", rsaKey))
+	assert.ErrorIs(t, err, ssoclient.ErrInvalidToken, "a key must only verify its own signatures")
+	_, err = v.Verify(ctx, issue(t, app))
+	assert.ErrorIs(t, err, ssoclient.ErrInvalidToken, "HMAC tokens must be rejected by a JWKS verifier")
+	assert.EqualValues(t, 1, fetches.Load(), "unknown keys must not refetch right after a fetch")
+}
+
+func TestUnaryServerInterceptor(t *testing.T) {
+	interceptor := ssoclient.UnaryServerInterceptor(ssoclient.NewHMACVerifier(issuer, "7", app.Secret))
+	handler := func(ctx context.Context, _ any) (any, error) {
+		claims, ok := ssoclient.ClaimsFromContext(ctx)
+		require.True(t, ok)
+		return claims.UserID, nil
+	}
+
+	ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs("authorization", "Bearer "+issue(t, app)))
+	resp, err := interceptor(ctx, nil, &grpc.UnaryServerInfo{}, handler)
+	require.NoError(t, err)
+	assert.EqualValues(t, 42, resp)
+
+	for name, ctx := range map[string]context.Context{
+		"no metadata":   context.Background(),
+		"no bearer":     metadata.NewIncomingContext(context.Background(), metadata.Pairs("authorization", issue(t, app))),
+		"invalid token": metadata.NewIncomingContext(context.Background(), metadata.Pairs("authorization", "Bearer nope")),
+	} {
+		t.Run(name, func(t *testing.T) {
+			_, err := interceptor(ctx, nil, &grpc.UnaryServerInfo{}, handler)
+			assert.Equal(t, codes.Unauthenticated, status.Code(err))
+		})
+	}
+}
+
+func TestMiddleware(t *testing.T) {
+	handler := ssoclient.Middleware(ssoclient.NewHMACVerifier(issuer, "7", app.Secret))(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		claims, ok := ssoclient.ClaimsFromContext(r.Context())
+		require.True(t, ok)
+		_, _ = w.Write([]byte(strconv.FormatInt(claims.UserID, 10)))
+	}))
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("Authorization", "Bearer "+issue(t, app))
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "42", rec.Body.String())
+
+	req = httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("Authorization", "Bearer nope")
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusUnauthorized, rec.Code)
+	assert.Equal(t, `Bearer error="invalid_token"`, rec.Header().Get("WWW-Authenticate"))
+
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+	assert.Equal(t, http.StatusUnauthorized, rec.Code)
+	assert.Equal(t, "Bearer", rec.Header().Get("WWW-Authenticate"))
+}
@@ -6,7 +6,10 @@ import (
 	ssov1 "github.com/some-kikikiss/protos/gen/go/sso"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/status"
 	"sso/tests/suite"
+	"strconv"
 	"testing"
 	"time"
 )
@@ -39,8 +42,8 @@ func TestRegisterLogin_Login_HappyPath(t *testing.T) {
 	respLogin, err := st.AuthClient.Login(ctx, &ssov1.LoginRequest{
 		Email:             email,
 		Password:          pass,
-		KeyPressTimes:     presses,
-		KeyPressIntervals: intervals,
+		KeyPressTimes:     jitterTimes(presses),
+		KeyPressIntervals: jitterTimes(intervals),
 		AppId:             appID,
 	})
 	require.NoError(t, err)
@@ -61,14 +64,70 @@ func TestRegisterLogin_Login_HappyPath(t *testing.T) {
 	assert.Equal(t, email, claims["email"])
 	assert.Equal(t, appID, int(claims["app_id"].(float64)))
 	assert.Equal(t, respReg.GetUserId(), int64(claims["uid"].(float64)))
-	assert.Equal(t, presses, claims["times"].([]int64))
-	assert.Equal(t, intervals, claims["intervals"].([]int64))
+	assert.Equal(t, st.Cfg.TokenIssuer, claims["iss"])
+	assert.Equal(t, strconv.FormatInt(respReg.GetUserId(), 10), claims["sub"])
+	assert.NotEmpty(t, claims["jti"])
+	assert.NotContains(t, claims, "times", "keystroke templates are only put in tokens of apps opting in")
+	assert.NotContains(t, claims, "intervals")
 
 	const deltaSeconds = 1
 
 	assert.InDelta(t, loginTime.Add(st.Cfg.TokenTTL).Unix(), claims["exp"].(float64), deltaSeconds)
 }
 
+func TestLogin_ReplayedSample(t *testing.T) {
+	ctx, st := suite.New(t)
+
+	email := gofakeit.Email()
+	pass := randomFakePassword()
+	presses := randomFakeTimes(len(pass))
+	intervals := randomFakeTimes(len(pass))
+
+	_, err := st.AuthClient.Register(ctx, &ssov1.RegisterRequest{
+		Email:             email,
+		Password:          pass,
+		KeyPressTimes:     presses,
+		KeyPressIntervals: intervals,
+	})
+	require.NoError(t, err)
+
+	// Exact copy of the enrolled template looks synthetic.
+	_, err = st.AuthClient.Login(ctx, &ssov1.LoginRequest{
+		Email:             email,
+		Password:          pass,
+		KeyPressTimes:     presses,
+		KeyPressIntervals: intervals,
+		AppId:             appID,
+	})
+	require.Error(t, err)
+	assert.Equal(t, "SAMPLE_SYNTHETIC", errorReason(err))
+
+	login := &ssov1.LoginRequest{
+		Email:             email,
+		Password:          pass,
+		KeyPressTimes:     jitterTimes(presses),
+		KeyPressIntervals: jitterTimes(intervals),
+		AppId:             appID,
+	}
+	_, err = st.AuthClient.Login(ctx, login)
+	require.NoError(t, err)
+
+	// Replaying a captured request must fail.
+	_, err = st.AuthClient.Login(ctx, login)
+	require.Error(t, err)
+	assert.Equal(t, "SAMPLE_REPLAYED", errorReason(err))
+}
+
+// errorReason returns the reason of the errdetails.ErrorInfo of err.
+func errorReason(err error) string {
+	for _, d := range status.Convert(err).Details() {
+		if info, ok := d.(*errdetails.ErrorInfo); ok {
+			return info.GetReason()
+		}
+	}
+	return ""
+}
+
 func TestRegisterLogin_DuplicatedRegistration(t *testing.T) {
 	ctx, st := suite.New(t)
 
@@ -145,14 +204,69 @@ func TestRegister_FailCases(t *testing.T) {
 		})
 	}
 }
+func TestRegister_InvalidTimings(t *testing.T) {
+	ctx, st := suite.New(t)
+
+	pass := randomFakePassword()
+
+	tests := []struct {
+		name        string
+		presses     []int64
+		intervals   []int64
+		expectedErr string
+	}{
+		{
+			name:        "Register with Too Few Presses",
+			presses:     randomFakeTimes(len(pass) - 2),
+			intervals:   randomFakeTimes(len(pass)),
+			expectedErr: "one per password character",
+		},
+		{
+			name:        "Register with Negative Presses",
+			presses:     append(randomFakeTimes(len(pass)-1), -100),
+			intervals:   randomFakeTimes(len(pass)),
+			expectedErr: "value must be positive",
+		},
+		{
+			name:        "Register with Hour Long Intervals",
+			presses:     randomFakeTimes(len(pass)),
+			intervals:   append(randomFakeTimes(len(pass)-1), int64(time.Hour/time.Millisecond)),
+			expectedErr: "value must not exceed",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := st.AuthClient.Register(ctx, &ssov1.RegisterRequest{
+				Email:             gofakeit.Email(),
+				Password:          pass,
+				KeyPressTimes:     tt.presses,
+				KeyPressIntervals: tt.intervals,
+			})
+			require.Error(t, err)
+			require.Contains(t, err.Error(), tt.expectedErr)
+		})
+	}
+}
+
 func randomFakePassword() string {
 	return gofakeit.Password(true, true, true, true, false, passDefaultLen)
 }
 
+// jitterTimes imitates natural variation between two typing attempts.
+func jitterTimes(times []int64) []int64 {
+	jittered := make([]int64, 0, len(times))
+	for _, v := range times {
+		jittered = append(jittered, v-v/int64(gofakeit.Number(5, 10)))
+	}
+	return jittered
+}
+
+// randomFakeTimes returns human-like keystroke timings in milliseconds.
 func randomFakeTimes(length int) []int64 {
 	var times []int64
 	for i := 0; i < length; i++ {
-		times = append(times, gofakeit.Int64())
+		times = append(times, int64(gofakeit.Number(60, 400)))
 	}
 	return times
 }